@@ -43,7 +43,7 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to connect to database")
 	}
 
-	if err := db.Migrate(database); err != nil {
+	if err := db.Migrate(database, cfg); err != nil {
 		log.Fatal().Err(err).Msg("Failed to run migrations")
 	}
 
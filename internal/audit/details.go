@@ -51,8 +51,11 @@ func DetailsTypeForAction(action string) DetailsType {
 	case ActionUserCreate, ActionUserDelete,
 		ActionSMTPCreate, ActionSMTPUpdate, ActionSMTPDelete,
 		ActionWebhookCreate, ActionWebhookUpdate, ActionWebhookDelete,
-		ActionCaptureCreate, ActionCaptureDelete,
-		ActionDeliveryCreate:
+		ActionCaptureCreate, ActionCaptureDelete, ActionBatchCreate,
+		ActionDeliveryCreate,
+		ActionSnippetCreate, ActionSnippetUpdate, ActionSnippetDelete,
+		ActionLoginCreate, ActionLoginUpdate, ActionLoginDelete,
+		ActionScheduleCreate, ActionScheduleUpdate, ActionScheduleDelete:
 		return DetailsTypeResource
 	default:
 		return DetailsTypeRaw
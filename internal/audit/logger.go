@@ -98,5 +98,15 @@ const (
 	ActionWebhookDelete  = "webhook.delete"
 	ActionCaptureCreate  = "capture.create"
 	ActionCaptureDelete  = "capture.delete"
+	ActionBatchCreate    = "batch.create"
 	ActionDeliveryCreate = "delivery.create"
+	ActionSnippetCreate  = "snippet.create"
+	ActionSnippetUpdate  = "snippet.update"
+	ActionSnippetDelete  = "snippet.delete"
+	ActionLoginCreate    = "login_recipe.create"
+	ActionLoginUpdate    = "login_recipe.update"
+	ActionLoginDelete    = "login_recipe.delete"
+	ActionScheduleCreate = "schedule.create"
+	ActionScheduleUpdate = "schedule.update"
+	ActionScheduleDelete = "schedule.delete"
 )
@@ -1,13 +1,17 @@
 package capture
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-rod/rod"
@@ -22,11 +26,27 @@ type BrowserConfig struct {
 	ViewportHeight int
 	UserAgent      string
 	Timeout        time.Duration
+	// Inline holds the size, concurrency and timeout limits applied when
+	// embedding sub-resources into the HTML output.
+	Inline InlinerConfig
+	// MaxPageSize aborts a capture once the page has downloaded more than
+	// this many bytes. Zero means defaultMaxPageSize.
+	MaxPageSize int64
+	// Filters is used by captures that block ads; nil means the bundled
+	// list.
+	Filters *FilterList
+	// ConsentRules dismiss cookie banners; nil means the bundled rules.
+	ConsentRules []ConsentRule
+	// TunnelPorts are ports besides 443 and 80 that pages may open HTTPS
+	// and WebSocket connections to.
+	TunnelPorts []int
 }
 
 type Browser struct {
-	browser *rod.Browser
-	config  *BrowserConfig
+	browser  *rod.Browser
+	launcher *launcher.Launcher
+	proxy    *egressProxy
+	config   *BrowserConfig
 }
 
 func findChromium() string {
@@ -50,6 +70,14 @@ func NewBrowser(cfg *BrowserConfig) (*Browser, error) {
 		return nil, fmt.Errorf("chromium not found")
 	}
 
+	proxy, err := startEgressProxy(cfg.TunnelPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	// All traffic goes through the egress proxy, loopback included, which
+	// Chromium would otherwise connect to directly. WebRTC may only use
+	// UDP through the proxy, which it cannot, so it gets none.
 	l := launcher.New().
 		Bin(chromePath).
 		Headless(cfg.Headless).
@@ -64,26 +92,101 @@ func NewBrowser(cfg *BrowserConfig) (*Browser, error) {
 		Set("disable-default-apps").
 		Set("mute-audio").
 		Set("hide-scrollbars").
-		Set("disable-features", "VizDisplayCompositor")
+		Set("disable-features", "VizDisplayCompositor").
+		Set("proxy-server", "http://"+proxy.Addr()).
+		Set("proxy-bypass-list", "<-loopback>").
+		Set("force-webrtc-ip-handling-policy", "disable_non_proxied_udp")
 
 	browserURL, err := l.Launch()
 	if err != nil {
+		_ = proxy.Close()
 		return nil, fmt.Errorf("failed to launch browser: %w", err)
 	}
 
 	browser := rod.New().ControlURL(browserURL)
 	if err := browser.Connect(); err != nil {
+		l.Kill()
+		l.Cleanup()
+		_ = proxy.Close()
 		return nil, fmt.Errorf("failed to connect to browser: %w", err)
 	}
 
 	return &Browser{
-		browser: browser,
-		config:  cfg,
+		browser:  browser,
+		launcher: l,
+		proxy:    proxy,
+		config:   cfg,
 	}, nil
 }
 
+// Close shuts the browser down and removes its profile directory. The
+// process is killed even if it no longer responds to CDP.
 func (b *Browser) Close() error {
-	return b.browser.Close()
+	err := b.browser.Close()
+	b.launcher.Kill()
+	b.launcher.Cleanup()
+	_ = b.proxy.Close()
+	return err
+}
+
+// Ping checks that the browser process still answers CDP commands.
+func (b *Browser) Ping(ctx context.Context) error {
+	if _, err := (proto.BrowserGetVersion{}).Call(b.browser.Context(ctx)); err != nil {
+		return fmt.Errorf("browser not responding: %w", err)
+	}
+	return nil
+}
+
+// MemoryUsage returns the resident memory of the browser and all of its
+// child processes, in bytes.
+func (b *Browser) MemoryUsage() (int64, error) {
+	return processTreeRSS(b.launcher.PID())
+}
+
+// Wait strategies applied after navigation, before any output is rendered.
+const (
+	WaitLoad             = "load"
+	WaitDOMContentLoaded = "domcontentloaded"
+	WaitNetworkIdle      = "networkidle"
+	WaitSelector         = "selector"
+	WaitFunction         = "function"
+)
+
+const (
+	defaultNetworkIdle = 500 * time.Millisecond
+	defaultMaxPageSize = 100 << 20
+)
+
+// Outputs Capture can render besides the screenshot image encodings.
+const (
+	OutputHTML = "html"
+	OutputPDF  = "pdf"
+	// OutputMarkdown and OutputText render the page's main content, as
+	// extracted by reader mode.
+	OutputMarkdown = "markdown"
+	OutputText     = "text"
+	// OutputEPUB packages the reader-mode article and its images as an
+	// EPUB 3 book.
+	OutputEPUB = "epub"
+	// OutputMHTML is Chromium's single-file page snapshot.
+	OutputMHTML = "mhtml"
+	// OutputWARC archives every request and response made for the page.
+	OutputWARC = "warc"
+	// OutputHAR logs the page's requests and their timings as HAR 1.2.
+	OutputHAR = "har"
+	// OutputConsole logs console messages and uncaught exceptions as JSON.
+	OutputConsole = "console"
+)
+
+// defaultOutputs is rendered when CaptureOptions.Outputs is empty.
+var defaultOutputs = []string{OutputHTML, OutputPDF, ImagePNG}
+
+// longLivedResourceTypes never settle, so they are ignored when waiting
+// for the network to become idle.
+var longLivedResourceTypes = []proto.NetworkResourceType{
+	proto.NetworkResourceTypeWebSocket,
+	proto.NetworkResourceTypeEventSource,
+	proto.NetworkResourceTypeMedia,
 }
 
 //nolint:revive // CaptureOptions is clearer than Options in this context
@@ -94,16 +197,107 @@ type CaptureOptions struct {
 	ViewportHeight int
 	UserAgent      string
 	Timeout        time.Duration
-	WaitUntil      string
+	// WaitUntil selects the wait strategy; empty means WaitLoad.
+	WaitUntil string
+	// WaitSelector is the CSS selector awaited by WaitSelector.
+	WaitSelector string
+	// WaitExpression is polled by WaitFunction until truthy: a single JS
+	// expression, or a function body that returns the value to test.
+	WaitExpression string
+	// NetworkIdle is the quiet period required by WaitNetworkIdle.
+	NetworkIdle time.Duration
+	// Delay is an additional fixed pause after the wait strategy completes.
+	Delay time.Duration
+	// PDF overrides the default US Letter rendering when set.
+	PDF *PDFOptions
+	// Screenshot overrides the default full-page capture when set.
+	Screenshot *ScreenshotOptions
+	// Outputs lists what to render: OutputHTML, OutputPDF, the reader
+	// outputs and any of the image encodings. Empty means HTML, PDF and a
+	// PNG screenshot.
+	Outputs []string
+	// DeviceScaleFactor emulates a high-DPI display when greater than zero.
+	DeviceScaleFactor float64
+	// Emulation sets a device preset, locale, timezone and similar overrides.
+	// A device preset replaces the viewport size and user agent.
+	Emulation *EmulationOptions
+	// Login signs in before the target is opened.
+	Login *LoginFlow
+	// Headers are added to every request for the captured site.
+	Headers map[string]string
+	// BasicAuth sends HTTP Basic credentials to the captured site.
+	BasicAuth *BasicAuth
+	// Blocking turns on ad, tracker and cookie banner blocking.
+	Blocking *BlockingOptions
+	// Scroll scrolls through the page after injections to trigger lazy
+	// loading; nil disables it.
+	Scroll *ScrollOptions
+	// Injections are applied in order once the page is ready.
+	Injections []Injection
+	// ScriptTimeout bounds each injected script; zero means five seconds.
+	ScriptTimeout time.Duration
+	// Monitor takes a Snapshot for change detection when set.
+	Monitor *MonitorOptions
 }
 
 //nolint:revive // CaptureResult is clearer than Result in this context
 type CaptureResult struct {
-	HTML       []byte
-	PDF        []byte
-	Screenshot []byte
-	Title      string
-	FinalURL   string
+	HTML     []byte
+	PDF      []byte
+	Markdown []byte
+	Text     []byte
+	EPUB     []byte
+	MHTML    []byte
+	WARC     []byte
+	HAR      []byte
+	Console  []byte
+	// Screenshots holds one image per requested encoding, keyed by ImagePNG etc.
+	Screenshots map[string][]byte
+	Title       string
+	FinalURL    string
+	// Metadata describes the page and the response that served it.
+	Metadata *PageMetadata
+	// Errors holds the reason each requested output could not be rendered,
+	// keyed like CaptureOptions.Outputs.
+	Errors map[string]error
+	// InjectionErrors describes each injection that failed or timed out.
+	InjectionErrors []string
+	// Snapshot is set for monitored captures, unless it failed.
+	Snapshot *Snapshot
+
+	// article is extracted once and shared by the reader outputs.
+	article *Article
+	// network records the page's traffic when an archive or HAR was
+	// requested.
+	network *networkRecorder
+	// console records the page's console when its log was requested.
+	console *consoleRecorder
+}
+
+// Output returns the rendered data for an output name, or nil.
+func (r *CaptureResult) Output(name string) []byte {
+	switch name {
+	case OutputHTML:
+		return r.HTML
+	case OutputPDF:
+		return r.PDF
+	case OutputMarkdown:
+		return r.Markdown
+	case OutputText:
+		return r.Text
+	case OutputEPUB:
+		return r.EPUB
+	case OutputMHTML:
+		return r.MHTML
+	case OutputWARC:
+		return r.WARC
+	case OutputHAR:
+		return r.HAR
+	case OutputConsole:
+		return r.Console
+	default:
+		return r.Screenshots[name]
+	}
 }
 
 //nolint:gocyclo // Browser capture has inherent complexity with viewport, cookies, timeout, navigation
@@ -112,34 +306,44 @@ func (b *Browser) Capture(ctx context.Context, opts *CaptureOptions) (*CaptureRe
 		return nil, err
 	}
 
-	page, err := b.browser.Page(proto.TargetCreateTarget{URL: "about:blank"})
+	// Each capture gets its own incognito context so cookies, storage and
+	// cache never leak between tasks sharing a browser.
+	incognito, err := b.browser.Incognito()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create browser context: %w", err)
+	}
+	defer incognito.Close()
+
+	page, err := incognito.Page(proto.TargetCreateTarget{URL: "about:blank"})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create page: %w", err)
 	}
 	defer page.Close()
 
-	width := opts.ViewportWidth
-	if width == 0 {
-		width = b.config.ViewportWidth
+	var blocker *requestBlocker
+	if opts.Blocking != nil && opts.Blocking.Ads {
+		target, _ := url.Parse(opts.URL)
+		blocker = &requestBlocker{list: b.filters(), site: siteOf(target.Hostname())}
 	}
-	height := opts.ViewportHeight
-	if height == 0 {
-		height = b.config.ViewportHeight
+
+	extra := newSiteHeaders(opts.URL, opts.Headers, opts.BasicAuth)
+	stopGuard, err := guardPage(page, blocker, extra)
+	if err != nil {
+		return nil, err
 	}
+	defer stopGuard()
+
+	pageCtx, cancelPage := context.WithCancel(ctx)
+	defer cancelPage()
+	page = page.Context(pageCtx)
 
-	if err := page.SetViewport(&proto.EmulationSetDeviceMetricsOverride{
-		Width:  width,
-		Height: height,
-	}); err != nil {
-		return nil, fmt.Errorf("failed to set viewport: %w", err)
+	oversized, err := b.limitPageSize(page, cancelPage)
+	if err != nil {
+		return nil, err
 	}
 
-	if opts.UserAgent != "" {
-		if err := page.SetUserAgent(&proto.NetworkSetUserAgentOverride{
-			UserAgent: opts.UserAgent,
-		}); err != nil {
-			return nil, fmt.Errorf("failed to set user agent: %w", err)
-		}
+	if err := b.emulate(page, incognito, opts); err != nil {
+		return nil, err
 	}
 
 	if len(opts.Cookies) > 0 {
@@ -154,56 +358,419 @@ func (b *Browser) Capture(ctx context.Context, opts *CaptureOptions) (*CaptureRe
 	}
 	page = page.Timeout(timeout)
 
+	if opts.Login != nil {
+		if err := login(page, opts.Login); err != nil {
+			return nil, oversized.wrap(err)
+		}
+	}
+
+	outputs := orderOutputs(opts.Outputs)
+
+	// Recording starts after login so credentials never end up in an
+	// archive or log. Only the archive needs response bodies.
+	var network *networkRecorder
+	var console *consoleRecorder
+	if slices.Contains(outputs, OutputWARC) || slices.Contains(outputs, OutputHAR) {
+		network = recordNetwork(page, slices.Contains(outputs, OutputWARC))
+	}
+	if slices.Contains(outputs, OutputConsole) {
+		console = recordConsole(page)
+	}
+
+	// Lifecycle and network listeners must be attached before navigating,
+	// otherwise early events are missed.
+	document := watchDocumentResponse(page)
+	var waitReady func()
+	switch opts.WaitUntil {
+	case WaitDOMContentLoaded:
+		waitReady = page.WaitNavigation(proto.PageLifecycleEventNameDOMContentLoaded)
+	case WaitNetworkIdle:
+		idle := opts.NetworkIdle
+		if idle == 0 {
+			idle = defaultNetworkIdle
+		}
+		waitReady = page.WaitRequestIdle(idle, nil, nil, longLivedResourceTypes)
+	}
+
 	if err := page.Navigate(opts.URL); err != nil {
-		return nil, fmt.Errorf("failed to navigate: %w", err)
+		return nil, oversized.wrap(fmt.Errorf("failed to navigate: %w", err))
 	}
 
-	if err := page.WaitLoad(); err != nil {
-		return nil, fmt.Errorf("failed to wait for page load: %w", err)
+	if err := waitForPage(page, opts, waitReady); err != nil {
+		return nil, oversized.wrap(err)
 	}
 
-	time.Sleep(2 * time.Second)
+	result := &CaptureResult{
+		Screenshots: make(map[string][]byte),
+		Errors:      make(map[string]error),
+		network:     network,
+		console:     console,
+	}
 
-	result := &CaptureResult{}
+	if opts.Blocking != nil {
+		b.cleanPage(page, opts)
+	}
+
+	if len(opts.Injections) > 0 {
+		result.InjectionErrors = inject(page, opts.Injections, opts.ScriptTimeout)
+		for _, msg := range result.InjectionErrors {
+			log.Warn().Str("error", msg).Msg("Injection failed")
+		}
+	}
+
+	if opts.Scroll != nil {
+		if err := autoScroll(page, opts.Scroll); err != nil {
+			// Rendering the page as far as it loaded beats failing it.
+			log.Warn().Err(err).Msg("Auto-scroll failed")
+		}
+	}
 
 	info, err := page.Info()
 	if err == nil {
 		result.Title = info.Title
 		result.FinalURL = info.URL
 	}
+	result.Metadata = pageMetadata(page, result, document)
+
+	for _, output := range outputs {
+		if err := b.renderOutput(ctx, page, opts, output, result); err != nil {
+			log.Warn().Err(err).Str("output", output).Msg("Failed to render output")
+			result.Errors[output] = oversized.wrap(err)
+		}
+	}
+
+	if opts.Monitor != nil {
+		snapshot, err := takeSnapshot(page, opts.Monitor)
+		if err != nil {
+			// The outputs are still delivered; the capture just cannot be
+			// compared.
+			log.Warn().Err(err).Msg("Failed to take page snapshot")
+		}
+		result.Snapshot = snapshot
+	}
+
+	return result, nil
+}
+
+// pageMetadata describes the page as it will be rendered. The title and
+// final URL come from the browser, which knows them even when the markup
+// does not.
+func pageMetadata(page *rod.Page, result *CaptureResult, document *documentResponse) *PageMetadata {
+	meta := &PageMetadata{}
+	if html, err := page.HTML(); err == nil {
+		meta = ExtractMetadata([]byte(html), result.FinalURL)
+	} else {
+		log.Warn().Err(err).Msg("Failed to read page metadata")
+	}
+	if result.Title != "" {
+		meta.Title = result.Title
+	}
+	meta.FinalURL = result.FinalURL
+	if resp := document.get(); resp != nil {
+		meta.setResponse(resp)
+	}
+	return meta
+}
+
+// recordedOutputs are built from what the page did during the capture.
+var recordedOutputs = []string{OutputWARC, OutputHAR, OutputConsole}
+
+// orderOutputs returns the outputs to render, defaulting when none were
+// requested. Recorded outputs go last so they include requests made and
+// messages logged while the other outputs were rendered.
+func orderOutputs(requested []string) []string {
+	if len(requested) == 0 {
+		return defaultOutputs
+	}
+	outputs := make([]string, 0, len(requested))
+	for _, output := range requested {
+		if !slices.Contains(recordedOutputs, output) {
+			outputs = append(outputs, output)
+		}
+	}
+	for _, output := range recordedOutputs {
+		if slices.Contains(requested, output) {
+			outputs = append(outputs, output)
+		}
+	}
+	return outputs
+}
+
+func (b *Browser) filters() *FilterList {
+	if b.config.Filters != nil {
+		return b.config.Filters
+	}
+	return DefaultFilterList()
+}
+
+// cleanPage hides ad placeholders and dismisses cookie banners. Failures are
+// logged but never fail the capture; the page is rendered as it is.
+func (b *Browser) cleanPage(page *rod.Page, opts *CaptureOptions) {
+	if opts.Blocking.Ads {
+		info, err := page.Info()
+		if err == nil {
+			host := ""
+			if u, err := url.Parse(info.URL); err == nil {
+				host = u.Hostname()
+			}
+			if css := b.filters().HidingCSS(host); css != "" {
+				_, err = page.Evaluate(rod.Eval(injectStyleJS, css))
+			}
+		}
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to hide ad elements")
+		}
+	}
+
+	if opts.Blocking.CookieBanners {
+		rules := b.config.ConsentRules
+		if rules == nil {
+			rules = DefaultConsentRules()
+		}
+		dismissed, err := dismissConsent(page, rules)
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to dismiss cookie banners")
+		}
+		if len(dismissed) > 0 {
+			log.Debug().Strs("banners", dismissed).Msg("Dismissed cookie banners")
+		}
+	}
+}
+
+// pageSizeLimit records whether a page was aborted for downloading too much.
+type pageSizeLimit struct {
+	limit    int64
+	exceeded atomic.Bool
+}
+
+func (l *pageSizeLimit) wrap(err error) error {
+	if l.exceeded.Load() {
+		return fmt.Errorf("page exceeded %d bytes: %w", l.limit, err)
+	}
+	return err
+}
+
+// limitPageSize cancels the page once the bytes received across all of its
+// requests exceed the configured limit.
+func (b *Browser) limitPageSize(page *rod.Page, cancel context.CancelFunc) (*pageSizeLimit, error) {
+	l := &pageSizeLimit{limit: b.config.MaxPageSize}
+	if l.limit == 0 {
+		l.limit = defaultMaxPageSize
+	}
+
+	if err := (proto.NetworkEnable{}).Call(page); err != nil {
+		return nil, fmt.Errorf("failed to enable network events: %w", err)
+	}
 
+	var received int64
+	go page.EachEvent(func(e *proto.NetworkDataReceived) bool {
+		received += int64(e.DataLength)
+		if received > l.limit {
+			l.exceeded.Store(true)
+			cancel()
+			return true
+		}
+		return false
+	})()
+
+	return l, nil
+}
+
+func (b *Browser) renderOutput(ctx context.Context, page *rod.Page, opts *CaptureOptions, output string, result *CaptureResult) error {
+	switch output {
+	case OutputHTML:
+		html, err := page.HTML()
+		if err != nil {
+			return fmt.Errorf("failed to get HTML: %w", err)
+		}
+		inlined, err := b.inlineHTML(ctx, page, result.FinalURL, []byte(html))
+		if err != nil {
+			return err
+		}
+		result.HTML = inlined
+	case OutputPDF:
+		pdfOpts := opts.PDF
+		if pdfOpts == nil {
+			pdfOpts = &PDFOptions{}
+		}
+		pdf, err := page.PDF(pdfOpts.printParams())
+		if err != nil {
+			return fmt.Errorf("failed to generate PDF: %w", err)
+		}
+		data, err := io.ReadAll(pdf)
+		if err != nil {
+			return fmt.Errorf("failed to read PDF: %w", err)
+		}
+		result.PDF = data
+	case OutputMarkdown:
+		article, err := readArticle(page, result)
+		if err != nil {
+			return err
+		}
+		result.Markdown = []byte(article.Markdown())
+	case OutputText:
+		article, err := readArticle(page, result)
+		if err != nil {
+			return err
+		}
+		result.Text = []byte(article.Text())
+	case OutputEPUB:
+		article, err := readArticle(page, result)
+		if err != nil {
+			return err
+		}
+		inliner, err := b.pageInliner(page, result.FinalURL)
+		if err != nil {
+			return err
+		}
+		epub, err := buildEPUB(article, time.Now(), fetchEPUBImages(ctx, inliner, article))
+		if err != nil {
+			return fmt.Errorf("failed to build EPUB: %w", err)
+		}
+		result.EPUB = epub
+	case OutputMHTML:
+		snapshot, err := proto.PageCaptureSnapshot{Format: proto.PageCaptureSnapshotFormatMhtml}.Call(page)
+		if err != nil {
+			return fmt.Errorf("failed to capture MHTML snapshot: %w", err)
+		}
+		result.MHTML = []byte(snapshot.Data)
+	case OutputWARC:
+		var buf bytes.Buffer
+		if err := writeWARC(&buf, result.network.exchanges(), time.Now()); err != nil {
+			return fmt.Errorf("failed to write WARC: %w", err)
+		}
+		result.WARC = buf.Bytes()
+	case OutputHAR:
+		har, err := buildHAR(result.network.exchanges(), result.network.pending(), result.Title, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to build HAR: %w", err)
+		}
+		result.HAR = har
+	case OutputConsole:
+		data, err := result.console.marshal()
+		if err != nil {
+			return fmt.Errorf("failed to write console log: %w", err)
+		}
+		result.Console = data
+	default:
+		shotOpts := opts.Screenshot
+		if shotOpts == nil {
+			shotOpts = &ScreenshotOptions{}
+		}
+		screenshot, err := takeScreenshot(page, shotOpts, output)
+		if err != nil {
+			return fmt.Errorf("failed to take screenshot: %w", err)
+		}
+		result.Screenshots[output] = screenshot
+	}
+	return nil
+}
+
+// readArticle extracts the page's main content, reusing an earlier
+// extraction from the same capture.
+func readArticle(page *rod.Page, result *CaptureResult) (*Article, error) {
+	if result.article != nil {
+		return result.article, nil
+	}
 	html, err := page.HTML()
 	if err != nil {
-		log.Warn().Err(err).Msg("Failed to get HTML")
-	} else {
-		result.HTML = []byte(html)
+		return nil, fmt.Errorf("failed to get HTML: %w", err)
 	}
+	article, err := ExtractArticle([]byte(html), result.FinalURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract article: %w", err)
+	}
+	result.article = article
+	return article, nil
+}
 
-	paperWidth := 8.5
-	paperHeight := 11.0
-	pdf, err := page.PDF(&proto.PagePrintToPDF{
-		PrintBackground: true,
-		PaperWidth:      &paperWidth,
-		PaperHeight:     &paperHeight,
-	})
+// inlineHTML embeds sub-resources fetched the way the browser did.
+func (b *Browser) inlineHTML(ctx context.Context, page *rod.Page, pageURL string, html []byte) ([]byte, error) {
+	inliner, err := b.pageInliner(page, pageURL)
 	if err != nil {
-		log.Warn().Err(err).Msg("Failed to generate PDF")
-	} else {
-		result.PDF, _ = io.ReadAll(pdf)
+		return nil, err
 	}
 
-	quality := 90
-	screenshot, err := page.Screenshot(true, &proto.PageCaptureScreenshot{
-		Format:  proto.PageCaptureScreenshotFormatPng,
-		Quality: &quality,
-	})
+	inlined, err := inliner.InlineHTML(ctx, html)
 	if err != nil {
-		log.Warn().Err(err).Msg("Failed to take screenshot")
-	} else {
-		result.Screenshot = screenshot
+		return nil, fmt.Errorf("failed to inline HTML: %w", err)
+	}
+	return inlined, nil
+}
+
+// pageInliner returns an Inliner using the page's cookies and user agent,
+// so resources behind a login are fetched the same way the browser did.
+func (b *Browser) pageInliner(page *rod.Page, pageURL string) (*Inliner, error) {
+	cfg := b.config.Inline
+
+	cookies, err := page.Browser().GetCookies()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read cookies for inlining")
 	}
+	cfg.Cookies = cookies
 
-	return result, nil
+	if ua, err := page.Eval(`() => navigator.userAgent`); err == nil {
+		cfg.UserAgent = ua.Value.Str()
+	}
+
+	return NewInliner(pageURL, &cfg)
+}
+
+// waitFunctions returns the functions WaitFunction may poll for expr: first
+// expr as a single expression, then, if that is not valid JavaScript, expr
+// as the body of an async function. The line breaks keep a trailing "//"
+// comment from swallowing the closing parenthesis.
+func waitFunctions(expr string) []string {
+	trimmed := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(expr), ";"))
+	return []string{
+		"() => !!(\n" + trimmed + "\n)",
+		"async () => !!(await (async () => {\n" + expr + "\n})())",
+	}
+}
+
+// isSyntaxError reports whether err is the page rejecting a function that
+// does not parse.
+func isSyntaxError(err error) bool {
+	var evalErr *rod.EvalError
+	return errors.As(err, &evalErr) && evalErr.Exception != nil && evalErr.Exception.ClassName == "SyntaxError"
+}
+
+func waitForPage(page *rod.Page, opts *CaptureOptions, waitReady func()) error {
+	switch opts.WaitUntil {
+	case WaitDOMContentLoaded, WaitNetworkIdle:
+		waitReady()
+		if err := page.GetContext().Err(); err != nil {
+			return fmt.Errorf("failed to wait for %s: %w", opts.WaitUntil, err)
+		}
+	case WaitSelector:
+		if _, err := page.Element(opts.WaitSelector); err != nil {
+			return fmt.Errorf("failed to wait for selector %q: %w", opts.WaitSelector, err)
+		}
+	case WaitFunction:
+		var err error
+		for _, js := range waitFunctions(opts.WaitExpression) {
+			if err = page.Wait(rod.Eval(js).ByPromise()); !isSyntaxError(err) {
+				break
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to wait for expression: %w", err)
+		}
+	default:
+		if err := page.WaitLoad(); err != nil {
+			return fmt.Errorf("failed to wait for page load: %w", err)
+		}
+	}
+
+	if opts.Delay > 0 {
+		select {
+		case <-time.After(opts.Delay):
+		case <-page.GetContext().Done():
+			return fmt.Errorf("failed to wait for delay: %w", page.GetContext().Err())
+		}
+	}
+
+	return nil
 }
 
 func validateURL(rawURL string) error {
@@ -218,59 +785,33 @@ func validateURL(rawURL string) error {
 
 	host := parsed.Hostname()
 
-	if isPrivateIP(host) {
-		return fmt.Errorf("private IP addresses are not allowed")
+	if isBlockedHostname(host) {
+		return fmt.Errorf("blocked host: %s", host)
 	}
 
-	blockedHosts := []string{
-		"localhost",
-		"127.0.0.1",
-		"0.0.0.0",
-		"::1",
-		"metadata.google.internal",
-		"169.254.169.254",
-	}
-
-	for _, blocked := range blockedHosts {
-		if strings.EqualFold(host, blocked) {
-			return fmt.Errorf("blocked host: %s", host)
-		}
+	if isPrivateIP(host) {
+		return fmt.Errorf("private IP addresses are not allowed")
 	}
 
 	return nil
 }
 
+// isPrivateIP reports whether host is, or resolves to, a blocked address.
+// It is an early check only; requests are vetted again as they are made.
 func isPrivateIP(host string) bool {
-	ip := net.ParseIP(host)
-	if ip == nil {
-		ips, err := net.LookupIP(host)
-		if err != nil || len(ips) == 0 {
-			return false
-		}
-		ip = ips[0]
+	if ip := net.ParseIP(host); ip != nil {
+		return isBlockedIP(ip)
 	}
 
-	privateRanges := []string{
-		"10.0.0.0/8",
-		"172.16.0.0/12",
-		"192.168.0.0/16",
-		"127.0.0.0/8",
-		"169.254.0.0/16",
-		"fc00::/7",
-		"fe80::/10",
-		"::1/128",
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return false
 	}
-
-	for _, cidr := range privateRanges {
-		_, network, err := net.ParseCIDR(cidr)
-		if err != nil {
-			continue
-		}
-		if network.Contains(ip) {
+	for _, ip := range ips {
+		if isBlockedIP(ip) {
 			return true
 		}
 	}
-
 	return false
 }
 
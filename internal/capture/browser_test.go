@@ -1,7 +1,13 @@
 package capture
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
 )
 
 func TestValidateURL(t *testing.T) {
@@ -166,3 +172,71 @@ func TestParseCookies(t *testing.T) {
 		})
 	}
 }
+
+func TestOrderOutputs(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested []string
+		want      []string
+	}{
+		{"default", nil, defaultOutputs},
+		{"archive last", []string{OutputWARC, OutputPDF, ImagePNG}, []string{OutputPDF, ImagePNG, OutputWARC}},
+		{"unchanged", []string{OutputMHTML, OutputHTML}, []string{OutputMHTML, OutputHTML}},
+		{"recordings last", []string{OutputConsole, OutputHAR, OutputHTML, OutputWARC}, []string{OutputHTML, OutputWARC, OutputHAR, OutputConsole}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := orderOutputs(tt.requested)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("orderOutputs(%v) = %v, want %v", tt.requested, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitFunctions(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want []string
+	}{
+		{"expression", "window.ready", []string{"() => !!(\nwindow.ready\n)", "async () => !!(await (async () => {\nwindow.ready\n})())"}},
+		{"trailing semicolon", " document.title !== '';  ", []string{"() => !!(\ndocument.title !== ''\n)", "async () => !!(await (async () => {\n document.title !== '';  \n})())"}},
+		{"comment", "window.ready // set by app.js", []string{"() => !!(\nwindow.ready // set by app.js\n)", "async () => !!(await (async () => {\nwindow.ready // set by app.js\n})())"}},
+		{"function body", "const el = document.querySelector('#chart');\nreturn el && el.dataset.loaded", []string{
+			"() => !!(\nconst el = document.querySelector('#chart');\nreturn el && el.dataset.loaded\n)",
+			"async () => !!(await (async () => {\nconst el = document.querySelector('#chart');\nreturn el && el.dataset.loaded\n})())",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := waitFunctions(tt.expr)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("waitFunctions(%q) = %q, want %q", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSyntaxError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"syntax error", fmt.Errorf("wrapped: %w", &rod.EvalError{RuntimeExceptionDetails: &proto.RuntimeExceptionDetails{Exception: &proto.RuntimeRemoteObject{ClassName: "SyntaxError"}}}), true},
+		{"reference error", &rod.EvalError{RuntimeExceptionDetails: &proto.RuntimeExceptionDetails{Exception: &proto.RuntimeRemoteObject{ClassName: "ReferenceError"}}}, false},
+		{"other", errors.New("context deadline exceeded"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSyntaxError(tt.err); got != tt.want {
+				t.Errorf("isSyntaxError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
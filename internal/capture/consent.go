@@ -0,0 +1,127 @@
+package capture
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+)
+
+//go:embed filters/consent.json
+var defaultConsentJSON []byte
+
+// consentSettle is how long to wait after clicking a consent button, since
+// many consent platforms reload or restyle the page in response.
+const consentSettle = 500 * time.Millisecond
+
+// ConsentRule recognises one cookie consent platform and dismisses it.
+type ConsentRule struct {
+	Name string `json:"name"`
+	// Detect matches only while the banner is shown.
+	Detect string `json:"detect"`
+	// Click lists buttons tried in order; the first visible one is clicked.
+	// Reject buttons come first so captures do not opt in to tracking.
+	Click []string `json:"click"`
+	// Hide lists elements removed afterwards, whether or not a click
+	// succeeded.
+	Hide []string `json:"hide"`
+}
+
+var (
+	defaultConsentOnce  sync.Once
+	defaultConsentRules []ConsentRule
+)
+
+// DefaultConsentRules returns the bundled rule set.
+func DefaultConsentRules() []ConsentRule {
+	defaultConsentOnce.Do(func() {
+		if err := json.Unmarshal(defaultConsentJSON, &defaultConsentRules); err != nil {
+			panic(fmt.Sprintf("invalid bundled consent rules: %v", err))
+		}
+	})
+	return defaultConsentRules
+}
+
+// dismissConsentJS applies the rules to the document and returns the names
+// of the rules that matched, marking those where a button was clicked.
+// Consent overlays usually lock scrolling, which is undone once one is
+// removed.
+const dismissConsentJS = `(rules) => {
+	const visible = (el) => {
+		const rect = el.getBoundingClientRect();
+		const style = getComputedStyle(el);
+		return rect.width > 0 && rect.height > 0 && style.visibility !== 'hidden' && style.display !== 'none';
+	};
+	const matched = [];
+	for (const rule of rules) {
+		let present = false;
+		try { present = !!document.querySelector(rule.detect); } catch (e) {}
+		if (!present) continue;
+
+		let clicked = false;
+		for (const selector of rule.click || []) {
+			let button = null;
+			try { button = document.querySelector(selector); } catch (e) {}
+			if (button && visible(button)) {
+				button.click();
+				clicked = true;
+				break;
+			}
+		}
+		for (const selector of rule.hide || []) {
+			try { document.querySelectorAll(selector).forEach((el) => el.remove()); } catch (e) {}
+		}
+		matched.push({ name: rule.name, clicked });
+	}
+	if (matched.length > 0) {
+		for (const el of [document.documentElement, document.body]) {
+			if (!el) continue;
+			const style = getComputedStyle(el);
+			if (style.overflow === 'hidden') el.style.setProperty('overflow', 'visible', 'important');
+			if (style.position === 'fixed') el.style.setProperty('position', 'static', 'important');
+		}
+	}
+	return matched;
+}`
+
+type consentMatch struct {
+	Name    string `json:"name"`
+	Clicked bool   `json:"clicked"`
+}
+
+// dismissConsent runs the consent rules against the loaded page and returns
+// the names of the banners it found.
+func dismissConsent(page *rod.Page, rules []ConsentRule) ([]string, error) {
+	obj, err := page.Evaluate(rod.Eval(dismissConsentJS, rules))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dismiss cookie banners: %w", err)
+	}
+
+	var matches []consentMatch
+	if err := obj.Value.Unmarshal(&matches); err != nil {
+		return nil, fmt.Errorf("failed to read cookie banner result: %w", err)
+	}
+
+	names := make([]string, 0, len(matches))
+	clicked := false
+	for _, m := range matches {
+		names = append(names, m.Name)
+		clicked = clicked || m.Clicked
+	}
+
+	if clicked {
+		select {
+		case <-time.After(consentSettle):
+		case <-page.GetContext().Done():
+			return names, page.GetContext().Err()
+		}
+		// A click may have triggered a reload.
+		if err := page.WaitLoad(); err != nil {
+			return names, fmt.Errorf("failed to wait for page after consent: %w", err)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,188 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+const (
+	// maxConsoleEntries bounds the console log of a chatty page.
+	maxConsoleEntries = 1000
+	// maxConsoleText truncates long messages such as dumped objects.
+	maxConsoleText = 4096
+)
+
+// Console log entry sources.
+const (
+	consoleSourceConsole   = "console"
+	consoleSourceException = "exception"
+	// consoleSourceBrowser marks messages logged by the browser itself,
+	// such as failed loads and security violations.
+	consoleSourceBrowser = "browser"
+)
+
+// consoleEntry is one console message or uncaught exception.
+type consoleEntry struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Level  string    `json:"level"`
+	Text   string    `json:"text"`
+	URL    string    `json:"url,omitempty"`
+	Line   int       `json:"line,omitempty"`
+	Column int       `json:"column,omitempty"`
+	// Stack lists the calling frames, innermost first.
+	Stack []string `json:"stack,omitempty"`
+}
+
+// consoleRecorder collects what a page logs to its console, the exceptions
+// it throws and the browser's own warnings about it.
+type consoleRecorder struct {
+	mu        sync.Mutex
+	entries   []consoleEntry
+	truncated bool
+}
+
+func recordConsole(page *rod.Page) *consoleRecorder {
+	r := &consoleRecorder{}
+
+	go page.EachEvent(
+		func(e *proto.RuntimeConsoleAPICalled) {
+			args := make([]string, 0, len(e.Args))
+			for _, arg := range e.Args {
+				args = append(args, remoteObjectText(arg))
+			}
+			entry := consoleEntry{
+				Time:   consoleTime(e.Timestamp),
+				Source: consoleSourceConsole,
+				Level:  consoleLevel(e.Type),
+				Text:   strings.Join(args, " "),
+			}
+			entry.setStack(e.StackTrace)
+			r.add(entry)
+		},
+		func(e *proto.RuntimeExceptionThrown) {
+			details := e.ExceptionDetails
+			text := details.Text
+			if details.Exception != nil && details.Exception.Description != "" {
+				// The description holds the message and the JS stack.
+				text = details.Exception.Description
+			}
+			entry := consoleEntry{
+				Time:   consoleTime(e.Timestamp),
+				Source: consoleSourceException,
+				Level:  "error",
+				Text:   text,
+				URL:    details.URL,
+				Line:   details.LineNumber + 1,
+				Column: details.ColumnNumber + 1,
+			}
+			entry.setStack(details.StackTrace)
+			r.add(entry)
+		},
+		func(e *proto.LogEntryAdded) {
+			entry := consoleEntry{
+				Time:   consoleTime(e.Entry.Timestamp),
+				Source: consoleSourceBrowser,
+				Level:  string(e.Entry.Level),
+				Text:   e.Entry.Text,
+				URL:    e.Entry.URL,
+			}
+			if e.Entry.LineNumber != nil {
+				entry.Line = *e.Entry.LineNumber + 1
+			}
+			entry.setStack(e.Entry.StackTrace)
+			r.add(entry)
+		},
+	)()
+
+	return r
+}
+
+func (r *consoleRecorder) add(entry consoleEntry) {
+	if len(entry.Text) > maxConsoleText {
+		entry.Text = entry.Text[:maxConsoleText]
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.entries) >= maxConsoleEntries {
+		r.truncated = true
+		return
+	}
+	r.entries = append(r.entries, entry)
+}
+
+// marshal renders the log recorded so far.
+func (r *consoleRecorder) marshal() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := r.entries
+	if entries == nil {
+		entries = []consoleEntry{}
+	}
+	return json.MarshalIndent(struct {
+		Entries   []consoleEntry `json:"entries"`
+		Truncated bool           `json:"truncated,omitempty"`
+	}{entries, r.truncated}, "", "  ")
+}
+
+func (e *consoleEntry) setStack(trace *proto.RuntimeStackTrace) {
+	if trace == nil {
+		return
+	}
+	for i, frame := range trace.CallFrames {
+		name := frame.FunctionName
+		if name == "" {
+			name = "(anonymous)"
+		}
+		e.Stack = append(e.Stack, fmt.Sprintf("%s (%s:%d:%d)", name, frame.URL, frame.LineNumber+1, frame.ColumnNumber+1))
+		if i == 0 && e.URL == "" {
+			e.URL, e.Line, e.Column = frame.URL, frame.LineNumber+1, frame.ColumnNumber+1
+		}
+	}
+}
+
+// remoteObjectText formats a console argument the way DevTools prints it
+// in a single line: strings as-is, primitives by value and objects by
+// their description.
+func remoteObjectText(obj *proto.RuntimeRemoteObject) string {
+	switch {
+	case obj.Type == proto.RuntimeRemoteObjectTypeString:
+		return obj.Value.Str()
+	case obj.UnserializableValue != "":
+		return string(obj.UnserializableValue)
+	case obj.Description != "":
+		return obj.Description
+	case obj.Type == proto.RuntimeRemoteObjectTypeUndefined:
+		return "undefined"
+	default:
+		return obj.Value.JSON("", "")
+	}
+}
+
+// consoleLevel maps console API calls onto the levels the browser uses
+// for its own messages.
+func consoleLevel(t proto.RuntimeConsoleAPICalledType) string {
+	switch t {
+	case proto.RuntimeConsoleAPICalledTypeError, proto.RuntimeConsoleAPICalledTypeAssert:
+		return "error"
+	case proto.RuntimeConsoleAPICalledTypeWarning:
+		return "warning"
+	case proto.RuntimeConsoleAPICalledTypeDebug, proto.RuntimeConsoleAPICalledTypeTrace:
+		return "verbose"
+	default:
+		return "info"
+	}
+}
+
+func consoleTime(ts proto.RuntimeTimestamp) time.Time {
+	if ts == 0 {
+		return time.Now().UTC()
+	}
+	return time.UnixMilli(int64(ts)).UTC()
+}
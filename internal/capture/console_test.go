@@ -0,0 +1,99 @@
+package capture
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+func remoteObject(t *testing.T, raw string) *proto.RuntimeRemoteObject {
+	t.Helper()
+	var obj proto.RuntimeRemoteObject
+	if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+		t.Fatalf("invalid remote object: %v", err)
+	}
+	return &obj
+}
+
+func TestRemoteObjectText(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"string", `{"type":"string","value":"hello"}`, "hello"},
+		{"number", `{"type":"number","value":42,"description":"42"}`, "42"},
+		{"unserializable", `{"type":"number","unserializableValue":"NaN"}`, "NaN"},
+		{"object", `{"type":"object","className":"Object","description":"Object"}`, "Object"},
+		{"error", `{"type":"object","subtype":"error","description":"TypeError: x is undefined"}`, "TypeError: x is undefined"},
+		{"undefined", `{"type":"undefined"}`, "undefined"},
+		{"null", `{"type":"object","subtype":"null","value":null}`, "null"},
+		{"boolean", `{"type":"boolean","value":true}`, "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := remoteObjectText(remoteObject(t, tt.raw)); got != tt.want {
+				t.Errorf("remoteObjectText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsoleLevel(t *testing.T) {
+	tests := map[proto.RuntimeConsoleAPICalledType]string{
+		proto.RuntimeConsoleAPICalledTypeLog:     "info",
+		proto.RuntimeConsoleAPICalledTypeError:   "error",
+		proto.RuntimeConsoleAPICalledTypeAssert:  "error",
+		proto.RuntimeConsoleAPICalledTypeWarning: "warning",
+		proto.RuntimeConsoleAPICalledTypeDebug:   "verbose",
+	}
+	for typ, want := range tests {
+		if got := consoleLevel(typ); got != want {
+			t.Errorf("consoleLevel(%s) = %q, want %q", typ, got, want)
+		}
+	}
+}
+
+func TestConsoleRecorderLimits(t *testing.T) {
+	r := &consoleRecorder{}
+	entry := consoleEntry{Source: consoleSourceException, Level: "error", Text: "ReferenceError: x"}
+	entry.setStack(&proto.RuntimeStackTrace{CallFrames: []*proto.RuntimeCallFrame{
+		{URL: "https://example.com/app.js", LineNumber: 9, ColumnNumber: 4},
+	}})
+	r.add(entry)
+	r.add(consoleEntry{Text: strings.Repeat("x", 2*maxConsoleText)})
+	for i := 0; i < maxConsoleEntries; i++ {
+		r.add(consoleEntry{Text: "spam"})
+	}
+
+	data, err := r.marshal()
+	if err != nil {
+		t.Fatalf("marshal() error = %v", err)
+	}
+	var log struct {
+		Entries   []consoleEntry `json:"entries"`
+		Truncated bool           `json:"truncated"`
+	}
+	if err := json.Unmarshal(data, &log); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(log.Entries) != maxConsoleEntries || !log.Truncated {
+		t.Errorf("got %d entries (truncated %v), want %d truncated", len(log.Entries), log.Truncated, maxConsoleEntries)
+	}
+	first := log.Entries[0]
+	if first.URL != "https://example.com/app.js" || first.Line != 10 || first.Column != 5 ||
+		len(first.Stack) != 1 || first.Stack[0] != "(anonymous) (https://example.com/app.js:10:5)" {
+		t.Errorf("first entry = %+v", first)
+	}
+	if len(log.Entries[1].Text) != maxConsoleText {
+		t.Errorf("long text length = %d, want %d", len(log.Entries[1].Text), maxConsoleText)
+	}
+
+	empty, _ := (&consoleRecorder{}).marshal()
+	if !strings.Contains(string(empty), `"entries": []`) {
+		t.Errorf("empty log = %s", empty)
+	}
+}
@@ -0,0 +1,238 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// Cookie import formats.
+const (
+	CookieFormatHeader   = "header"
+	CookieFormatNetscape = "netscape"
+	CookieFormatJSON     = "json"
+)
+
+const maxImportedCookies = 500
+
+// exportedCookie covers the JSON written by browser extensions such as
+// EditThisCookie and Cookie-Editor, and by Puppeteer and Playwright.
+type exportedCookie struct {
+	Name           string   `json:"name"`
+	Value          string   `json:"value"`
+	Domain         string   `json:"domain"`
+	Path           string   `json:"path"`
+	Secure         bool     `json:"secure"`
+	HTTPOnly       bool     `json:"httpOnly"`
+	HostOnly       bool     `json:"hostOnly"`
+	SameSite       string   `json:"sameSite"`
+	Session        bool     `json:"session"`
+	ExpirationDate *float64 `json:"expirationDate"`
+	Expires        *float64 `json:"expires"`
+}
+
+// DetectCookieFormat guesses the format of imported cookie data.
+func DetectCookieFormat(data string) string {
+	trimmed := strings.TrimSpace(data)
+	switch {
+	case strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{"):
+		return CookieFormatJSON
+	case strings.HasPrefix(trimmed, "# Netscape") || strings.HasPrefix(trimmed, "# HTTP Cookie File") || strings.Contains(trimmed, "\t"):
+		return CookieFormatNetscape
+	default:
+		return CookieFormatHeader
+	}
+}
+
+// ImportCookies parses cookies in the given format, or the detected one when
+// format is empty. Header-style "name=value; ..." cookies carry no domain and
+// are scoped to targetURL. Expired cookies are dropped.
+func ImportCookies(data, format, targetURL string) ([]*proto.NetworkCookieParam, error) {
+	if format == "" {
+		format = DetectCookieFormat(data)
+	}
+
+	var cookies []*proto.NetworkCookieParam
+	var err error
+	switch format {
+	case CookieFormatHeader:
+		cookies = parseCookieHeader(data, targetURL)
+	case CookieFormatNetscape:
+		cookies, err = parseNetscapeCookies(data)
+	case CookieFormatJSON:
+		cookies, err = parseJSONCookies(data)
+	default:
+		return nil, fmt.Errorf("unknown cookie format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(cookies) > maxImportedCookies {
+		return nil, fmt.Errorf("at most %d cookies are allowed", maxImportedCookies)
+	}
+	return cookies, nil
+}
+
+func parseCookieHeader(data, targetURL string) []*proto.NetworkCookieParam {
+	var cookies []*proto.NetworkCookieParam
+	for _, pair := range strings.Split(data, ";") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			continue
+		}
+		cookies = append(cookies, &proto.NetworkCookieParam{
+			Name:  name,
+			Value: strings.TrimSpace(value),
+			URL:   targetURL,
+		})
+	}
+	return cookies
+}
+
+// parseNetscapeCookies reads the cookies.txt format used by curl, wget and
+// browser export extensions: domain, include-subdomains flag, path, secure
+// flag, expiry, name and value, separated by tabs. A "#HttpOnly_" domain
+// prefix marks HttpOnly cookies.
+func parseNetscapeCookies(data string) ([]*proto.NetworkCookieParam, error) {
+	var cookies []*proto.NetworkCookieParam
+	now := time.Now()
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := false
+		if strings.HasPrefix(line, "#HttpOnly_") {
+			httpOnly = true
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("cookies.txt line %d: expected 7 tab-separated fields", lineNo)
+		}
+		domain, subdomains, path, secure, expiry := fields[0], fields[1], fields[2], fields[3], fields[4]
+		name, value := fields[5], strings.Join(fields[6:], "\t")
+
+		expires, err := strconv.ParseFloat(expiry, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cookies.txt line %d: invalid expiry %q", lineNo, expiry)
+		}
+		if expires > 0 && time.Unix(int64(expires), 0).Before(now) {
+			continue
+		}
+
+		cookie := &proto.NetworkCookieParam{
+			Name:     name,
+			Value:    value,
+			Path:     path,
+			Secure:   strings.EqualFold(secure, "TRUE"),
+			HTTPOnly: httpOnly,
+			Expires:  proto.TimeSinceEpoch(expires),
+		}
+		scopeCookie(cookie, domain, !strings.EqualFold(subdomains, "TRUE"))
+		cookies = append(cookies, cookie)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cookies.txt: %w", err)
+	}
+	return cookies, nil
+}
+
+func parseJSONCookies(data string) ([]*proto.NetworkCookieParam, error) {
+	var exported []exportedCookie
+	trimmed := bytes.TrimSpace([]byte(data))
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		// Playwright's storage state wraps the list.
+		var state struct {
+			Cookies []exportedCookie `json:"cookies"`
+		}
+		if err := json.Unmarshal(trimmed, &state); err != nil {
+			return nil, fmt.Errorf("invalid cookie JSON: %w", err)
+		}
+		exported = state.Cookies
+	} else if err := json.Unmarshal(trimmed, &exported); err != nil {
+		return nil, fmt.Errorf("invalid cookie JSON: %w", err)
+	}
+
+	now := time.Now()
+	cookies := make([]*proto.NetworkCookieParam, 0, len(exported))
+	for i := range exported {
+		e := &exported[i]
+		if e.Name == "" {
+			return nil, fmt.Errorf("cookie %d has no name", i+1)
+		}
+		if e.Domain == "" {
+			return nil, fmt.Errorf("cookie %s has no domain", e.Name)
+		}
+
+		var expires float64
+		switch {
+		case e.Session:
+		case e.ExpirationDate != nil:
+			expires = *e.ExpirationDate
+		case e.Expires != nil && *e.Expires > 0:
+			expires = *e.Expires
+		}
+		if expires > 0 && time.Unix(int64(expires), 0).Before(now) {
+			continue
+		}
+
+		cookie := &proto.NetworkCookieParam{
+			Name:     e.Name,
+			Value:    e.Value,
+			Path:     e.Path,
+			Secure:   e.Secure,
+			HTTPOnly: e.HTTPOnly,
+			SameSite: cookieSameSite(e.SameSite),
+			Expires:  proto.TimeSinceEpoch(expires),
+		}
+		scopeCookie(cookie, e.Domain, e.HostOnly)
+		cookies = append(cookies, cookie)
+	}
+	return cookies, nil
+}
+
+// scopeCookie sets the domain of a cookie. Host-only cookies are given a URL
+// instead, since CDP turns any explicit domain into a domain cookie.
+func scopeCookie(cookie *proto.NetworkCookieParam, domain string, hostOnly bool) {
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	if !hostOnly {
+		cookie.Domain = domain
+		return
+	}
+	scheme := "http"
+	if cookie.Secure {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: strings.TrimPrefix(domain, "."), Path: cookie.Path}
+	cookie.URL = u.String()
+}
+
+func cookieSameSite(value string) proto.NetworkCookieSameSite {
+	switch strings.ToLower(value) {
+	case "strict":
+		return proto.NetworkCookieSameSiteStrict
+	case "lax":
+		return proto.NetworkCookieSameSiteLax
+	case "none", "no_restriction":
+		return proto.NetworkCookieSameSiteNone
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,123 @@
+package capture
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+func TestDetectCookieFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"header", "a=1; b=2", CookieFormatHeader},
+		{"netscape header", "# Netscape HTTP Cookie File\n", CookieFormatNetscape},
+		{"netscape tabs", ".example.com\tTRUE\t/\tFALSE\t0\ta\t1", CookieFormatNetscape},
+		{"json array", ` [{"name":"a"}]`, CookieFormatJSON},
+		{"json object", `{"cookies":[]}`, CookieFormatJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectCookieFormat(tt.data); got != tt.want {
+				t.Errorf("DetectCookieFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportCookies(t *testing.T) {
+	netscape := strings.Join([]string{
+		"# Netscape HTTP Cookie File",
+		".example.com\tTRUE\t/\tTRUE\t4102444800\tsession\tabc",
+		"#HttpOnly_www.example.com\tFALSE\t/app\tFALSE\t0\ttoken\txyz",
+		".example.com\tTRUE\t/\tFALSE\t946684800\told\tgone",
+	}, "\n")
+
+	extension := `[
+		{"name":"sid","value":"1","domain":".example.com","path":"/","secure":true,"httpOnly":true,
+		 "sameSite":"no_restriction","expirationDate":4102444800},
+		{"name":"pref","value":"dark","domain":"www.example.com","hostOnly":true,"session":true}
+	]`
+	playwright := `{"cookies":[{"name":"sid","value":"1","domain":".example.com","path":"/","expires":-1,"sameSite":"Lax"}],"origins":[]}`
+
+	tests := []struct {
+		name    string
+		data    string
+		format  string
+		want    []*proto.NetworkCookieParam
+		wantErr bool
+	}{
+		{
+			name: "header",
+			data: "a=1; b = 2 ;bad",
+			want: []*proto.NetworkCookieParam{
+				{Name: "a", Value: "1", URL: "https://example.com/page"},
+				{Name: "b", Value: "2", URL: "https://example.com/page"},
+			},
+		},
+		{
+			name:   "netscape",
+			data:   netscape,
+			format: CookieFormatNetscape,
+			want: []*proto.NetworkCookieParam{
+				{Name: "session", Value: "abc", Domain: ".example.com", Path: "/", Secure: true, Expires: 4102444800},
+				{Name: "token", Value: "xyz", URL: "http://www.example.com/app", Path: "/app", HTTPOnly: true},
+			},
+		},
+		{
+			name: "extension json",
+			data: extension,
+			want: []*proto.NetworkCookieParam{
+				{Name: "sid", Value: "1", Domain: ".example.com", Path: "/", Secure: true, HTTPOnly: true,
+					SameSite: proto.NetworkCookieSameSiteNone, Expires: 4102444800},
+				{Name: "pref", Value: "dark", URL: "http://www.example.com/", Path: "/"},
+			},
+		},
+		{
+			name: "playwright storage state",
+			data: playwright,
+			want: []*proto.NetworkCookieParam{
+				{Name: "sid", Value: "1", Domain: ".example.com", Path: "/", SameSite: proto.NetworkCookieSameSiteLax},
+			},
+		},
+		{"netscape missing fields", "example.com\tTRUE\t/", CookieFormatNetscape, nil, true},
+		{"netscape bad expiry", "example.com\tTRUE\t/\tFALSE\tsoon\ta\t1", CookieFormatNetscape, nil, true},
+		{"json without domain", `[{"name":"a","value":"1"}]`, "", nil, true},
+		{"invalid json", `[{"name":`, CookieFormatJSON, nil, true},
+		{"unknown format", "a=1", "yaml", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ImportCookies(tt.data, tt.format, "https://example.com/page")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ImportCookies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ImportCookies() returned %d cookies, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if *got[i] != *tt.want[i] {
+					t.Errorf("cookie %d = %+v, want %+v", i, *got[i], *tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestImportCookiesLimit(t *testing.T) {
+	pairs := make([]string, maxImportedCookies+1)
+	for i := range pairs {
+		pairs[i] = "c=1"
+	}
+	if _, err := ImportCookies(strings.Join(pairs, ";"), CookieFormatHeader, "https://example.com"); err == nil {
+		t.Error("ImportCookies() should reject too many cookies")
+	}
+}
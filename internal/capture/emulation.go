@@ -0,0 +1,241 @@
+package capture
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	// Timezone names are validated with time.LoadLocation, which must not
+	// depend on the host having tzdata installed.
+	_ "time/tzdata"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// DevicePreset describes an emulated device. Presets replace the viewport,
+// scale factor and user agent of a capture.
+type DevicePreset struct {
+	Width             int
+	Height            int
+	DeviceScaleFactor float64
+	Mobile            bool
+	Touch             bool
+	UserAgent         string
+}
+
+const (
+	iosSafariUA     = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1"
+	ipadSafariUA    = "Mozilla/5.0 (iPad; CPU OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1"
+	androidChromeUA = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Mobile Safari/537.36"
+)
+
+// devicePresets are keyed by the name accepted in EmulationOptions.Device.
+var devicePresets = map[string]DevicePreset{
+	"iphone-se":         {Width: 375, Height: 667, DeviceScaleFactor: 2, Mobile: true, Touch: true, UserAgent: iosSafariUA},
+	"iphone-15":         {Width: 393, Height: 852, DeviceScaleFactor: 3, Mobile: true, Touch: true, UserAgent: iosSafariUA},
+	"iphone-15-pro-max": {Width: 430, Height: 932, DeviceScaleFactor: 3, Mobile: true, Touch: true, UserAgent: iosSafariUA},
+	"pixel-8":           {Width: 412, Height: 915, DeviceScaleFactor: 2.625, Mobile: true, Touch: true, UserAgent: androidChromeUA},
+	"ipad":              {Width: 820, Height: 1180, DeviceScaleFactor: 2, Mobile: true, Touch: true, UserAgent: ipadSafariUA},
+	"ipad-pro":          {Width: 1024, Height: 1366, DeviceScaleFactor: 2, Mobile: true, Touch: true, UserAgent: ipadSafariUA},
+}
+
+// Emulated media values.
+const (
+	MediaScreen = "screen"
+	MediaPrint  = "print"
+)
+
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+type Geolocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// Accuracy is in meters; zero means 100.
+	Accuracy float64 `json:"accuracy,omitempty"`
+}
+
+// EmulationOptions controls how the page sees its environment.
+type EmulationOptions struct {
+	// Device selects a preset such as "iphone-15" or "pixel-8".
+	Device    string `json:"device,omitempty"`
+	Landscape bool   `json:"landscape,omitempty"`
+	// Locale is a BCP 47 tag like "de-DE"; it sets navigator.language, Intl
+	// defaults and the Accept-Language header.
+	Locale string `json:"locale,omitempty"`
+	// Timezone is an IANA name like "Europe/Berlin".
+	Timezone    string       `json:"timezone,omitempty"`
+	Geolocation *Geolocation `json:"geolocation,omitempty"`
+	// ColorScheme sets prefers-color-scheme: light, dark or no-preference.
+	ColorScheme string `json:"color_scheme,omitempty"`
+	// Media emulates screen or print CSS media.
+	Media string `json:"media,omitempty"`
+}
+
+// Validate reports the first invalid option.
+func (o *EmulationOptions) Validate() error {
+	if o.Device != "" {
+		if _, ok := devicePresets[strings.ToLower(o.Device)]; !ok {
+			return fmt.Errorf("unknown device: %s", o.Device)
+		}
+	}
+	if o.Locale != "" && !localePattern.MatchString(o.Locale) {
+		return fmt.Errorf("invalid locale: %s", o.Locale)
+	}
+	if o.Timezone != "" {
+		if _, err := time.LoadLocation(o.Timezone); err != nil || o.Timezone == "Local" {
+			return fmt.Errorf("unknown timezone: %s", o.Timezone)
+		}
+	}
+	if g := o.Geolocation; g != nil {
+		if g.Latitude < -90 || g.Latitude > 90 || g.Longitude < -180 || g.Longitude > 180 {
+			return fmt.Errorf("geolocation is out of range")
+		}
+		if g.Accuracy < 0 {
+			return fmt.Errorf("geolocation accuracy must not be negative")
+		}
+	}
+	switch o.ColorScheme {
+	case "", "light", "dark", "no-preference":
+	default:
+		return fmt.Errorf("color_scheme must be light, dark or no-preference")
+	}
+	switch o.Media {
+	case "", MediaScreen, MediaPrint:
+	default:
+		return fmt.Errorf("media must be screen or print")
+	}
+	return nil
+}
+
+// acceptLanguage builds an Accept-Language value preferring the full
+// locale, then its base language.
+func acceptLanguage(locale string) string {
+	base, _, found := strings.Cut(locale, "-")
+	if !found {
+		return locale
+	}
+	return locale + "," + base + ";q=0.9"
+}
+
+// emulate applies viewport, device, locale and environment overrides to a
+// page before navigation.
+//
+//nolint:gocyclo // each override is a separate, optional CDP call
+func (b *Browser) emulate(page *rod.Page, browserContext *rod.Browser, opts *CaptureOptions) error {
+	em := opts.Emulation
+	if em == nil {
+		em = &EmulationOptions{}
+	}
+
+	metrics := &proto.EmulationSetDeviceMetricsOverride{
+		Width:             opts.ViewportWidth,
+		Height:            opts.ViewportHeight,
+		DeviceScaleFactor: opts.DeviceScaleFactor,
+	}
+	if metrics.Width == 0 {
+		metrics.Width = b.config.ViewportWidth
+	}
+	if metrics.Height == 0 {
+		metrics.Height = b.config.ViewportHeight
+	}
+
+	userAgent := opts.UserAgent
+	var preset DevicePreset
+	if em.Device != "" {
+		preset = devicePresets[strings.ToLower(em.Device)]
+		metrics.Width, metrics.Height = preset.Width, preset.Height
+		metrics.Mobile = preset.Mobile
+		if metrics.DeviceScaleFactor == 0 {
+			metrics.DeviceScaleFactor = preset.DeviceScaleFactor
+		}
+		if userAgent == "" {
+			userAgent = preset.UserAgent
+		}
+	}
+	if em.Landscape {
+		metrics.Width, metrics.Height = metrics.Height, metrics.Width
+		metrics.ScreenOrientation = &proto.EmulationScreenOrientation{
+			Type:  proto.EmulationScreenOrientationTypeLandscapePrimary,
+			Angle: 90,
+		}
+	}
+	if err := page.SetViewport(metrics); err != nil {
+		return fmt.Errorf("failed to set viewport: %w", err)
+	}
+
+	if preset.Touch {
+		maxTouchPoints := 5
+		if err := (proto.EmulationSetTouchEmulationEnabled{
+			Enabled:        true,
+			MaxTouchPoints: &maxTouchPoints,
+		}).Call(page); err != nil {
+			return fmt.Errorf("failed to enable touch emulation: %w", err)
+		}
+	}
+
+	if userAgent != "" || em.Locale != "" {
+		if userAgent == "" {
+			// The override needs a user agent even when only the language
+			// changes, so keep the browser's own.
+			version, err := (proto.BrowserGetVersion{}).Call(page)
+			if err != nil {
+				return fmt.Errorf("failed to read user agent: %w", err)
+			}
+			userAgent = version.UserAgent
+		}
+		override := &proto.NetworkSetUserAgentOverride{UserAgent: userAgent}
+		if em.Locale != "" {
+			override.AcceptLanguage = acceptLanguage(em.Locale)
+		}
+		if err := page.SetUserAgent(override); err != nil {
+			return fmt.Errorf("failed to set user agent: %w", err)
+		}
+	}
+
+	if em.Locale != "" {
+		if err := (proto.EmulationSetLocaleOverride{Locale: em.Locale}).Call(page); err != nil {
+			return fmt.Errorf("failed to set locale: %w", err)
+		}
+	}
+
+	if em.Timezone != "" {
+		if err := (proto.EmulationSetTimezoneOverride{TimezoneID: em.Timezone}).Call(page); err != nil {
+			return fmt.Errorf("failed to set timezone: %w", err)
+		}
+	}
+
+	if g := em.Geolocation; g != nil {
+		if err := (proto.BrowserGrantPermissions{
+			Permissions:      []proto.BrowserPermissionType{proto.BrowserPermissionTypeGeolocation},
+			BrowserContextID: browserContext.BrowserContextID,
+		}).Call(browserContext); err != nil {
+			return fmt.Errorf("failed to grant geolocation permission: %w", err)
+		}
+		latitude, longitude, accuracy := g.Latitude, g.Longitude, g.Accuracy
+		if accuracy == 0 {
+			accuracy = 100
+		}
+		if err := (proto.EmulationSetGeolocationOverride{
+			Latitude:  &latitude,
+			Longitude: &longitude,
+			Accuracy:  &accuracy,
+		}).Call(page); err != nil {
+			return fmt.Errorf("failed to set geolocation: %w", err)
+		}
+	}
+
+	if em.ColorScheme != "" || em.Media != "" {
+		media := &proto.EmulationSetEmulatedMedia{Media: em.Media}
+		if em.ColorScheme != "" {
+			media.Features = []*proto.EmulationMediaFeature{
+				{Name: "prefers-color-scheme", Value: em.ColorScheme},
+			}
+		}
+		if err := media.Call(page); err != nil {
+			return fmt.Errorf("failed to set emulated media: %w", err)
+		}
+	}
+
+	return nil
+}
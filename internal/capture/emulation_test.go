@@ -0,0 +1,55 @@
+package capture
+
+import (
+	"testing"
+)
+
+func TestEmulationOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    EmulationOptions
+		wantErr bool
+	}{
+		{"empty", EmulationOptions{}, false},
+		{"device preset", EmulationOptions{Device: "iPhone-15", Landscape: true}, false},
+		{"locale and timezone", EmulationOptions{Locale: "de-DE", Timezone: "Europe/Berlin"}, false},
+		{"geolocation", EmulationOptions{Geolocation: &Geolocation{Latitude: 52.52, Longitude: 13.4}}, false},
+		{"dark print", EmulationOptions{ColorScheme: "dark", Media: MediaPrint}, false},
+		{"unknown device", EmulationOptions{Device: "nokia-3310"}, true},
+		{"invalid locale", EmulationOptions{Locale: "german please"}, true},
+		{"unknown timezone", EmulationOptions{Timezone: "Mars/Olympus"}, true},
+		{"local timezone", EmulationOptions{Timezone: "Local"}, true},
+		{"latitude out of range", EmulationOptions{Geolocation: &Geolocation{Latitude: 91}}, true},
+		{"negative accuracy", EmulationOptions{Geolocation: &Geolocation{Accuracy: -1}}, true},
+		{"invalid color scheme", EmulationOptions{ColorScheme: "sepia"}, true},
+		{"invalid media", EmulationOptions{Media: "tv"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{"en", "en"},
+		{"de-DE", "de-DE,de;q=0.9"},
+		{"zh-Hant-TW", "zh-Hant-TW,zh;q=0.9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			if got := acceptLanguage(tt.locale); got != tt.want {
+				t.Errorf("acceptLanguage(%q) = %q, want %q", tt.locale, got, tt.want)
+			}
+		})
+	}
+}
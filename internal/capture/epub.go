@@ -0,0 +1,345 @@
+package capture
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxEPUBImages bounds how many article images are downloaded into an EPUB.
+const maxEPUBImages = 100
+
+// epubImageTypes are the image core media types of EPUB 3, mapped to the
+// file extensions used inside the package.
+var epubImageTypes = map[string]string{
+	"image/jpeg":    "jpg",
+	"image/png":     "png",
+	"image/gif":     "gif",
+	"image/webp":    "webp",
+	"image/svg+xml": "svg",
+}
+
+// xhtmlTags are written to the EPUB as they are. Other elements are
+// replaced by their children so the document stays valid XHTML.
+var xhtmlTags = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.Article: true, atom.B: true,
+	atom.Blockquote: true, atom.Br: true, atom.Caption: true, atom.Cite: true,
+	atom.Code: true, atom.Dd: true, atom.Del: true, atom.Dfn: true, atom.Div: true,
+	atom.Dl: true, atom.Dt: true, atom.Em: true, atom.Figcaption: true,
+	atom.Figure: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true,
+	atom.H5: true, atom.H6: true, atom.Header: true, atom.Hr: true, atom.I: true,
+	atom.Img: true, atom.Ins: true, atom.Kbd: true, atom.Li: true, atom.Mark: true,
+	atom.Ol: true, atom.P: true, atom.Pre: true, atom.Q: true, atom.S: true,
+	atom.Samp: true, atom.Section: true, atom.Small: true, atom.Span: true,
+	atom.Strong: true, atom.Sub: true, atom.Sup: true, atom.Table: true,
+	atom.Tbody: true, atom.Td: true, atom.Tfoot: true, atom.Th: true,
+	atom.Thead: true, atom.Tr: true, atom.U: true, atom.Ul: true, atom.Var: true,
+}
+
+var voidTags = map[atom.Atom]bool{atom.Br: true, atom.Hr: true, atom.Img: true}
+
+// epubImage is an article image packaged into the EPUB.
+type epubImage struct {
+	Path        string
+	ContentType string
+	Data        []byte
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const epubStyle = `body { font-family: serif; line-height: 1.5; margin: 0 1em; }
+h1, h2, h3, h4, h5, h6 { font-family: sans-serif; line-height: 1.2; }
+img { max-width: 100%; height: auto; }
+pre { white-space: pre-wrap; font-size: 0.85em; }
+blockquote { margin-left: 1em; padding-left: 1em; border-left: 3px solid #ccc; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 0.25em 0.5em; }
+.meta { color: #555; font-size: 0.9em; }
+`
+
+const epubOPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid" xml:lang="{{x .Lang}}">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="uid">{{x .ID}}</dc:identifier>
+    <dc:title>{{x .Title}}</dc:title>
+    <dc:language>{{x .Lang}}</dc:language>
+{{- if .Byline}}
+    <dc:creator>{{x .Byline}}</dc:creator>
+{{- end}}
+{{- if .SiteName}}
+    <dc:publisher>{{x .SiteName}}</dc:publisher>
+{{- end}}
+{{- if .Excerpt}}
+    <dc:description>{{x .Excerpt}}</dc:description>
+{{- end}}
+    <dc:source>{{x .URL}}</dc:source>
+    <dc:date>{{.Date}}</dc:date>
+    <meta property="dcterms:modified">{{.Date}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+    <item id="article" href="article.xhtml" media-type="application/xhtml+xml"/>
+{{- range $i, $img := .Images}}
+    <item id="img{{$i}}" href="{{x $img.Path}}" media-type="{{$img.ContentType}}"/>
+{{- end}}
+  </manifest>
+  <spine>
+    <itemref idref="article"/>
+  </spine>
+</package>
+`
+
+const epubNav = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="{{x .Lang}}" lang="{{x .Lang}}">
+<head><title>{{x .Title}}</title></head>
+<body>
+<nav epub:type="toc" id="toc">
+<ol><li><a href="article.xhtml">{{x .Title}}</a></li></ol>
+</nav>
+</body>
+</html>
+`
+
+const epubArticle = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="{{x .Lang}}" lang="{{x .Lang}}">
+<head>
+<title>{{x .Title}}</title>
+<link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+<h1>{{x .Title}}</h1>
+{{- if .Byline}}
+<p class="meta">{{x .Byline}}</p>
+{{- end}}
+<p class="meta"><a href="{{x .URL}}">{{x .URL}}</a><br/>Captured {{.Captured}}</p>
+{{.Body}}
+</body>
+</html>
+`
+
+var epubTemplates = func() *template.Template {
+	t := template.New("epub").Funcs(template.FuncMap{"x": xmlEscape})
+	template.Must(t.New("opf").Parse(epubOPF))
+	template.Must(t.New("nav").Parse(epubNav))
+	template.Must(t.New("article").Parse(epubArticle))
+	return t
+}()
+
+// buildEPUB packages an article as an EPUB 3 book. images maps the
+// absolute source URLs used in the article to their downloaded data;
+// images that were not downloaded are left out.
+func buildEPUB(article *Article, capturedAt time.Time, images map[string]*epubImage) ([]byte, error) {
+	title := article.Title
+	if title == "" {
+		title = article.URL
+	}
+	lang := article.Lang
+	if lang == "" {
+		lang = "en"
+	}
+
+	var body strings.Builder
+	writeXHTML(&body, article.Content, func(src string) (string, bool) {
+		img, ok := images[src]
+		if !ok {
+			return "", false
+		}
+		return img.Path, true
+	})
+
+	// The manifest must list each image once, in a stable order.
+	var manifest []*epubImage
+	seen := make(map[string]bool)
+	walk(article.Content, func(n *nethtml.Node) bool {
+		if n.DataAtom == atom.Img {
+			if img, ok := images[getAttr(n, "src")]; ok && !seen[img.Path] {
+				seen[img.Path] = true
+				manifest = append(manifest, img)
+			}
+		}
+		return true
+	})
+
+	data := map[string]interface{}{
+		"ID":       "urn:uuid:" + uuid.NewSHA1(uuid.NameSpaceURL, []byte(article.URL+"@"+capturedAt.UTC().Format(time.RFC3339Nano))).String(),
+		"Title":    title,
+		"Lang":     lang,
+		"Byline":   article.Byline,
+		"SiteName": article.SiteName,
+		"Excerpt":  article.Excerpt,
+		"URL":      article.URL,
+		"Date":     capturedAt.UTC().Format("2006-01-02T15:04:05Z"),
+		"Captured": capturedAt.UTC().Format("2 January 2006 15:04 MST"),
+		"Images":   manifest,
+		"Body":     body.String(),
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	// The mimetype entry must come first and be stored uncompressed.
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte("application/epub+zip")); err != nil {
+		return nil, err
+	}
+
+	type epubFile struct {
+		name     string
+		template string
+		content  []byte
+	}
+	files := []epubFile{
+		{name: "META-INF/container.xml", content: []byte(epubContainer)},
+		{name: "OEBPS/content.opf", template: "opf"},
+		{name: "OEBPS/nav.xhtml", template: "nav"},
+		{name: "OEBPS/style.css", content: []byte(epubStyle)},
+		{name: "OEBPS/article.xhtml", template: "article"},
+	}
+	for _, img := range manifest {
+		files = append(files, epubFile{name: "OEBPS/" + img.Path, content: img.Data})
+	}
+
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if f.template != "" {
+			err = epubTemplates.ExecuteTemplate(w, f.template, data)
+		} else {
+			_, err = w.Write(f.content)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeXHTML serializes the children of n as XHTML. rewriteSrc maps image
+// sources to package paths; images it rejects are dropped.
+func writeXHTML(sb *strings.Builder, n *nethtml.Node, rewriteSrc func(string) (string, bool)) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case nethtml.TextNode:
+			sb.WriteString(xmlEscape(c.Data))
+			continue
+		case nethtml.ElementNode:
+		default:
+			continue
+		}
+
+		if !xhtmlTags[c.DataAtom] {
+			writeXHTML(sb, c, rewriteSrc)
+			continue
+		}
+
+		attrs := c.Attr
+		if c.DataAtom == atom.Img {
+			path, ok := rewriteSrc(getAttr(c, "src"))
+			if !ok {
+				continue
+			}
+			attrs = []nethtml.Attribute{{Key: "src", Val: path}, {Key: "alt", Val: getAttr(c, "alt")}}
+		}
+
+		sb.WriteString("<" + c.Data)
+		for _, a := range attrs {
+			if a.Key == "href" && a.Val == "" {
+				continue
+			}
+			sb.WriteString(" " + a.Key + `="` + xmlEscape(a.Val) + `"`)
+		}
+		if voidTags[c.DataAtom] {
+			sb.WriteString("/>")
+			continue
+		}
+		sb.WriteString(">")
+		writeXHTML(sb, c, rewriteSrc)
+		sb.WriteString("</" + c.Data + ">")
+	}
+}
+
+// xmlEscape escapes s for XML text and attributes, dropping characters XML
+// does not allow.
+func xmlEscape(s string) string {
+	return html.EscapeString(strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || r >= 0x20 && r != 0xFFFE && r != 0xFFFF {
+			return r
+		}
+		return -1
+	}, s))
+}
+
+// fetchEPUBImages downloads the article's images with the page's cookies.
+// Images that fail or are not an EPUB image type are skipped.
+func fetchEPUBImages(ctx context.Context, inliner *Inliner, article *Article) map[string]*epubImage {
+	sources := make(map[string]*url.URL)
+	walk(article.Content, func(n *nethtml.Node) bool {
+		if n.DataAtom != atom.Img || len(sources) >= maxEPUBImages {
+			return true
+		}
+		src := getAttr(n, "src")
+		if _, ok := sources[src]; ok {
+			return true
+		}
+		if u, err := inliner.resolve(inliner.baseURL, src); err == nil {
+			sources[src] = u
+		}
+		return true
+	})
+
+	images := make(map[string]*epubImage)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for src, u := range sources {
+		wg.Add(1)
+		go func(src string, u *url.URL) {
+			defer wg.Done()
+			res := inliner.fetch(ctx, u)
+			if res.err != nil {
+				return
+			}
+			ext, ok := epubImageTypes[res.contentType]
+			if !ok || !inliner.reserve(len(res.content)) {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			images[src] = &epubImage{
+				Path:        fmt.Sprintf("images/%03d.%s", len(images)+1, ext),
+				ContentType: res.contentType,
+				Data:        res.content,
+			}
+		}(src, u)
+	}
+	wg.Wait()
+	return images
+}
@@ -0,0 +1,135 @@
+package capture
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readZip(t *testing.T, data []byte) (*zip.Reader, map[string]string) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Open(%s) error = %v", f.Name, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+	return zr, files
+}
+
+func checkWellFormed(t *testing.T, name, content string) {
+	t.Helper()
+	dec := xml.NewDecoder(strings.NewReader(content))
+	dec.Strict = true
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("%s is not well-formed XML: %v\n%s", name, err, content)
+		}
+	}
+}
+
+func TestBuildEPUB(t *testing.T) {
+	page := strings.Replace(testArticlePage, `<h2>Why they matter</h2>`,
+		`<h2>Why they matter</h2><p>Delta &amp; estuary<br>notes <custom-tag>kept</custom-tag> <img src="/img/missing.png" alt="gone"></p>`, 1)
+	article, err := ExtractArticle([]byte(page), "https://news.example.com/2024/rivers")
+	if err != nil {
+		t.Fatalf("ExtractArticle() error = %v", err)
+	}
+
+	images := map[string]*epubImage{
+		"https://news.example.com/img/nile.jpg": {Path: "images/001.jpg", ContentType: "image/jpeg", Data: []byte("jpeg")},
+	}
+	capturedAt := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	data, err := buildEPUB(article, capturedAt, images)
+	if err != nil {
+		t.Fatalf("buildEPUB() error = %v", err)
+	}
+
+	zr, files := readZip(t, data)
+	if first := zr.File[0]; first.Name != "mimetype" || first.Method != zip.Store || files["mimetype"] != "application/epub+zip" {
+		t.Errorf("first entry = %s (method %d), want stored mimetype", first.Name, first.Method)
+	}
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/content.opf", "OEBPS/nav.xhtml", "OEBPS/article.xhtml"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("missing %s", name)
+		}
+		checkWellFormed(t, name, files[name])
+	}
+	if files["OEBPS/images/001.jpg"] != "jpeg" {
+		t.Error("image not packaged")
+	}
+
+	opf := files["OEBPS/content.opf"]
+	for _, want := range []string{
+		"<dc:title>Rivers of the World</dc:title>",
+		"<dc:creator>Jane Doe</dc:creator>",
+		"<dc:language>en</dc:language>",
+		"<dc:source>https://news.example.com/2024/rivers</dc:source>",
+		`<meta property="dcterms:modified">2024-05-01T12:30:00Z</meta>`,
+		`href="images/001.jpg" media-type="image/jpeg"`,
+	} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("content.opf missing %q", want)
+		}
+	}
+
+	body := files["OEBPS/article.xhtml"]
+	for _, want := range []string{`<img src="images/001.jpg" alt="The Nile at dusk"/>`, "Delta &amp; estuary<br/>notes kept", "Captured 1 May 2024"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("article.xhtml missing %q", want)
+		}
+	}
+	if strings.Contains(body, "missing.png") || strings.Contains(body, "custom-tag") {
+		t.Error("article.xhtml should drop unfetched images and unknown elements")
+	}
+}
+
+func TestFetchEPUBImages(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png"))
+	})
+	mux.HandleFunc("/b.tiff", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/tiff")
+		_, _ = w.Write([]byte("tiff"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	page := `<html><body><article><p>Long enough paragraph of article text, with a comma or two, to be chosen.</p>
+<img src="/a.png" alt="a"><img src="/a.png" alt="again"><img src="/b.tiff"><img src="/404.png"></article></body></html>`
+	article, err := ExtractArticle([]byte(page), srv.URL+"/post")
+	if err != nil {
+		t.Fatalf("ExtractArticle() error = %v", err)
+	}
+
+	inliner := newTestInliner(t, srv.URL+"/post", nil)
+	images := fetchEPUBImages(context.Background(), inliner, article)
+	if len(images) != 1 {
+		t.Fatalf("fetched %d images, want only the PNG: %v", len(images), images)
+	}
+	img := images[srv.URL+"/a.png"]
+	if img == nil || img.ContentType != "image/png" || !strings.HasSuffix(img.Path, ".png") {
+		t.Errorf("image = %+v, want PNG", img)
+	}
+}
@@ -0,0 +1,489 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-rod/rod/lib/proto"
+	"golang.org/x/net/publicsuffix"
+)
+
+//go:embed filters/default.txt
+var defaultFilterRules []byte
+
+// BlockingOptions turns on content blocking for a capture.
+type BlockingOptions struct {
+	// Ads blocks ad and tracker requests and hides ad placeholders.
+	Ads bool `json:"ads,omitempty"`
+	// CookieBanners dismisses cookie consent dialogs once the page is ready.
+	CookieBanners bool `json:"cookie_banners,omitempty"`
+}
+
+// FilterList matches browser requests against EasyList-style rules. It
+// supports the subset that covers most ad and tracker blocking: domain
+// anchors (||example.com^), URL patterns with * and ^, exceptions (@@), the
+// third-party and resource type options, and element hiding (##). Rules
+// using other options are skipped rather than applied too broadly.
+type FilterList struct {
+	blockDomains map[string]bool
+	allowDomains map[string]bool
+	block        ruleIndex
+	allow        ruleIndex
+	// hide maps a site ("" for all sites) to element hiding selectors.
+	hide map[string][]string
+}
+
+type filterRule struct {
+	pattern *regexp.Regexp
+	// party is 1 for third-party only, -1 for first-party only, 0 for both.
+	party int
+	// types limits the rule to these resource types; nil matches all.
+	types map[proto.NetworkResourceType]bool
+}
+
+var filterResourceTypes = map[string][]proto.NetworkResourceType{
+	"script":         {proto.NetworkResourceTypeScript},
+	"image":          {proto.NetworkResourceTypeImage},
+	"stylesheet":     {proto.NetworkResourceTypeStylesheet},
+	"font":           {proto.NetworkResourceTypeFont},
+	"media":          {proto.NetworkResourceTypeMedia},
+	"xmlhttprequest": {proto.NetworkResourceTypeXHR, proto.NetworkResourceTypeFetch},
+	"subdocument":    {proto.NetworkResourceTypeDocument},
+	"websocket":      {proto.NetworkResourceTypeWebSocket},
+	"ping":           {proto.NetworkResourceTypePing},
+	"other":          {proto.NetworkResourceTypeOther},
+}
+
+var (
+	defaultFilterOnce sync.Once
+	defaultFilter     *FilterList
+)
+
+// DefaultFilterList returns the bundled list.
+func DefaultFilterList() *FilterList {
+	defaultFilterOnce.Do(func() {
+		defaultFilter = NewFilterList()
+		_ = defaultFilter.Parse(bytes.NewReader(defaultFilterRules))
+	})
+	return defaultFilter
+}
+
+// LoadFilterList parses the bundled list followed by each file in paths.
+func LoadFilterList(paths ...string) (*FilterList, error) {
+	f := NewFilterList()
+	if err := f.Parse(bytes.NewReader(defaultFilterRules)); err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open filter list: %w", err)
+		}
+		err = f.Parse(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read filter list %s: %w", path, err)
+		}
+	}
+	return f, nil
+}
+
+func NewFilterList() *FilterList {
+	return &FilterList{
+		blockDomains: make(map[string]bool),
+		allowDomains: make(map[string]bool),
+		block:        ruleIndex{byToken: make(map[string][]*filterRule)},
+		allow:        ruleIndex{byToken: make(map[string][]*filterRule)},
+		hide:         make(map[string][]string),
+	}
+}
+
+// Parse adds the rules read from r. Comments and unsupported rules are
+// ignored.
+func (f *FilterList) Parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		f.addRule(strings.TrimSpace(scanner.Text()))
+	}
+	return scanner.Err()
+}
+
+func (f *FilterList) addRule(line string) {
+	if line == "" || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") {
+		return
+	}
+
+	if sites, selector, ok := strings.Cut(line, "##"); ok {
+		f.addHidingRule(sites, selector)
+		return
+	}
+	// Hiding exceptions and extended syntaxes (#@#, #?#, #$#) are not
+	// supported.
+	if strings.Contains(line, "#@#") || strings.Contains(line, "#?#") || strings.Contains(line, "#$#") {
+		return
+	}
+
+	exception := strings.HasPrefix(line, "@@")
+	line = strings.TrimPrefix(line, "@@")
+
+	pattern, options, _ := strings.Cut(line, "$")
+	// Regular expression rules are rare in practice and expensive to match.
+	if pattern == "" || (strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") && len(pattern) > 1) {
+		return
+	}
+
+	rule := &filterRule{}
+	if options != "" && !rule.parseOptions(options) {
+		return
+	}
+
+	if host, ok := plainDomainAnchor(pattern); ok && rule.party == 0 && rule.types == nil {
+		if exception {
+			f.allowDomains[host] = true
+		} else {
+			f.blockDomains[host] = true
+		}
+		return
+	}
+
+	re, err := regexp.Compile(filterPatternToRegexp(pattern))
+	if err != nil {
+		return
+	}
+	rule.pattern = re
+	if exception {
+		f.allow.add(rule, pattern)
+	} else {
+		f.block.add(rule, pattern)
+	}
+}
+
+func (f *FilterList) addHidingRule(sites, selector string) {
+	selector = strings.TrimSpace(selector)
+	if selector == "" || strings.ContainsAny(selector, "{}") {
+		return
+	}
+	if sites == "" {
+		f.hide[""] = append(f.hide[""], selector)
+		return
+	}
+	for _, site := range strings.Split(sites, ",") {
+		site = strings.ToLower(strings.TrimSpace(site))
+		if site == "" || strings.HasPrefix(site, "~") {
+			continue
+		}
+		f.hide[site] = append(f.hide[site], selector)
+	}
+}
+
+// parseOptions applies the $-options of a rule and reports whether all of
+// them are supported.
+func (r *filterRule) parseOptions(options string) bool {
+	var include, exclude []proto.NetworkResourceType
+	for _, opt := range strings.Split(options, ",") {
+		opt = strings.ToLower(strings.TrimSpace(opt))
+		switch opt {
+		case "third-party", "3p":
+			r.party = 1
+		case "~third-party", "first-party", "1p":
+			r.party = -1
+		case "important", "match-case":
+			// Matching is always case-insensitive and there is no rule
+			// priority beyond exceptions.
+		default:
+			negated := strings.HasPrefix(opt, "~")
+			types, ok := filterResourceTypes[strings.TrimPrefix(opt, "~")]
+			if !ok {
+				return false
+			}
+			if negated {
+				exclude = append(exclude, types...)
+			} else {
+				include = append(include, types...)
+			}
+		}
+	}
+
+	switch {
+	case len(include) > 0:
+		r.types = make(map[proto.NetworkResourceType]bool)
+		for _, t := range include {
+			r.types[t] = true
+		}
+	case len(exclude) > 0:
+		r.types = make(map[proto.NetworkResourceType]bool)
+		for _, types := range filterResourceTypes {
+			for _, t := range types {
+				r.types[t] = true
+			}
+		}
+		for _, t := range exclude {
+			delete(r.types, t)
+		}
+	}
+	return true
+}
+
+func (r *filterRule) matches(rawURL string, resourceType proto.NetworkResourceType, thirdParty bool) bool {
+	if r.party == 1 && !thirdParty || r.party == -1 && thirdParty {
+		return false
+	}
+	if r.types != nil && !r.types[resourceType] {
+		return false
+	}
+	return r.pattern.MatchString(rawURL)
+}
+
+var plainDomainPattern = regexp.MustCompile(`^\|\|([a-z0-9.-]+)\^?$`)
+
+// plainDomainAnchor recognises ||example.com^, which is matched by a map
+// lookup instead of a regular expression.
+func plainDomainAnchor(pattern string) (string, bool) {
+	m := plainDomainPattern.FindStringSubmatch(strings.ToLower(pattern))
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// filterPatternToRegexp translates an Adblock Plus URL pattern: || anchors
+// to a domain, | to the start or end of the URL, * to anything and ^ to a
+// separator character or the end of the URL.
+func filterPatternToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("(?i)")
+	switch {
+	case strings.HasPrefix(pattern, "||"):
+		b.WriteString(`^[a-z][a-z0-9+.-]*://([^/?#]*\.)?`)
+		pattern = pattern[2:]
+	case strings.HasPrefix(pattern, "|"):
+		b.WriteString("^")
+		pattern = pattern[1:]
+	}
+	anchorEnd := strings.HasSuffix(pattern, "|")
+	pattern = strings.TrimSuffix(pattern, "|")
+
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '^':
+			b.WriteString(`(?:[^a-z0-9_.%-]|$)`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if anchorEnd {
+		b.WriteString("$")
+	}
+	return b.String()
+}
+
+// Blocks reports whether a request should be blocked. thirdParty tells
+// whether the request goes to a different site than the page.
+func (f *FilterList) Blocks(u *url.URL, resourceType proto.NetworkResourceType, thirdParty bool) bool {
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ws" && u.Scheme != "wss" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	raw := u.String()
+
+	var tokens []string
+	if !matchDomain(f.blockDomains, host) {
+		tokens = urlTokens(raw)
+		if !f.block.matches(raw, tokens, resourceType, thirdParty) {
+			return false
+		}
+	}
+
+	if matchDomain(f.allowDomains, host) {
+		return false
+	}
+	if tokens == nil {
+		tokens = urlTokens(raw)
+	}
+	return !f.allow.matches(raw, tokens, resourceType, thirdParty)
+}
+
+// ruleIndex files URL rules by a token every URL they match contains, as
+// ad blockers do, so a request only runs the few rules whose token appears
+// in its URL. Rules without such a token are always run.
+type ruleIndex struct {
+	byToken map[string][]*filterRule
+	other   []*filterRule
+}
+
+// add files rule under the token of its pattern with the fewest rules so
+// far, which keeps tokens shared by many rules, like a popular domain, from
+// collecting them all.
+func (x *ruleIndex) add(rule *filterRule, pattern string) {
+	best := ""
+	for _, token := range ruleTokens(pattern) {
+		if best == "" || len(x.byToken[token]) < len(x.byToken[best]) ||
+			len(x.byToken[token]) == len(x.byToken[best]) && len(token) > len(best) {
+			best = token
+		}
+	}
+	if best == "" {
+		x.other = append(x.other, rule)
+		return
+	}
+	x.byToken[best] = append(x.byToken[best], rule)
+}
+
+// matches reports whether a rule matches the URL, given its urlTokens.
+func (x *ruleIndex) matches(rawURL string, tokens []string, resourceType proto.NetworkResourceType, thirdParty bool) bool {
+	for _, token := range tokens {
+		for _, rule := range x.byToken[token] {
+			if rule.matches(rawURL, resourceType, thirdParty) {
+				return true
+			}
+		}
+	}
+	for _, rule := range x.other {
+		if rule.matches(rawURL, resourceType, thirdParty) {
+			return true
+		}
+	}
+	return false
+}
+
+// commonTokens appear in most URLs, so indexing rules by them saves little.
+var commonTokens = map[string]bool{"http": true, "https": true, "www": true, "com": true}
+
+// ruleTokens returns the runs of letters and digits in a pattern that a
+// matching URL must contain as whole runs: those delimited on both sides
+// by a literal character, a separator (^) or an anchor, rather than by a
+// wildcard or an unanchored end of the pattern.
+func ruleTokens(pattern string) []string {
+	p := strings.ToLower(pattern)
+	anchoredStart := strings.HasPrefix(p, "|")
+	p = strings.TrimLeft(p, "|")
+	anchoredEnd := strings.HasSuffix(p, "|")
+	p = strings.TrimSuffix(p, "|")
+
+	var tokens []string
+	for i := 0; i < len(p); {
+		if !isTokenChar(p[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(p) && isTokenChar(p[j]) {
+			j++
+		}
+		delimitedBefore := (i == 0 && anchoredStart) || (i > 0 && isTokenDelimiter(p[i-1]))
+		delimitedAfter := (j == len(p) && anchoredEnd) || (j < len(p) && isTokenDelimiter(p[j]))
+		if delimitedBefore && delimitedAfter && !commonTokens[p[i:j]] {
+			tokens = append(tokens, p[i:j])
+		}
+		i = j
+	}
+	return tokens
+}
+
+// urlTokens returns the distinct runs of letters and digits in a URL.
+func urlTokens(rawURL string) []string {
+	u := strings.ToLower(rawURL)
+	var tokens []string
+	seen := make(map[string]bool)
+	for i := 0; i < len(u); {
+		if !isTokenChar(u[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(u) && isTokenChar(u[j]) {
+			j++
+		}
+		if token := u[i:j]; !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+		i = j
+	}
+	return tokens
+}
+
+func isTokenChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+// isTokenDelimiter reports whether c in a pattern ends a token in every
+// URL the pattern matches. Wildcards may stand for more letters, and other
+// than ASCII may be percent-encoded in the URL.
+func isTokenDelimiter(c byte) bool {
+	return c != '*' && c < 0x80 && !isTokenChar(c)
+}
+
+// matchDomain reports whether host or one of its parent domains is listed.
+func matchDomain(domains map[string]bool, host string) bool {
+	for host != "" {
+		if domains[host] {
+			return true
+		}
+		_, parent, found := strings.Cut(host, ".")
+		if !found {
+			return false
+		}
+		host = parent
+	}
+	return false
+}
+
+// HidingCSS returns a stylesheet hiding the elements matched by the generic
+// rules and the rules for host. Each selector gets its own rule so that one
+// the browser does not understand cannot disable the rest.
+func (f *FilterList) HidingCSS(host string) string {
+	host = strings.ToLower(host)
+	selectors := append([]string(nil), f.hide[""]...)
+	for site, siteSelectors := range f.hide {
+		if site != "" && (host == site || strings.HasSuffix(host, "."+site)) {
+			selectors = append(selectors, siteSelectors...)
+		}
+	}
+
+	var b strings.Builder
+	for _, s := range selectors {
+		b.WriteString(s)
+		b.WriteString(" { display: none !important; }\n")
+	}
+	return b.String()
+}
+
+// siteOf returns the registrable domain of host, used to tell first-party
+// from third-party requests.
+func siteOf(host string) string {
+	site, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(host))
+	if err != nil {
+		return strings.ToLower(host)
+	}
+	return site
+}
+
+// requestBlocker applies a filter list to the requests of one page.
+type requestBlocker struct {
+	list *FilterList
+	// site is the registrable domain of the captured URL.
+	site string
+}
+
+// blocks reports whether a request should be blocked. mainFrame tells
+// whether the request loads the page's main frame, which is never blocked,
+// even after a redirect to another site; only third-party child frames are.
+func (b *requestBlocker) blocks(u *url.URL, resourceType proto.NetworkResourceType, mainFrame bool) bool {
+	thirdParty := siteOf(u.Hostname()) != b.site
+	if resourceType == proto.NetworkResourceTypeDocument && (mainFrame || !thirdParty) {
+		return false
+	}
+	return b.list.Blocks(u, resourceType, thirdParty)
+}
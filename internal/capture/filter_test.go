@@ -0,0 +1,216 @@
+package capture
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+func TestFilterListBlocks(t *testing.T) {
+	rules := `! comment
+[Adblock Plus 2.0]
+||ads.example^
+||tracker.example^$third-party
+/banner/*/ad.js$script
+|https://cdn.example/pixel.gif|
+@@||ads.example/allowed^
+||video.example^$~media
+||popups.example^$popup
+`
+	f := NewFilterList()
+	if err := f.Parse(strings.NewReader(rules)); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		url          string
+		resourceType proto.NetworkResourceType
+		thirdParty   bool
+		want         bool
+	}{
+		{"domain anchor", "https://ads.example/x.js", proto.NetworkResourceTypeScript, true, true},
+		{"subdomain", "https://cdn.ads.example/x.js", proto.NetworkResourceTypeScript, true, true},
+		{"similar domain", "https://notads.example/x.js", proto.NetworkResourceTypeScript, true, false},
+		{"exception", "https://ads.example/allowed/x.js", proto.NetworkResourceTypeScript, true, false},
+		{"third-party only", "https://tracker.example/t", proto.NetworkResourceTypeXHR, true, true},
+		{"first-party skipped", "https://tracker.example/t", proto.NetworkResourceTypeXHR, false, false},
+		{"wildcard path", "https://site.example/banner/top/ad.js", proto.NetworkResourceTypeScript, false, true},
+		{"wrong type", "https://site.example/banner/top/ad.js", proto.NetworkResourceTypeImage, false, false},
+		{"anchored url", "https://cdn.example/pixel.gif", proto.NetworkResourceTypeImage, true, true},
+		{"anchored url with query", "https://cdn.example/pixel.gif?x=1", proto.NetworkResourceTypeImage, true, false},
+		{"negated type", "https://video.example/clip.mp4", proto.NetworkResourceTypeMedia, true, false},
+		{"negated type other", "https://video.example/app.js", proto.NetworkResourceTypeScript, true, true},
+		{"unsupported option ignored", "https://popups.example/", proto.NetworkResourceTypeDocument, true, false},
+		{"data url", "data:text/plain,ads.example", proto.NetworkResourceTypeOther, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(tt.url)
+			if got := f.Blocks(u, tt.resourceType, tt.thirdParty); got != tt.want {
+				t.Errorf("Blocks(%s) = %v, want %v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleTokens(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"||cdn.example/ads/loader.js", []string{"cdn", "example", "ads", "loader"}},
+		{"/banner/*/ad.js", []string{"banner", "ad"}},
+		{"&adparam=", []string{"adparam"}},
+		{"|https://cdn.example/pixel.gif|", []string{"cdn", "example", "pixel", "gif"}},
+		{"||tracker.example^", []string{"tracker", "example"}},
+		{"ads.js", nil},
+		{"*banner*", nil},
+		{"/adv*ertising/", nil},
+		{"/caf\u00e9ads/", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := ruleTokens(tt.pattern); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("ruleTokens(%q) = %q, want %q", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleIndexSpreadsRules(t *testing.T) {
+	x := ruleIndex{byToken: make(map[string][]*filterRule)}
+	for _, pattern := range []string{"||cdn.example/a/", "||cdn.example/b/", "||cdn.example/a/c/"} {
+		x.add(&filterRule{}, pattern)
+	}
+	x.add(&filterRule{}, "ads.js")
+
+	for token, want := range map[string]int{"example": 1, "cdn": 1, "a": 1, "b": 0, "c": 0} {
+		if got := len(x.byToken[token]); got != want {
+			t.Errorf("rules under %q = %d, want %d", token, got, want)
+		}
+	}
+	if len(x.other) != 1 {
+		t.Errorf("rules without a token = %d, want 1", len(x.other))
+	}
+}
+
+// BenchmarkFilterListBlocks matches requests against a list the size of
+// EasyList, with the mix of domain and URL rules it has.
+func BenchmarkFilterListBlocks(b *testing.B) {
+	var rules strings.Builder
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&rules, "||ads%d.example^\n", i)
+	}
+	for i := 0; i < 15000; i++ {
+		fmt.Fprintf(&rules, "||cdn%d.example/banners/*/slot%d.js$script,third-party\n", i, i)
+		fmt.Fprintf(&rules, "&adunit%d=\n", i)
+	}
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&rules, "-ad-%dx*.\n", i)
+		fmt.Fprintf(&rules, "@@||cdn%d.example/banners/ok/\n", i)
+	}
+	f := NewFilterList()
+	if err := f.Parse(strings.NewReader(rules.String())); err != nil {
+		b.Fatalf("Parse() error = %v", err)
+	}
+
+	var urls []*url.URL
+	for _, raw := range []string{
+		"https://www.news.example/2024/05/article.html?utm_source=feed",
+		"https://static.news.example/assets/app.3f9a2c.js",
+		"https://cdn42.example/banners/top/slot42.js",
+		"https://ads17.example/serve?zone=3",
+		"https://images.news.example/photos/large/1234.jpg?w=800&adunit7=top",
+	} {
+		u, _ := url.Parse(raw)
+		urls = append(urls, u)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Blocks(urls[i%len(urls)], proto.NetworkResourceTypeScript, true)
+	}
+}
+
+func TestFilterListHidingCSS(t *testing.T) {
+	rules := `##.ad-banner
+news.example,~blog.news.example##.sponsored
+other.example###promo
+##.broken { color: red }
+`
+	f := NewFilterList()
+	if err := f.Parse(strings.NewReader(rules)); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		host    string
+		want    []string
+		notWant []string
+	}{
+		{"www.news.example", []string{".ad-banner {", ".sponsored {"}, []string{"#promo", ".broken"}},
+		{"other.example", []string{".ad-banner {", "#promo {"}, []string{".sponsored"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			css := f.HidingCSS(tt.host)
+			for _, want := range tt.want {
+				if !strings.Contains(css, want) {
+					t.Errorf("HidingCSS(%s) missing %q:\n%s", tt.host, want, css)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(css, notWant) {
+					t.Errorf("HidingCSS(%s) contains %q:\n%s", tt.host, notWant, css)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestBlocker(t *testing.T) {
+	b := &requestBlocker{list: DefaultFilterList(), site: siteOf("www.news.example.co.uk")}
+
+	tests := []struct {
+		name         string
+		url          string
+		resourceType proto.NetworkResourceType
+		mainFrame    bool
+		want         bool
+	}{
+		{"ad script", "https://securepubads.g.doubleclick.net/tag/js/gpt.js", proto.NetworkResourceTypeScript, false, true},
+		{"analytics", "https://www.google-analytics.com/g/collect", proto.NetworkResourceTypePing, false, true},
+		{"third-party ad frame", "https://ads.doubleclick.net/frame", proto.NetworkResourceTypeDocument, false, true},
+		{"main frame redirected to ad site", "https://ads.doubleclick.net/frame", proto.NetworkResourceTypeDocument, true, false},
+		{"first-party page", "https://static.news.example.co.uk/app.js", proto.NetworkResourceTypeScript, false, false},
+		{"regular cdn", "https://cdn.jsdelivr.net/npm/lib.js", proto.NetworkResourceTypeScript, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(tt.url)
+			if got := b.blocks(u, tt.resourceType, tt.mainFrame); got != tt.want {
+				t.Errorf("blocks(%s) = %v, want %v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultConsentRules(t *testing.T) {
+	rules := DefaultConsentRules()
+	if len(rules) == 0 {
+		t.Fatal("no bundled consent rules")
+	}
+	for _, r := range rules {
+		if r.Name == "" || r.Detect == "" || len(r.Click)+len(r.Hide) == 0 {
+			t.Errorf("incomplete consent rule: %+v", r)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package capture
+
+import (
+	"encoding/json"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// harRedactedHeaders carry credentials. They are left out of the HAR, which
+// is a debugging aid rather than an archive.
+var harRedactedHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+}
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Pages   []harPage   `json:"pages"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harPage struct {
+	StartedDateTime string         `json:"startedDateTime"`
+	ID              string         `json:"id"`
+	Title           string         `json:"title"`
+	PageTimings     harPageTimings `json:"pageTimings"`
+}
+
+type harPageTimings struct {
+	OnContentLoad float64 `json:"onContentLoad"`
+	OnLoad        float64 `json:"onLoad"`
+}
+
+type harEntry struct {
+	PageRef         string      `json:"pageref"`
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	ResourceType    string      `json:"_resourceType,omitempty"`
+	// Error explains why the request got no response: a network error,
+	// a blocked request or one still pending when the capture ended.
+	Error         string `json:"_error,omitempty"`
+	BlockedReason string `json:"_blockedReason,omitempty"`
+
+	started time.Time
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status       int            `json:"status"`
+	StatusText   string         `json:"statusText"`
+	HTTPVersion  string         `json:"httpVersion"`
+	Cookies      []harNameValue `json:"cookies"`
+	Headers      []harNameValue `json:"headers"`
+	Content      harContent     `json:"content"`
+	RedirectURL  string         `json:"redirectURL"`
+	HeadersSize  int            `json:"headersSize"`
+	BodySize     int            `json:"bodySize"`
+	TransferSize int64          `json:"_transferSize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+// harTimings are in milliseconds; -1 marks a phase that did not apply.
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// buildHAR renders a HAR 1.2 log of the page's requests. Requests that
+// failed, were blocked or never finished are kept with status 0 and an
+// _error field. Response bodies are not included; the WARC output keeps
+// those.
+func buildHAR(finished, pending []*exchange, title string, capturedAt time.Time) ([]byte, error) {
+	entries := make([]*harEntry, 0, len(finished)+len(pending))
+	for _, ex := range finished {
+		entries = append(entries, harEntryFor(ex))
+	}
+	for _, ex := range pending {
+		entry := harEntryFor(ex)
+		if entry.Error == "" && ex.response == nil {
+			entry.Error = "request did not complete before the capture ended"
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].started.Before(entries[j].started)
+	})
+
+	started := capturedAt
+	if len(entries) > 0 {
+		started = entries[0].started
+	}
+	har := harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "pagemail", Version: "1.0"},
+		Pages: []harPage{{
+			StartedDateTime: harTime(started),
+			ID:              "page_1",
+			Title:           title,
+			PageTimings:     harPageTimings{OnContentLoad: -1, OnLoad: -1},
+		}},
+		Entries: entries,
+	}}
+	return json.MarshalIndent(har, "", "  ")
+}
+
+func harEntryFor(ex *exchange) *harEntry {
+	entry := &harEntry{
+		PageRef:         "page_1",
+		StartedDateTime: harTime(ex.started),
+		ResourceType:    string(ex.resourceType),
+		Error:           ex.failure,
+		BlockedReason:   string(ex.blockedReason),
+		started:         ex.started,
+		Request: harRequest{
+			Method:      ex.request.Method,
+			URL:         ex.request.URL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(ex.request.Headers),
+			QueryString: harQueryString(ex.request.URL),
+			HeadersSize: -1,
+			BodySize:    len(ex.request.PostData),
+		},
+		Response: harResponse{
+			Cookies:     []harNameValue{},
+			Headers:     []harNameValue{},
+			HTTPVersion: "HTTP/1.1",
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+	}
+	if ex.request.PostData != "" {
+		entry.Request.PostData = &harPostData{
+			MimeType: headerValue(ex.request.Headers, "content-type"),
+			Text:     ex.request.PostData,
+		}
+	}
+
+	duration := -1.0
+	if ex.endTime > 0 && ex.requestTime > 0 {
+		duration = (ex.endTime - ex.requestTime) * 1000
+	}
+	entry.Timings = harTimingsFor(nil, duration)
+
+	if resp := ex.response; resp != nil {
+		version := harHTTPVersion(resp.Protocol)
+		entry.Request.HTTPVersion = version
+		entry.Response = harResponse{
+			Status:       resp.Status,
+			StatusText:   resp.StatusText,
+			HTTPVersion:  version,
+			Cookies:      []harNameValue{},
+			Headers:      harHeaders(resp.Headers),
+			RedirectURL:  headerValue(resp.Headers, "location"),
+			HeadersSize:  -1,
+			BodySize:     -1,
+			TransferSize: ex.encodedSize,
+			Content:      harContent{Size: ex.encodedSize, MimeType: resp.MIMEType},
+		}
+		if ex.body != nil {
+			entry.Response.Content.Size = int64(len(ex.body))
+		}
+		entry.ServerIPAddress = strings.Trim(resp.RemoteIPAddress, "[]")
+		if resp.Timing != nil && ex.endTime > 0 {
+			duration = (ex.endTime - resp.Timing.RequestTime) * 1000
+		}
+		entry.Timings = harTimingsFor(resp.Timing, duration)
+	}
+
+	for _, phase := range []float64{entry.Timings.Blocked, entry.Timings.DNS, entry.Timings.Connect,
+		entry.Timings.Send, entry.Timings.Wait, entry.Timings.Receive} {
+		if phase > 0 {
+			entry.Time += phase
+		}
+	}
+	entry.Time = harMillis(entry.Time)
+	return entry
+}
+
+// harTimingsFor splits a request's duration into HAR phases using the
+// browser's resource timing, whose offsets are relative to the request
+// start. Without timing, as for cached or failed requests, the whole
+// duration counts as receiving.
+func harTimingsFor(timing *proto.NetworkResourceTiming, duration float64) harTimings {
+	t := harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1}
+	if duration < 0 {
+		duration = 0
+	}
+	if timing == nil {
+		t.Receive = harMillis(duration)
+		return t
+	}
+
+	span := func(start, end float64) float64 {
+		if start < 0 || end < start {
+			return -1
+		}
+		return end - start
+	}
+	switch {
+	case timing.DNSStart >= 0:
+		t.Blocked = timing.DNSStart
+	case timing.ConnectStart >= 0:
+		t.Blocked = timing.ConnectStart
+	case timing.SendStart >= 0:
+		t.Blocked = timing.SendStart
+	}
+	t.DNS = span(timing.DNSStart, timing.DNSEnd)
+	t.Connect = span(timing.ConnectStart, timing.ConnectEnd)
+	t.SSL = span(timing.SslStart, timing.SslEnd)
+	t.Send = max(span(timing.SendStart, timing.SendEnd), 0)
+	t.Wait = max(span(timing.SendEnd, timing.ReceiveHeadersEnd), 0)
+	t.Receive = harMillis(max(duration-timing.ReceiveHeadersEnd, 0))
+	return t
+}
+
+// harMillis rounds a duration to microseconds, hiding float noise from
+// subtracting monotonic clock readings.
+func harMillis(ms float64) float64 {
+	return math.Round(ms*1000) / 1000
+}
+
+// harHeaders lists headers sorted by name, splitting values CDP joined
+// with newlines. Pseudo-headers and credentials are dropped.
+func harHeaders(headers proto.NetworkHeaders) []harNameValue {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		if strings.HasPrefix(name, ":") || harRedactedHeaders[strings.ToLower(name)] {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]harNameValue, 0, len(names))
+	for _, name := range names {
+		for _, value := range strings.Split(headers[name].Str(), "\n") {
+			list = append(list, harNameValue{Name: name, Value: value})
+		}
+	}
+	return list
+}
+
+func harQueryString(rawURL string) []harNameValue {
+	list := []harNameValue{}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return list
+	}
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if v, err := url.QueryUnescape(value); err == nil {
+			value = v
+		}
+		list = append(list, harNameValue{Name: name, Value: value})
+	}
+	return list
+}
+
+func headerValue(headers proto.NetworkHeaders, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value.Str()
+		}
+	}
+	return ""
+}
+
+// harHTTPVersion maps the ALPN protocol CDP reports to an HTTP version.
+func harHTTPVersion(protocol string) string {
+	switch strings.ToLower(protocol) {
+	case "h2":
+		return "HTTP/2"
+	case "h3", "h3-29":
+		return "HTTP/3"
+	case "http/1.0":
+		return "HTTP/1.0"
+	default:
+		return "HTTP/1.1"
+	}
+}
+
+func harTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
@@ -0,0 +1,146 @@
+package capture
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+func TestBuildHAR(t *testing.T) {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	finished := []*exchange{
+		{
+			request: &proto.NetworkRequest{
+				URL: "https://example.com/app.js", Method: "GET",
+				Headers: networkHeaders(map[string]string{"Referer": "https://example.com/"}),
+			},
+			response: &proto.NetworkResponse{
+				Status: 200, StatusText: "OK", MIMEType: "application/javascript", Protocol: "h2",
+				Headers: networkHeaders(map[string]string{"Content-Type": "application/javascript"}),
+			},
+			resourceType: proto.NetworkResourceTypeScript,
+			started:      started.Add(200 * time.Millisecond),
+			requestTime:  10.2,
+			endTime:      10.35,
+			encodedSize:  512,
+		},
+		{
+			request: &proto.NetworkRequest{
+				URL: "https://example.com/?q=a%20b&lang=en", Method: "POST", PostData: "name=x",
+				Headers: networkHeaders(map[string]string{
+					"Content-Type": "application/x-www-form-urlencoded",
+					"Cookie":       "session=secret",
+				}),
+			},
+			response: &proto.NetworkResponse{
+				Status: 200, MIMEType: "text/html", RemoteIPAddress: "[2001:db8::1]",
+				Headers: networkHeaders(map[string]string{"Set-Cookie": "session=secret", "Vary": "Accept\nCookie"}),
+				Timing: &proto.NetworkResourceTiming{
+					RequestTime: 10, DNSStart: 1, DNSEnd: 5, ConnectStart: 5, ConnectEnd: 20,
+					SslStart: 10, SslEnd: 20, SendStart: 21, SendEnd: 22, ReceiveHeadersEnd: 80,
+				},
+			},
+			resourceType: proto.NetworkResourceTypeDocument,
+			started:      started,
+			requestTime:  10,
+			endTime:      10.1,
+			body:         []byte("<html></html>"),
+			encodedSize:  300,
+		},
+		{
+			request:       &proto.NetworkRequest{URL: "https://ads.example.net/ad.js", Method: "GET"},
+			resourceType:  proto.NetworkResourceTypeScript,
+			started:       started.Add(300 * time.Millisecond),
+			failure:       "net::ERR_BLOCKED_BY_CLIENT",
+			blockedReason: proto.NetworkBlockedReasonInspector,
+		},
+	}
+	pending := []*exchange{
+		{
+			request: &proto.NetworkRequest{URL: "https://example.com/slow", Method: "GET"},
+			started: started.Add(time.Second),
+		},
+	}
+
+	data, err := buildHAR(finished, pending, "Example", started.Add(2*time.Second))
+	if err != nil {
+		t.Fatalf("buildHAR() error = %v", err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Error("HAR should not contain cookies")
+	}
+
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatalf("invalid HAR JSON: %v", err)
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Pages) != 1 || har.Log.Pages[0].Title != "Example" {
+		t.Errorf("log = %+v", har.Log)
+	}
+	if got := har.Log.Pages[0].StartedDateTime; got != "2024-05-01T12:00:00.000Z" {
+		t.Errorf("page started = %s", got)
+	}
+
+	entries := har.Log.Entries
+	if len(entries) != 4 {
+		t.Fatalf("got %d entries, want 4", len(entries))
+	}
+	wantOrder := []string{"https://example.com/?q=a%20b&lang=en", "https://example.com/app.js", "https://ads.example.net/ad.js", "https://example.com/slow"}
+	for i, want := range wantOrder {
+		if entries[i].Request.URL != want {
+			t.Errorf("entry %d = %s, want %s", i, entries[i].Request.URL, want)
+		}
+	}
+
+	doc := entries[0]
+	if doc.ServerIPAddress != "2001:db8::1" || doc.Response.Content.Size != 13 || doc.Response.TransferSize != 300 {
+		t.Errorf("document entry = %+v", doc)
+	}
+	if doc.Request.PostData == nil || doc.Request.PostData.Text != "name=x" ||
+		doc.Request.PostData.MimeType != "application/x-www-form-urlencoded" {
+		t.Errorf("postData = %+v", doc.Request.PostData)
+	}
+	if len(doc.Request.QueryString) != 2 || doc.Request.QueryString[0] != (harNameValue{"q", "a b"}) {
+		t.Errorf("queryString = %+v", doc.Request.QueryString)
+	}
+	for _, h := range append(doc.Request.Headers, doc.Response.Headers...) {
+		if strings.EqualFold(h.Name, "cookie") || strings.EqualFold(h.Name, "set-cookie") {
+			t.Errorf("credential header %s kept", h.Name)
+		}
+	}
+	if len(doc.Response.Headers) != 2 {
+		t.Errorf("response headers = %+v, want Vary split in two", doc.Response.Headers)
+	}
+	wantTimings := harTimings{Blocked: 1, DNS: 4, Connect: 15, SSL: 10, Send: 1, Wait: 58, Receive: 20}
+	if doc.Timings != wantTimings {
+		t.Errorf("timings = %+v, want %+v", doc.Timings, wantTimings)
+	}
+	if doc.Time != 99 {
+		t.Errorf("time = %v, want 99", doc.Time)
+	}
+
+	script := entries[1]
+	if script.Request.HTTPVersion != "HTTP/2" || script.ResourceType != "Script" || script.Timings.Receive != 150 {
+		t.Errorf("script entry = %+v", script)
+	}
+
+	blocked := entries[2]
+	if blocked.Response.Status != 0 || blocked.Error != "net::ERR_BLOCKED_BY_CLIENT" || blocked.BlockedReason != "inspector" {
+		t.Errorf("blocked entry = %+v", blocked)
+	}
+	if entries[3].Error == "" {
+		t.Error("pending entry should explain it did not complete")
+	}
+}
+
+func TestHARHTTPVersion(t *testing.T) {
+	tests := map[string]string{"h2": "HTTP/2", "h3": "HTTP/3", "http/1.1": "HTTP/1.1", "": "HTTP/1.1"}
+	for protocol, want := range tests {
+		if got := harHTTPVersion(protocol); got != want {
+			t.Errorf("harHTTPVersion(%q) = %q, want %q", protocol, got, want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// Injection types.
+const (
+	InjectJS  = "js"
+	InjectCSS = "css"
+)
+
+const (
+	defaultScriptTimeout = 5 * time.Second
+	// MaxScriptTimeout bounds the time one injected script may run.
+	MaxScriptTimeout = 30 * time.Second
+	// MaxInjectionSize limits the content of a single script or stylesheet.
+	MaxInjectionSize = 64 << 10
+)
+
+// Injection is a script or stylesheet applied to the page after it has
+// loaded and before any output is rendered.
+type Injection struct {
+	// Name identifies the injection in error messages, e.g. a snippet name.
+	Name    string `json:"name,omitempty"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+}
+
+// Validate reports whether the injection can be applied.
+func (i *Injection) Validate() error {
+	switch i.Type {
+	case InjectJS, InjectCSS:
+	default:
+		return fmt.Errorf("injection type must be js or css")
+	}
+	if i.Content == "" {
+		return fmt.Errorf("injection content is required")
+	}
+	if len(i.Content) > MaxInjectionSize {
+		return fmt.Errorf("injection content exceeds %d bytes", MaxInjectionSize)
+	}
+	return nil
+}
+
+func (i *Injection) label(index int) string {
+	if i.Name != "" {
+		return i.Name
+	}
+	if i.Type == InjectCSS {
+		return fmt.Sprintf("style %d", index+1)
+	}
+	return fmt.Sprintf("script %d", index+1)
+}
+
+// injectStyleJS appends a stylesheet without waiting for it to load, so
+// inline CSS applies immediately.
+const injectStyleJS = `(css) => {
+	const style = document.createElement('style');
+	style.textContent = css;
+	(document.head || document.documentElement).appendChild(style);
+}`
+
+// inject applies each injection in order. A failing injection does not stop
+// the others; its error is returned in the list instead. Scripts run inside
+// an async function, so they may await, and are terminated after timeout.
+func inject(page *rod.Page, injections []Injection, timeout time.Duration) []string {
+	if timeout <= 0 {
+		timeout = defaultScriptTimeout
+	}
+
+	var errs []string
+	for idx := range injections {
+		in := &injections[idx]
+		var err error
+		switch in.Type {
+		case InjectCSS:
+			_, err = page.Evaluate(rod.Eval(injectStyleJS, in.Content))
+		case InjectJS:
+			err = runScript(page, in.Content, timeout)
+		default:
+			err = fmt.Errorf("unknown injection type %q", in.Type)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", in.label(idx), err))
+		}
+	}
+	return errs
+}
+
+func runScript(page *rod.Page, script string, timeout time.Duration) error {
+	bounded := page.Timeout(timeout)
+	defer bounded.CancelTimeout()
+
+	_, err := bounded.Evaluate(rod.Eval("async () => {\n" + script + "\n}").ByPromise())
+	if err == nil {
+		return nil
+	}
+
+	var evalErr *rod.EvalError
+	if errors.As(err, &evalErr) && evalErr.Exception != nil && evalErr.Exception.Description != "" {
+		// The description carries the message and stack without rod's prefix.
+		return errors.New(evalErr.Exception.Description)
+	}
+	if bounded.GetContext().Err() != nil {
+		// The script is still running in the page; stop it so rendering
+		// is not blocked behind a busy main thread.
+		_ = proto.RuntimeTerminateExecution{}.Call(page)
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
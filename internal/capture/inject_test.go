@@ -0,0 +1,46 @@
+package capture
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInjectionValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		injection Injection
+		wantErr   bool
+	}{
+		{"script", Injection{Type: InjectJS, Content: "document.body.remove()"}, false},
+		{"style", Injection{Type: InjectCSS, Content: "header { display: none }"}, false},
+		{"unknown type", Injection{Type: "html", Content: "<p>"}, true},
+		{"empty", Injection{Type: InjectJS}, true},
+		{"too large", Injection{Type: InjectCSS, Content: strings.Repeat("x", MaxInjectionSize+1)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.injection.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInjectionLabel(t *testing.T) {
+	tests := []struct {
+		injection Injection
+		index     int
+		want      string
+	}{
+		{Injection{Name: "hide-header", Type: InjectCSS}, 0, "hide-header"},
+		{Injection{Type: InjectCSS}, 1, "style 2"},
+		{Injection{Type: InjectJS}, 2, "script 3"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.injection.label(tt.index); got != tt.want {
+			t.Errorf("label(%d) = %q, want %q", tt.index, got, tt.want)
+		}
+	}
+}
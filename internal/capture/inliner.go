@@ -7,40 +7,195 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/go-rod/rod/lib/proto"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/net/html"
 )
 
 const tagStyle = "style"
 
+// Inliner defaults, used when the matching InlinerConfig field is zero.
+const (
+	defaultInlineMaxResourceSize = 10 << 20
+	defaultInlineMaxTotalSize    = 50 << 20
+	defaultInlineConcurrency     = 8
+	defaultInlineTimeout         = 15 * time.Second
+	// maxImportDepth bounds nested CSS @import chains.
+	maxImportDepth = 3
+)
+
+var (
+	cssURLPattern    = regexp.MustCompile(`url\(\s*['"]?([^'")\s]+)['"]?\s*\)`)
+	cssImportPattern = regexp.MustCompile(`@import\s+(?:url\(\s*)?['"]?([^'")\s;]+)['"]?\s*\)?\s*([^;]*);`)
+)
+
+// InlinerConfig controls how sub-resources are fetched and embedded.
+type InlinerConfig struct {
+	// UserAgent is sent with every sub-resource request.
+	UserAgent string
+	// Cookies are the browser's cookies after the capture; each request only
+	// carries the cookies that match its URL.
+	Cookies []*proto.NetworkCookie
+	// MaxResourceSize skips resources larger than this many bytes.
+	MaxResourceSize int64
+	// MaxTotalSize stops inlining once this many bytes have been embedded;
+	// the remaining references are left pointing at the original URLs.
+	MaxTotalSize int64
+	// Concurrency limits parallel sub-resource requests.
+	Concurrency int
+	// Timeout applies to each sub-resource request.
+	Timeout time.Duration
+}
+
+// Inliner rewrites an HTML document into a self-contained file by embedding
+// stylesheets, images, fonts and icons. The document is the DOM after the
+// page's scripts ran, so scripts are removed rather than embedded: run
+// again when the file is opened, they would duplicate what they already
+// rendered and reach the network.
 type Inliner struct {
 	baseURL    *url.URL
 	httpClient *http.Client
+	userAgent  string
+	maxSize    int64
+	maxTotal   int64
+	total      atomic.Int64
+	sem        chan struct{}
+
+	mu    sync.Mutex
+	cache map[string]*resource
+}
+
+type resource struct {
+	done        chan struct{}
+	content     []byte
+	contentType string
+	err         error
 }
 
-func NewInliner(baseURL string) (*Inliner, error) {
+// inlineJob fetches what a node needs and returns the change to apply to
+// the document, or nil. Jobs run concurrently; changes are applied serially.
+type inlineJob func(ctx context.Context) func()
+
+func NewInliner(baseURL string, cfg *InlinerConfig) (*Inliner, error) {
 	parsed, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid base URL: %w", err)
 	}
+	if cfg == nil {
+		cfg = &InlinerConfig{}
+	}
+
+	jar, err := cookieJar(cfg.Cookies)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultInlineTimeout
+	}
+	maxSize := cfg.MaxResourceSize
+	if maxSize == 0 {
+		maxSize = defaultInlineMaxResourceSize
+	}
+	maxTotal := cfg.MaxTotalSize
+	if maxTotal == 0 {
+		maxTotal = defaultInlineMaxTotalSize
+	}
+	concurrency := cfg.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultInlineConcurrency
+	}
+
+	i := &Inliner{
+		baseURL:   parsed,
+		userAgent: cfg.UserAgent,
+		maxSize:   maxSize,
+		maxTotal:  maxTotal,
+		sem:       make(chan struct{}, concurrency),
+		cache:     make(map[string]*resource),
+		httpClient: &http.Client{
+			Transport:     safeTransport,
+			Jar:           jar,
+			Timeout:       timeout,
+			CheckRedirect: checkRedirect,
+		},
+	}
 
-	return &Inliner{
-		baseURL:    parsed,
-		httpClient: &http.Client{},
-	}, nil
+	return i, nil
 }
 
-func (i *Inliner) InlineHTML(htmlContent []byte) ([]byte, error) {
+// cookieJar loads browser cookies into a jar so that domain, path and
+// secure matching follow the usual browser rules.
+func cookieJar(cookies []*proto.NetworkCookie) (http.CookieJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cookie jar: %w", err)
+	}
+
+	for _, c := range cookies {
+		host := strings.TrimPrefix(c.Domain, ".")
+		if host == "" {
+			continue
+		}
+		scheme := "http"
+		if c.Secure {
+			scheme = "https"
+		}
+		cookie := &http.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HttpOnly: c.HTTPOnly,
+		}
+		// A leading dot marks a domain cookie; without it the cookie is
+		// host-only, which the jar expresses as an empty Domain.
+		if strings.HasPrefix(c.Domain, ".") {
+			cookie.Domain = host
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: "/"}, []*http.Cookie{cookie})
+	}
+
+	return jar, nil
+}
+
+func (i *Inliner) InlineHTML(ctx context.Context, htmlContent []byte) ([]byte, error) {
 	doc, err := html.Parse(bytes.NewReader(htmlContent))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse HTML: %w", err)
 	}
 
-	i.processNode(doc)
+	i.applyBase(doc)
+	removeScripts(doc)
+
+	var jobs []inlineJob
+	i.collectJobs(doc, &jobs)
+
+	changes := make([]func(), len(jobs))
+	var wg sync.WaitGroup
+	for idx, job := range jobs {
+		wg.Add(1)
+		go func(idx int, job inlineJob) {
+			defer wg.Done()
+			changes[idx] = job(ctx)
+		}(idx, job)
+	}
+	wg.Wait()
+
+	for _, change := range changes {
+		if change != nil {
+			change()
+		}
+	}
 
 	var buf bytes.Buffer
 	if err := html.Render(&buf, doc); err != nil {
@@ -50,161 +205,415 @@ func (i *Inliner) InlineHTML(htmlContent []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func (i *Inliner) processNode(n *html.Node) {
+// applyBase honours a <base href> so relative references resolve the same
+// way they did in the browser.
+func (i *Inliner) applyBase(n *html.Node) bool {
+	if n.Type == html.ElementNode && n.Data == "base" {
+		if href := getAttr(n, "href"); href != "" {
+			if ref, err := url.Parse(href); err == nil {
+				i.baseURL = i.baseURL.ResolveReference(ref)
+			}
+			return true
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if i.applyBase(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Inliner) collectJobs(n *html.Node, jobs *[]inlineJob) {
 	if n.Type == html.ElementNode {
 		switch n.Data {
 		case "link":
-			i.processLink(n)
-		case "script":
-			i.processScript(n)
+			if job := i.linkJob(n); job != nil {
+				*jobs = append(*jobs, job)
+			}
 		case "img":
-			i.processImage(n)
+			*jobs = append(*jobs, i.attrJobs(n, "src", "srcset")...)
+		case "source":
+			// Only <picture> sources are images; audio and video sources
+			// are left alone.
+			if n.Parent != nil && n.Parent.Type == html.ElementNode && n.Parent.Data == "picture" {
+				*jobs = append(*jobs, i.attrJobs(n, "src", "srcset")...)
+			}
 		case tagStyle:
-			i.processStyleTag(n)
+			*jobs = append(*jobs, i.styleTagJobs(n)...)
 		}
 
+		// Link jobs rewrite the attribute list, so style attributes are
+		// only rewritten on other elements.
 		for j := range n.Attr {
-			if n.Attr[j].Key == tagStyle {
-				n.Attr[j].Val = i.inlineStyleURLs(n.Attr[j].Val)
+			if n.Data != "link" && n.Attr[j].Key == tagStyle && strings.Contains(n.Attr[j].Val, "url(") {
+				*jobs = append(*jobs, i.styleAttrJob(n, j))
 			}
 		}
 	}
 
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		i.processNode(c)
+		i.collectJobs(c, jobs)
 	}
 }
 
-func (i *Inliner) processLink(n *html.Node) {
-	var rel, href string
-	var hrefIdx int
-
-	for j, attr := range n.Attr {
-		switch attr.Key {
-		case "rel":
-			rel = attr.Val
-		case "href":
-			href = attr.Val
-			hrefIdx = j
-		}
+func (i *Inliner) linkJob(n *html.Node) inlineJob {
+	href := getAttr(n, "href")
+	if href == "" || strings.HasPrefix(href, "data:") {
+		return nil
 	}
 
-	if rel == "stylesheet" && href != "" {
-		content, contentType, err := i.fetchResource(href)
-		if err != nil {
-			log.Debug().Err(err).Str("href", href).Msg("Failed to fetch stylesheet")
-			return
+	rels := strings.Fields(strings.ToLower(getAttr(n, "rel")))
+	for _, rel := range rels {
+		switch rel {
+		case "stylesheet":
+			return func(ctx context.Context) func() {
+				absURL, err := i.resolve(i.baseURL, href)
+				if err != nil {
+					return nil
+				}
+				css, err := i.fetchStylesheet(ctx, absURL, 0)
+				if err != nil {
+					log.Debug().Err(err).Str("href", href).Msg("Failed to fetch stylesheet")
+					return nil
+				}
+				return func() {
+					media := getAttr(n, "media")
+					n.Data = tagStyle
+					n.DataAtom = 0
+					n.Attr = nil
+					if media != "" {
+						n.Attr = []html.Attribute{{Key: "media", Val: media}}
+					}
+					n.AppendChild(&html.Node{Type: html.TextNode, Data: css})
+				}
+			}
+		case "icon", "apple-touch-icon", "mask-icon":
+			jobs := i.attrJobs(n, "href")
+			if len(jobs) == 0 {
+				return nil
+			}
+			return jobs[0]
 		}
-
-		content = []byte(i.inlineStyleURLs(string(content)))
-
-		n.Data = "style"
-		n.Attr = nil
-
-		n.AppendChild(&html.Node{
-			Type: html.TextNode,
-			Data: string(content),
-		})
-
-		_ = contentType
-		_ = hrefIdx
 	}
-}
 
-func (i *Inliner) processScript(n *html.Node) {
-	var src string
-	var srcIdx int
+	return nil
+}
 
-	for j, attr := range n.Attr {
-		if attr.Key == "src" {
-			src = attr.Val
-			srcIdx = j
-			break
+// removeScripts removes the scripts of a document, along with event
+// handler attributes and javascript: URLs. Script elements holding data,
+// such as JSON-LD, do not run and are kept.
+func removeScripts(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode && c.Data == "script" && isExecutableScript(c) {
+			n.RemoveChild(c)
+		} else {
+			removeScripts(c)
 		}
+		c = next
 	}
-
-	if src == "" {
+	if n.Type != html.ElementNode {
 		return
 	}
 
-	content, _, err := i.fetchResource(src)
-	if err != nil {
-		log.Debug().Err(err).Str("src", src).Msg("Failed to fetch script")
-		return
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		key := strings.ToLower(attr.Key)
+		if strings.HasPrefix(key, "on") || isJavaScriptURL(attr.Val) && scriptURLAttrs[key] {
+			continue
+		}
+		attrs = append(attrs, attr)
 	}
+	n.Attr = attrs
+}
 
-	n.Attr = append(n.Attr[:srcIdx], n.Attr[srcIdx+1:]...)
+// scriptURLAttrs are the attributes whose javascript: URLs run.
+var scriptURLAttrs = map[string]bool{
+	"href": true, "src": true, "action": true, "formaction": true, "xlink:href": true,
+}
 
-	n.AppendChild(&html.Node{
-		Type: html.TextNode,
-		Data: string(content),
-	})
+// isExecutableScript reports whether a <script> runs: one without a type,
+// a module, or one typed as JavaScript.
+func isExecutableScript(n *html.Node) bool {
+	typ := strings.ToLower(strings.TrimSpace(getAttr(n, "type")))
+	if typ == "" || typ == "module" {
+		return true
+	}
+	for _, js := range []string{"javascript", "ecmascript", "jscript", "livescript"} {
+		if strings.Contains(typ, js) {
+			return true
+		}
+	}
+	return false
 }
 
-func (i *Inliner) processImage(n *html.Node) {
-	for j, attr := range n.Attr {
-		if attr.Key == "src" && !strings.HasPrefix(attr.Val, "data:") {
-			dataURI, err := i.toDataURI(attr.Val)
-			if err != nil {
-				log.Debug().Err(err).Str("src", attr.Val).Msg("Failed to convert image to data URI")
+func isJavaScriptURL(val string) bool {
+	// Browsers ignore whitespace and control characters around the scheme.
+	val = strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, val)
+	return strings.HasPrefix(strings.ToLower(val), "javascript:")
+}
+
+// attrJobs returns one job per URL-bearing attribute present on n. Attributes
+// named "srcset" are parsed as image candidate lists.
+func (i *Inliner) attrJobs(n *html.Node, keys ...string) []inlineJob {
+	var jobs []inlineJob
+	for j := range n.Attr {
+		attr := n.Attr[j]
+		for _, key := range keys {
+			if attr.Key != key || strings.TrimSpace(attr.Val) == "" {
+				continue
+			}
+			idx := j
+			if key == "srcset" {
+				jobs = append(jobs, func(ctx context.Context) func() {
+					srcset := i.inlineSrcset(ctx, attr.Val)
+					return func() { n.Attr[idx].Val = srcset }
+				})
+				continue
+			}
+			if strings.HasPrefix(attr.Val, "data:") {
 				continue
 			}
-			n.Attr[j].Val = dataURI
+			jobs = append(jobs, func(ctx context.Context) func() {
+				absURL, err := i.resolve(i.baseURL, attr.Val)
+				if err != nil {
+					return nil
+				}
+				dataURI, err := i.toDataURI(ctx, absURL)
+				if err != nil {
+					log.Debug().Err(err).Str("url", attr.Val).Msg("Failed to convert resource to data URI")
+					return nil
+				}
+				return func() { n.Attr[idx].Val = dataURI }
+			})
 		}
 	}
+	return jobs
 }
 
-func (i *Inliner) processStyleTag(n *html.Node) {
+func (i *Inliner) styleTagJobs(n *html.Node) []inlineJob {
+	var jobs []inlineJob
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		if c.Type == html.TextNode {
-			c.Data = i.inlineStyleURLs(c.Data)
+		if c.Type != html.TextNode {
+			continue
 		}
+		text := c
+		jobs = append(jobs, func(ctx context.Context) func() {
+			css := i.inlineCSS(ctx, text.Data, i.baseURL, 0)
+			return func() { text.Data = css }
+		})
 	}
+	return jobs
 }
 
-func (i *Inliner) inlineStyleURLs(css string) string {
-	urlRegex := regexp.MustCompile(`url\(['"]?([^'")\s]+)['"]?\)`)
+func (i *Inliner) styleAttrJob(n *html.Node, idx int) inlineJob {
+	style := n.Attr[idx].Val
+	return func(ctx context.Context) func() {
+		css := i.inlineCSS(ctx, style, i.baseURL, 0)
+		return func() { n.Attr[idx].Val = css }
+	}
+}
 
-	return urlRegex.ReplaceAllStringFunc(css, func(match string) string {
-		submatch := urlRegex.FindStringSubmatch(match)
-		if len(submatch) < 2 {
-			return match
+func (i *Inliner) fetchStylesheet(ctx context.Context, absURL *url.URL, depth int) (string, error) {
+	res := i.fetch(ctx, absURL)
+	if res.err != nil {
+		return "", res.err
+	}
+	if !i.reserve(len(res.content)) {
+		return "", fmt.Errorf("total inline size limit reached")
+	}
+	return i.inlineCSS(ctx, string(res.content), absURL, depth), nil
+}
+
+// inlineCSS embeds @import rules and url() references. Relative URLs are
+// resolved against the stylesheet they appear in, which matters for fonts
+// and background images referenced from external CSS.
+func (i *Inliner) inlineCSS(ctx context.Context, css string, base *url.URL, depth int) string {
+	if depth < maxImportDepth {
+		css = cssImportPattern.ReplaceAllStringFunc(css, func(match string) string {
+			submatch := cssImportPattern.FindStringSubmatch(match)
+			absURL, err := i.resolve(base, submatch[1])
+			if err != nil {
+				return match
+			}
+			imported, err := i.fetchStylesheet(ctx, absURL, depth+1)
+			if err != nil {
+				log.Debug().Err(err).Str("url", absURL.String()).Msg("Failed to fetch imported stylesheet")
+				return match
+			}
+			if media := strings.TrimSpace(submatch[2]); media != "" {
+				return "@media " + media + " {\n" + imported + "\n}"
+			}
+			return imported
+		})
+	}
+
+	// Fetch every referenced URL up front so they download in parallel.
+	refs := make(map[string]*url.URL)
+	for _, submatch := range cssURLPattern.FindAllStringSubmatch(css, -1) {
+		if absURL, err := i.resolve(base, submatch[1]); err == nil {
+			refs[submatch[1]] = absURL
 		}
+	}
+	var wg sync.WaitGroup
+	for _, absURL := range refs {
+		wg.Add(1)
+		go func(absURL *url.URL) {
+			defer wg.Done()
+			i.fetch(ctx, absURL)
+		}(absURL)
+	}
+	wg.Wait()
 
-		resourceURL := submatch[1]
-		if strings.HasPrefix(resourceURL, "data:") {
+	return cssURLPattern.ReplaceAllStringFunc(css, func(match string) string {
+		submatch := cssURLPattern.FindStringSubmatch(match)
+		absURL, ok := refs[submatch[1]]
+		if !ok {
 			return match
 		}
-
-		dataURI, err := i.toDataURI(resourceURL)
+		dataURI, err := i.toDataURI(ctx, absURL)
 		if err != nil {
 			return match
 		}
-
 		return fmt.Sprintf("url(%s)", dataURI)
 	})
 }
 
-func (i *Inliner) toDataURI(resourceURL string) (string, error) {
-	content, contentType, err := i.fetchResource(resourceURL)
-	if err != nil {
-		return "", err
+type srcsetCandidate struct {
+	url        string
+	descriptor string
+}
+
+// parseSrcset splits a srcset attribute into candidates. URLs may contain
+// commas (data URIs), so candidates are split on whitespace first.
+func parseSrcset(srcset string) []srcsetCandidate {
+	var candidates []srcsetCandidate
+	s := srcset
+	for {
+		s = strings.TrimLeft(s, " \t\n\r\f,")
+		if s == "" {
+			return candidates
+		}
+
+		end := strings.IndexAny(s, " \t\n\r\f")
+		if end == -1 {
+			end = len(s)
+		}
+		candidate := srcsetCandidate{url: s[:end]}
+		s = s[end:]
+
+		if strings.HasSuffix(candidate.url, ",") {
+			candidate.url = strings.TrimRight(candidate.url, ",")
+		} else {
+			descEnd := strings.IndexByte(s, ',')
+			if descEnd == -1 {
+				descEnd = len(s)
+			}
+			candidate.descriptor = strings.TrimSpace(s[:descEnd])
+			s = s[descEnd:]
+		}
+
+		candidates = append(candidates, candidate)
+	}
+}
+
+func (i *Inliner) inlineSrcset(ctx context.Context, srcset string) string {
+	candidates := parseSrcset(srcset)
+
+	var wg sync.WaitGroup
+	for idx := range candidates {
+		if strings.HasPrefix(candidates[idx].url, "data:") {
+			continue
+		}
+		wg.Add(1)
+		go func(c *srcsetCandidate) {
+			defer wg.Done()
+			absURL, err := i.resolve(i.baseURL, c.url)
+			if err != nil {
+				return
+			}
+			if dataURI, err := i.toDataURI(ctx, absURL); err == nil {
+				c.url = dataURI
+			}
+		}(&candidates[idx])
 	}
+	wg.Wait()
 
-	encoded := base64.StdEncoding.EncodeToString(content)
-	return fmt.Sprintf("data:%s;base64,%s", contentType, encoded), nil
+	parts := make([]string, len(candidates))
+	for idx, c := range candidates {
+		parts[idx] = strings.TrimSpace(c.url + " " + c.descriptor)
+	}
+	return strings.Join(parts, ", ")
 }
 
-func (i *Inliner) fetchResource(resourceURL string) (content []byte, contentType string, err error) {
-	absURL, err := i.resolveURL(resourceURL)
-	if err != nil {
-		return nil, "", err
+func (i *Inliner) toDataURI(ctx context.Context, absURL *url.URL) (string, error) {
+	res := i.fetch(ctx, absURL)
+	if res.err != nil {
+		return "", res.err
+	}
+
+	encodedLen := base64.StdEncoding.EncodedLen(len(res.content))
+	if !i.reserve(encodedLen) {
+		return "", fmt.Errorf("total inline size limit reached")
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(res.content)
+	return fmt.Sprintf("data:%s;base64,%s", res.contentType, encoded), nil
+}
+
+// reserve accounts n bytes against the total size cap.
+func (i *Inliner) reserve(n int) bool {
+	if i.total.Add(int64(n)) > i.maxTotal {
+		i.total.Add(int64(-n))
+		return false
+	}
+	return true
+}
+
+// fetch downloads a resource once per Inliner; concurrent callers for the
+// same URL share the result.
+func (i *Inliner) fetch(ctx context.Context, absURL *url.URL) *resource {
+	key := absURL.String()
+
+	i.mu.Lock()
+	if res, ok := i.cache[key]; ok {
+		i.mu.Unlock()
+		<-res.done
+		return res
+	}
+	res := &resource{done: make(chan struct{})}
+	i.cache[key] = res
+	i.mu.Unlock()
+
+	defer close(res.done)
+
+	select {
+	case i.sem <- struct{}{}:
+		defer func() { <-i.sem }()
+	case <-ctx.Done():
+		res.err = ctx.Err()
+		return res
 	}
 
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, absURL, http.NoBody)
+	res.content, res.contentType, res.err = i.fetchResource(ctx, key)
+	return res
+}
+
+func (i *Inliner) fetchResource(ctx context.Context, resourceURL string) (content []byte, contentType string, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, resourceURL, http.NoBody)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to create request: %w", err)
 	}
+	if i.userAgent != "" {
+		req.Header.Set("User-Agent", i.userAgent)
+	}
+	req.Header.Set("Referer", i.baseURL.String())
 
 	resp, err := i.httpClient.Do(req)
 	if err != nil {
@@ -215,29 +624,64 @@ func (i *Inliner) fetchResource(resourceURL string) (content []byte, contentType
 	if resp.StatusCode != http.StatusOK {
 		return nil, "", fmt.Errorf("resource returned status %d", resp.StatusCode)
 	}
+	if resp.ContentLength > i.maxSize {
+		return nil, "", fmt.Errorf("resource exceeds %d bytes", i.maxSize)
+	}
 
-	content, err = io.ReadAll(resp.Body)
+	content, err = io.ReadAll(io.LimitReader(resp.Body, i.maxSize+1))
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to read resource: %w", err)
 	}
+	if int64(len(content)) > i.maxSize {
+		return nil, "", fmt.Errorf("resource exceeds %d bytes", i.maxSize)
+	}
 
 	contentType = resp.Header.Get("Content-Type")
 	if contentType == "" {
-		contentType = "application/octet-stream"
+		contentType = http.DetectContentType(content)
 	}
 	if idx := strings.Index(contentType, ";"); idx != -1 {
 		contentType = contentType[:idx]
 	}
 
-	return content, contentType, nil
+	return content, strings.TrimSpace(contentType), nil
 }
 
-func (i *Inliner) resolveURL(resourceURL string) (string, error) {
-	parsed, err := url.Parse(resourceURL)
+// resolve turns a reference into an absolute http(s) URL. Fragments, data
+// URIs and other schemes are rejected so they are left untouched.
+func (i *Inliner) resolve(base *url.URL, ref string) (*url.URL, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "data:") {
+		return nil, fmt.Errorf("not a fetchable reference")
+	}
+
+	parsed, err := url.Parse(ref)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	resolved := base.ResolveReference(parsed)
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme: %s", resolved.Scheme)
 	}
+	resolved.Fragment = ""
+	return resolved, nil
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
 
-	resolved := i.baseURL.ResolveReference(parsed)
-	return resolved.String(), nil
+func removeAttr(n *html.Node, key string) {
+	for j, attr := range n.Attr {
+		if attr.Key == key {
+			n.Attr = append(n.Attr[:j], n.Attr[j+1:]...)
+			return
+		}
+	}
 }
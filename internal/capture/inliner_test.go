@@ -0,0 +1,241 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-rod/rod/lib/proto"
+	"golang.org/x/net/html"
+)
+
+func newTestInliner(t *testing.T, baseURL string, cfg *InlinerConfig) *Inliner {
+	t.Helper()
+	allowLoopback(t)
+	inliner, err := NewInliner(baseURL, cfg)
+	if err != nil {
+		t.Fatalf("NewInliner() error = %v", err)
+	}
+	return inliner
+}
+
+func TestInlineHTML(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/css/site.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		_, _ = w.Write([]byte(`@import "print.css" print; @font-face { src: url(fonts/a.woff2); } body { background: url('/bg.png'); }`))
+	})
+	mux.HandleFunc("/css/print.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		_, _ = w.Write([]byte(`h1 { color: black; }`))
+	})
+	mux.HandleFunc("/css/fonts/a.woff2", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "font/woff2")
+		_, _ = w.Write([]byte("font"))
+	})
+	for _, p := range []string{"/bg.png", "/small.png", "/large.png", "/icon.png", "/wide.png"} {
+		mux.HandleFunc(p, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte("png"))
+		})
+	}
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	page := `<html><head>
+<link rel="stylesheet" href="css/site.css" media="screen">
+<link rel="shortcut icon" href="/icon.png">
+</head><body>
+<img src="/small.png" srcset="/small.png 1x, /large.png 2x">
+<picture><source srcset="/wide.png" media="(min-width: 800px)"><img src="/small.png"></picture>
+<svg><rect fill="url(#grad)"></rect></svg>
+<div style="background-image: url(bg.png)"></div>
+</body></html>`
+
+	inliner := newTestInliner(t, srv.URL+"/", nil)
+	out, err := inliner.InlineHTML(context.Background(), []byte(page))
+	if err != nil {
+		t.Fatalf("InlineHTML() error = %v", err)
+	}
+	got := string(out)
+
+	if strings.Contains(got, srv.URL) || strings.Contains(got, `"/small.png`) || strings.Contains(got, "href=\"css/") {
+		t.Errorf("output still references external resources:\n%s", got)
+	}
+
+	wants := []string{
+		`<style media="screen">`,
+		"@media print {",
+		"h1 { color: black; }",
+		"url(data:font/woff2;base64,",
+		"url(data:image/png;base64,",
+		`href="data:image/png;base64,`,
+		`srcset="data:image/png;base64,cG5n 1x, data:image/png;base64,cG5n 2x"`,
+		`<source srcset="data:image/png;base64,cG5n"`,
+		"url(#grad)",
+	}
+	for _, want := range wants {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestInlineHTMLLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		cfg      *InlinerConfig
+		wantData int
+	}{
+		{"within limits", &InlinerConfig{}, 2},
+		{"resource too large", &InlinerConfig{MaxResourceSize: 50}, 0},
+		{"total cap reached", &InlinerConfig{MaxTotalSize: 200}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := `<img src="/a.png"><img src="/b.png">`
+			inliner := newTestInliner(t, srv.URL, tt.cfg)
+			out, err := inliner.InlineHTML(context.Background(), []byte(page))
+			if err != nil {
+				t.Fatalf("InlineHTML() error = %v", err)
+			}
+			if got := strings.Count(string(out), "data:image/png"); got != tt.wantData {
+				t.Errorf("inlined %d images, want %d", got, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestInlineHTMLForwardsBrowserIdentity(t *testing.T) {
+	var gotUA, gotCookie string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUA = r.Header.Get("User-Agent")
+		gotCookie = r.Header.Get("Cookie")
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	inliner := newTestInliner(t, srv.URL, &InlinerConfig{
+		UserAgent: "TestBrowser/1.0",
+		Cookies: []*proto.NetworkCookie{
+			{Name: "session", Value: "abc", Domain: "127.0.0.1", Path: "/"},
+			{Name: "other", Value: "x", Domain: "example.com", Path: "/"},
+		},
+	})
+	if _, err := inliner.InlineHTML(context.Background(), []byte(`<img src="/a.png">`)); err != nil {
+		t.Fatalf("InlineHTML() error = %v", err)
+	}
+
+	if gotUA != "TestBrowser/1.0" {
+		t.Errorf("User-Agent = %q, want %q", gotUA, "TestBrowser/1.0")
+	}
+	if gotCookie != "session=abc" {
+		t.Errorf("Cookie = %q, want %q", gotCookie, "session=abc")
+	}
+}
+
+func TestInlineHTMLRemovesScripts(t *testing.T) {
+	var fetched atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched.Add(1)
+		w.Header().Set("Content-Type", "text/javascript")
+		_, _ = w.Write([]byte(`document.body.append("widget")`))
+	}))
+	defer srv.Close()
+
+	page := `<html><head>
+<script src="/app.js"></script>
+<script type="module">import "/mod.js";</script>
+<script type="text/javascript">track()</script>
+<script type="application/ld+json">{"@type":"Article"}</script>
+</head><body onload="init()">
+<p>rendered</p>
+<a href=" JavaScript:alert(1)" onclick="go()">link</a>
+<a href="/about">about</a>
+<svg><script>svg()</script></svg>
+</body></html>`
+
+	inliner := newTestInliner(t, srv.URL, nil)
+	out, err := inliner.InlineHTML(context.Background(), []byte(page))
+	if err != nil {
+		t.Fatalf("InlineHTML() error = %v", err)
+	}
+
+	doc, err := html.Parse(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("Failed to parse output: %v", err)
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if n.Data == "script" && isExecutableScript(n) {
+				t.Errorf("output has a script that runs: %s", renderNode(n))
+			}
+			for _, attr := range n.Attr {
+				if strings.HasPrefix(attr.Key, "on") || isJavaScriptURL(attr.Val) {
+					t.Errorf("output has a script attribute on <%s>: %s=%q", n.Data, attr.Key, attr.Val)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	got := string(out)
+	for _, want := range []string{`<script type="application/ld+json">{"@type":"Article"}</script>`, "<p>rendered</p>", `<a href="/about">about</a>`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+	if n := fetched.Load(); n != 0 {
+		t.Errorf("Fetched %d scripts, want none", n)
+	}
+}
+
+func renderNode(n *html.Node) string {
+	var buf bytes.Buffer
+	_ = html.Render(&buf, n)
+	return buf.String()
+}
+
+func TestParseSrcset(t *testing.T) {
+	tests := []struct {
+		name   string
+		srcset string
+		want   []srcsetCandidate
+	}{
+		{"single", "a.png", []srcsetCandidate{{"a.png", ""}}},
+		{"descriptors", "a.png 1x, b.png 2x", []srcsetCandidate{{"a.png", "1x"}, {"b.png", "2x"}}},
+		{"widths no spaces", "a.png 100w,b.png 200w", []srcsetCandidate{{"a.png", "100w"}, {"b.png", "200w"}}},
+		{"data uri with comma", "data:image/png;base64,AAA 1x, b.png 2x", []srcsetCandidate{{"data:image/png;base64,AAA", "1x"}, {"b.png", "2x"}}},
+		{"trailing comma url", "a.png, b.png", []srcsetCandidate{{"a.png", ""}, {"b.png", ""}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseSrcset(tt.srcset)
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseSrcset(%q) = %v, want %v", tt.srcset, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("parseSrcset(%q)[%d] = %v, want %v", tt.srcset, i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
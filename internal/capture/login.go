@@ -0,0 +1,212 @@
+package capture
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"golang.org/x/net/http/httpguts"
+)
+
+// Login step actions.
+const (
+	LoginFill  = "fill"
+	LoginClick = "click"
+	LoginWait  = "wait"
+)
+
+const (
+	maxLoginSteps   = 20
+	maxExtraHeaders = 20
+)
+
+// LoginStep is one action of a login flow.
+type LoginStep struct {
+	Action   string `json:"action"`
+	Selector string `json:"selector"`
+	// Credential names the secret typed by a fill step. It is looked up in
+	// LoginFlow.Credentials so recipes never contain the secret itself.
+	Credential string `json:"credential,omitempty"`
+	// Value is literal text typed by a fill step when Credential is empty.
+	Value string `json:"value,omitempty"`
+}
+
+// LoginFlow signs in before the target page is captured, leaving the
+// session cookies in the capture's browser context.
+type LoginFlow struct {
+	URL         string
+	Steps       []LoginStep
+	Credentials map[string]string
+}
+
+// ValidateLoginSteps checks a recipe's steps. credentials lists the names
+// that fill steps may reference.
+func ValidateLoginSteps(steps []LoginStep, credentials map[string]string) error {
+	if len(steps) == 0 {
+		return fmt.Errorf("at least one step is required")
+	}
+	if len(steps) > maxLoginSteps {
+		return fmt.Errorf("at most %d steps are allowed", maxLoginSteps)
+	}
+	for i, step := range steps {
+		if strings.TrimSpace(step.Selector) == "" {
+			return fmt.Errorf("step %d: selector is required", i+1)
+		}
+		switch step.Action {
+		case LoginFill:
+			if step.Credential != "" {
+				if _, ok := credentials[step.Credential]; !ok {
+					return fmt.Errorf("step %d: unknown credential %q", i+1, step.Credential)
+				}
+			}
+		case LoginClick, LoginWait:
+			if step.Credential != "" || step.Value != "" {
+				return fmt.Errorf("step %d: only fill steps take a value", i+1)
+			}
+		default:
+			return fmt.Errorf("step %d: action must be fill, click or wait", i+1)
+		}
+	}
+	return nil
+}
+
+// login runs the flow on page. Errors name the failing step but never the
+// text typed, which may be a secret.
+func login(page *rod.Page, flow *LoginFlow) error {
+	if err := validateURL(flow.URL); err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+	if err := page.Navigate(flow.URL); err != nil {
+		return fmt.Errorf("login: failed to open %s: %w", flow.URL, err)
+	}
+	if err := page.WaitLoad(); err != nil {
+		return fmt.Errorf("login: failed to load %s: %w", flow.URL, err)
+	}
+
+	for i, step := range flow.Steps {
+		if err := runLoginStep(page, &step, flow.Credentials); err != nil {
+			return fmt.Errorf("login step %d (%s %s): %w", i+1, step.Action, step.Selector, err)
+		}
+	}
+
+	// A submit usually navigates; let the response land before the target
+	// is opened so its cookies are stored.
+	if err := page.WaitLoad(); err != nil {
+		return fmt.Errorf("login: failed to wait for page load: %w", err)
+	}
+	return nil
+}
+
+func runLoginStep(page *rod.Page, step *LoginStep, credentials map[string]string) error {
+	el, err := page.Element(step.Selector)
+	if err != nil {
+		return err
+	}
+
+	switch step.Action {
+	case LoginFill:
+		text := step.Value
+		if step.Credential != "" {
+			text = credentials[step.Credential]
+		}
+		if err := el.SelectAllText(); err != nil {
+			return err
+		}
+		return el.Input(text)
+	case LoginClick:
+		return el.Click(proto.InputMouseButtonLeft, 1)
+	case LoginWait:
+		// page.Element already waited for the selector to appear.
+		return nil
+	default:
+		return fmt.Errorf("unknown action")
+	}
+}
+
+// BasicAuth holds HTTP Basic credentials for the captured site.
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// forbiddenHeaders are managed by the browser or by other options.
+var forbiddenHeaders = map[string]bool{
+	"host":              true,
+	"content-length":    true,
+	"connection":        true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+	"cookie":            true,
+}
+
+// ValidateHeaders checks custom request headers.
+func ValidateHeaders(headers map[string]string) error {
+	if len(headers) > maxExtraHeaders {
+		return fmt.Errorf("at most %d headers are allowed", maxExtraHeaders)
+	}
+	for name, value := range headers {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if forbiddenHeaders[strings.ToLower(name)] {
+			return fmt.Errorf("header %s cannot be set", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return fmt.Errorf("invalid value for header %s", name)
+		}
+	}
+	return nil
+}
+
+// siteHeaders adds headers to requests for the captured site only, so
+// credentials and tokens are never sent to third parties.
+type siteHeaders struct {
+	site    string
+	headers map[string]string
+}
+
+func newSiteHeaders(target string, headers map[string]string, auth *BasicAuth) *siteHeaders {
+	if len(headers) == 0 && auth == nil {
+		return nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil
+	}
+
+	merged := make(map[string]string, len(headers)+1)
+	for name, value := range headers {
+		merged[http.CanonicalHeaderKey(name)] = value
+	}
+	if auth != nil {
+		req := &http.Request{Header: make(http.Header)}
+		req.SetBasicAuth(auth.Username, auth.Password)
+		merged["Authorization"] = req.Header.Get("Authorization")
+	}
+	return &siteHeaders{site: siteOf(u.Hostname()), headers: merged}
+}
+
+// apply returns the continue parameters for a request, adding the headers
+// when it targets the captured site.
+func (s *siteHeaders) apply(u *url.URL, headers proto.NetworkHeaders) *proto.FetchContinueRequest {
+	if s == nil || siteOf(u.Hostname()) != s.site {
+		return &proto.FetchContinueRequest{}
+	}
+
+	// Continuing with headers replaces all of them, so the originals are
+	// copied first.
+	entries := make([]*proto.FetchHeaderEntry, 0, len(headers)+len(s.headers))
+	for name, value := range headers {
+		if _, override := s.headers[http.CanonicalHeaderKey(name)]; override {
+			continue
+		}
+		entries = append(entries, &proto.FetchHeaderEntry{Name: name, Value: value.Str()})
+	}
+	for name, value := range s.headers {
+		entries = append(entries, &proto.FetchHeaderEntry{Name: name, Value: value})
+	}
+	return &proto.FetchContinueRequest{Headers: entries}
+}
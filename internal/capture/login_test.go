@@ -0,0 +1,80 @@
+package capture
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateLoginSteps(t *testing.T) {
+	credentials := map[string]string{"username": "alice", "password": "secret"}
+
+	tests := []struct {
+		name    string
+		steps   []LoginStep
+		wantErr bool
+	}{
+		{"typical flow", []LoginStep{
+			{Action: LoginFill, Selector: "#user", Credential: "username"},
+			{Action: LoginFill, Selector: "#pass", Credential: "password"},
+			{Action: LoginFill, Selector: "#remember", Value: "yes"},
+			{Action: LoginClick, Selector: "button[type=submit]"},
+			{Action: LoginWait, Selector: "#dashboard"},
+		}, false},
+		{"no steps", nil, true},
+		{"missing selector", []LoginStep{{Action: LoginClick}}, true},
+		{"unknown credential", []LoginStep{{Action: LoginFill, Selector: "#otp", Credential: "otp"}}, true},
+		{"value on click", []LoginStep{{Action: LoginClick, Selector: "a", Value: "x"}}, true},
+		{"unknown action", []LoginStep{{Action: "hover", Selector: "a"}}, true},
+		{"too many steps", make([]LoginStep, maxLoginSteps+1), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateLoginSteps(tt.steps, credentials); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateLoginSteps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"token", map[string]string{"Authorization": "Bearer abc", "X-Team": "archive"}, false},
+		{"invalid name", map[string]string{"X Team": "a"}, true},
+		{"newline in value", map[string]string{"X-Team": "a\r\nX-Other: b"}, true},
+		{"host", map[string]string{"host": "internal"}, true},
+		{"cookie", map[string]string{"Cookie": "a=b"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateHeaders(tt.headers); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateHeaders() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewSiteHeaders(t *testing.T) {
+	if h := newSiteHeaders("https://example.com", nil, nil); h != nil {
+		t.Errorf("newSiteHeaders() without headers = %+v, want nil", h)
+	}
+
+	h := newSiteHeaders("https://app.example.co.uk/dashboard",
+		map[string]string{"x-team": "archive"},
+		&BasicAuth{Username: "alice", Password: "secret"})
+	if h.site != "example.co.uk" {
+		t.Errorf("site = %q, want %q", h.site, "example.co.uk")
+	}
+	if h.headers["X-Team"] != "archive" {
+		t.Errorf("headers = %v, want canonical X-Team", h.headers)
+	}
+	if auth := h.headers["Authorization"]; !strings.HasPrefix(auth, "Basic ") {
+		t.Errorf("Authorization = %q, want Basic credentials", auth)
+	}
+}
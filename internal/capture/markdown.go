@@ -0,0 +1,337 @@
+package capture
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// markdownEscaper escapes characters that would otherwise start Markdown
+// formatting inside text.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`,
+)
+
+// Markdown renders the article as Markdown, headed by its title.
+func (a *Article) Markdown() string {
+	r := &readerRenderer{}
+	var sb strings.Builder
+	if a.Title != "" {
+		sb.WriteString("# " + r.escape(a.Title) + "\n\n")
+	}
+	if a.Byline != "" {
+		sb.WriteString("_" + r.escape(a.Byline) + "_\n\n")
+	}
+	sb.WriteString(r.blocks(a.Content))
+	return strings.TrimSpace(sb.String()) + "\n"
+}
+
+// Text renders the article as plain text, headed by its title.
+func (a *Article) Text() string {
+	r := &readerRenderer{plain: true}
+	var sb strings.Builder
+	if a.Title != "" {
+		sb.WriteString(a.Title + "\n\n")
+	}
+	if a.Byline != "" {
+		sb.WriteString(a.Byline + "\n\n")
+	}
+	sb.WriteString(r.blocks(a.Content))
+	return strings.TrimSpace(sb.String()) + "\n"
+}
+
+// readerRenderer converts cleaned article HTML to Markdown, or to plain
+// text with the same layout when plain is set.
+type readerRenderer struct {
+	plain bool
+}
+
+// blocks renders the children of n as blocks separated by blank lines.
+func (r *readerRenderer) blocks(n *html.Node) string {
+	var out []string
+	var para strings.Builder
+	flush := func() {
+		if text := r.collapse(para.String()); text != "" {
+			out = append(out, text)
+		}
+		para.Reset()
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (blockTags[c.DataAtom] || isBlockContainer(c)) {
+			flush()
+			if block := r.block(c); block != "" {
+				out = append(out, block)
+			}
+			continue
+		}
+		para.WriteString(r.inline(c))
+	}
+	flush()
+	return strings.Join(out, "\n\n")
+}
+
+func isBlockContainer(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Li, atom.Figcaption, atom.Dd, atom.Dt, atom.Details, atom.Summary:
+		return true
+	}
+	return false
+}
+
+func (r *readerRenderer) block(n *html.Node) string {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		text := r.collapse(r.inlineChildren(n))
+		if text == "" || r.plain {
+			return text
+		}
+		level := int(n.Data[1] - '0')
+		return strings.Repeat("#", level) + " " + text
+	case atom.P:
+		return r.collapse(r.inlineChildren(n))
+	case atom.Hr:
+		if r.plain {
+			return ""
+		}
+		return "---"
+	case atom.Pre:
+		code := strings.Trim(textContent(n), "\n")
+		if r.plain {
+			return code
+		}
+		fence := "```"
+		for strings.Contains(code, fence) {
+			fence += "`"
+		}
+		return fence + "\n" + code + "\n" + fence
+	case atom.Blockquote:
+		inner := r.blocks(n)
+		if r.plain || inner == "" {
+			return inner
+		}
+		return prefixLines(inner, "> ", "> ")
+	case atom.Ul, atom.Ol:
+		return r.list(n)
+	case atom.Table:
+		return r.table(n)
+	default:
+		return r.blocks(n)
+	}
+}
+
+func (r *readerRenderer) list(n *html.Node) string {
+	var items []string
+	number := 1
+	if start, err := strconv.Atoi(getAttr(n, "start")); err == nil {
+		number = start
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+			continue
+		}
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		content := r.blocks(c)
+		if content == "" {
+			continue
+		}
+		items = append(items, prefixLines(content, marker, strings.Repeat(" ", len(marker))))
+	}
+	return strings.Join(items, "\n")
+}
+
+// table renders rows as a GFM table, or as tab-separated lines in plain
+// text. The first row is used as the header.
+func (r *readerRenderer) table(n *html.Node) string {
+	var rows [][]string
+	walk(n, func(c *html.Node) bool {
+		if c.Type != html.ElementNode {
+			return true
+		}
+		if c.DataAtom == atom.Table && c != n {
+			return false
+		}
+		if c.DataAtom != atom.Tr {
+			return true
+		}
+		var cells []string
+		for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+			if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+				text := normalizeSpace(r.inlineChildren(cell))
+				cells = append(cells, strings.ReplaceAll(text, "|", `\|`))
+			}
+		}
+		if len(cells) > 0 {
+			rows = append(rows, cells)
+		}
+		return false
+	})
+	if len(rows) == 0 {
+		return ""
+	}
+
+	if r.plain {
+		lines := make([]string, len(rows))
+		for i, row := range rows {
+			lines[i] = strings.Join(row, "\t")
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row))
+	}
+	lines := make([]string, 0, len(rows)+1)
+	for i, row := range rows {
+		for len(row) < width {
+			row = append(row, "")
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", width))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *readerRenderer) inlineChildren(n *html.Node) string {
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(r.inline(c))
+	}
+	return sb.String()
+}
+
+func (r *readerRenderer) inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return r.escape(collapseSpace(n.Data))
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		return "\n"
+	case atom.Img:
+		alt := normalizeSpace(getAttr(n, "alt"))
+		src := getAttr(n, "src")
+		if r.plain || src == "" {
+			return alt
+		}
+		return "![" + r.escape(alt) + "](" + markdownURL(src) + ")"
+	case atom.Code, atom.Kbd, atom.Samp:
+		code := normalizeSpace(textContent(n))
+		if r.plain || code == "" {
+			return code
+		}
+		fence := "`"
+		for strings.Contains(code, fence) {
+			fence += "`"
+		}
+		return fence + code + fence
+	}
+
+	inner := r.inlineChildren(n)
+	if r.plain {
+		return inner
+	}
+	switch n.DataAtom {
+	case atom.A:
+		href := getAttr(n, "href")
+		text := strings.TrimSpace(inner)
+		if href == "" || text == "" || strings.HasPrefix(href, "#") {
+			return inner
+		}
+		return "[" + text + "](" + markdownURL(href) + ")"
+	case atom.Strong, atom.B:
+		return wrapInline(inner, "**")
+	case atom.Em, atom.I, atom.Cite:
+		return wrapInline(inner, "_")
+	case atom.Del, atom.S, atom.Strike:
+		return wrapInline(inner, "~~")
+	}
+	return inner
+}
+
+func (r *readerRenderer) escape(s string) string {
+	if r.plain {
+		return s
+	}
+	return markdownEscaper.Replace(s)
+}
+
+// wrapInline adds emphasis markers inside any surrounding whitespace, which
+// Markdown would otherwise not treat as emphasis.
+func wrapInline(s, marker string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	start := strings.Index(s, trimmed)
+	return s[:start] + marker + trimmed + marker + s[start+len(trimmed):]
+}
+
+func markdownURL(u string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(u)
+}
+
+// collapse trims inline content and turns the line breaks left by <br>
+// into hard breaks.
+func (r *readerRenderer) collapse(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = normalizeSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if r.plain {
+		return strings.Join(lines, "\n")
+	}
+	return strings.Join(lines, "\\\n")
+}
+
+// collapseSpace replaces whitespace runs in a text node with single spaces,
+// keeping a space at either end so adjacent inline elements stay apart.
+func collapseSpace(s string) string {
+	collapsed := strings.Join(strings.Fields(s), " ")
+	if collapsed == "" {
+		if s == "" {
+			return ""
+		}
+		return " "
+	}
+	if strings.TrimLeftFunc(s, unicode.IsSpace) != s {
+		collapsed = " " + collapsed
+	}
+	if strings.TrimRightFunc(s, unicode.IsSpace) != s {
+		collapsed += " "
+	}
+	return collapsed
+}
+
+// prefixLines prefixes the first line with first and the others with rest.
+func prefixLines(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" {
+			lines[i] = strings.TrimRight(prefix, " ")
+		} else {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
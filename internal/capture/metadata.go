@@ -0,0 +1,182 @@
+package capture
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// maxMetaValueLength truncates overly long meta values.
+	maxMetaValueLength = 1000
+	// maxCardProperties bounds the OpenGraph and Twitter card entries kept.
+	maxCardProperties = 50
+)
+
+// PageMetadata describes the captured page.
+type PageMetadata struct {
+	Title       string `json:"title,omitempty"`
+	FinalURL    string `json:"final_url,omitempty"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	Canonical   string `json:"canonical_url,omitempty"`
+	Favicon     string `json:"favicon_url,omitempty"`
+	Lang        string `json:"lang,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Headers are the main document's response headers, without
+	// Set-Cookie.
+	Headers map[string]string `json:"headers,omitempty"`
+	// OpenGraph and Twitter hold the og:* and twitter:* properties, keyed
+	// without the prefix.
+	OpenGraph map[string]string `json:"open_graph,omitempty"`
+	Twitter   map[string]string `json:"twitter,omitempty"`
+}
+
+// documentResponse keeps the latest response for the page's main frame,
+// which after redirects is the one that served the captured document.
+type documentResponse struct {
+	mu       sync.Mutex
+	response *proto.NetworkResponse
+}
+
+func watchDocumentResponse(page *rod.Page) *documentResponse {
+	d := &documentResponse{}
+	go page.EachEvent(func(e *proto.NetworkResponseReceived) {
+		if e.Type != proto.NetworkResourceTypeDocument || e.FrameID != page.FrameID {
+			return
+		}
+		d.mu.Lock()
+		d.response = e.Response
+		d.mu.Unlock()
+	})()
+	return d
+}
+
+func (d *documentResponse) get() *proto.NetworkResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.response
+}
+
+// ExtractMetadata reads the canonical URL, favicon, language, description
+// and social card properties from a page. pageURL resolves relative links.
+func ExtractMetadata(htmlContent []byte, pageURL string) *PageMetadata {
+	meta := &PageMetadata{
+		OpenGraph: make(map[string]string),
+		Twitter:   make(map[string]string),
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		base = &url.URL{}
+	}
+	doc, err := html.Parse(bytes.NewReader(htmlContent))
+	if err != nil {
+		return meta
+	}
+
+	var iconRank int
+	walk(doc, func(n *html.Node) bool {
+		if n.Type != html.ElementNode {
+			return true
+		}
+		switch n.DataAtom {
+		case atom.Html:
+			meta.Lang = strings.TrimSpace(getAttr(n, "lang"))
+		case atom.Title:
+			if meta.Title == "" {
+				meta.Title = normalizeSpace(textContent(n))
+			}
+		case atom.Link:
+			href := strings.TrimSpace(getAttr(n, "href"))
+			if href == "" {
+				break
+			}
+			for _, rel := range strings.Fields(strings.ToLower(getAttr(n, "rel"))) {
+				switch rel {
+				case "canonical":
+					if meta.Canonical == "" {
+						meta.Canonical = absoluteURL(base, href)
+					}
+				case "icon", "apple-touch-icon":
+					// Prefer a plain icon over touch icons.
+					rank := 1
+					if rel == "icon" {
+						rank = 2
+					}
+					if rank > iconRank {
+						meta.Favicon, iconRank = absoluteURL(base, href), rank
+					}
+				}
+			}
+		case atom.Meta:
+			readMetaTag(n, meta)
+		}
+		return true
+	})
+
+	if meta.Favicon == "" && (base.Scheme == "http" || base.Scheme == "https") {
+		meta.Favicon = (&url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/favicon.ico"}).String()
+	}
+	return meta
+}
+
+func readMetaTag(n *html.Node, meta *PageMetadata) {
+	key := getAttr(n, "property")
+	if key == "" {
+		key = getAttr(n, "name")
+	}
+	key = strings.ToLower(strings.TrimSpace(key))
+	value := normalizeSpace(getAttr(n, "content"))
+	if len(value) > maxMetaValueLength {
+		value = value[:maxMetaValueLength]
+	}
+
+	switch {
+	case strings.EqualFold(getAttr(n, "http-equiv"), "content-language"):
+		if meta.Lang == "" {
+			meta.Lang = value
+		}
+	case key == "description":
+		if meta.Description == "" {
+			meta.Description = value
+		}
+	case strings.HasPrefix(key, "og:"):
+		addCardProperty(meta.OpenGraph, strings.TrimPrefix(key, "og:"), value)
+	case strings.HasPrefix(key, "twitter:"):
+		addCardProperty(meta.Twitter, strings.TrimPrefix(key, "twitter:"), value)
+	}
+}
+
+// addCardProperty keeps the first value of each property; later ones, such
+// as extra og:image entries, are ignored.
+func addCardProperty(card map[string]string, key, value string) {
+	if key == "" || value == "" || len(card) >= maxCardProperties {
+		return
+	}
+	if _, ok := card[key]; !ok {
+		card[key] = value
+	}
+}
+
+// setResponse records the main document's status and headers. Set-Cookie
+// is dropped since it may carry session secrets.
+func (m *PageMetadata) setResponse(resp *proto.NetworkResponse) {
+	m.StatusCode = resp.Status
+	m.Headers = make(map[string]string, len(resp.Headers))
+	for name, value := range resp.Headers {
+		canonical := http.CanonicalHeaderKey(name)
+		if strings.HasPrefix(name, ":") || canonical == "Set-Cookie" {
+			continue
+		}
+		m.Headers[canonical] = value.Str()
+	}
+	if m.Lang == "" {
+		m.Lang = m.Headers["Content-Language"]
+	}
+}
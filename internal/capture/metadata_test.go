@@ -0,0 +1,98 @@
+package capture
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+func TestExtractMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		page    string
+		pageURL string
+		want    PageMetadata
+	}{
+		{
+			name: "full head",
+			page: `<html lang="en-GB"><head><title> Rivers
+of the World </title>
+<link rel="apple-touch-icon" href="/touch.png">
+<link rel="shortcut icon" href="/static/icon.png">
+<link rel="canonical" href="/2024/rivers">
+<meta name="description" content="All about rivers.">
+<meta property="og:title" content="Rivers">
+<meta property="og:image" content="https://cdn.example.com/a.jpg">
+<meta property="og:image" content="https://cdn.example.com/b.jpg">
+<meta name="twitter:card" content="summary_large_image">
+</head><body></body></html>`,
+			pageURL: "https://news.example.com/2024/rivers?ref=home",
+			want: PageMetadata{
+				Title:       "Rivers of the World",
+				Canonical:   "https://news.example.com/2024/rivers",
+				Favicon:     "https://news.example.com/static/icon.png",
+				Lang:        "en-GB",
+				Description: "All about rivers.",
+				OpenGraph:   map[string]string{"title": "Rivers", "image": "https://cdn.example.com/a.jpg"},
+				Twitter:     map[string]string{"card": "summary_large_image"},
+			},
+		},
+		{
+			name:    "defaults",
+			page:    `<html><head><meta http-equiv="Content-Language" content="fr"></head><body><p>Bonjour</p></body></html>`,
+			pageURL: "https://example.fr/page",
+			want: PageMetadata{
+				Favicon:   "https://example.fr/favicon.ico",
+				Lang:      "fr",
+				OpenGraph: map[string]string{},
+				Twitter:   map[string]string{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExtractMetadata([]byte(tt.page), tt.pageURL)
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("ExtractMetadata() = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestExtractMetadataTruncates(t *testing.T) {
+	page := `<meta name="description" content="` + strings.Repeat("a", 2*maxMetaValueLength) + `">`
+	if got := ExtractMetadata([]byte(page), "https://example.com/"); len(got.Description) != maxMetaValueLength {
+		t.Errorf("description length = %d, want %d", len(got.Description), maxMetaValueLength)
+	}
+}
+
+func TestPageMetadataSetResponse(t *testing.T) {
+	meta := &PageMetadata{}
+	meta.setResponse(&proto.NetworkResponse{
+		Status: 404,
+		Headers: networkHeaders(map[string]string{
+			"content-type":     "text/html",
+			"content-language": "de",
+			"set-cookie":       "session=secret",
+			":status":          "404",
+		}),
+	})
+
+	if meta.StatusCode != 404 || meta.Lang != "de" {
+		t.Errorf("setResponse() status = %d, lang = %q", meta.StatusCode, meta.Lang)
+	}
+	want := map[string]string{"Content-Type": "text/html", "Content-Language": "de"}
+	if len(meta.Headers) != len(want) {
+		t.Errorf("setResponse() headers = %v, want %v", meta.Headers, want)
+	}
+	for name, value := range want {
+		if meta.Headers[name] != value {
+			t.Errorf("header %s = %q, want %q", name, meta.Headers[name], value)
+		}
+	}
+}
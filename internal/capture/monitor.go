@@ -0,0 +1,114 @@
+package capture
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// maxIgnoreSelectors bounds MonitorOptions.IgnoreSelectors.
+const maxIgnoreSelectors = 20
+
+// MaxSnapshotPixels bounds the snapshot screenshot, which is decoded in
+// full to reduce it to a thumbnail. Longer pages are compared by their top
+// part only.
+const MaxSnapshotPixels = 16 << 20
+
+// MonitorOptions turns on change detection: each capture is compared with
+// the previous capture of the same page and only delivered when it changed.
+type MonitorOptions struct {
+	// Threshold is the share of text lines or screenshot area, from 0 to
+	// 1, that must change for a capture to count as changed. Zero means
+	// any change.
+	Threshold float64 `json:"threshold,omitempty"`
+	// IgnoreSelectors hides elements that change on every visit, such as
+	// clocks, counters and ad slots, before the page is compared.
+	IgnoreSelectors []string `json:"ignore_selectors,omitempty"`
+}
+
+// Validate reports the first invalid option.
+func (o *MonitorOptions) Validate() error {
+	if o.Threshold < 0 || o.Threshold > 1 {
+		return fmt.Errorf("threshold must be between 0 and 1")
+	}
+	if len(o.IgnoreSelectors) > maxIgnoreSelectors {
+		return fmt.Errorf("at most %d ignore_selectors are allowed", maxIgnoreSelectors)
+	}
+	for _, selector := range o.IgnoreSelectors {
+		if strings.TrimSpace(selector) == "" {
+			return fmt.Errorf("ignore_selectors must not be empty")
+		}
+	}
+	return nil
+}
+
+// Snapshot is what change detection compares a capture by.
+type Snapshot struct {
+	// Text is the page's rendered text, as the user would copy it.
+	Text string
+	// Screenshot is a full-page PNG, cut off at MaxSnapshotPixels.
+	Screenshot []byte
+}
+
+// snapshotJS hides the ignored elements and returns the page text.
+// Selectors the browser rejects are skipped.
+const snapshotJS = `(selectors) => {
+	for (const selector of selectors) {
+		let elements = [];
+		try {
+			elements = document.querySelectorAll(selector);
+		} catch (e) {
+			continue;
+		}
+		for (const el of elements) el.style.setProperty('display', 'none', 'important');
+	}
+	return document.body ? document.body.innerText : '';
+}`
+
+// snapshotSizeJS returns the page's size in CSS pixels and how many device
+// pixels make one.
+const snapshotSizeJS = `() => ({
+	width: document.documentElement.scrollWidth,
+	height: document.documentElement.scrollHeight,
+	scale: window.devicePixelRatio || 1,
+})`
+
+// takeSnapshot runs after every output is rendered, since hiding the
+// ignored elements changes the page.
+func takeSnapshot(page *rod.Page, opts *MonitorOptions) (*Snapshot, error) {
+	selectors := opts.IgnoreSelectors
+	if selectors == nil {
+		selectors = []string{}
+	}
+	text, err := page.Eval(snapshotJS, selectors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page text: %w", err)
+	}
+	size, err := page.Eval(snapshotSizeJS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure page: %w", err)
+	}
+	width := max(size.Value.Get("width").Num(), 1)
+	height := snapshotHeight(width, size.Value.Get("height").Num(), size.Value.Get("scale").Num())
+
+	screenshot, err := page.Screenshot(false, &proto.PageCaptureScreenshot{
+		Format:                proto.PageCaptureScreenshotFormatPng,
+		Clip:                  &proto.PageViewport{Width: width, Height: height, Scale: 1},
+		CaptureBeyondViewport: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to take screenshot: %w", err)
+	}
+	return &Snapshot{Text: text.Value.Str(), Screenshot: screenshot}, nil
+}
+
+// snapshotHeight returns how much of a page of the given CSS size fits in
+// MaxSnapshotPixels at scale device pixels per CSS pixel. The clip is
+// rounded up to whole device pixels, so the rounded width is used.
+func snapshotHeight(width, height, scale float64) float64 {
+	maxHeight := math.Floor(MaxSnapshotPixels/math.Ceil(width*scale)) / scale
+	return min(max(height, 1), maxHeight)
+}
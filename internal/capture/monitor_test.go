@@ -0,0 +1,62 @@
+package capture
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMonitorOptionsValidate(t *testing.T) {
+	tooMany := make([]string, maxIgnoreSelectors+1)
+	for i := range tooMany {
+		tooMany[i] = ".ad"
+	}
+
+	tests := []struct {
+		name    string
+		opts    MonitorOptions
+		wantErr bool
+	}{
+		{"defaults", MonitorOptions{}, false},
+		{"custom", MonitorOptions{Threshold: 0.05, IgnoreSelectors: []string{"#clock", ".ad-slot"}}, false},
+		{"whole page", MonitorOptions{Threshold: 1}, false},
+		{"negative threshold", MonitorOptions{Threshold: -0.1}, true},
+		{"threshold above one", MonitorOptions{Threshold: 5}, true},
+		{"empty selector", MonitorOptions{IgnoreSelectors: []string{" "}}, true},
+		{"too many selectors", MonitorOptions{IgnoreSelectors: tooMany}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSnapshotHeight(t *testing.T) {
+	tests := []struct {
+		name                 string
+		width, height, scale float64
+		want                 float64
+	}{
+		{"short page", 1280, 2000, 1, 2000},
+		{"empty page", 1280, 0, 1, 1},
+		{"long page", 1920, 100000, 1, 8738},
+		{"long page at 2x", 1920, 100000, 2, 2184.5},
+		{"fractional scale", 1366, 100000, 1.25, math.Floor(MaxSnapshotPixels/math.Ceil(1366*1.25)) / 1.25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := snapshotHeight(tt.width, tt.height, tt.scale)
+			if got != tt.want {
+				t.Errorf("snapshotHeight(%v, %v, %v) = %v, want %v", tt.width, tt.height, tt.scale, got, tt.want)
+			}
+			pixels := math.Ceil(tt.width*tt.scale) * math.Ceil(got*tt.scale)
+			if pixels > MaxSnapshotPixels {
+				t.Errorf("snapshotHeight(%v, %v, %v) gives %v device pixels, max %d", tt.width, tt.height, tt.scale, pixels, MaxSnapshotPixels)
+			}
+		})
+	}
+}
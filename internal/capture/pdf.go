@@ -0,0 +1,143 @@
+package capture
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// Paper sizes in inches (width x height, portrait).
+var paperSizes = map[string][2]float64{
+	"a3":      {11.69, 16.54},
+	"a4":      {8.27, 11.69},
+	"a5":      {5.83, 8.27},
+	"letter":  {8.5, 11},
+	"legal":   {8.5, 14},
+	"tabloid": {11, 17},
+}
+
+const defaultPaperSize = "letter"
+
+// Default header and footer used when ShowHeaderFooter is set without
+// explicit templates. Chromium fills elements with the classes url, title,
+// date, pageNumber and totalPages.
+const (
+	defaultHeaderTemplate = `<div style="font-size:8px;width:100%;padding:0 0.4in;display:flex;justify-content:space-between;">` +
+		`<span class="url"></span><span class="date"></span></div>`
+	defaultFooterTemplate = `<div style="font-size:8px;width:100%;text-align:center;">` +
+		`<span class="pageNumber"></span> / <span class="totalPages"></span></div>`
+	// headerFooterMargin leaves room for the templates when no margin is set.
+	headerFooterMargin = 0.6
+)
+
+var pageRangesPattern = regexp.MustCompile(`^\s*\d+(\s*-\s*\d*)?(\s*,\s*\d+(\s*-\s*\d*)?)*\s*$`)
+
+// PDFOptions controls PDF rendering. Sizes and margins are in inches.
+// Custom sizes are given with PaperWidth and PaperHeight instead of PaperSize.
+type PDFOptions struct {
+	PaperSize         string   `json:"paper_size,omitempty"`
+	PaperWidth        float64  `json:"paper_width,omitempty"`
+	PaperHeight       float64  `json:"paper_height,omitempty"`
+	Landscape         bool     `json:"landscape,omitempty"`
+	MarginTop         *float64 `json:"margin_top,omitempty"`
+	MarginBottom      *float64 `json:"margin_bottom,omitempty"`
+	MarginLeft        *float64 `json:"margin_left,omitempty"`
+	MarginRight       *float64 `json:"margin_right,omitempty"`
+	Scale             float64  `json:"scale,omitempty"`
+	PageRanges        string   `json:"page_ranges,omitempty"`
+	PreferCSSPageSize bool     `json:"prefer_css_page_size,omitempty"`
+	OmitBackground    bool     `json:"omit_background,omitempty"`
+	ShowHeaderFooter  bool     `json:"show_header_footer,omitempty"`
+	HeaderTemplate    string   `json:"header_template,omitempty"`
+	FooterTemplate    string   `json:"footer_template,omitempty"`
+}
+
+// Validate reports the first invalid option.
+func (o *PDFOptions) Validate() error {
+	if o.PaperSize != "" {
+		if _, ok := paperSizes[strings.ToLower(o.PaperSize)]; !ok {
+			return fmt.Errorf("unsupported paper size: %s", o.PaperSize)
+		}
+		if o.PaperWidth != 0 || o.PaperHeight != 0 {
+			return fmt.Errorf("paper_size cannot be combined with paper_width/paper_height")
+		}
+	}
+	if (o.PaperWidth != 0) != (o.PaperHeight != 0) {
+		return fmt.Errorf("paper_width and paper_height must be set together")
+	}
+	if o.PaperWidth < 0 || o.PaperWidth > 100 || o.PaperHeight < 0 || o.PaperHeight > 100 {
+		return fmt.Errorf("paper dimensions must be between 0 and 100 inches")
+	}
+	for _, m := range []*float64{o.MarginTop, o.MarginBottom, o.MarginLeft, o.MarginRight} {
+		if m != nil && (*m < 0 || *m > 10) {
+			return fmt.Errorf("margins must be between 0 and 10 inches")
+		}
+	}
+	if o.Scale != 0 && (o.Scale < 0.1 || o.Scale > 2) {
+		return fmt.Errorf("scale must be between 0.1 and 2")
+	}
+	if o.PageRanges != "" && !pageRangesPattern.MatchString(o.PageRanges) {
+		return fmt.Errorf("invalid page_ranges: %s", o.PageRanges)
+	}
+	return nil
+}
+
+func (o *PDFOptions) printParams() *proto.PagePrintToPDF {
+	size := paperSizes[defaultPaperSize]
+	if o.PaperSize != "" {
+		size = paperSizes[strings.ToLower(o.PaperSize)]
+	} else if o.PaperWidth > 0 && o.PaperHeight > 0 {
+		size = [2]float64{o.PaperWidth, o.PaperHeight}
+	}
+	width, height := size[0], size[1]
+
+	params := &proto.PagePrintToPDF{
+		Landscape:         o.Landscape,
+		PrintBackground:   !o.OmitBackground,
+		PaperWidth:        &width,
+		PaperHeight:       &height,
+		MarginTop:         o.MarginTop,
+		MarginBottom:      o.MarginBottom,
+		MarginLeft:        o.MarginLeft,
+		MarginRight:       o.MarginRight,
+		PageRanges:        strings.ReplaceAll(o.PageRanges, " ", ""),
+		PreferCSSPageSize: o.PreferCSSPageSize,
+	}
+	if o.Scale != 0 {
+		scale := o.Scale
+		params.Scale = &scale
+	}
+
+	if o.ShowHeaderFooter || o.HeaderTemplate != "" || o.FooterTemplate != "" {
+		params.DisplayHeaderFooter = true
+		params.HeaderTemplate = o.HeaderTemplate
+		params.FooterTemplate = o.FooterTemplate
+		if o.ShowHeaderFooter {
+			if params.HeaderTemplate == "" {
+				params.HeaderTemplate = defaultHeaderTemplate
+			}
+			if params.FooterTemplate == "" {
+				params.FooterTemplate = defaultFooterTemplate
+			}
+		}
+		// Chromium renders a default title/date header when a template is
+		// empty; a blank span suppresses it.
+		if params.HeaderTemplate == "" {
+			params.HeaderTemplate = "<span></span>"
+		}
+		if params.FooterTemplate == "" {
+			params.FooterTemplate = "<span></span>"
+		}
+		margin := headerFooterMargin
+		if params.MarginTop == nil {
+			params.MarginTop = &margin
+		}
+		if params.MarginBottom == nil {
+			params.MarginBottom = &margin
+		}
+	}
+
+	return params
+}
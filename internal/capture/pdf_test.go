@@ -0,0 +1,78 @@
+package capture
+
+import (
+	"testing"
+)
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func TestPDFOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    PDFOptions
+		wantErr bool
+	}{
+		{"empty", PDFOptions{}, false},
+		{"a4 landscape", PDFOptions{PaperSize: "A4", Landscape: true}, false},
+		{"custom size", PDFOptions{PaperWidth: 5, PaperHeight: 7}, false},
+		{"margins and scale", PDFOptions{MarginTop: floatPtr(0), MarginLeft: floatPtr(1), Scale: 0.8}, false},
+		{"page ranges", PDFOptions{PageRanges: "1-3, 5, 8-"}, false},
+		{"unknown paper size", PDFOptions{PaperSize: "B7"}, true},
+		{"size with custom dimensions", PDFOptions{PaperSize: "A4", PaperWidth: 5, PaperHeight: 7}, true},
+		{"width without height", PDFOptions{PaperWidth: 5}, true},
+		{"negative margin", PDFOptions{MarginBottom: floatPtr(-1)}, true},
+		{"scale too large", PDFOptions{Scale: 3}, true},
+		{"invalid page ranges", PDFOptions{PageRanges: "one-two"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPDFOptionsPrintParams(t *testing.T) {
+	defaults := (&PDFOptions{}).printParams()
+	if *defaults.PaperWidth != 8.5 || *defaults.PaperHeight != 11 {
+		t.Errorf("Default paper = %vx%v, want 8.5x11", *defaults.PaperWidth, *defaults.PaperHeight)
+	}
+	if !defaults.PrintBackground {
+		t.Error("Default should print backgrounds")
+	}
+	if defaults.DisplayHeaderFooter {
+		t.Error("Default should not display header/footer")
+	}
+
+	a4 := (&PDFOptions{PaperSize: "a4", Scale: 0.5, PageRanges: "1 - 2"}).printParams()
+	if *a4.PaperWidth != 8.27 || *a4.PaperHeight != 11.69 {
+		t.Errorf("A4 paper = %vx%v, want 8.27x11.69", *a4.PaperWidth, *a4.PaperHeight)
+	}
+	if a4.Scale == nil || *a4.Scale != 0.5 {
+		t.Errorf("Scale = %v, want 0.5", a4.Scale)
+	}
+	if a4.PageRanges != "1-2" {
+		t.Errorf("PageRanges = %q, want %q", a4.PageRanges, "1-2")
+	}
+
+	withFooter := (&PDFOptions{ShowHeaderFooter: true, MarginTop: floatPtr(1)}).printParams()
+	if !withFooter.DisplayHeaderFooter {
+		t.Error("ShowHeaderFooter should enable header/footer")
+	}
+	if withFooter.HeaderTemplate != defaultHeaderTemplate || withFooter.FooterTemplate != defaultFooterTemplate {
+		t.Error("ShowHeaderFooter should use default templates")
+	}
+	if *withFooter.MarginTop != 1 || withFooter.MarginBottom == nil || *withFooter.MarginBottom != headerFooterMargin {
+		t.Errorf("Margins = %v/%v, want 1/%v", *withFooter.MarginTop, withFooter.MarginBottom, headerFooterMargin)
+	}
+
+	footerOnly := (&PDFOptions{FooterTemplate: `<span class="pageNumber"></span>`}).printParams()
+	if footerOnly.HeaderTemplate != "<span></span>" {
+		t.Errorf("HeaderTemplate = %q, want blank span", footerOnly.HeaderTemplate)
+	}
+}
@@ -0,0 +1,400 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Pool defaults, used when the matching PoolConfig field is zero.
+const (
+	defaultPoolSize            = 1
+	defaultPagesPerBrowser     = 3
+	defaultRecycleAfterPages   = 100
+	defaultHealthCheckInterval = 30 * time.Second
+	healthCheckTimeout         = 5 * time.Second
+)
+
+// ErrPoolClosed is returned by Capture after Close.
+var ErrPoolClosed = errors.New("browser pool closed")
+
+type PoolConfig struct {
+	Browser BrowserConfig
+	// Size is the number of browser processes kept running.
+	Size int
+	// PagesPerBrowser limits concurrent captures in one browser.
+	PagesPerBrowser int
+	// RecycleAfterPages restarts a browser after it has served this many
+	// captures, which bounds slow leaks in long-running processes.
+	RecycleAfterPages int
+	// MaxMemory restarts a browser whose process tree uses more than this
+	// many bytes. Zero disables the check.
+	MaxMemory int64
+	// HealthCheckInterval is how often idle browsers are pinged and their
+	// memory is measured.
+	HealthCheckInterval time.Duration
+	// launch starts a browser; tests replace it to avoid Chromium.
+	launch func(*BrowserConfig) (browserHandle, error)
+}
+
+// browserHandle is the part of *Browser the pool depends on.
+type browserHandle interface {
+	Capture(ctx context.Context, opts *CaptureOptions) (*CaptureResult, error)
+	Ping(ctx context.Context) error
+	MemoryUsage() (int64, error)
+	Close() error
+}
+
+type pooledBrowser struct {
+	browser  browserHandle
+	active   int
+	served   int
+	retiring bool
+}
+
+// Pool shares a few browser processes between capture workers. Browsers
+// are launched on demand, recycled after a number of pages or when their
+// memory grows too large, and replaced when they stop responding.
+type Pool struct {
+	cfg   PoolConfig
+	slots chan struct{}
+
+	mu       sync.Mutex
+	browsers []*pooledBrowser
+	closed   bool
+	// launching counts browsers being started outside mu; changed is
+	// broadcast when one is registered, pages are released or the pool
+	// closes.
+	launching int
+	changed   *sync.Cond
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewPool(cfg *PoolConfig) *Pool {
+	p := &Pool{cfg: *cfg, stop: make(chan struct{})}
+	if p.cfg.Size <= 0 {
+		p.cfg.Size = defaultPoolSize
+	}
+	if p.cfg.PagesPerBrowser <= 0 {
+		p.cfg.PagesPerBrowser = defaultPagesPerBrowser
+	}
+	if p.cfg.RecycleAfterPages <= 0 {
+		p.cfg.RecycleAfterPages = defaultRecycleAfterPages
+	}
+	if p.cfg.HealthCheckInterval <= 0 {
+		p.cfg.HealthCheckInterval = defaultHealthCheckInterval
+	}
+	if p.cfg.launch == nil {
+		p.cfg.launch = func(bc *BrowserConfig) (browserHandle, error) {
+			return NewBrowser(bc)
+		}
+	}
+	p.slots = make(chan struct{}, p.cfg.Size*p.cfg.PagesPerBrowser)
+	p.changed = sync.NewCond(&p.mu)
+	return p
+}
+
+// Start runs periodic health checks until Close is called.
+func (p *Pool) Start() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.cfg.HealthCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.checkHealth()
+			}
+		}
+	}()
+}
+
+// Close stops health checks and shuts down every browser. Captures still in
+// flight fail as their browser goes away.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	browsers := p.browsers
+	p.browsers = nil
+	p.changed.Broadcast()
+	p.mu.Unlock()
+
+	close(p.stop)
+	p.wg.Wait()
+
+	for _, pb := range browsers {
+		closeBrowser(pb.browser)
+	}
+}
+
+// Capture runs a capture on a pooled browser, waiting for a free page slot.
+func (p *Pool) Capture(ctx context.Context, opts *CaptureOptions) (*CaptureResult, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	pb, err := p.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := pb.browser.Capture(ctx, opts)
+	healthy := true
+	if err != nil {
+		// A failed capture may mean the process died; check before handing
+		// the browser to the next task.
+		pingCtx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+		if pingErr := pb.browser.Ping(pingCtx); pingErr != nil {
+			log.Warn().Err(pingErr).Msg("Browser failed after capture error, replacing")
+			healthy = false
+		}
+		cancel()
+	}
+	p.release(pb, healthy)
+
+	return result, err
+}
+
+// acquire picks the least busy live browser with a free page, launching a
+// new one when the pool is not yet full. The caller holds a slot, so one of
+// the two is always possible, though it may mean waiting for a browser
+// another caller is launching. Launches run without p.mu, which they would
+// otherwise hold for seconds.
+func (p *Pool) acquire() (*pooledBrowser, error) {
+	p.mu.Lock()
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		best, live := p.leastBusy()
+		live += p.launching
+		if best != nil && (best.active == 0 || live >= p.cfg.Size) {
+			p.use(best)
+			p.mu.Unlock()
+			return best, nil
+		}
+		if live < p.cfg.Size {
+			break
+		}
+		p.changed.Wait()
+	}
+	p.launching++
+	p.mu.Unlock()
+
+	browser, err := p.cfg.launch(&p.cfg.Browser)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.launching--
+	p.changed.Broadcast()
+
+	if p.closed {
+		if err == nil {
+			go closeBrowser(browser)
+		}
+		return nil, ErrPoolClosed
+	}
+	if err != nil {
+		best, _ := p.leastBusy()
+		if best == nil {
+			return nil, fmt.Errorf("failed to launch browser: %w", err)
+		}
+		log.Warn().Err(err).Msg("Failed to launch additional browser")
+		p.use(best)
+		return best, nil
+	}
+
+	pb := &pooledBrowser{browser: browser}
+	p.browsers = append(p.browsers, pb)
+	log.Info().Int("browsers", len(p.browsers)).Msg("Launched pooled browser")
+	p.use(pb)
+	return pb, nil
+}
+
+// leastBusy returns the live browser with the fewest pages among those with
+// a free one, and how many browsers are live. The caller holds p.mu.
+func (p *Pool) leastBusy() (best *pooledBrowser, live int) {
+	for _, pb := range p.browsers {
+		if pb.retiring {
+			continue
+		}
+		live++
+		if pb.active < p.cfg.PagesPerBrowser && (best == nil || pb.active < best.active) {
+			best = pb
+		}
+	}
+	return best, live
+}
+
+// use counts a page on pb. The caller holds p.mu.
+func (p *Pool) use(pb *pooledBrowser) {
+	pb.active++
+	pb.served++
+	if pb.served >= p.cfg.RecycleAfterPages {
+		pb.retiring = true
+	}
+}
+
+func (p *Pool) release(pb *pooledBrowser, healthy bool) {
+	p.mu.Lock()
+	pb.active--
+	if !healthy {
+		pb.retiring = true
+	}
+	closeNow := pb.retiring && pb.active == 0 && p.remove(pb)
+	p.changed.Broadcast()
+	p.mu.Unlock()
+
+	if closeNow {
+		go closeBrowser(pb.browser)
+	}
+}
+
+// remove drops pb from the pool and reports whether it was still present.
+// The caller holds p.mu.
+func (p *Pool) remove(pb *pooledBrowser) bool {
+	for i, b := range p.browsers {
+		if b == pb {
+			p.browsers = append(p.browsers[:i], p.browsers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// checkHealth replaces browsers that stopped responding and retires the
+// ones using too much memory. Busy browsers are only pinged; retiring them
+// waits until their captures finish.
+func (p *Pool) checkHealth() {
+	p.mu.Lock()
+	browsers := make([]*pooledBrowser, len(p.browsers))
+	copy(browsers, p.browsers)
+	p.mu.Unlock()
+
+	for _, pb := range browsers {
+		ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+		err := pb.browser.Ping(ctx)
+		cancel()
+
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else if p.cfg.MaxMemory > 0 {
+			if rss, memErr := pb.browser.MemoryUsage(); memErr == nil && rss > p.cfg.MaxMemory {
+				reason = fmt.Sprintf("memory usage %d bytes exceeds %d", rss, p.cfg.MaxMemory)
+			}
+		}
+		if reason == "" {
+			continue
+		}
+
+		p.mu.Lock()
+		pb.retiring = true
+		// A dead browser is dropped at once so new captures launch a
+		// replacement; its in-flight captures are already failing.
+		closeNow := (err != nil || pb.active == 0) && p.remove(pb)
+		p.changed.Broadcast()
+		p.mu.Unlock()
+
+		log.Warn().Str("reason", reason).Msg("Recycling pooled browser")
+		if closeNow {
+			closeBrowser(pb.browser)
+		}
+	}
+}
+
+func closeBrowser(b browserHandle) {
+	if err := b.Close(); err != nil {
+		log.Debug().Err(err).Msg("Error closing browser")
+	}
+}
+
+// processTreeRSS sums the resident memory of pid and its descendants using
+// /proc, which covers Chromium's renderer and GPU processes.
+func processTreeRSS(pid int) (int64, error) {
+	if pid == 0 {
+		return 0, fmt.Errorf("unknown browser pid")
+	}
+
+	statFiles, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil || len(statFiles) == 0 {
+		return 0, fmt.Errorf("process information unavailable")
+	}
+
+	children := make(map[int][]int)
+	for _, f := range statFiles {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		// The command name is parenthesised and may contain spaces, so
+		// fields are read after the last ')'.
+		stat := string(data)
+		idx := strings.LastIndexByte(stat, ')')
+		if idx == -1 {
+			continue
+		}
+		fields := strings.Fields(stat[idx+1:])
+		if len(fields) < 2 {
+			continue
+		}
+		ppid, _ := strconv.Atoi(fields[1])
+		child, _ := strconv.Atoi(filepath.Base(filepath.Dir(f)))
+		children[ppid] = append(children[ppid], child)
+	}
+
+	var total int64
+	queue := []int{pid}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		total += processRSS(current)
+		queue = append(queue, children[current]...)
+	}
+	return total, nil
+}
+
+func processRSS(pid int) int64 {
+	f, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "status"))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "VmRSS:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return 0
+		}
+		kb, _ := strconv.ParseInt(fields[1], 10, 64)
+		return kb * 1024
+	}
+	return 0
+}
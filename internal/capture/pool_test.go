@@ -0,0 +1,267 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeBrowser struct {
+	mu         sync.Mutex
+	captures   int
+	active     int32
+	maxActive  int32
+	dead       atomic.Bool
+	closed     atomic.Bool
+	memory     int64
+	captureErr error
+	hold       chan struct{}
+}
+
+func (f *fakeBrowser) Capture(ctx context.Context, opts *CaptureOptions) (*CaptureResult, error) {
+	n := atomic.AddInt32(&f.active, 1)
+	defer atomic.AddInt32(&f.active, -1)
+	for {
+		m := atomic.LoadInt32(&f.maxActive)
+		if n <= m || atomic.CompareAndSwapInt32(&f.maxActive, m, n) {
+			break
+		}
+	}
+	if f.hold != nil {
+		<-f.hold
+	}
+
+	f.mu.Lock()
+	f.captures++
+	f.mu.Unlock()
+	if f.captureErr != nil {
+		return nil, f.captureErr
+	}
+	return &CaptureResult{}, nil
+}
+
+func (f *fakeBrowser) Ping(ctx context.Context) error {
+	if f.dead.Load() {
+		return errors.New("connection closed")
+	}
+	return nil
+}
+
+func (f *fakeBrowser) MemoryUsage() (int64, error) { return f.memory, nil }
+
+func (f *fakeBrowser) Close() error {
+	f.closed.Store(true)
+	return nil
+}
+
+type fakeLauncher struct {
+	mu       sync.Mutex
+	browsers []*fakeBrowser
+	setup    func(*fakeBrowser)
+}
+
+func (l *fakeLauncher) launch(*BrowserConfig) (browserHandle, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := &fakeBrowser{}
+	if l.setup != nil {
+		l.setup(b)
+	}
+	l.browsers = append(l.browsers, b)
+	return b, nil
+}
+
+func (l *fakeLauncher) launched() []*fakeBrowser {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*fakeBrowser(nil), l.browsers...)
+}
+
+func newTestPool(l *fakeLauncher, cfg PoolConfig) *Pool {
+	cfg.launch = l.launch
+	return NewPool(&cfg)
+}
+
+func TestPoolRecyclesAfterPages(t *testing.T) {
+	l := &fakeLauncher{}
+	p := newTestPool(l, PoolConfig{Size: 1, PagesPerBrowser: 1, RecycleAfterPages: 2})
+	defer p.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := p.Capture(context.Background(), &CaptureOptions{}); err != nil {
+			t.Fatalf("Capture() error = %v", err)
+		}
+	}
+
+	browsers := l.launched()
+	if len(browsers) != 3 {
+		t.Fatalf("launched %d browsers, want 3", len(browsers))
+	}
+	// Closing happens in the background once the last page is released.
+	deadline := time.Now().Add(time.Second)
+	for !browsers[1].closed.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !browsers[0].closed.Load() || !browsers[1].closed.Load() {
+		t.Error("recycled browsers were not closed")
+	}
+	if browsers[2].closed.Load() {
+		t.Error("current browser should still be open")
+	}
+}
+
+func TestPoolReplacesCrashedBrowser(t *testing.T) {
+	l := &fakeLauncher{}
+	p := newTestPool(l, PoolConfig{Size: 1})
+	defer p.Close()
+
+	if _, err := p.Capture(context.Background(), &CaptureOptions{}); err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+
+	first := l.launched()[0]
+	first.dead.Store(true)
+	first.captureErr = errors.New("websocket closed")
+
+	if _, err := p.Capture(context.Background(), &CaptureOptions{}); err == nil {
+		t.Fatal("expected capture on crashed browser to fail")
+	}
+	if _, err := p.Capture(context.Background(), &CaptureOptions{}); err != nil {
+		t.Fatalf("Capture() after crash error = %v", err)
+	}
+
+	if got := len(l.launched()); got != 2 {
+		t.Errorf("launched %d browsers, want 2", got)
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		dead      bool
+		memory    int64
+		wantClose bool
+	}{
+		{"healthy", false, 100, false},
+		{"unresponsive", true, 0, true},
+		{"memory growth", false, 2048, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &fakeLauncher{}
+			p := newTestPool(l, PoolConfig{Size: 1, MaxMemory: 1024})
+			defer p.Close()
+
+			if _, err := p.Capture(context.Background(), &CaptureOptions{}); err != nil {
+				t.Fatalf("Capture() error = %v", err)
+			}
+			b := l.launched()[0]
+			b.dead.Store(tt.dead)
+			b.memory = tt.memory
+
+			p.checkHealth()
+
+			if got := b.closed.Load(); got != tt.wantClose {
+				t.Errorf("closed = %v, want %v", got, tt.wantClose)
+			}
+		})
+	}
+}
+
+func TestPoolLimitsConcurrentPages(t *testing.T) {
+	hold := make(chan struct{})
+	l := &fakeLauncher{setup: func(b *fakeBrowser) { b.hold = hold }}
+	p := newTestPool(l, PoolConfig{Size: 2, PagesPerBrowser: 2})
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = p.Capture(context.Background(), &CaptureOptions{})
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(hold)
+	wg.Wait()
+
+	browsers := l.launched()
+	if len(browsers) != 2 {
+		t.Fatalf("launched %d browsers, want 2", len(browsers))
+	}
+	for i, b := range browsers {
+		if m := atomic.LoadInt32(&b.maxActive); m > 2 {
+			t.Errorf("browser %d ran %d pages at once, want at most 2", i, m)
+		}
+	}
+}
+
+func TestPoolLaunchDoesNotBlock(t *testing.T) {
+	l := &fakeLauncher{}
+	started, unblock := make(chan struct{}), make(chan struct{})
+	var launches atomic.Int32
+	cfg := PoolConfig{Size: 2, PagesPerBrowser: 1}
+	cfg.launch = func(bc *BrowserConfig) (browserHandle, error) {
+		if launches.Add(1) == 2 {
+			close(started)
+			<-unblock
+		}
+		return l.launch(bc)
+	}
+	p := NewPool(&cfg)
+	defer p.Close()
+	defer close(unblock)
+
+	hold := make(chan struct{})
+	l.setup = func(b *fakeBrowser) { b.hold = hold }
+	first := make(chan error, 1)
+	go func() {
+		_, err := p.Capture(context.Background(), &CaptureOptions{})
+		first <- err
+	}()
+	for len(l.launched()) == 0 || atomic.LoadInt32(&l.launched()[0].active) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// The second capture launches the second browser, which hangs.
+	go func() { _, _ = p.Capture(context.Background(), &CaptureOptions{}) }()
+	<-started
+
+	done := make(chan struct{})
+	go func() {
+		p.checkHealth()
+		close(hold)
+		if err := <-first; err != nil {
+			t.Errorf("Capture() error = %v", err)
+		}
+		// The first browser is free again and takes the next capture.
+		if _, err := p.Capture(context.Background(), &CaptureOptions{}); err != nil {
+			t.Errorf("Capture() error = %v", err)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("captures and health checks blocked behind a browser launch")
+	}
+
+	if got := l.launched()[0].captures; got != 2 {
+		t.Errorf("first browser ran %d captures, want 2", got)
+	}
+}
+
+func TestPoolClosed(t *testing.T) {
+	p := newTestPool(&fakeLauncher{}, PoolConfig{})
+	p.Close()
+
+	if _, err := p.Capture(context.Background(), &CaptureOptions{}); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Capture() error = %v, want ErrPoolClosed", err)
+	}
+}
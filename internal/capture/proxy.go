@@ -0,0 +1,232 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// lookupIPAddr resolves hostnames for the address checks. Tests replace it
+// to simulate DNS rebinding.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// dialPublic resolves the host of address itself and dials the resolved
+// addresses through safeDialer, so the address that is checked is the one
+// that is connected to.
+func dialPublic(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+	}
+	if isBlockedHostname(host) {
+		return nil, fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	if net.ParseIP(host) != nil {
+		return safeDialer.DialContext(ctx, network, address)
+	}
+
+	addrs, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("failed to resolve %s: no addresses", host)
+	}
+	for _, addr := range addrs {
+		conn, dialErr := safeDialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+		if dialErr == nil {
+			return conn, nil
+		}
+		err = dialErr
+	}
+	return nil, err
+}
+
+// proxyTransport forwards plain HTTP requests for the egress proxy. The
+// browser follows redirects itself, each through the proxy again.
+var proxyTransport = &http.Transport{
+	Proxy:               nil,
+	DialContext:         dialPublic,
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 8,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// defaultTunnelPorts are the ports CONNECT may reach: HTTPS, and plain
+// HTTP for WebSocket upgrades.
+var defaultTunnelPorts = []int{443, 80}
+
+// tunnelIdleTimeout closes tunnels that carry no data in either direction
+// for this long. Tests shorten it.
+var tunnelIdleTimeout = 2 * time.Minute
+
+// egressProxy is the HTTP proxy every browser connects through, including
+// those of out-of-process frames, workers and WebSockets, which request
+// interception does not see. Its connections are made by dialPublic, so a
+// hostname that resolves to a blocked address when Chromium connects is
+// refused even if it resolved to a public one when the request was checked.
+type egressProxy struct {
+	listener    net.Listener
+	server      *http.Server
+	forward     *httputil.ReverseProxy
+	tunnelPorts []int
+	idleTimeout time.Duration
+}
+
+// startEgressProxy starts a proxy whose tunnels may reach the default ports
+// and extraPorts.
+func startEgressProxy(extraPorts []int) (*egressProxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start egress proxy: %w", err)
+	}
+
+	p := &egressProxy{
+		listener:    listener,
+		tunnelPorts: slices.Concat(defaultTunnelPorts, extraPorts),
+		idleTimeout: tunnelIdleTimeout,
+	}
+	p.forward = &httputil.ReverseProxy{
+		// Requests are forwarded as they are, without X-Forwarded headers.
+		Rewrite:   func(*httputil.ProxyRequest) {},
+		Transport: proxyTransport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			p.refuse(w, r, err)
+		},
+	}
+	p.server = &http.Server{
+		Handler:           p,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := p.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("Egress proxy stopped")
+		}
+	}()
+	return p, nil
+}
+
+// Addr returns the host:port the proxy listens on.
+func (p *egressProxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Close stops the proxy. Open tunnels end when the browser goes away.
+func (p *egressProxy) Close() error {
+	return p.server.Close()
+}
+
+func (p *egressProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	if r.URL.Scheme != "http" || r.URL.Host == "" {
+		http.Error(w, "only absolute http URLs are proxied", http.StatusBadRequest)
+		return
+	}
+	p.forward.ServeHTTP(w, r)
+}
+
+// tunnel serves CONNECT requests, which carry HTTPS and WebSocket traffic.
+func (p *egressProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	_, port, err := net.SplitHostPort(r.Host)
+	if n, convErr := strconv.Atoi(port); err != nil || convErr != nil || !slices.Contains(p.tunnelPorts, n) {
+		p.refuse(w, r, fmt.Errorf("%w: port %q is not allowed", ErrBlockedAddress, port))
+		return
+	}
+
+	upstream, err := dialPublic(r.Context(), "tcp", r.Host)
+	if err != nil {
+		p.refuse(w, r, err)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "tunnelling not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+
+	// Bytes the client sent after the request are already buffered.
+	var fromClient io.Reader = client
+	if n := buffered.Reader.Buffered(); n > 0 {
+		pending, _ := buffered.Reader.Peek(n)
+		fromClient = io.MultiReader(bytes.NewReader(pending), client)
+	}
+
+	idle := &idleDeadline{conns: []net.Conn{client, upstream}, timeout: p.idleTimeout}
+	idle.extend()
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(upstream, activeReader{fromClient, idle})
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(client, activeReader{upstream, idle})
+		done <- struct{}{}
+	}()
+	<-done
+	client.Close()
+	upstream.Close()
+}
+
+// idleDeadline pushes back the deadline of a tunnel's connections whenever
+// data moves in either direction, so a tunnel is closed once both sides go
+// quiet but not while a response streams one way.
+type idleDeadline struct {
+	conns   []net.Conn
+	timeout time.Duration
+}
+
+func (d *idleDeadline) extend() {
+	deadline := time.Now().Add(d.timeout)
+	for _, conn := range d.conns {
+		_ = conn.SetDeadline(deadline)
+	}
+}
+
+// activeReader extends the tunnel's deadline on every read that returns data.
+type activeReader struct {
+	r    io.Reader
+	idle *idleDeadline
+}
+
+func (a activeReader) Read(b []byte) (int, error) {
+	n, err := a.r.Read(b)
+	if n > 0 {
+		a.idle.extend()
+	}
+	return n, err
+}
+
+func (p *egressProxy) refuse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrBlockedAddress) {
+		log.Warn().Err(err).Str("host", r.Host).Msg("Blocked browser connection")
+		http.Error(w, "blocked address", http.StatusForbidden)
+		return
+	}
+	log.Debug().Err(err).Str("host", r.Host).Msg("Browser connection failed")
+	http.Error(w, "bad gateway", http.StatusBadGateway)
+}
@@ -0,0 +1,182 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeLookup makes hostnames resolve to each of answers in turn, the last
+// one repeating, for the duration of a test.
+func fakeLookup(t *testing.T, answers ...string) {
+	t.Helper()
+	orig := lookupIPAddr
+	var mu sync.Mutex
+	lookupIPAddr = func(_ context.Context, _ string) ([]net.IPAddr, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		ip := net.ParseIP(answers[0])
+		if len(answers) > 1 {
+			answers = answers[1:]
+		}
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+	t.Cleanup(func() { lookupIPAddr = orig })
+}
+
+func proxyClient(t *testing.T, p *egressProxy) *http.Client {
+	t.Helper()
+	proxyURL, _ := url.Parse("http://" + p.Addr())
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec // httptest certificate
+	}}
+}
+
+func TestEgressProxy(t *testing.T) {
+	tests := []struct {
+		name      string
+		tls       bool
+		loopback  bool
+		allowPort bool
+		answers   []string
+		wantHit   bool
+	}{
+		{"http to public address", false, true, true, []string{"127.0.0.1"}, true},
+		{"https to public address", true, true, true, []string{"127.0.0.1"}, true},
+		{"https to a port not allowed", true, true, false, []string{"127.0.0.1"}, false},
+		{"http rebound to loopback", false, false, true, []string{"93.184.216.34", "127.0.0.1"}, false},
+		{"https rebound to loopback", true, false, true, []string{"93.184.216.34", "127.0.0.1"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hits.Add(1)
+				_, _ = w.Write([]byte("ok"))
+			})
+			server := httptest.NewUnstartedServer(handler)
+			scheme := "http"
+			if tt.tls {
+				server.StartTLS()
+				scheme = "https"
+			} else {
+				server.Start()
+			}
+			defer server.Close()
+
+			if tt.loopback {
+				allowLoopback(t)
+			}
+			fakeLookup(t, tt.answers...)
+
+			// The page's request is checked first, as request interception
+			// does, and passes whenever the first answer is public.
+			_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+			target, _ := url.Parse(scheme + "://rebind.example:" + port + "/")
+			if !tt.loopback {
+				if err := newRequestGuard().check(context.Background(), target); err != nil {
+					t.Fatalf("check() error = %v, want the first lookup to pass", err)
+				}
+			}
+
+			var extraPorts []int
+			if tt.allowPort {
+				n, _ := strconv.Atoi(port)
+				extraPorts = append(extraPorts, n)
+			}
+			p, err := startEgressProxy(extraPorts)
+			if err != nil {
+				t.Fatalf("startEgressProxy() error = %v", err)
+			}
+			defer p.Close()
+
+			resp, err := proxyClient(t, p).Get(target.String())
+			if err == nil {
+				resp.Body.Close()
+			}
+			if tt.wantHit {
+				if err != nil || resp.StatusCode != http.StatusOK {
+					t.Fatalf("Get() = %v, %v, want 200", resp, err)
+				}
+			} else if err == nil && resp.StatusCode != http.StatusForbidden {
+				t.Errorf("Get() status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+			}
+			if got := hits.Load() > 0; got != tt.wantHit {
+				t.Errorf("server hit = %v, want %v", got, tt.wantHit)
+			}
+		})
+	}
+}
+
+func TestEgressProxyClosesIdleTunnels(t *testing.T) {
+	allowLoopback(t)
+	orig := tunnelIdleTimeout
+	tunnelIdleTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { tunnelIdleTimeout = orig })
+
+	// The upstream accepts and then says nothing.
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer upstream.Close()
+	held := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := upstream.Accept(); err == nil {
+			held <- conn
+		}
+	}()
+	defer func() {
+		select {
+		case conn := <-held:
+			conn.Close()
+		default:
+		}
+	}()
+	_, port, _ := net.SplitHostPort(upstream.Addr().String())
+	n, _ := strconv.Atoi(port)
+
+	p, err := startEgressProxy([]int{n})
+	if err != nil {
+		t.Fatalf("startEgressProxy() error = %v", err)
+	}
+	defer p.Close()
+
+	conn, err := net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("CONNECT " + upstream.Addr().String() + " HTTP/1.1\r\nHost: " + upstream.Addr().String() + "\r\n\r\n"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT = %v, %v, want 200", resp, err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Read() on idle tunnel error = %v, want EOF", err)
+	}
+}
+
+func TestDialPublicRejectsBlockedHostnames(t *testing.T) {
+	for _, address := range []string{"localhost:80", "metadata.google.internal:80", "127.0.0.1:80", "no-port"} {
+		if conn, err := dialPublic(context.Background(), "tcp", address); err == nil {
+			conn.Close()
+			t.Errorf("dialPublic(%q) error = nil, want blocked", address)
+		}
+	}
+}
@@ -0,0 +1,485 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Article is the main content of a page, extracted the way browser reader
+// modes do.
+type Article struct {
+	Title    string
+	Byline   string
+	Excerpt  string
+	SiteName string
+	Lang     string
+	URL      string
+	// Content is a <div> holding the cleaned article body. Links and image
+	// sources in it are absolute.
+	Content *html.Node
+}
+
+const (
+	// minParagraphLength is the text length below which a paragraph does
+	// not count towards its container's score.
+	minParagraphLength = 25
+	// maxScoredAncestors is how many levels above a paragraph get a share
+	// of its score.
+	maxScoredAncestors = 3
+)
+
+// Class and id hints, after Mozilla's Readability.
+var (
+	unlikelyPattern = regexp.MustCompile(`(?i)-ad-|ai2html|banner|breadcrumbs|combx|comment|community|cover-wrap|disqus|extra|footer|gdpr|header|legends|menu|related|remark|replies|rss|shoutbox|sidebar|skyscraper|social|sponsor|supplemental|ad-break|agegate|pagination|pager|popup|yom-remote|cookie|consent|newsletter|subscribe`)
+	maybePattern    = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow`)
+	positivePattern = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+	negativePattern = regexp.MustCompile(`(?i)-ad-|hidden|^hid$| hid$| hid |^hid |banner|combx|comment|com-|contact|foot|footer|footnote|gdpr|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|tool|widget`)
+)
+
+// strippedTags never hold article content.
+var strippedTags = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Iframe: true, atom.Object: true, atom.Embed: true, atom.Canvas: true,
+	atom.Svg: true, atom.Form: true, atom.Input: true, atom.Button: true,
+	atom.Select: true, atom.Textarea: true, atom.Nav: true, atom.Aside: true,
+	atom.Footer: true, atom.Link: true, atom.Meta: true, atom.Dialog: true,
+}
+
+var blockTags = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Div: true, atom.Dl: true, atom.Fieldset: true, atom.Figure: true,
+	atom.Footer: true, atom.Form: true, atom.H1: true, atom.H2: true, atom.H3: true,
+	atom.H4: true, atom.H5: true, atom.H6: true, atom.Header: true, atom.Hr: true,
+	atom.Main: true, atom.Nav: true, atom.Ol: true, atom.P: true, atom.Pre: true,
+	atom.Section: true, atom.Table: true, atom.Ul: true,
+}
+
+// keptAttrs survive cleaning; everything else, including styles and event
+// handlers, is dropped.
+var keptAttrs = map[string]bool{
+	"href": true, "src": true, "alt": true, "title": true,
+	"colspan": true, "rowspan": true, "start": true, "lang": true, "dir": true,
+}
+
+// lazySrcAttrs hold the real image source on lazy-loaded images.
+var lazySrcAttrs = []string{"data-src", "data-original", "data-lazy-src", "data-url"}
+
+// ExtractArticle finds the main content of an HTML page. pageURL is used to
+// make links and image sources absolute.
+func ExtractArticle(htmlContent []byte, pageURL string) (*Article, error) {
+	doc, err := html.Parse(bytes.NewReader(htmlContent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page URL: %w", err)
+	}
+
+	article := &Article{URL: pageURL}
+	readMetadata(doc, article)
+
+	body := findElement(doc, atom.Body)
+	if body == nil {
+		return nil, fmt.Errorf("page has no body")
+	}
+	pruneUnlikely(body)
+
+	content := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for _, n := range selectContent(body) {
+		n.Parent.RemoveChild(n)
+		content.AppendChild(n)
+	}
+	cleanArticle(content, base, article.Title)
+
+	if normalizeSpace(textContent(content)) == "" && findElement(content, atom.Img) == nil {
+		return nil, fmt.Errorf("no readable content found")
+	}
+	article.Content = content
+
+	if article.Title == "" {
+		if h1 := findElement(doc, atom.H1); h1 != nil {
+			article.Title = normalizeSpace(textContent(h1))
+		}
+	}
+	if article.Excerpt == "" {
+		if p := findElement(content, atom.P); p != nil {
+			article.Excerpt = normalizeSpace(textContent(p))
+		}
+	}
+	return article, nil
+}
+
+// readMetadata fills the title, byline, excerpt, site name and language
+// from the document head, preferring OpenGraph values.
+func readMetadata(doc *html.Node, article *Article) {
+	meta := make(map[string]string)
+	var title string
+	walk(doc, func(n *html.Node) bool {
+		switch n.DataAtom {
+		case atom.Html:
+			article.Lang = getAttr(n, "lang")
+		case atom.Title:
+			if title == "" {
+				title = normalizeSpace(textContent(n))
+			}
+		case atom.Meta:
+			key := getAttr(n, "property")
+			if key == "" {
+				key = getAttr(n, "name")
+			}
+			key = strings.ToLower(key)
+			if _, seen := meta[key]; key != "" && !seen {
+				meta[key] = normalizeSpace(getAttr(n, "content"))
+			}
+		case atom.Body:
+			return false
+		}
+		return true
+	})
+
+	article.Title = firstNonEmpty(meta["og:title"], meta["twitter:title"], title)
+	article.Byline = firstNonEmpty(meta["author"], meta["article:author"])
+	article.Excerpt = firstNonEmpty(meta["og:description"], meta["twitter:description"], meta["description"])
+	article.SiteName = meta["og:site_name"]
+}
+
+// pruneUnlikely removes elements that are hidden or, judging by their
+// class and id, navigation, comments, ads and similar.
+func pruneUnlikely(root *html.Node) {
+	var remove []*html.Node
+	walk(root, func(n *html.Node) bool {
+		if n.Type == html.CommentNode {
+			remove = append(remove, n)
+			return false
+		}
+		if n.Type != html.ElementNode || n == root {
+			return true
+		}
+		if strippedTags[n.DataAtom] || isHidden(n) {
+			remove = append(remove, n)
+			return false
+		}
+		switch n.DataAtom {
+		case atom.Article, atom.Main, atom.A, atom.Table, atom.Tbody, atom.Tr, atom.Td, atom.Th:
+			return true
+		}
+		hints := getAttr(n, "class") + " " + getAttr(n, "id")
+		if unlikelyPattern.MatchString(hints) && !maybePattern.MatchString(hints) ||
+			getAttr(n, "role") == "complementary" || getAttr(n, "role") == "navigation" {
+			remove = append(remove, n)
+			return false
+		}
+		return true
+	})
+	for _, n := range remove {
+		n.Parent.RemoveChild(n)
+	}
+}
+
+func isHidden(n *html.Node) bool {
+	if _, ok := attr(n, "hidden"); ok || getAttr(n, "aria-hidden") == "true" {
+		return true
+	}
+	style := strings.ReplaceAll(strings.ToLower(getAttr(n, "style")), " ", "")
+	return strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden")
+}
+
+// selectContent scores containers by the paragraphs they hold and returns
+// the best one together with any siblings that look like part of the same
+// article.
+func selectContent(body *html.Node) []*html.Node {
+	scores := make(map[*html.Node]float64)
+	var candidates []*html.Node
+
+	walk(body, func(n *html.Node) bool {
+		if n.Type != html.ElementNode || !isParagraphLike(n) {
+			return true
+		}
+		text := normalizeSpace(textContent(n))
+		if len(text) < minParagraphLength {
+			return true
+		}
+		score := 1 + float64(strings.Count(text, ",")) + math.Min(float64(len(text)/100), 3)
+
+		level := 0
+		for ancestor := n.Parent; ancestor != nil && ancestor != body.Parent && level < maxScoredAncestors; ancestor = ancestor.Parent {
+			if ancestor.Type != html.ElementNode {
+				continue
+			}
+			if _, ok := scores[ancestor]; !ok {
+				scores[ancestor] = initialScore(ancestor)
+				candidates = append(candidates, ancestor)
+			}
+			divider := 1.0
+			switch level {
+			case 0:
+			case 1:
+				divider = 2
+			default:
+				divider = float64(level * 3)
+			}
+			scores[ancestor] += score / divider
+			level++
+		}
+		// Paragraphs are not containers themselves.
+		return n.DataAtom != atom.P
+	})
+
+	var top *html.Node
+	var topScore float64
+	for _, c := range candidates {
+		scores[c] *= 1 - linkDensity(c)
+		if top == nil || scores[c] > topScore {
+			top, topScore = c, scores[c]
+		}
+	}
+	// Paragraphs placed straight in the body have no better container.
+	if top == nil || top == body {
+		var children []*html.Node
+		for c := body.FirstChild; c != nil; c = c.NextSibling {
+			children = append(children, c)
+		}
+		return children
+	}
+
+	threshold := math.Max(10, topScore*0.2)
+	topHints := getAttr(top, "class")
+	var selected []*html.Node
+	for s := top.Parent.FirstChild; s != nil; s = s.NextSibling {
+		if s.Type != html.ElementNode {
+			continue
+		}
+		include := s == top
+		if !include {
+			bonus := 0.0
+			if topHints != "" && getAttr(s, "class") == topHints {
+				bonus = topScore * 0.2
+			}
+			if score, ok := scores[s]; ok && score+bonus >= threshold {
+				include = true
+			} else if s.DataAtom == atom.P {
+				text := normalizeSpace(textContent(s))
+				density := linkDensity(s)
+				include = len(text) > 80 && density < 0.25 ||
+					len(text) > 0 && density == 0 && strings.HasSuffix(text, ".")
+			}
+		}
+		if include {
+			selected = append(selected, s)
+		}
+	}
+	return selected
+}
+
+func isParagraphLike(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.P, atom.Pre, atom.Td, atom.Blockquote:
+		return true
+	case atom.Div, atom.Section:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && blockTags[c.DataAtom] {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func initialScore(n *html.Node) float64 {
+	score := classWeight(n)
+	switch n.DataAtom {
+	case atom.Article, atom.Main:
+		score += 10
+	case atom.Div:
+		score += 5
+	case atom.Pre, atom.Td, atom.Blockquote:
+		score += 3
+	case atom.Address, atom.Ol, atom.Ul, atom.Dl, atom.Dd, atom.Dt, atom.Li, atom.Form:
+		score -= 3
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Th:
+		score -= 5
+	}
+	return score
+}
+
+func classWeight(n *html.Node) float64 {
+	var weight float64
+	for _, hint := range []string{getAttr(n, "class"), getAttr(n, "id")} {
+		if hint == "" {
+			continue
+		}
+		if negativePattern.MatchString(hint) {
+			weight -= 25
+		}
+		if positivePattern.MatchString(hint) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+// linkDensity is the share of n's text that sits inside links.
+func linkDensity(n *html.Node) float64 {
+	total := len(normalizeSpace(textContent(n)))
+	if total == 0 {
+		return 0
+	}
+	linked := 0
+	walk(n, func(c *html.Node) bool {
+		if c.DataAtom == atom.A {
+			linked += len(normalizeSpace(textContent(c)))
+			return false
+		}
+		return true
+	})
+	return float64(linked) / float64(total)
+}
+
+// cleanArticle removes link farms and empty paragraphs from the selected
+// content, strips presentational attributes and makes URLs absolute.
+func cleanArticle(content *html.Node, base *url.URL, title string) {
+	var remove []*html.Node
+	walk(content, func(n *html.Node) bool {
+		if n.Type != html.ElementNode || n == content {
+			return true
+		}
+		switch n.DataAtom {
+		case atom.Ul, atom.Ol, atom.Div, atom.Section, atom.Table:
+			if classWeight(n) < 0 || linkDensity(n) > 0.5 {
+				remove = append(remove, n)
+				return false
+			}
+		case atom.H1, atom.H2:
+			// The title is rendered separately.
+			if title != "" && normalizeSpace(textContent(n)) == title {
+				remove = append(remove, n)
+				return false
+			}
+		case atom.P:
+			if normalizeSpace(textContent(n)) == "" && findElement(n, atom.Img) == nil {
+				remove = append(remove, n)
+				return false
+			}
+		case atom.Img:
+			src := getAttr(n, "src")
+			if src == "" || strings.HasPrefix(src, "data:") {
+				for _, key := range lazySrcAttrs {
+					if lazy := getAttr(n, key); lazy != "" {
+						setAttr(n, "src", lazy)
+						break
+					}
+				}
+			}
+		}
+
+		kept := n.Attr[:0]
+		for _, a := range n.Attr {
+			if keptAttrs[a.Key] {
+				kept = append(kept, a)
+			}
+		}
+		n.Attr = kept
+		for _, key := range []string{"href", "src"} {
+			if v, ok := attr(n, key); ok {
+				setAttr(n, key, absoluteURL(base, v))
+			}
+		}
+		return true
+	})
+	for _, n := range remove {
+		n.Parent.RemoveChild(n)
+	}
+}
+
+// absoluteURL resolves ref against base. Script URLs are dropped.
+func absoluteURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if strings.HasPrefix(strings.ToLower(ref), "javascript:") {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
+
+// walk visits n and its descendants depth first. Returning false from fn
+// skips the children of the node.
+func walk(n *html.Node, fn func(*html.Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		walk(c, fn)
+		c = next
+	}
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	var found *html.Node
+	walk(n, func(c *html.Node) bool {
+		if found != nil {
+			return false
+		}
+		if c.Type == html.ElementNode && c.DataAtom == a {
+			found = c
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	walk(n, func(c *html.Node) bool {
+		if c.Type == html.TextNode {
+			sb.WriteString(c.Data)
+		}
+		return true
+	})
+	return sb.String()
+}
+
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func attr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,160 @@
+package capture
+
+import (
+	"strings"
+	"testing"
+)
+
+const testArticlePage = `<!DOCTYPE html>
+<html lang="en"><head>
+<title>Ignored title | Example News</title>
+<meta property="og:title" content="Rivers of the World">
+<meta property="og:site_name" content="Example News">
+<meta name="author" content="Jane Doe">
+<script>var tracking = true;</script>
+</head><body>
+<header class="site-header"><a href="/">Home</a> <a href="/world">World</a></header>
+<nav><ul><li><a href="/a">A</a></li><li><a href="/b">B</a></li></ul></nav>
+<div id="main-wrapper">
+  <article class="post-body">
+    <h1>Rivers of the World</h1>
+    <p>Rivers shape the land they cross, carving valleys, depositing silt, and feeding the farms, towns and cities that grow along their banks.</p>
+    <p>The <a href="/nile">Nile</a>, the Amazon and the Yangtze are the longest, each running for thousands of kilometres across several countries.</p>
+    <img data-src="/img/nile.jpg" src="data:image/gif;base64,R0lGOD" alt="The Nile at dusk">
+    <h2>Why they matter</h2>
+    <ul><li>Water for <strong>drinking</strong> and farming, supplied to millions of people every day</li><li>Transport for goods and people</li></ul>
+    <p style="display:none">Hidden promotional text that should never appear in the article.</p>
+  </article>
+  <div class="comments"><p>First! Great article, thanks for writing it, really enjoyed the read.</p></div>
+</div>
+<aside class="sidebar"><p>Subscribe to our newsletter for more stories like this one, every single week.</p></aside>
+<footer><p>Copyright Example News, all rights reserved, since the beginning of time.</p></footer>
+</body></html>`
+
+func TestExtractArticle(t *testing.T) {
+	article, err := ExtractArticle([]byte(testArticlePage), "https://news.example.com/2024/rivers")
+	if err != nil {
+		t.Fatalf("ExtractArticle() error = %v", err)
+	}
+
+	if article.Title != "Rivers of the World" {
+		t.Errorf("Title = %q, want og:title", article.Title)
+	}
+	if article.Byline != "Jane Doe" || article.SiteName != "Example News" || article.Lang != "en" {
+		t.Errorf("metadata = %q/%q/%q", article.Byline, article.SiteName, article.Lang)
+	}
+	if !strings.HasPrefix(article.Excerpt, "Rivers shape the land") {
+		t.Errorf("Excerpt = %q, want first paragraph", article.Excerpt)
+	}
+
+	text := textContent(article.Content)
+	for _, want := range []string{"carving valleys", "Yangtze", "Why they matter", "Transport for goods"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("content missing %q", want)
+		}
+	}
+	for _, unwanted := range []string{"tracking", "First!", "newsletter", "Copyright", "Hidden promotional", "World"} {
+		if strings.Contains(text, unwanted) {
+			t.Errorf("content should not contain %q", unwanted)
+		}
+	}
+}
+
+func TestExtractArticleEmpty(t *testing.T) {
+	if _, err := ExtractArticle([]byte(`<html><body><nav><a href="/">Home</a></nav></body></html>`), "https://example.com/"); err == nil {
+		t.Error("ExtractArticle() should fail when there is no content")
+	}
+}
+
+func TestArticleMarkdown(t *testing.T) {
+	article, err := ExtractArticle([]byte(testArticlePage), "https://news.example.com/2024/rivers")
+	if err != nil {
+		t.Fatalf("ExtractArticle() error = %v", err)
+	}
+
+	md := article.Markdown()
+	for _, want := range []string{
+		"# Rivers of the World\n\n_Jane Doe_\n\n",
+		"The [Nile](https://news.example.com/nile), the Amazon",
+		"![The Nile at dusk](https://news.example.com/img/nile.jpg)",
+		"## Why they matter",
+		"- Water for **drinking** and farming",
+		"\n- Transport for goods and people",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown() missing %q in:\n%s", want, md)
+		}
+	}
+	if strings.Count(md, "Rivers of the World") != 1 {
+		t.Errorf("Markdown() should not repeat the title:\n%s", md)
+	}
+
+	text := article.Text()
+	if !strings.HasPrefix(text, "Rivers of the World\n\nJane Doe\n\n") {
+		t.Errorf("Text() header = %q", text[:40])
+	}
+	if strings.ContainsAny(text, "*[]#") {
+		t.Errorf("Text() should not contain Markdown syntax:\n%s", text)
+	}
+}
+
+func TestReaderRendererBlocks(t *testing.T) {
+	tests := []struct {
+		name  string
+		html  string
+		want  string
+		plain string
+	}{
+		{
+			name:  "line breaks",
+			html:  "<p>one<br>two\n   three</p>",
+			want:  "one\\\ntwo three",
+			plain: "one\ntwo three",
+		},
+		{
+			name:  "escaping",
+			html:  "<p>2*3 = 6, use_snake [ok]</p>",
+			want:  `2\*3 = 6, use\_snake \[ok\]`,
+			plain: "2*3 = 6, use_snake [ok]",
+		},
+		{
+			name:  "code",
+			html:  "<p>Run <code>go test</code></p><pre>a := 1\nb := 2</pre>",
+			want:  "Run `go test`\n\n```\na := 1\nb := 2\n```",
+			plain: "Run go test\n\na := 1\nb := 2",
+		},
+		{
+			name:  "blockquote",
+			html:  "<blockquote><p>first</p><p>second</p></blockquote>",
+			want:  "> first\n>\n> second",
+			plain: "first\n\nsecond",
+		},
+		{
+			name:  "ordered list",
+			html:  `<ol start="3"><li>three</li><li>four<ul><li>nested</li></ul></li></ol>`,
+			want:  "3. three\n4. four\n\n   - nested",
+			plain: "3. three\n4. four\n\n   - nested",
+		},
+		{
+			name:  "table",
+			html:  "<table><tr><th>Name</th><th>Qty</th></tr><tr><td>a|b</td><td>2</td></tr></table>",
+			want:  "| Name | Qty |\n| --- | --- |\n| a\\|b | 2 |",
+			plain: "Name\tQty\na\\|b\t2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			article, err := ExtractArticle([]byte("<html><body>"+tt.html+"</body></html>"), "https://example.com/")
+			if err != nil {
+				t.Fatalf("ExtractArticle() error = %v", err)
+			}
+			if got := (&readerRenderer{}).blocks(article.Content); got != tt.want {
+				t.Errorf("markdown = %q, want %q", got, tt.want)
+			}
+			if got := (&readerRenderer{plain: true}).blocks(article.Content); got != tt.plain {
+				t.Errorf("plain = %q, want %q", got, tt.plain)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package capture
+
+import (
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/rs/zerolog/log"
+)
+
+// exchange is one request the page made and, once it completed, its
+// response and body.
+type exchange struct {
+	request      *proto.NetworkRequest
+	response     *proto.NetworkResponse
+	resourceType proto.NetworkResourceType
+	started      time.Time
+	body         []byte
+	// failure is set when the request never got a response.
+	failure string
+	// blockedReason says why the browser refused the request, if it did.
+	blockedReason proto.NetworkBlockedReason
+	// requestTime and endTime are monotonic clock readings, in seconds.
+	requestTime, endTime float64
+	// encodedSize is the number of bytes received over the network.
+	encodedSize int64
+}
+
+// networkRecorder keeps every request/response pair a page makes, in the
+// order they completed. Redirects are recorded as separate exchanges.
+type networkRecorder struct {
+	page *rod.Page
+	// bodies fetches each response body once it finished loading.
+	bodies bool
+
+	mu       sync.Mutex
+	inflight map[proto.NetworkRequestID]*exchange
+	finished []*exchange
+}
+
+// recordNetwork starts recording the page's traffic, keeping response
+// bodies if asked to. Network events must already be enabled on the page.
+func recordNetwork(page *rod.Page, bodies bool) *networkRecorder {
+	r := &networkRecorder{
+		page:     page,
+		bodies:   bodies,
+		inflight: make(map[proto.NetworkRequestID]*exchange),
+	}
+
+	go page.EachEvent(
+		func(e *proto.NetworkRequestWillBeSent) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if prev := r.inflight[e.RequestID]; prev != nil && e.RedirectResponse != nil {
+				prev.response = e.RedirectResponse
+				prev.endTime = float64(e.Timestamp)
+				r.finished = append(r.finished, prev)
+			}
+			started := e.WallTime.Time()
+			if e.WallTime == 0 {
+				started = time.Now()
+			}
+			r.inflight[e.RequestID] = &exchange{
+				request:      e.Request,
+				resourceType: e.Type,
+				started:      started,
+				requestTime:  float64(e.Timestamp),
+			}
+		},
+		func(e *proto.NetworkResponseReceived) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if ex := r.inflight[e.RequestID]; ex != nil {
+				ex.response = e.Response
+			}
+		},
+		func(e *proto.NetworkLoadingFinished) {
+			ex := r.take(e.RequestID)
+			if ex == nil {
+				return
+			}
+			ex.endTime = float64(e.Timestamp)
+			ex.encodedSize = int64(e.EncodedDataLength)
+			if r.bodies {
+				ex.body = r.responseBody(e.RequestID)
+			}
+			r.finish(ex)
+		},
+		func(e *proto.NetworkLoadingFailed) {
+			if ex := r.take(e.RequestID); ex != nil {
+				ex.endTime = float64(e.Timestamp)
+				ex.failure = e.ErrorText
+				ex.blockedReason = e.BlockedReason
+				r.finish(ex)
+			}
+		},
+	)()
+
+	return r
+}
+
+func (r *networkRecorder) take(id proto.NetworkRequestID) *exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ex := r.inflight[id]
+	delete(r.inflight, id)
+	return ex
+}
+
+func (r *networkRecorder) finish(ex *exchange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finished = append(r.finished, ex)
+}
+
+// responseBody fetches a finished response's body. Bodies the browser did
+// not keep, such as those of aborted media requests, are recorded empty.
+func (r *networkRecorder) responseBody(id proto.NetworkRequestID) []byte {
+	res, err := proto.NetworkGetResponseBody{RequestID: id}.Call(r.page)
+	if err != nil {
+		log.Debug().Err(err).Str("request_id", string(id)).Msg("Response body unavailable")
+		return nil
+	}
+	if !res.Base64Encoded {
+		return []byte(res.Body)
+	}
+	body, err := base64.StdEncoding.DecodeString(res.Body)
+	if err != nil {
+		return nil
+	}
+	return body
+}
+
+// exchanges returns the exchanges completed so far.
+func (r *networkRecorder) exchanges() []*exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*exchange(nil), r.finished...)
+}
+
+// pending returns copies of the exchanges still in flight, since events
+// may keep updating the originals.
+func (r *networkRecorder) pending() []*exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := make([]*exchange, 0, len(r.inflight))
+	for _, ex := range r.inflight {
+		cp := *ex
+		pending = append(pending, &cp)
+	}
+	return pending
+}
@@ -0,0 +1,95 @@
+package capture
+
+import (
+	"fmt"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// Image encodings a screenshot can be produced in.
+const (
+	ImagePNG  = "png"
+	ImageJPEG = "jpeg"
+	ImageWebP = "webp"
+)
+
+const defaultImageQuality = 90
+
+// ScreenshotOptions controls how screenshots are taken. By default the
+// full page is captured; ViewportOnly limits it to the visible area and
+// Selector clips it to the bounding box of the first matching element.
+type ScreenshotOptions struct {
+	ViewportOnly      bool    `json:"viewport_only,omitempty"`
+	Selector          string  `json:"selector,omitempty"`
+	DeviceScaleFactor float64 `json:"device_scale_factor,omitempty"`
+	// Quality applies to JPEG and WebP only.
+	Quality int `json:"quality,omitempty"`
+}
+
+// Validate reports the first invalid option.
+func (o *ScreenshotOptions) Validate() error {
+	if o.ViewportOnly && o.Selector != "" {
+		return fmt.Errorf("viewport_only cannot be combined with selector")
+	}
+	if o.DeviceScaleFactor != 0 && (o.DeviceScaleFactor < 0.5 || o.DeviceScaleFactor > 4) {
+		return fmt.Errorf("device_scale_factor must be between 0.5 and 4")
+	}
+	if o.Quality != 0 && (o.Quality < 1 || o.Quality > 100) {
+		return fmt.Errorf("quality must be between 1 and 100")
+	}
+	return nil
+}
+
+func takeScreenshot(page *rod.Page, opts *ScreenshotOptions, format string) ([]byte, error) {
+	req := &proto.PageCaptureScreenshot{
+		Format: proto.PageCaptureScreenshotFormat(format),
+	}
+	switch format {
+	case ImagePNG:
+	case ImageJPEG, ImageWebP:
+		quality := opts.Quality
+		if quality == 0 {
+			quality = defaultImageQuality
+		}
+		req.Quality = &quality
+	default:
+		return nil, fmt.Errorf("unsupported image format: %s", format)
+	}
+
+	if opts.Selector == "" {
+		return page.Screenshot(!opts.ViewportOnly, req)
+	}
+
+	el, err := page.Element(opts.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find element %q: %w", opts.Selector, err)
+	}
+
+	// Clip in document coordinates so elements below the fold work
+	// without resizing the viewport.
+	box, err := el.Eval(`() => {
+		const r = this.getBoundingClientRect();
+		return {x: r.left + window.scrollX, y: r.top + window.scrollY, width: r.width, height: r.height};
+	}`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure element %q: %w", opts.Selector, err)
+	}
+
+	width := box.Value.Get("width").Num()
+	height := box.Value.Get("height").Num()
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("element %q has no visible area", opts.Selector)
+	}
+
+	req.Clip = &proto.PageViewport{
+		X:      box.Value.Get("x").Num(),
+		Y:      box.Value.Get("y").Num(),
+		Width:  width,
+		Height: height,
+		Scale:  1,
+	}
+	req.CaptureBeyondViewport = true
+
+	return page.Screenshot(false, req)
+}
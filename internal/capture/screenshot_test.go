@@ -0,0 +1,32 @@
+package capture
+
+import (
+	"testing"
+)
+
+func TestScreenshotOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ScreenshotOptions
+		wantErr bool
+	}{
+		{"empty", ScreenshotOptions{}, false},
+		{"viewport only", ScreenshotOptions{ViewportOnly: true}, false},
+		{"element clip", ScreenshotOptions{Selector: "#main"}, false},
+		{"retina with quality", ScreenshotOptions{DeviceScaleFactor: 2, Quality: 75}, false},
+		{"viewport with selector", ScreenshotOptions{ViewportOnly: true, Selector: "#main"}, true},
+		{"scale too small", ScreenshotOptions{DeviceScaleFactor: 0.1}, true},
+		{"scale too large", ScreenshotOptions{DeviceScaleFactor: 5}, true},
+		{"quality too high", ScreenshotOptions{Quality: 101}, true},
+		{"negative quality", ScreenshotOptions{Quality: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package capture
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-rod/rod"
+)
+
+// Auto-scroll defaults and limits.
+const (
+	defaultScrollDelay     = 100 * time.Millisecond
+	defaultScrollMaxHeight = 20000
+	defaultScrollIdle      = 500 * time.Millisecond
+	maxScrollHeight        = 100000
+	// scrollIdleTimeout bounds the wait for lazy-loaded content, so a page
+	// that keeps polling does not use up the whole capture timeout.
+	scrollIdleTimeout = 10 * time.Second
+)
+
+// ScrollOptions controls scrolling through the page before rendering, which
+// triggers images and sections that only load when they come into view.
+type ScrollOptions struct {
+	// StepPx is the distance scrolled per step; zero means one viewport.
+	StepPx int `json:"step_px,omitempty"`
+	// DelayMs is the pause after each step; zero means 100.
+	DelayMs int `json:"delay_ms,omitempty"`
+	// MaxHeight stops scrolling at this many pixels, which bounds infinite
+	// feeds; zero means 20000.
+	MaxHeight int `json:"max_height,omitempty"`
+	// IdleMs is the network quiet period awaited after scrolling; zero
+	// means 500.
+	IdleMs int `json:"idle_ms,omitempty"`
+}
+
+// Validate reports the first invalid option.
+func (o *ScrollOptions) Validate() error {
+	if o.StepPx < 0 || o.StepPx > 10000 {
+		return fmt.Errorf("step_px must be between 0 and 10000")
+	}
+	if o.DelayMs < 0 || o.DelayMs > 2000 {
+		return fmt.Errorf("delay_ms must be between 0 and 2000")
+	}
+	if o.MaxHeight < 0 || o.MaxHeight > maxScrollHeight {
+		return fmt.Errorf("max_height must be between 0 and %d", maxScrollHeight)
+	}
+	if o.IdleMs < 0 || o.IdleMs > 10000 {
+		return fmt.Errorf("idle_ms must be between 0 and 10000")
+	}
+	return nil
+}
+
+// scrollStepJS scrolls by one step and reports how far down the page the
+// bottom of the viewport is and how tall the page currently is.
+const scrollStepJS = `(step) => {
+	const el = document.scrollingElement || document.documentElement;
+	window.scrollBy(0, step || window.innerHeight);
+	return { bottom: window.scrollY + window.innerHeight, height: el.scrollHeight };
+}`
+
+// eagerImagesJS switches native lazy loading off so images below the
+// viewport load even if scrolling stops early.
+const eagerImagesJS = `() => {
+	document.querySelectorAll('img[loading="lazy"], iframe[loading="lazy"]').forEach((el) => { el.loading = 'eager'; });
+}`
+
+type scrollPosition struct {
+	Bottom float64 `json:"bottom"`
+	Height float64 `json:"height"`
+}
+
+// autoScroll scrolls to the bottom of the page, or to the height limit,
+// waits for the requests it triggered to settle and scrolls back to the top.
+func autoScroll(page *rod.Page, opts *ScrollOptions) error {
+	delay := time.Duration(opts.DelayMs) * time.Millisecond
+	if delay == 0 {
+		delay = defaultScrollDelay
+	}
+	maxHeight := float64(opts.MaxHeight)
+	if maxHeight == 0 {
+		maxHeight = defaultScrollMaxHeight
+	}
+	idle := time.Duration(opts.IdleMs) * time.Millisecond
+	if idle == 0 {
+		idle = defaultScrollIdle
+	}
+
+	// Listen before scrolling so requests started by the first steps count.
+	// The idle wait is bounded only once scrolling is done.
+	idleCtx, cancelIdle := context.WithCancel(page.GetContext())
+	defer cancelIdle()
+	waitIdle := page.Context(idleCtx).WaitRequestIdle(idle, nil, nil, longLivedResourceTypes)
+
+	if _, err := page.Evaluate(rod.Eval(eagerImagesJS)); err != nil {
+		return fmt.Errorf("failed to disable lazy loading: %w", err)
+	}
+
+	last := -1.0
+	for {
+		obj, err := page.Evaluate(rod.Eval(scrollStepJS, opts.StepPx))
+		if err != nil {
+			return fmt.Errorf("failed to scroll: %w", err)
+		}
+		var pos scrollPosition
+		if err := obj.Value.Unmarshal(&pos); err != nil {
+			return fmt.Errorf("failed to read scroll position: %w", err)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-page.GetContext().Done():
+			return fmt.Errorf("failed to scroll: %w", page.GetContext().Err())
+		}
+
+		// Stop at the end of the page, at the limit, or when the page no
+		// longer scrolls, e.g. because scrolling happens in an inner element.
+		if pos.Bottom >= pos.Height-1 || pos.Bottom >= maxHeight || pos.Bottom <= last {
+			break
+		}
+		last = pos.Bottom
+	}
+
+	// Running out of idle time is not an error; whatever has loaded by
+	// then is captured.
+	timer := time.AfterFunc(scrollIdleTimeout, cancelIdle)
+	waitIdle()
+	timer.Stop()
+
+	if _, err := page.Evaluate(rod.Eval(`() => window.scrollTo(0, 0)`)); err != nil {
+		return fmt.Errorf("failed to scroll back: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package capture
+
+import "testing"
+
+func TestScrollOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ScrollOptions
+		wantErr bool
+	}{
+		{"defaults", ScrollOptions{}, false},
+		{"custom", ScrollOptions{StepPx: 600, DelayMs: 250, MaxHeight: 50000, IdleMs: 1000}, false},
+		{"negative step", ScrollOptions{StepPx: -1}, true},
+		{"delay too long", ScrollOptions{DelayMs: 5000}, true},
+		{"height too large", ScrollOptions{MaxHeight: maxScrollHeight + 1}, true},
+		{"idle too long", ScrollOptions{IdleMs: 20000}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
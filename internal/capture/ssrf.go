@@ -0,0 +1,251 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrBlockedAddress is returned when a URL or connection targets an address
+// that captures must not reach.
+var ErrBlockedAddress = errors.New("blocked address")
+
+const maxRedirects = 5
+
+// blockedNetworks covers loopback, private, link-local (including cloud
+// metadata), carrier-grade NAT, benchmarking, multicast and reserved ranges.
+var blockedNetworks = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+var blockedHostnames = []string{
+	"localhost",
+	"metadata.google.internal",
+	"metadata",
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func isBlockedIP(ip net.IP) bool {
+	// IPv4-mapped IPv6 addresses are checked as IPv4.
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBlockedHostname(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, blocked := range blockedHostnames {
+		if host == blocked || strings.HasSuffix(host, "."+blocked) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHost resolves host and rejects it if any of its addresses is
+// blocked. Resolution failures are treated as blocked.
+func checkHost(ctx context.Context, host string) error {
+	if isBlockedHostname(host) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if isBlockedIP(ip) {
+			return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+		}
+		return nil
+	}
+
+	addrs, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if isBlockedIP(addr.IP) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrBlockedAddress, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// dialGuard vets the resolved address of every outgoing connection.
+// Checking at connect time rather than before resolution defeats DNS
+// rebinding. Tests replace it to reach loopback servers.
+var dialGuard = func(network, address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isBlockedIP(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	return nil
+}
+
+// safeDialer is shared by every HTTP client that fetches user-controlled URLs.
+var safeDialer = &net.Dialer{
+	Timeout:   10 * time.Second,
+	KeepAlive: 30 * time.Second,
+	Control: func(network, address string, _ syscall.RawConn) error {
+		return dialGuard(network, address)
+	},
+}
+
+// safeTransport never uses environment proxies, which would bypass the
+// dialer's address checks.
+var safeTransport = &http.Transport{
+	Proxy:                 nil,
+	DialContext:           safeDialer.DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   8,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 15 * time.Second,
+}
+
+// checkRedirect limits redirect chains and keeps them on http(s). The target
+// address is vetted by safeDialer when the redirect is followed.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("%w: redirect to %s URL", ErrBlockedAddress, req.URL.Scheme)
+	}
+	return nil
+}
+
+// requestGuard vets the requests the browser makes through CDP request
+// interception, so blocked URLs fail early with a clear error and non-HTTP
+// schemes are refused. Host checks are cached for the lifetime of one
+// capture; the addresses actually connected to are enforced by the
+// browser's egress proxy.
+type requestGuard struct {
+	mu    sync.Mutex
+	hosts map[string]error
+}
+
+func newRequestGuard() *requestGuard {
+	return &requestGuard{hosts: make(map[string]error)}
+}
+
+func (g *requestGuard) check(ctx context.Context, u *url.URL) error {
+	switch u.Scheme {
+	case "http", "https", "ws", "wss":
+	case "data", "blob", "about":
+		return nil
+	default:
+		return fmt.Errorf("%w: %s URL", ErrBlockedAddress, u.Scheme)
+	}
+
+	host := u.Hostname()
+	g.mu.Lock()
+	err, ok := g.hosts[host]
+	g.mu.Unlock()
+	if ok {
+		return err
+	}
+
+	err = checkHost(ctx, host)
+	g.mu.Lock()
+	g.hosts[host] = err
+	g.mu.Unlock()
+	return err
+}
+
+// guardPage installs request interception on page. Requests matched by
+// blocker, when set, are failed before their host is even resolved; allowed
+// requests get the extra headers, if any. The returned function stops the
+// interception and must be called once the capture is done.
+//
+// rod's hijack router hides the frame a request belongs to, which the
+// blocker needs, so the Fetch events are handled here directly.
+func guardPage(page *rod.Page, blocker *requestBlocker, extra *siteHeaders) (func(), error) {
+	guard := newRequestGuard()
+	ctx, cancel := context.WithCancel(page.GetContext())
+	wait := page.Context(ctx).EachEvent(func(e *proto.FetchRequestPaused) {
+		go guardRequest(ctx, page, guard, blocker, extra, e)
+	})
+
+	enable := proto.FetchEnable{Patterns: []*proto.FetchRequestPattern{{URLPattern: "*"}}}
+	if err := enable.Call(page); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to enable request interception: %w", err)
+	}
+	go wait()
+	return func() {
+		cancel()
+		_ = proto.FetchDisable{}.Call(page)
+	}, nil
+}
+
+// guardRequest fails or continues one paused request.
+func guardRequest(ctx context.Context, page *rod.Page, guard *requestGuard, blocker *requestBlocker, extra *siteHeaders, e *proto.FetchRequestPaused) {
+	fail := proto.FetchFailRequest{RequestID: e.RequestID, ErrorReason: proto.NetworkErrorReasonBlockedByClient}
+	u, err := url.Parse(e.Request.URL)
+	if err != nil {
+		_ = fail.Call(page)
+		return
+	}
+
+	mainFrame := e.FrameID == page.FrameID
+	if blocker != nil && blocker.blocks(u, e.ResourceType, mainFrame) {
+		log.Debug().Str("url", e.Request.URL).Msg("Blocked ad or tracker request")
+		_ = fail.Call(page)
+		return
+	}
+	if err := guard.check(ctx, u); err != nil {
+		log.Warn().Err(err).Str("url", e.Request.URL).Msg("Blocked browser request")
+		_ = fail.Call(page)
+		return
+	}
+
+	continued := extra.apply(u, e.Request.Headers)
+	continued.RequestID = e.RequestID
+	_ = continued.Call(page)
+}
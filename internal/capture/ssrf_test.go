@@ -0,0 +1,144 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// allowLoopback lets the safe dialer reach httptest servers for the
+// duration of a test.
+func allowLoopback(t *testing.T) {
+	t.Helper()
+	orig := dialGuard
+	dialGuard = func(network, address string) error {
+		host, _, _ := net.SplitHostPort(address)
+		if net.ParseIP(host).IsLoopback() {
+			return nil
+		}
+		return orig(network, address)
+	}
+	t.Cleanup(func() { dialGuard = orig })
+}
+
+func TestIsBlockedIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"100.64.0.1", true},
+		{"169.254.169.254", true},
+		{"0.0.0.0", true},
+		{"224.0.0.1", true},
+		{"::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"8.8.8.8", false},
+		{"2001:4860:4860::8888", false},
+		{"::ffff:8.8.8.8", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := isBlockedIP(net.ParseIP(tt.ip)); got != tt.blocked {
+				t.Errorf("isBlockedIP(%s) = %v, want %v", tt.ip, got, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestDialGuard(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{"127.0.0.1:80", true},
+		{"[::1]:443", true},
+		{"169.254.169.254:80", true},
+		{"93.184.216.34:443", false},
+		{"not-an-address", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := dialGuard("tcp", tt.address)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("dialGuard(%q) error = %v, wantErr %v", tt.address, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequestGuardCheck(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"data:text/plain,hi", false},
+		{"https://93.184.216.34/", false},
+		{"http://127.0.0.1/", true},
+		{"http://localhost:8080/", true},
+		{"http://metadata.google.internal/computeMetadata/v1/", true},
+		{"http://[fe80::1]/", true},
+		{"file:///etc/passwd", true},
+		{"ftp://93.184.216.34/", true},
+	}
+
+	guard := newRequestGuard()
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatalf("url.Parse() error = %v", err)
+			}
+			err = guard.check(context.Background(), u)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("check(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSafeTransportBlocksLoopback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("secret"))
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: safeTransport, CheckRedirect: checkRedirect}
+	resp, err := client.Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("expected request to loopback server to be blocked")
+	}
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("error = %v, want ErrBlockedAddress", err)
+	}
+}
+
+func TestInlinerBlocksRedirectToPrivateAddress(t *testing.T) {
+	// Loopback is allowed so the test can reach its own server; the
+	// redirect target is a link-local metadata address instead.
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer public.Close()
+
+	inliner := newTestInliner(t, public.URL, nil)
+	out, err := inliner.InlineHTML(context.Background(), []byte(`<img src="/logo.png">`))
+	if err != nil {
+		t.Fatalf("InlineHTML() error = %v", err)
+	}
+	if got := string(out); !strings.Contains(got, `src="/logo.png"`) {
+		t.Errorf("redirected image should not be inlined:\n%s", got)
+	}
+}
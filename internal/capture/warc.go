@@ -0,0 +1,169 @@
+package capture
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // WARC digests are defined as SHA-1
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/google/uuid"
+)
+
+const warcVersion = "WARC/1.1"
+
+// warcSkippedHeaders describe the body as it travelled over the wire. The
+// browser hands bodies over decoded, so they are replaced by a
+// Content-Length matching the stored body.
+var warcSkippedHeaders = map[string]bool{
+	"content-encoding":  true,
+	"content-length":    true,
+	"transfer-encoding": true,
+}
+
+// writeWARC writes a WARC 1.1 file holding a warcinfo record followed by a
+// request and response record for each exchange that got a response.
+func writeWARC(w io.Writer, exchanges []*exchange, capturedAt time.Time) error {
+	info := "software: pagemail\r\n" +
+		"format: WARC File Format 1.1\r\n" +
+		"conformsTo: http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/\r\n"
+	if err := writeWARCRecord(w, []string{
+		"WARC-Type: warcinfo",
+		"WARC-Record-ID: " + warcRecordID(),
+		"WARC-Date: " + warcDate(capturedAt),
+		"Content-Type: application/warc-fields",
+	}, []byte(info)); err != nil {
+		return err
+	}
+
+	for _, ex := range exchanges {
+		if ex.response == nil {
+			continue
+		}
+		target, err := url.Parse(ex.request.URL)
+		if err != nil || target.Scheme != "http" && target.Scheme != "https" {
+			continue
+		}
+		date := warcDate(ex.started)
+		responseID := warcRecordID()
+
+		response := httpResponseBlock(ex.response, ex.body)
+		headers := []string{
+			"WARC-Type: response",
+			"WARC-Record-ID: " + responseID,
+			"WARC-Date: " + date,
+			"WARC-Target-URI: " + ex.request.URL,
+			"Content-Type: application/http;msgtype=response",
+			"WARC-Block-Digest: " + warcDigest(response),
+			"WARC-Payload-Digest: " + warcDigest(ex.body),
+		}
+		if ex.response.RemoteIPAddress != "" {
+			headers = append(headers, "WARC-IP-Address: "+strings.Trim(ex.response.RemoteIPAddress, "[]"))
+		}
+		if err := writeWARCRecord(w, headers, response); err != nil {
+			return err
+		}
+
+		request := httpRequestBlock(ex.request, target)
+		if err := writeWARCRecord(w, []string{
+			"WARC-Type: request",
+			"WARC-Record-ID: " + warcRecordID(),
+			"WARC-Date: " + date,
+			"WARC-Target-URI: " + ex.request.URL,
+			"WARC-Concurrent-To: " + responseID,
+			"Content-Type: application/http;msgtype=request",
+			"WARC-Block-Digest: " + warcDigest(request),
+		}, request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeWARCRecord(w io.Writer, headers []string, block []byte) error {
+	var buf bytes.Buffer
+	buf.WriteString(warcVersion + "\r\n")
+	for _, h := range headers {
+		buf.WriteString(h + "\r\n")
+	}
+	fmt.Fprintf(&buf, "Content-Length: %d\r\n\r\n", len(block))
+	buf.Write(block)
+	buf.WriteString("\r\n\r\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// httpResponseBlock rebuilds the HTTP/1.1 form of a response. HTTP/2
+// responses are stored the same way, as replay tools expect.
+func httpResponseBlock(resp *proto.NetworkResponse, body []byte) []byte {
+	statusText := resp.StatusText
+	if statusText == "" {
+		statusText = http.StatusText(resp.Status)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "HTTP/1.1 %d %s\r\n", resp.Status, statusText)
+	writeHTTPHeaders(&buf, resp.Headers, warcSkippedHeaders)
+	fmt.Fprintf(&buf, "Content-Length: %d\r\n\r\n", len(body))
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func httpRequestBlock(req *proto.NetworkRequest, target *url.URL) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/1.1\r\n", req.Method, target.RequestURI())
+	if !hasHeader(req.Headers, "host") {
+		fmt.Fprintf(&buf, "Host: %s\r\n", target.Host)
+	}
+	writeHTTPHeaders(&buf, req.Headers, nil)
+	buf.WriteString("\r\n")
+	buf.WriteString(req.PostData)
+	return buf.Bytes()
+}
+
+// writeHTTPHeaders writes headers sorted by name. CDP joins repeated
+// headers such as Set-Cookie with newlines; they are split back into one
+// line each. HTTP/2 pseudo-headers are dropped.
+func writeHTTPHeaders(buf *bytes.Buffer, headers proto.NetworkHeaders, skip map[string]bool) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		if strings.HasPrefix(name, ":") || skip[strings.ToLower(name)] {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range strings.Split(headers[name].Str(), "\n") {
+			fmt.Fprintf(buf, "%s: %s\r\n", name, value)
+		}
+	}
+}
+
+func hasHeader(headers proto.NetworkHeaders, name string) bool {
+	for key := range headers {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func warcRecordID() string {
+	return "<urn:uuid:" + uuid.New().String() + ">"
+}
+
+func warcDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000Z")
+}
+
+func warcDigest(data []byte) string {
+	sum := sha1.Sum(data) //nolint:gosec // WARC digests are defined as SHA-1
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
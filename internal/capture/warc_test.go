@@ -0,0 +1,144 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+type warcRecord struct {
+	headers textproto.MIMEHeader
+	block   []byte
+}
+
+func readWARC(t *testing.T, data []byte) []warcRecord {
+	t.Helper()
+	r := bufio.NewReader(bytes.NewReader(data))
+	var records []warcRecord
+	for {
+		version, err := r.ReadString('\n')
+		if err == io.EOF {
+			return records
+		}
+		if err != nil || version != warcVersion+"\r\n" {
+			t.Fatalf("record %d: bad version line %q (%v)", len(records), version, err)
+		}
+		headers, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err != nil {
+			t.Fatalf("record %d: bad headers: %v", len(records), err)
+		}
+		length, _ := strconv.Atoi(headers.Get("Content-Length"))
+		block := make([]byte, length)
+		if _, err := io.ReadFull(r, block); err != nil {
+			t.Fatalf("record %d: short block: %v", len(records), err)
+		}
+		if trailer, _ := r.Peek(4); string(trailer) != "\r\n\r\n" {
+			t.Fatalf("record %d: missing trailer", len(records))
+		}
+		_, _ = r.Discard(4)
+		records = append(records, warcRecord{headers: headers, block: block})
+	}
+}
+
+func networkHeaders(values map[string]string) proto.NetworkHeaders {
+	data, _ := json.Marshal(values)
+	var headers proto.NetworkHeaders
+	_ = json.Unmarshal(data, &headers)
+	return headers
+}
+
+func TestWriteWARC(t *testing.T) {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	exchanges := []*exchange{
+		{
+			request: &proto.NetworkRequest{
+				URL: "https://example.com/", Method: "GET",
+				Headers: networkHeaders(map[string]string{"User-Agent": "test", ":authority": "example.com"}),
+			},
+			response: &proto.NetworkResponse{
+				Status: 301, RemoteIPAddress: "[2001:db8::1]",
+				Headers: networkHeaders(map[string]string{"Location": "https://example.com/home"}),
+			},
+			started: started,
+		},
+		{
+			request: &proto.NetworkRequest{
+				URL: "https://example.com/home?q=1", Method: "POST", PostData: "a=1",
+				Headers: networkHeaders(map[string]string{"Content-Type": "application/x-www-form-urlencoded"}),
+			},
+			response: &proto.NetworkResponse{
+				Status: 200, StatusText: "OK", RemoteIPAddress: "93.184.216.34",
+				Headers: networkHeaders(map[string]string{
+					"Content-Type":     "text/html",
+					"Content-Encoding": "gzip",
+					"Content-Length":   "12",
+					"Set-Cookie":       "a=1\nb=2",
+				}),
+			},
+			body:    []byte("<p>home</p>"),
+			started: started.Add(time.Second),
+		},
+		{request: &proto.NetworkRequest{URL: "https://example.com/blocked.js", Method: "GET"}, failure: "net::ERR_BLOCKED_BY_CLIENT"},
+		{request: &proto.NetworkRequest{URL: "data:text/plain,hi", Method: "GET"}, response: &proto.NetworkResponse{Status: 200}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeWARC(&buf, exchanges, started); err != nil {
+		t.Fatalf("writeWARC() error = %v", err)
+	}
+	records := readWARC(t, buf.Bytes())
+
+	types := make([]string, len(records))
+	for i, rec := range records {
+		types[i] = rec.headers.Get("WARC-Type")
+		if got := rec.headers.Get("WARC-Block-Digest"); got != "" && got != warcDigest(rec.block) {
+			t.Errorf("record %d block digest = %s, want %s", i, got, warcDigest(rec.block))
+		}
+	}
+	if want := "warcinfo response request response request"; strings.Join(types, " ") != want {
+		t.Fatalf("record types = %v, want %s", types, want)
+	}
+
+	redirect := records[1]
+	if redirect.headers.Get("WARC-IP-Address") != "2001:db8::1" || redirect.headers.Get("WARC-Date") != "2024-05-01T12:00:00.000000Z" {
+		t.Errorf("redirect record headers = %v", redirect.headers)
+	}
+	if !bytes.HasPrefix(redirect.block, []byte("HTTP/1.1 301 Moved Permanently\r\n")) {
+		t.Errorf("redirect block = %q", redirect.block)
+	}
+	if records[2].headers.Get("WARC-Concurrent-To") != redirect.headers.Get("WARC-Record-ID") {
+		t.Error("request record should point at its response")
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(records[3].block)), nil)
+	if err != nil {
+		t.Fatalf("response block is not HTTP: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "<p>home</p>" || resp.Header.Get("Content-Encoding") != "" || len(resp.Header.Values("Set-Cookie")) != 2 {
+		t.Errorf("response = %v %q", resp.Header, body)
+	}
+	if records[3].headers.Get("WARC-Payload-Digest") != warcDigest(body) {
+		t.Error("payload digest should cover the body only")
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(records[4].block)))
+	if err != nil {
+		t.Fatalf("request block is not HTTP: %v", err)
+	}
+	if req.Method != "POST" || req.RequestURI != "/home?q=1" || req.Host != "example.com" {
+		t.Errorf("request = %s %s host %s", req.Method, req.RequestURI, req.Host)
+	}
+	if strings.Contains(string(records[2].block), ":authority") {
+		t.Error("pseudo-headers should be dropped")
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -69,6 +70,22 @@ type CaptureConfig struct {
 	ViewportHeight int `mapstructure:"CAPTURE_VIEWPORT_HEIGHT"`
 	WaitTimeout    int `mapstructure:"CAPTURE_WAIT_TIMEOUT"`
 	Workers        int `mapstructure:"CAPTURE_WORKERS" validate:"min=1,max=10"`
+	// Browser pool shared by all workers.
+	Browsers              int `mapstructure:"CAPTURE_BROWSERS" validate:"min=1,max=10"`
+	PagesPerBrowser       int `mapstructure:"CAPTURE_PAGES_PER_BROWSER" validate:"min=1,max=20"`
+	BrowserRecycleAfter   int `mapstructure:"CAPTURE_BROWSER_RECYCLE_AFTER" validate:"min=1"`
+	BrowserMaxMemoryMB    int `mapstructure:"CAPTURE_BROWSER_MAX_MEMORY_MB" validate:"min=0"`
+	BrowserHealthInterval int `mapstructure:"CAPTURE_BROWSER_HEALTH_INTERVAL" validate:"min=1"`
+	// MaxPageSize aborts captures that download more than this many bytes.
+	MaxPageSize int64 `mapstructure:"CAPTURE_MAX_PAGE_SIZE" validate:"min=0"`
+	// Limits for embedding sub-resources into HTML outputs.
+	InlineMaxResourceSize int64 `mapstructure:"CAPTURE_INLINE_MAX_RESOURCE_SIZE" validate:"min=0"`
+	InlineMaxTotalSize    int64 `mapstructure:"CAPTURE_INLINE_MAX_TOTAL_SIZE" validate:"min=0"`
+	InlineConcurrency     int   `mapstructure:"CAPTURE_INLINE_CONCURRENCY" validate:"min=1,max=32"`
+	// FilterListFile adds EasyList-style rules to the bundled ad blocking list.
+	FilterListFile string `mapstructure:"CAPTURE_FILTER_LIST_FILE"`
+	// TunnelPorts lets pages connect to ports besides 443 and 80.
+	TunnelPorts []int `mapstructure:"CAPTURE_TUNNEL_PORTS" validate:"dive,min=1,max=65535"`
 }
 
 type QueueConfig struct {
@@ -125,6 +142,21 @@ func Load() (*Config, error) {
 	cfg.Capture.ViewportHeight = viper.GetInt("CAPTURE_VIEWPORT_HEIGHT")
 	cfg.Capture.WaitTimeout = viper.GetInt("CAPTURE_WAIT_TIMEOUT")
 	cfg.Capture.Workers = viper.GetInt("CAPTURE_WORKERS")
+	cfg.Capture.Browsers = viper.GetInt("CAPTURE_BROWSERS")
+	cfg.Capture.PagesPerBrowser = viper.GetInt("CAPTURE_PAGES_PER_BROWSER")
+	cfg.Capture.BrowserRecycleAfter = viper.GetInt("CAPTURE_BROWSER_RECYCLE_AFTER")
+	cfg.Capture.BrowserMaxMemoryMB = viper.GetInt("CAPTURE_BROWSER_MAX_MEMORY_MB")
+	cfg.Capture.BrowserHealthInterval = viper.GetInt("CAPTURE_BROWSER_HEALTH_INTERVAL")
+	cfg.Capture.MaxPageSize = viper.GetInt64("CAPTURE_MAX_PAGE_SIZE")
+	cfg.Capture.InlineMaxResourceSize = viper.GetInt64("CAPTURE_INLINE_MAX_RESOURCE_SIZE")
+	cfg.Capture.InlineMaxTotalSize = viper.GetInt64("CAPTURE_INLINE_MAX_TOTAL_SIZE")
+	cfg.Capture.InlineConcurrency = viper.GetInt("CAPTURE_INLINE_CONCURRENCY")
+	cfg.Capture.FilterListFile = viper.GetString("CAPTURE_FILTER_LIST_FILE")
+	tunnelPorts, err := parsePorts(viper.GetString("CAPTURE_TUNNEL_PORTS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CAPTURE_TUNNEL_PORTS: %w", err)
+	}
+	cfg.Capture.TunnelPorts = tunnelPorts
 	cfg.Queue.PollInterval = viper.GetInt("QUEUE_POLL_INTERVAL")
 	cfg.Queue.MaxRetries = viper.GetInt("QUEUE_MAX_RETRIES")
 	cfg.Queue.LeaseDuration = viper.GetInt("QUEUE_LEASE_DURATION")
@@ -156,6 +188,15 @@ func setDefaults() {
 	viper.SetDefault("CAPTURE_VIEWPORT_HEIGHT", 1080)
 	viper.SetDefault("CAPTURE_WAIT_TIMEOUT", 30000)
 	viper.SetDefault("CAPTURE_WORKERS", 3)
+	viper.SetDefault("CAPTURE_BROWSERS", 1)
+	viper.SetDefault("CAPTURE_PAGES_PER_BROWSER", 3)
+	viper.SetDefault("CAPTURE_BROWSER_RECYCLE_AFTER", 100)
+	viper.SetDefault("CAPTURE_BROWSER_MAX_MEMORY_MB", 1024)
+	viper.SetDefault("CAPTURE_BROWSER_HEALTH_INTERVAL", 30)
+	viper.SetDefault("CAPTURE_MAX_PAGE_SIZE", 100<<20)
+	viper.SetDefault("CAPTURE_INLINE_MAX_RESOURCE_SIZE", 10<<20)
+	viper.SetDefault("CAPTURE_INLINE_MAX_TOTAL_SIZE", 50<<20)
+	viper.SetDefault("CAPTURE_INLINE_CONCURRENCY", 8)
 	viper.SetDefault("QUEUE_POLL_INTERVAL", 5)
 	viper.SetDefault("QUEUE_MAX_RETRIES", 3)
 	viper.SetDefault("QUEUE_LEASE_DURATION", 300)
@@ -192,3 +233,20 @@ func validateConfig(cfg *Config) error {
 
 	return nil
 }
+
+// parsePorts reads a comma-separated list of port numbers.
+func parsePorts(list string) ([]int, error) {
+	var ports []int
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		port, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a port", field)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
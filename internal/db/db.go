@@ -1,7 +1,9 @@
 package db
 
 import (
+	"encoding/json"
 	"fmt"
+	"unicode/utf8"
 
 	"github.com/rs/zerolog/log"
 	"gorm.io/driver/postgres"
@@ -9,8 +11,10 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 
+	"pagemail/internal/capture"
 	"pagemail/internal/config"
 	"pagemail/internal/models"
+	"pagemail/internal/pkg/crypto"
 )
 
 func Connect(cfg *config.Config) (*gorm.DB, error) {
@@ -42,7 +46,7 @@ func Connect(cfg *config.Config) (*gorm.DB, error) {
 	return db, nil
 }
 
-func Migrate(db *gorm.DB) error {
+func Migrate(db *gorm.DB, cfg *config.Config) error {
 	log.Info().Msg("Running database migrations")
 
 	err := db.AutoMigrate(
@@ -52,8 +56,14 @@ func Migrate(db *gorm.DB) error {
 		&models.SystemSetting{},
 		&models.SMTPProfile{},
 		&models.WebhookEndpoint{},
+		&models.Snippet{},
+		&models.LoginRecipe{},
 		&models.CaptureTask{},
 		&models.CaptureOutput{},
+		&models.CaptureSchedule{},
+		&models.CaptureBatch{},
+		&models.CaptureSnapshot{},
+		&models.CaptureDiff{},
 		&models.Delivery{},
 		&models.Job{},
 		&models.AuditLog{},
@@ -66,6 +76,10 @@ func Migrate(db *gorm.DB) error {
 		return fmt.Errorf("failed to backfill capture task fields: %w", err)
 	}
 
+	if err := encryptLegacyCookies(db, cfg.Encryption.Key); err != nil {
+		return fmt.Errorf("failed to encrypt legacy cookies: %w", err)
+	}
+
 	if err := seedPermissions(db); err != nil {
 		return fmt.Errorf("failed to seed permissions: %w", err)
 	}
@@ -161,3 +175,67 @@ func backfillCaptureTaskRetryFields(db *gorm.DB) error {
 	}
 	return nil
 }
+
+// encryptLegacyCookies encrypts the cookies of tasks queued before cookies
+// were encrypted, which hold the raw "name=value; ..." header. Cookies that
+// neither decrypt nor look like a header are left for the task to fail on.
+func encryptLegacyCookies(db *gorm.DB, key string) error {
+	encryptor, err := crypto.NewEncryptor(key)
+	if err != nil {
+		return err
+	}
+
+	var tasks []models.CaptureTask
+	migrated := 0
+	err = db.Select("id", "url", "cookies_enc").
+		Where("cookies_enc IS NOT NULL").
+		FindInBatches(&tasks, 500, func(tx *gorm.DB, _ int) error {
+			for i := range tasks {
+				if _, err := encryptor.Decrypt(tasks[i].CookiesEnc); err == nil || !isCookieHeader(tasks[i].CookiesEnc) {
+					continue
+				}
+				cookies, err := capture.ImportCookies(string(tasks[i].CookiesEnc), capture.CookieFormatHeader, tasks[i].URL)
+				if err != nil {
+					continue
+				}
+				plaintext, err := json.Marshal(cookies)
+				if err != nil {
+					return err
+				}
+				ciphertext, err := encryptor.Encrypt(plaintext)
+				if err != nil {
+					return err
+				}
+				if err := tx.Model(&tasks[i]).Update("cookies_enc", ciphertext).Error; err != nil {
+					return err
+				}
+				migrated++
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return err
+	}
+	if migrated > 0 {
+		log.Info().Int("rows", migrated).Msg("Encrypted legacy task cookies")
+	}
+	return nil
+}
+
+// isCookieHeader reports whether data is printable text with a name=value
+// pair, which ciphertext practically never is.
+func isCookieHeader(data []byte) bool {
+	if len(data) == 0 || !utf8.Valid(data) {
+		return false
+	}
+	hasPair := false
+	for _, r := range string(data) {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+		if r == '=' {
+			hasPair = true
+		}
+	}
+	return hasPair
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-rod/rod/lib/proto"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"pagemail/internal/models"
+	"pagemail/internal/pkg/crypto"
+)
+
+func TestEncryptLegacyCookies(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.CaptureTask{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	const key = "test-encryption-key-32-bytes!!!!"
+	encryptor, _ := crypto.NewEncryptor(key)
+	encrypted, _ := encryptor.Encrypt([]byte(`[{"name":"session","value":"abc"}]`))
+	otherKey, _ := crypto.NewEncryptor("another-encryption-key-32-bytes!")
+	rotated, _ := otherKey.Encrypt([]byte(`[{"name":"session","value":"abc"}]`))
+
+	tests := []struct {
+		name        string
+		cookies     []byte
+		wantMigrate bool
+	}{
+		{"legacy header", []byte("a=1; b=2"), true},
+		{"encrypted", encrypted, false},
+		{"other key", rotated, false},
+		{"not a header", []byte("garbage"), false},
+	}
+
+	tasks := make([]models.CaptureTask, len(tests))
+	for i, tt := range tests {
+		tasks[i] = models.CaptureTask{URL: "https://example.com/", CookiesEnc: tt.cookies}
+		db.Create(&tasks[i])
+	}
+
+	for run := 0; run < 2; run++ {
+		if err := encryptLegacyCookies(db, key); err != nil {
+			t.Fatalf("encryptLegacyCookies() run %d error = %v", run, err)
+		}
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got models.CaptureTask
+			db.First(&got, "id = ?", tasks[i].ID)
+			if !tt.wantMigrate {
+				if string(got.CookiesEnc) != string(tt.cookies) {
+					t.Errorf("CookiesEnc changed to %q, want it left alone", got.CookiesEnc)
+				}
+				return
+			}
+
+			plaintext, err := encryptor.Decrypt(got.CookiesEnc)
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			var cookies []*proto.NetworkCookieParam
+			if err := json.Unmarshal(plaintext, &cookies); err != nil {
+				t.Fatalf("Failed to parse cookies: %v", err)
+			}
+			if len(cookies) != 2 || cookies[0].Name != "a" || cookies[0].URL != "https://example.com/" {
+				t.Errorf("Cookies = %+v, want a and b scoped to the task URL", cookies)
+			}
+		})
+	}
+}
+
+func TestIsCookieHeader(t *testing.T) {
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{"a=1; b=2", true},
+		{"session=abc", true},
+		{"", false},
+		{"no pair", false},
+		{"a=1\x00\x9f", false},
+		{"a=\n1", false},
+	}
+
+	for _, tt := range tests {
+		if got := isCookieHeader([]byte(tt.data)); got != tt.want {
+			t.Errorf("isCookieHeader(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
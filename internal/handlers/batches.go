@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pagemail/internal/audit"
+	"pagemail/internal/models"
+	"pagemail/internal/pkg/errors"
+	"pagemail/internal/queue"
+)
+
+const (
+	// maxBatchURLs bounds the URLs of one batch.
+	maxBatchURLs = 100
+	// maxBatchUploadBytes bounds an uploaded URL list.
+	maxBatchUploadBytes = 1 << 20
+)
+
+// BatchCaptureRequest captures a list of URLs with the same settings. By
+// default each capture is delivered on its own; with Combine set, the
+// delivery is sent once, with the outputs of every capture, after the
+// last one finishes.
+type BatchCaptureRequest struct {
+	Name    string   `json:"name" binding:"max=100"`
+	URLs    []string `json:"urls" binding:"dive,url"`
+	Combine string   `json:"combine" binding:"omitempty,oneof=attachments zip"`
+	CaptureSettings
+}
+
+// bindBatchRequest reads a batch request from a JSON body, or from a
+// multipart form with the URL list uploaded as "file" and the rest of the
+// request as JSON in "options".
+func bindBatchRequest(c *gin.Context) (*BatchCaptureRequest, *errors.ProblemDetail) {
+	var req BatchCaptureRequest
+	if c.ContentType() != "multipart/form-data" {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			return nil, errors.BadRequest(err.Error())
+		}
+		return &req, nil
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBatchUploadBytes+1<<20)
+	if options := c.PostForm("options"); options != "" {
+		if err := json.Unmarshal([]byte(options), &req); err != nil {
+			return nil, errors.BadRequest("Invalid options: " + err.Error())
+		}
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case stderrors.As(err, &maxBytesErr):
+			return nil, errors.NewProblemDetail(http.StatusRequestEntityTooLarge, "Payload Too Large",
+				fmt.Sprintf("URL list too large, max %d bytes", maxBatchUploadBytes))
+		case stderrors.Is(err, http.ErrMissingFile):
+			return nil, errors.BadRequest("Missing URL list file")
+		default:
+			return nil, errors.BadRequest("Failed to read upload")
+		}
+	}
+	if fileHeader.Size > maxBatchUploadBytes {
+		return nil, errors.NewProblemDetail(http.StatusRequestEntityTooLarge, "Payload Too Large",
+			fmt.Sprintf("URL list too large, max %d bytes", maxBatchUploadBytes))
+	}
+
+	f, err := fileHeader.Open()
+	if err != nil {
+		return nil, errors.BadRequest("Failed to read file")
+	}
+	defer f.Close()
+
+	urls, err := parseURLList(f)
+	if err != nil {
+		return nil, errors.BadRequest("Invalid URL list: " + err.Error())
+	}
+	req.URLs = append(req.URLs, urls...)
+
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		return nil, errors.BadRequest(err.Error())
+	}
+	return &req, nil
+}
+
+// parseURLList reads a newline separated list of URLs, or a CSV file with
+// the URLs in its first column. Blank lines, lines starting with "#" and a
+// "url" header are skipped.
+func parseURLList(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	var urls []string
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return urls, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		value := strings.TrimSpace(record[0])
+		if first {
+			// Spreadsheets may start the file with a byte order mark.
+			value = strings.TrimPrefix(value, "\ufeff")
+			if strings.EqualFold(value, "url") {
+				continue
+			}
+		}
+		if value != "" {
+			urls = append(urls, value)
+		}
+	}
+}
+
+func (h *Handler) CreateCaptureBatch(c *gin.Context) {
+	req, problem := bindBatchRequest(c)
+	if problem != nil {
+		problem.Respond(c)
+		return
+	}
+
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	if len(req.URLs) == 0 {
+		errors.BadRequest("At least one URL is required").Respond(c)
+		return
+	}
+	if len(req.URLs) > maxBatchURLs {
+		errors.BadRequest(fmt.Sprintf("A batch takes at most %d URLs", maxBatchURLs)).Respond(c)
+		return
+	}
+
+	batch := &models.CaptureBatch{UserID: uid, Name: req.Name, Total: len(req.URLs)}
+	settings := req.CaptureSettings
+	if req.Combine != "" {
+		if req.DeliveryConfig == nil {
+			errors.BadRequest("combine requires delivery_config").Respond(c)
+			return
+		}
+		delivery, problem := h.newDelivery(uid, req.DeliveryConfig)
+		if problem != nil {
+			problem.Respond(c)
+			return
+		}
+		batch.DeliveryChannel = delivery.Channel
+		batch.DeliveryTarget = delivery.TargetConfig
+		batch.Combine = req.Combine
+		batch.DeliveryStatus = models.DeliveryStatusPending
+		// The tasks are delivered together, not one by one.
+		settings.DeliveryConfig = nil
+	}
+
+	tasks := make([]*models.CaptureTask, len(req.URLs))
+	deliveries := make([]*models.Delivery, len(req.URLs))
+	for i, u := range req.URLs {
+		task, delivery, problem := h.newCaptureTask(uid, &CreateCaptureRequest{URL: u, CaptureSettings: settings})
+		if problem != nil {
+			problem.Detail = u + ": " + problem.Detail
+			problem.Respond(c)
+			return
+		}
+		task.BatchIndex = i
+		tasks[i], deliveries[i] = task, delivery
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(batch).Error; err != nil {
+			return err
+		}
+		for i, task := range tasks {
+			task.BatchID = &batch.ID
+			if err := tx.Create(task).Error; err != nil {
+				return err
+			}
+			if deliveries[i] != nil {
+				deliveries[i].TaskID = task.ID
+				if err := tx.Create(deliveries[i]).Error; err != nil {
+					return err
+				}
+			}
+			payload := map[string]interface{}{
+				"task_id": task.ID.String(),
+				"url":     task.URL,
+				"formats": req.Formats,
+			}
+			if err := queue.EnqueueJob(tx, models.JobTypeCapture, payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		errors.InternalError("Failed to create capture batch").Respond(c)
+		return
+	}
+
+	h.logAudit(c, audit.ActionBatchCreate, "batch", &batch.ID, audit.ResourceDetails{
+		Name: batch.Name, Formats: req.Formats,
+	})
+
+	resp := batchResponse(batch, h.batchTaskCounts(batch.ID)[batch.ID])
+	resp["tasks"] = batchTasks(tasks)
+	c.JSON(http.StatusCreated, resp)
+}
+
+func (h *Handler) ListCaptureBatches(c *gin.Context) {
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+	page, limit := parsePagination(c)
+
+	var batches []models.CaptureBatch
+	var total int64
+
+	query := h.db.Model(&models.CaptureBatch{}).Where("user_id = ?", uid)
+	query.Count(&total)
+	query.Order("created_at DESC").Offset((page - 1) * limit).Limit(limit).Find(&batches)
+
+	ids := make([]uuid.UUID, len(batches))
+	for i := range batches {
+		ids[i] = batches[i].ID
+	}
+	counts := h.batchTaskCounts(ids...)
+
+	result := make([]gin.H, len(batches))
+	for i := range batches {
+		result[i] = batchResponse(&batches[i], counts[batches[i].ID])
+	}
+
+	paginatedResponse(c, result, total, page, limit)
+}
+
+func (h *Handler) GetCaptureBatch(c *gin.Context) {
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	var batch models.CaptureBatch
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), uid).First(&batch).Error; err != nil {
+		errors.NotFound("Capture batch not found").Respond(c)
+		return
+	}
+
+	var tasks []*models.CaptureTask
+	h.db.Where("batch_id = ?", batch.ID).Order("batch_index ASC").Find(&tasks)
+
+	resp := batchResponse(&batch, h.batchTaskCounts(batch.ID)[batch.ID])
+	resp["tasks"] = batchTasks(tasks)
+	c.JSON(http.StatusOK, resp)
+}
+
+// batchTaskCounts counts the tasks of each of the batches by status, in a
+// single query.
+func (h *Handler) batchTaskCounts(ids ...uuid.UUID) map[uuid.UUID]map[string]int {
+	var rows []struct {
+		BatchID uuid.UUID
+		Status  string
+		Count   int
+	}
+	h.db.Model(&models.CaptureTask{}).Select("batch_id, status, count(*) AS count").
+		Where("batch_id IN ?", ids).Group("batch_id, status").Scan(&rows)
+
+	counts := make(map[uuid.UUID]map[string]int, len(ids))
+	for _, row := range rows {
+		if counts[row.BatchID] == nil {
+			counts[row.BatchID] = make(map[string]int)
+		}
+		counts[row.BatchID][row.Status] = row.Count
+	}
+	return counts
+}
+
+// batchResponse describes a batch with the progress of its tasks, given
+// their counts by status.
+func batchResponse(batch *models.CaptureBatch, counts map[string]int) gin.H {
+	progress := gin.H{
+		models.TaskStatusPending:   0,
+		models.TaskStatusRunning:   0,
+		models.TaskStatusCompleted: 0,
+		models.TaskStatusFailed:    0,
+	}
+	done, pending := 0, 0
+	for status, count := range counts {
+		progress[status] = count
+		switch status {
+		case models.TaskStatusCompleted, models.TaskStatusFailed:
+			done += count
+		case models.TaskStatusPending:
+			pending += count
+		}
+	}
+	progress["done"] = done
+	progress["percent"] = 0
+	if batch.Total > 0 {
+		progress["percent"] = done * 100 / batch.Total
+	}
+
+	status := models.TaskStatusRunning
+	switch {
+	case batch.CompletedAt != nil:
+		status = models.TaskStatusCompleted
+	case pending == batch.Total:
+		status = models.TaskStatusPending
+	}
+
+	var delivery gin.H
+	if batch.DeliveryChannel != "" {
+		var target queue.DeliveryTarget
+		_ = json.Unmarshal([]byte(batch.DeliveryTarget), &target)
+		delivery = gin.H{
+			"type":         batch.DeliveryChannel,
+			"id":           target.ID,
+			"recipients":   target.Recipients,
+			"formats":      target.Formats,
+			"combine":      batch.Combine,
+			"status":       batch.DeliveryStatus,
+			"attempts":     batch.DeliveryAttempts,
+			"error":        batch.DeliveryError,
+			"delivered_at": batch.DeliveredAt,
+		}
+	}
+
+	return gin.H{
+		"id":           batch.ID,
+		"name":         batch.Name,
+		"total":        batch.Total,
+		"status":       status,
+		"progress":     progress,
+		"delivery":     delivery,
+		"created_at":   batch.CreatedAt,
+		"completed_at": batch.CompletedAt,
+	}
+}
+
+func batchTasks(tasks []*models.CaptureTask) []gin.H {
+	result := make([]gin.H, len(tasks))
+	for i, task := range tasks {
+		result[i] = gin.H{
+			"id":     task.ID,
+			"url":    task.URL,
+			"status": task.Status,
+			"error":  task.ErrorMessage,
+		}
+	}
+	return result
+}
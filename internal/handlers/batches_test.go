@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"pagemail/internal/models"
+)
+
+func TestParseURLList(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{"newline list", "https://a.example.com\n\nhttps://b.example.com\n", []string{"https://a.example.com", "https://b.example.com"}, false},
+		{"windows line endings", "https://a.example.com\r\nhttps://b.example.com\r\n", []string{"https://a.example.com", "https://b.example.com"}, false},
+		{"comments", "# weekly report\nhttps://a.example.com\n", []string{"https://a.example.com"}, false},
+		{"csv with header", "\ufeffURL,owner\nhttps://a.example.com,ops\n\"https://b.example.com/?q=1,2\",web\n", []string{"https://a.example.com", "https://b.example.com/?q=1,2"}, false},
+		{"empty", "", nil, false},
+		{"bad quoting", "\"https://a.example.com\n", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseURLList(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseURLList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseURLList() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateCaptureBatch(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+	webhook := models.WebhookEndpoint{UserID: user.ID, Name: "hook", URL: "https://hooks.example.com", IsActive: true}
+	h.db.Create(&webhook)
+	profile := models.SMTPProfile{UserID: &user.ID, Name: "mail", Host: "smtp.example.com", Port: 587, FromEmail: "reports@example.com"}
+	h.db.Create(&profile)
+
+	withUser := func(handler gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set("user_id", user.ID.String())
+			handler(c)
+		}
+	}
+	r.POST("/captures/batch", withUser(h.CreateCaptureBatch))
+	r.GET("/captures/batch/:id", withUser(h.GetCaptureBatch))
+
+	manyURLs := make([]string, maxBatchURLs+1)
+	for i := range manyURLs {
+		manyURLs[i] = fmt.Sprintf("https://example.com/%d", i)
+	}
+	urls := []string{"https://a.example.com", "https://b.example.com", "https://c.example.com"}
+	webhookDelivery := map[string]interface{}{"type": "webhook", "id": webhook.ID.String()}
+	emailDelivery := map[string]interface{}{"type": "email", "id": profile.ID.String(), "formats": []string{"pdf"}}
+
+	tests := []struct {
+		name           string
+		body           map[string]interface{}
+		wantStatus     int
+		wantDeliveries int64
+	}{
+		{"delivered one by one", map[string]interface{}{"urls": urls, "formats": []string{"pdf"}, "delivery_config": webhookDelivery}, http.StatusCreated, 3},
+		{"combined zip", map[string]interface{}{"name": "Weekly", "urls": urls, "formats": []string{"pdf"}, "delivery_config": emailDelivery, "combine": "zip"}, http.StatusCreated, 0},
+		{"no urls", map[string]interface{}{"urls": []string{}, "formats": []string{"pdf"}}, http.StatusBadRequest, 0},
+		{"too many urls", map[string]interface{}{"urls": manyURLs, "formats": []string{"pdf"}}, http.StatusBadRequest, 0},
+		{"invalid url", map[string]interface{}{"urls": []string{"https://a.example.com", "not a url"}, "formats": []string{"pdf"}}, http.StatusBadRequest, 0},
+		{"invalid format", map[string]interface{}{"urls": urls, "formats": []string{"gif"}}, http.StatusBadRequest, 0},
+		{"combine without delivery", map[string]interface{}{"urls": urls, "formats": []string{"pdf"}, "combine": "attachments"}, http.StatusBadRequest, 0},
+		{"unknown combine", map[string]interface{}{"urls": urls, "formats": []string{"pdf"}, "delivery_config": emailDelivery, "combine": "tar"}, http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.body)
+			req := httptest.NewRequest(http.MethodPost, "/captures/batch", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("CreateCaptureBatch() status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code != http.StatusCreated {
+				return
+			}
+
+			var resp struct {
+				ID       string
+				Total    int
+				Status   string
+				Progress map[string]int
+				Tasks    []struct{ ID, URL string }
+			}
+			_ = json.Unmarshal(w.Body.Bytes(), &resp)
+			if resp.Total != 3 || resp.Status != "pending" || resp.Progress["pending"] != 3 || len(resp.Tasks) != 3 || resp.Tasks[2].URL != urls[2] {
+				t.Errorf("CreateCaptureBatch() = %s", w.Body.String())
+			}
+
+			var deliveries, jobs int64
+			h.db.Model(&models.Delivery{}).Where("task_id IN (?)",
+				h.db.Model(&models.CaptureTask{}).Select("id").Where("batch_id = ?", resp.ID)).Count(&deliveries)
+			h.db.Model(&models.Job{}).Where("type = ? AND payload LIKE ?", models.JobTypeCapture, "%"+resp.Tasks[0].ID+"%").Count(&jobs)
+			if deliveries != tt.wantDeliveries || jobs != 1 {
+				t.Errorf("Batch has %d task deliveries and %d jobs for its first task, want %d and 1", deliveries, jobs, tt.wantDeliveries)
+			}
+		})
+	}
+
+	t.Run("upload and progress", func(t *testing.T) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		_ = mw.WriteField("options", `{"formats":["pdf"],"delivery_config":{"type":"email","id":"`+profile.ID.String()+`"},"combine":"attachments"}`)
+		fw, _ := mw.CreateFormFile("file", "urls.csv")
+		_, _ = fw.Write([]byte("url,notes\nhttps://a.example.com,home\nhttps://b.example.com,pricing\n"))
+		mw.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/captures/batch", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("CreateCaptureBatch() upload status = %d, body = %s", w.Code, w.Body.String())
+		}
+
+		var created struct {
+			ID    string
+			Tasks []struct{ ID string }
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &created)
+		h.db.Model(&models.CaptureTask{}).Where("id = ?", created.Tasks[0].ID).Update("status", models.TaskStatusCompleted)
+
+		req = httptest.NewRequest(http.MethodGet, "/captures/batch/"+created.ID, http.NoBody)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var resp struct {
+			Status   string
+			Progress map[string]int
+			Delivery struct{ Combine, Status string }
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Status != "running" || resp.Progress["done"] != 1 || resp.Progress["percent"] != 50 {
+			t.Errorf("GetCaptureBatch() progress = %s", w.Body.String())
+		}
+		if resp.Delivery.Combine != "attachments" || resp.Delivery.Status != models.DeliveryStatusPending {
+			t.Errorf("GetCaptureBatch() delivery = %+v", resp.Delivery)
+		}
+	})
+}
+
+func TestListCaptureBatches(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+	statuses := [][]string{
+		{models.TaskStatusCompleted, models.TaskStatusFailed, models.TaskStatusPending},
+		{models.TaskStatusPending, models.TaskStatusPending},
+		{models.TaskStatusCompleted},
+	}
+	for i, batchStatuses := range statuses {
+		batch := models.CaptureBatch{UserID: user.ID, Name: fmt.Sprintf("batch %d", i), Total: len(batchStatuses)}
+		h.db.Create(&batch)
+		for j, status := range batchStatuses {
+			h.db.Create(&models.CaptureTask{UserID: user.ID, URL: "https://example.com", Status: status, BatchID: &batch.ID, BatchIndex: j})
+		}
+	}
+
+	taskQueries := 0
+	countTaskQueries := func(tx *gorm.DB) {
+		if tx.Statement.Table == "capture_tasks" {
+			taskQueries++
+		}
+	}
+	_ = h.db.Callback().Query().After("gorm:query").Register("test:count_task_queries", countTaskQueries)
+	_ = h.db.Callback().Row().After("gorm:row").Register("test:count_task_rows", countTaskQueries)
+
+	r.GET("/captures/batch", func(c *gin.Context) {
+		c.Set("user_id", user.ID.String())
+		h.ListCaptureBatches(c)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/captures/batch", http.NoBody)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("ListCaptureBatches() status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	if taskQueries != 1 {
+		t.Errorf("ListCaptureBatches() ran %d task queries for %d batches, want 1", taskQueries, len(statuses))
+	}
+
+	var resp struct {
+		Data []struct {
+			Name     string         `json:"name"`
+			Status   string         `json:"status"`
+			Progress map[string]int `json:"progress"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	got := map[string]string{}
+	for _, batch := range resp.Data {
+		got[batch.Name] = fmt.Sprintf("%s %d/%d pending=%d", batch.Status, batch.Progress["done"], batch.Progress["percent"], batch.Progress[models.TaskStatusPending])
+	}
+	want := map[string]string{
+		"batch 0": "running 2/66 pending=1",
+		"batch 1": "pending 0/0 pending=2",
+		"batch 2": "running 1/100 pending=0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListCaptureBatches() progress = %v, want %v", got, want)
+	}
+}
@@ -1,16 +1,22 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-rod/rod/lib/proto"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
 
 	"pagemail/internal/audit"
+	"pagemail/internal/capture"
 	"pagemail/internal/models"
+	"pagemail/internal/pkg/crypto"
 	"pagemail/internal/pkg/errors"
 	"pagemail/internal/queue"
 )
@@ -19,18 +25,125 @@ const (
 	formatPDF        = "pdf"
 	formatHTML       = "html"
 	formatScreenshot = "screenshot"
+	formatJPEG       = "jpeg"
+	formatWebP       = "webp"
+	formatMarkdown   = "markdown"
+	formatText       = "text"
+	formatEPUB       = "epub"
+	formatMHTML      = "mhtml"
+	formatWARC       = "warc"
+	formatHAR        = "har"
+	formatConsole    = "console"
+
+	// Derived by comparing two captures; these cannot be requested.
+	formatDiffImage = "diff_image"
+	formatDiffHTML  = "diff_html"
+	formatDiffText  = "diff_text"
 )
 
 type CreateCaptureRequest struct {
-	URL            string          `json:"url" binding:"required,url"`
-	Formats        []string        `json:"formats" binding:"required,min=1"`
-	Cookies        string          `json:"cookies"`
-	DeliveryConfig *DeliveryConfig `json:"delivery_config"`
+	URL string `json:"url" binding:"required,url"`
+	CaptureSettings
+}
+
+// CaptureSettings is everything about a capture but its URL, which a
+// batch shares between its URLs.
+type CaptureSettings struct {
+	Formats        []string                   `json:"formats" binding:"required,min=1"`
+	Cookies        string                     `json:"cookies" binding:"max=1048576"`
+	CookiesFormat  string                     `json:"cookies_format" binding:"omitempty,oneof=header netscape json"`
+	LoginRecipeID  *uuid.UUID                 `json:"login_recipe_id"`
+	BasicAuth      *capture.BasicAuth         `json:"basic_auth"`
+	Headers        map[string]string          `json:"headers"`
+	Wait           *WaitConfig                `json:"wait"`
+	PDF            *capture.PDFOptions        `json:"pdf"`
+	Screenshot     *capture.ScreenshotOptions `json:"screenshot"`
+	Emulation      *capture.EmulationOptions  `json:"emulation"`
+	Block          *capture.BlockingOptions   `json:"block"`
+	Scroll         *capture.ScrollOptions     `json:"scroll"`
+	Inject         *InjectConfig              `json:"inject"`
+	Monitor        *capture.MonitorOptions    `json:"monitor"`
+	DeliveryConfig *DeliveryConfig            `json:"delivery_config"`
+}
+
+type WaitConfig struct {
+	Until      string `json:"until" binding:"omitempty,oneof=load domcontentloaded networkidle selector function"`
+	Selector   string `json:"selector"`
+	Expression string `json:"expression"`
+	IdleMs     int    `json:"idle_ms" binding:"omitempty,min=100,max=10000"`
+	DelayMs    int    `json:"delay_ms" binding:"omitempty,min=0,max=30000"`
+	TimeoutMs  int    `json:"timeout_ms" binding:"omitempty,min=1000,max=120000"`
+}
+
+func (w *WaitConfig) validate() error {
+	switch w.Until {
+	case capture.WaitSelector:
+		if strings.TrimSpace(w.Selector) == "" {
+			return fmt.Errorf("wait.selector is required when wait.until is %q", w.Until)
+		}
+	case capture.WaitFunction:
+		if strings.TrimSpace(w.Expression) == "" {
+			return fmt.Errorf("wait.expression is required when wait.until is %q", w.Until)
+		}
+	}
+	if w.IdleMs > 0 && w.Until != capture.WaitNetworkIdle {
+		return fmt.Errorf("wait.idle_ms is only valid when wait.until is %q", capture.WaitNetworkIdle)
+	}
+	return nil
+}
+
+func (w *WaitConfig) apply(task *models.CaptureTask) {
+	task.WaitUntil = w.Until
+	task.WaitSelector = w.Selector
+	task.WaitExpression = w.Expression
+	task.WaitIdleMs = w.IdleMs
+	task.WaitDelayMs = w.DelayMs
+	if w.TimeoutMs > 0 {
+		task.WaitTimeoutMs = w.TimeoutMs
+	}
+}
+
+// InjectConfig lists scripts and stylesheets applied after the page is
+// ready. Snippets are referenced by name and run first, in the order given,
+// followed by styles and then scripts.
+type InjectConfig struct {
+	Snippets  []string `json:"snippets" binding:"omitempty,max=20"`
+	Styles    []string `json:"styles" binding:"omitempty,max=20"`
+	Scripts   []string `json:"scripts" binding:"omitempty,max=20"`
+	TimeoutMs int      `json:"timeout_ms" binding:"omitempty,min=100,max=30000"`
+}
+
+// resolveInjections expands snippet names into their content so later edits
+// to a snippet do not change tasks already queued.
+func (h *Handler) resolveInjections(uid uuid.UUID, cfg *InjectConfig) ([]capture.Injection, *errors.ProblemDetail) {
+	injections := make([]capture.Injection, 0, len(cfg.Snippets)+len(cfg.Styles)+len(cfg.Scripts))
+	for _, name := range cfg.Snippets {
+		var snippet models.Snippet
+		if err := h.db.Where("name = ? AND user_id = ?", name, uid).First(&snippet).Error; err != nil {
+			return nil, errors.BadRequest("Snippet not found: " + name)
+		}
+		injections = append(injections, capture.Injection{Name: snippet.Name, Type: snippet.Type, Content: snippet.Content})
+	}
+	for _, style := range cfg.Styles {
+		injections = append(injections, capture.Injection{Type: capture.InjectCSS, Content: style})
+	}
+	for _, script := range cfg.Scripts {
+		injections = append(injections, capture.Injection{Type: capture.InjectJS, Content: script})
+	}
+
+	for i := range injections {
+		if err := injections[i].Validate(); err != nil {
+			return nil, errors.BadRequest("Invalid injection: " + err.Error())
+		}
+	}
+	return injections, nil
 }
 
 type DeliveryConfig struct {
-	Type string `json:"type" binding:"required,oneof=email webhook"`
-	ID   string `json:"id" binding:"required"`
+	Type       string   `json:"type" binding:"required,oneof=email webhook"`
+	ID         string   `json:"id" binding:"required"`
+	Recipients []string `json:"recipients" binding:"omitempty,dive,email"`
+	Formats    []string `json:"formats"`
 }
 
 func formatsToInt(formats []string) int {
@@ -43,6 +156,24 @@ func formatsToInt(formats []string) int {
 			result |= models.FormatHTML
 		case formatScreenshot:
 			result |= models.FormatPNG
+		case formatJPEG:
+			result |= models.FormatJPEG
+		case formatWebP:
+			result |= models.FormatWebP
+		case formatMarkdown:
+			result |= models.FormatMarkdown
+		case formatText:
+			result |= models.FormatText
+		case formatEPUB:
+			result |= models.FormatEPUB
+		case formatMHTML:
+			result |= models.FormatMHTML
+		case formatWARC:
+			result |= models.FormatWARC
+		case formatHAR:
+			result |= models.FormatHAR
+		case formatConsole:
+			result |= models.FormatConsole
 		}
 	}
 	return result
@@ -59,27 +190,191 @@ func intToFormats(flags int) []string {
 	if flags&models.FormatPNG != 0 {
 		formats = append(formats, formatScreenshot)
 	}
+	if flags&models.FormatJPEG != 0 {
+		formats = append(formats, formatJPEG)
+	}
+	if flags&models.FormatWebP != 0 {
+		formats = append(formats, formatWebP)
+	}
+	if flags&models.FormatMarkdown != 0 {
+		formats = append(formats, formatMarkdown)
+	}
+	if flags&models.FormatText != 0 {
+		formats = append(formats, formatText)
+	}
+	if flags&models.FormatEPUB != 0 {
+		formats = append(formats, formatEPUB)
+	}
+	if flags&models.FormatMHTML != 0 {
+		formats = append(formats, formatMHTML)
+	}
+	if flags&models.FormatWARC != 0 {
+		formats = append(formats, formatWARC)
+	}
+	if flags&models.FormatHAR != 0 {
+		formats = append(formats, formatHAR)
+	}
+	if flags&models.FormatConsole != 0 {
+		formats = append(formats, formatConsole)
+	}
 	return formats
 }
 
-func (h *Handler) CreateCapture(c *gin.Context) {
-	var req CreateCaptureRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		errors.BadRequest(err.Error()).Respond(c)
-		return
+func isValidFormat(format string) bool {
+	switch format {
+	case formatPDF, formatHTML, formatScreenshot, formatJPEG, formatWebP,
+		formatMarkdown, formatText, formatEPUB, formatMHTML, formatWARC,
+		formatHAR, formatConsole:
+		return true
+	}
+	return false
+}
+
+// newDelivery validates that the delivery target belongs to the user and
+// builds a pending Delivery for it. The caller sets TaskID and saves it.
+func (h *Handler) newDelivery(uid uuid.UUID, cfg *DeliveryConfig) (*models.Delivery, *errors.ProblemDetail) {
+	for _, f := range cfg.Formats {
+		if !isValidFormat(f) {
+			return nil, errors.BadRequest("Invalid delivery format: " + f)
+		}
+	}
+
+	switch cfg.Type {
+	case models.ChannelEmail:
+		var profile models.SMTPProfile
+		if err := h.db.Where("id = ? AND user_id = ?", cfg.ID, uid).First(&profile).Error; err != nil {
+			return nil, errors.BadRequest("SMTP profile not found")
+		}
+	case models.ChannelWebhook:
+		if len(cfg.Recipients) > 0 {
+			return nil, errors.BadRequest("Recipients are only supported for email delivery")
+		}
+		var webhook models.WebhookEndpoint
+		if err := h.db.Where("id = ? AND user_id = ?", cfg.ID, uid).First(&webhook).Error; err != nil {
+			return nil, errors.BadRequest("Webhook not found")
+		}
+		if !webhook.IsActive {
+			return nil, errors.BadRequest("Webhook is inactive")
+		}
+	default:
+		return nil, errors.BadRequest("Invalid delivery type: " + cfg.Type)
+	}
+
+	target, err := json.Marshal(queue.DeliveryTarget{
+		ID:         cfg.ID,
+		Recipients: cfg.Recipients,
+		Formats:    cfg.Formats,
+	})
+	if err != nil {
+		return nil, errors.InternalError("Failed to encode delivery target")
 	}
 
+	return &models.Delivery{
+		Channel:      cfg.Type,
+		TargetConfig: string(target),
+		Status:       models.DeliveryStatusPending,
+		MaxAttempts:  3,
+	}, nil
+}
+
+// newCaptureTask validates req and builds the pending task it describes,
+// with secrets encrypted, and its delivery if one was requested. Neither is
+// saved.
+func (h *Handler) newCaptureTask(uid uuid.UUID, req *CreateCaptureRequest) (*models.CaptureTask, *models.Delivery, *errors.ProblemDetail) {
 	for _, f := range req.Formats {
-		if f != "pdf" && f != "html" && f != "screenshot" {
-			errors.BadRequest("Invalid format: " + f).Respond(c)
-			return
+		if !isValidFormat(f) {
+			return nil, nil, errors.BadRequest("Invalid format: " + f)
 		}
 	}
 
-	userID := c.GetString("user_id")
-	uid, _ := uuid.Parse(userID)
+	if req.Wait != nil {
+		if err := req.Wait.validate(); err != nil {
+			return nil, nil, errors.BadRequest(err.Error())
+		}
+	}
+
+	var pdfOptions []byte
+	if req.PDF != nil {
+		if err := req.PDF.Validate(); err != nil {
+			return nil, nil, errors.BadRequest("Invalid PDF options: " + err.Error())
+		}
+		pdfOptions, _ = json.Marshal(req.PDF)
+	}
+
+	var screenshotOptions []byte
+	if req.Screenshot != nil {
+		if err := req.Screenshot.Validate(); err != nil {
+			return nil, nil, errors.BadRequest("Invalid screenshot options: " + err.Error())
+		}
+		screenshotOptions, _ = json.Marshal(req.Screenshot)
+	}
+
+	var emulationOptions []byte
+	if req.Emulation != nil {
+		if err := req.Emulation.Validate(); err != nil {
+			return nil, nil, errors.BadRequest("Invalid emulation options: " + err.Error())
+		}
+		emulationOptions, _ = json.Marshal(req.Emulation)
+	}
 
-	task := models.CaptureTask{
+	var scrollOptions []byte
+	if req.Scroll != nil {
+		if err := req.Scroll.Validate(); err != nil {
+			return nil, nil, errors.BadRequest("Invalid scroll options: " + err.Error())
+		}
+		scrollOptions, _ = json.Marshal(req.Scroll)
+	}
+
+	var monitorOptions []byte
+	if req.Monitor != nil {
+		if err := req.Monitor.Validate(); err != nil {
+			return nil, nil, errors.BadRequest("Invalid monitor options: " + err.Error())
+		}
+		monitorOptions, _ = json.Marshal(req.Monitor)
+	}
+
+	var cookies []*proto.NetworkCookieParam
+	if req.Cookies != "" {
+		var err error
+		if cookies, err = capture.ImportCookies(req.Cookies, req.CookiesFormat, req.URL); err != nil {
+			return nil, nil, errors.BadRequest("Invalid cookies: " + err.Error())
+		}
+	}
+
+	if req.LoginRecipeID != nil {
+		var recipe models.LoginRecipe
+		if err := h.db.Where("id = ? AND user_id = ?", *req.LoginRecipeID, uid).First(&recipe).Error; err != nil {
+			return nil, nil, errors.BadRequest("Login recipe not found")
+		}
+	}
+	if req.BasicAuth != nil && req.BasicAuth.Username == "" {
+		return nil, nil, errors.BadRequest("basic_auth.username is required")
+	}
+	if err := capture.ValidateHeaders(req.Headers); err != nil {
+		return nil, nil, errors.BadRequest("Invalid headers: " + err.Error())
+	}
+
+	var injections []byte
+	if req.Inject != nil {
+		resolved, problem := h.resolveInjections(uid, req.Inject)
+		if problem != nil {
+			return nil, nil, problem
+		}
+		if len(resolved) > 0 {
+			injections, _ = json.Marshal(resolved)
+		}
+	}
+
+	var delivery *models.Delivery
+	if req.DeliveryConfig != nil {
+		var problem *errors.ProblemDetail
+		delivery, problem = h.newDelivery(uid, req.DeliveryConfig)
+		if problem != nil {
+			return nil, nil, problem
+		}
+	}
+
+	task := &models.CaptureTask{
 		UserID:      uid,
 		URL:         req.URL,
 		Formats:     formatsToInt(req.Formats),
@@ -87,19 +382,89 @@ func (h *Handler) CreateCapture(c *gin.Context) {
 		MaxAttempts: 3,
 	}
 
-	if req.Cookies != "" {
-		task.CookiesEnc = []byte(req.Cookies) // TODO: encrypt
+	if req.Wait != nil {
+		req.Wait.apply(task)
+	}
+	task.PDFOptions = string(pdfOptions)
+	task.ScreenshotOptions = string(screenshotOptions)
+	task.EmulationOptions = string(emulationOptions)
+	if req.Block != nil && (req.Block.Ads || req.Block.CookieBanners) {
+		blockingOptions, _ := json.Marshal(req.Block)
+		task.BlockingOptions = string(blockingOptions)
 	}
+	task.ScrollOptions = string(scrollOptions)
+	task.MonitorOptions = string(monitorOptions)
+	task.Injections = string(injections)
+	if req.Inject != nil {
+		task.ScriptTimeoutMs = req.Inject.TimeoutMs
+	}
+
+	// Cookies, credentials and headers may carry secrets, so they are
+	// stored encrypted like SMTP passwords.
+	task.LoginRecipeID = req.LoginRecipeID
+	var err error
+	if len(cookies) > 0 {
+		if task.CookiesEnc, err = h.encryptJSON(cookies); err != nil {
+			return nil, nil, errors.InternalError("Failed to encrypt cookies")
+		}
+	}
+	if req.BasicAuth != nil {
+		if task.BasicAuthEnc, err = h.encryptJSON(req.BasicAuth); err != nil {
+			return nil, nil, errors.InternalError("Failed to encrypt credentials")
+		}
+	}
+	if len(req.Headers) > 0 {
+		if task.HeadersEnc, err = h.encryptJSON(req.Headers); err != nil {
+			return nil, nil, errors.InternalError("Failed to encrypt headers")
+		}
+	}
+	return task, delivery, nil
+}
+
+// CreateCapture enqueues a capture. With ?wait it also waits for the
+// capture to finish, as CreateCaptureSync does.
+func (h *Handler) CreateCapture(c *gin.Context) {
+	h.createCapture(c, 0)
+}
+
+func (h *Handler) createCapture(c *gin.Context, defaultWait time.Duration) {
+	sync, problem := parseSyncOptions(c, defaultWait)
+	if problem != nil {
+		problem.Respond(c)
+		return
+	}
+
+	var req CreateCaptureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errors.BadRequest(err.Error()).Respond(c)
+		return
+	}
+
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
 
-	if err := h.db.Create(&task).Error; err != nil {
+	task, delivery, problem := h.newCaptureTask(uid, &req)
+	if problem != nil {
+		problem.Respond(c)
+		return
+	}
+
+	if err := h.db.Create(task).Error; err != nil {
 		errors.InternalError("Failed to create capture task").Respond(c)
 		return
 	}
 
+	if delivery != nil {
+		delivery.TaskID = task.ID
+		if err := h.db.Create(delivery).Error; err != nil {
+			errors.InternalError("Failed to create delivery").Respond(c)
+			return
+		}
+	}
+
 	payload := map[string]interface{}{
 		"task_id": task.ID.String(),
 		"url":     req.URL,
-		"cookies": req.Cookies,
 		"formats": req.Formats,
 	}
 
@@ -112,6 +477,11 @@ func (h *Handler) CreateCapture(c *gin.Context) {
 		URL: task.URL, Formats: req.Formats,
 	})
 
+	if sync.wait > 0 {
+		h.respondWhenDone(c, task, sync)
+		return
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"id":         task.ID,
 		"url":        task.URL,
@@ -121,11 +491,35 @@ func (h *Handler) CreateCapture(c *gin.Context) {
 	})
 }
 
+// encryptJSON marshals v and encrypts it with the configured key.
+func (h *Handler) encryptJSON(v interface{}) ([]byte, error) {
+	encryptor, err := crypto.NewEncryptor(h.cfg.Encryption.Key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return encryptor.Encrypt(plaintext)
+}
+
+// storedJSON returns a JSON text column as-is for API responses.
+func storedJSON(raw string) interface{} {
+	if raw == "" {
+		return nil
+	}
+	return json.RawMessage(raw)
+}
+
 func (h *Handler) ListCaptures(c *gin.Context) {
 	userID := c.GetString("user_id")
 	uid, _ := uuid.Parse(userID)
 	page, limit := parsePagination(c)
 	status := c.Query("status")
+	scheduleID := c.Query("schedule_id")
+	batchID := c.Query("batch_id")
+	changed := c.Query("changed")
 
 	var tasks []models.CaptureTask
 	var total int64
@@ -134,6 +528,15 @@ func (h *Handler) ListCaptures(c *gin.Context) {
 	if status != "" {
 		query = query.Where("status = ?", status)
 	}
+	if scheduleID != "" {
+		query = query.Where("schedule_id = ?", scheduleID)
+	}
+	if batchID != "" {
+		query = query.Where("batch_id = ?", batchID)
+	}
+	if changed != "" {
+		query = query.Where("changed = ?", changed == "true")
+	}
 
 	query.Count(&total)
 	query.Order("created_at DESC").Offset((page - 1) * limit).Limit(limit).Find(&tasks)
@@ -143,6 +546,12 @@ func (h *Handler) ListCaptures(c *gin.Context) {
 		result[i] = gin.H{
 			"id":           tasks[i].ID,
 			"url":          tasks[i].URL,
+			"title":        tasks[i].Title,
+			"final_url":    tasks[i].FinalURL,
+			"http_status":  tasks[i].HTTPStatus,
+			"schedule_id":  tasks[i].ScheduleID,
+			"batch_id":     tasks[i].BatchID,
+			"changed":      tasks[i].Changed,
 			"formats":      intToFormats(tasks[i].Formats),
 			"status":       tasks[i].Status,
 			"attempts":     tasks[i].Attempts,
@@ -173,10 +582,11 @@ func (h *Handler) GetCapture(c *gin.Context) {
 	outputResult := make([]gin.H, len(outputs))
 	for i := range outputs {
 		outputResult[i] = gin.H{
-			"id":     outputs[i].ID,
-			"format": outputs[i].Format,
-			"size":   outputs[i].SizeBytes,
-			"path":   outputs[i].ObjectKey,
+			"id":      outputs[i].ID,
+			"format":  outputs[i].Format,
+			"size":    outputs[i].SizeBytes,
+			"path":    outputs[i].ObjectKey,
+			"diff_id": outputs[i].DiffID,
 		}
 	}
 
@@ -196,6 +606,14 @@ func (h *Handler) GetCapture(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"id":               task.ID,
 		"url":              task.URL,
+		"title":            task.Title,
+		"final_url":        task.FinalURL,
+		"http_status":      task.HTTPStatus,
+		"metadata":         storedJSON(task.PageMetadata),
+		"schedule_id":      task.ScheduleID,
+		"batch_id":         task.BatchID,
+		"changed":          task.Changed,
+		"change_summary":   storedJSON(task.ChangeSummary),
 		"formats":          intToFormats(task.Formats),
 		"status":           task.Status,
 		"attempts":         task.Attempts,
@@ -205,6 +623,28 @@ func (h *Handler) GetCapture(c *gin.Context) {
 		"delivery_history": deliveryHistory,
 		"created_at":       task.CreatedAt,
 		"updated_at":       task.UpdatedAt,
+		"wait": gin.H{
+			"until":      task.WaitUntil,
+			"selector":   task.WaitSelector,
+			"expression": task.WaitExpression,
+			"idle_ms":    task.WaitIdleMs,
+			"delay_ms":   task.WaitDelayMs,
+			"timeout_ms": task.WaitTimeoutMs,
+		},
+		"pdf":               storedJSON(task.PDFOptions),
+		"screenshot":        storedJSON(task.ScreenshotOptions),
+		"emulation":         storedJSON(task.EmulationOptions),
+		"cookies":           len(task.CookiesEnc) > 0,
+		"login_recipe_id":   task.LoginRecipeID,
+		"basic_auth":        len(task.BasicAuthEnc) > 0,
+		"custom_headers":    len(task.HeadersEnc) > 0,
+		"block":             storedJSON(task.BlockingOptions),
+		"scroll":            storedJSON(task.ScrollOptions),
+		"monitor":           storedJSON(task.MonitorOptions),
+		"injections":        storedJSON(task.Injections),
+		"script_timeout_ms": task.ScriptTimeoutMs,
+		"output_errors":     storedJSON(task.OutputErrors),
+		"injection_errors":  storedJSON(task.InjectionErrors),
 	})
 }
 
@@ -227,18 +667,29 @@ func (h *Handler) RetryCapture(c *gin.Context) {
 	task.Status = models.TaskStatusPending
 	task.ErrorMessage = ""
 	task.Attempts = 0
-	h.db.Save(&task)
+	// Deliveries failed along with the capture are sent if the retry
+	// succeeds, and a finished batch waits for the retry again.
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&task).Error; err != nil {
+			return err
+		}
+		if task.BatchID != nil {
+			if err := queue.ReopenBatch(tx, *task.BatchID); err != nil {
+				return err
+			}
+		}
+		return queue.ResetFailedDeliveries(tx, task.ID)
+	})
+	if err != nil {
+		errors.InternalError("Failed to retry task").Respond(c)
+		return
+	}
 
 	formats := intToFormats(task.Formats)
-	cookies := ""
-	if task.CookiesEnc != nil {
-		cookies = string(task.CookiesEnc) // TODO: decrypt
-	}
 
 	payload := map[string]interface{}{
 		"task_id": task.ID.String(),
 		"url":     task.URL,
-		"cookies": cookies,
 		"formats": formats,
 	}
 
@@ -266,6 +717,11 @@ func (h *Handler) DeleteCapture(c *gin.Context) {
 	// Delete related records first (foreign key constraints)
 	h.db.Where("task_id = ?", task.ID).Delete(&models.CaptureOutput{})
 	h.db.Where("task_id = ?", task.ID).Delete(&models.Delivery{})
+	h.db.Where("task_id = ?", task.ID).Delete(&models.CaptureSnapshot{})
+	// Diffs with other captures go too, along with their outputs.
+	diffs := h.db.Model(&models.CaptureDiff{}).Select("id").Where("task_id = ? OR other_task_id = ?", task.ID, task.ID)
+	h.db.Where("diff_id IN (?)", diffs).Delete(&models.CaptureOutput{})
+	h.db.Where("task_id = ? OR other_task_id = ?", task.ID, task.ID).Delete(&models.CaptureDiff{})
 
 	if err := h.db.Delete(&task).Error; err != nil {
 		errors.InternalError("Failed to delete task").Respond(c)
@@ -297,6 +753,7 @@ func (h *Handler) ListCaptureOutputs(c *gin.Context) {
 			"id":         outputs[i].ID,
 			"format":     outputs[i].Format,
 			"size":       outputs[i].SizeBytes,
+			"diff_id":    outputs[i].DiffID,
 			"created_at": outputs[i].CreatedAt,
 		}
 	}
@@ -353,8 +810,10 @@ func (h *Handler) PreviewOutput(c *gin.Context) {
 		return
 	}
 
-	if output.Format != formatPDF && output.Format != formatScreenshot {
-		errors.NewProblemDetail(http.StatusUnsupportedMediaType, "Unsupported Media Type", "Preview is only supported for PDF and screenshot formats").Respond(c)
+	switch output.Format {
+	case formatPDF, formatScreenshot, formatJPEG, formatWebP, formatDiffImage:
+	default:
+		errors.NewProblemDetail(http.StatusUnsupportedMediaType, "Unsupported Media Type", "Preview is only supported for PDF and image formats").Respond(c)
 		return
 	}
 
@@ -386,6 +845,28 @@ func getContentType(format string) string {
 		return "application/pdf"
 	case formatScreenshot:
 		return "image/png"
+	case formatJPEG:
+		return "image/jpeg"
+	case formatWebP:
+		return "image/webp"
+	case formatMarkdown:
+		return "text/markdown; charset=utf-8"
+	case formatText:
+		return "text/plain; charset=utf-8"
+	case formatEPUB:
+		return "application/epub+zip"
+	case formatMHTML:
+		return "multipart/related"
+	case formatWARC:
+		return "application/warc"
+	case formatHAR, formatConsole:
+		return "application/json"
+	case formatDiffImage:
+		return "image/png"
+	case formatDiffHTML:
+		return "text/html; charset=utf-8"
+	case formatDiffText:
+		return "text/x-diff; charset=utf-8"
 	default:
 		return "application/octet-stream"
 	}
@@ -399,13 +880,96 @@ func getExtension(format string) string {
 		return ".html"
 	case "screenshot":
 		return ".png"
+	case "jpeg":
+		return ".jpg"
+	case "webp":
+		return ".webp"
+	case "markdown":
+		return ".md"
+	case "text":
+		return ".txt"
+	case "epub":
+		return ".epub"
+	case "mhtml":
+		return ".mhtml"
+	case "warc":
+		return ".warc"
+	case "har":
+		return ".har"
+	case "console":
+		return ".json"
+	case formatDiffImage:
+		return ".png"
+	case formatDiffHTML:
+		return ".html"
+	case formatDiffText:
+		return ".diff"
 	default:
 		return ""
 	}
 }
 
 func (h *Handler) DeliverCapture(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"detail": "Use delivery config in task creation"})
+	taskID := c.Param("id")
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	var task models.CaptureTask
+	if err := h.db.Where("id = ? AND user_id = ?", taskID, uid).First(&task).Error; err != nil {
+		errors.NotFound("Capture task not found").Respond(c)
+		return
+	}
+
+	if task.Status != models.TaskStatusCompleted {
+		errors.Conflict("Only completed captures can be delivered").Respond(c)
+		return
+	}
+
+	var req DeliveryConfig
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errors.BadRequest(err.Error()).Respond(c)
+		return
+	}
+
+	delivery, problem := h.newDelivery(uid, &req)
+	if problem != nil {
+		problem.Respond(c)
+		return
+	}
+
+	query := h.db.Model(&models.CaptureOutput{}).Where("task_id = ? AND diff_id IS NULL", task.ID)
+	if len(req.Formats) > 0 {
+		query = query.Where("format IN ?", req.Formats)
+	}
+	var outputCount int64
+	query.Count(&outputCount)
+	if outputCount == 0 {
+		errors.BadRequest("No outputs available for the requested formats").Respond(c)
+		return
+	}
+
+	delivery.TaskID = task.ID
+	if err := h.db.Create(delivery).Error; err != nil {
+		errors.InternalError("Failed to create delivery").Respond(c)
+		return
+	}
+
+	if err := queue.EnqueueJob(h.db, models.JobTypeDeliver, queue.DeliveryPayload{DeliveryID: delivery.ID.String()}); err != nil {
+		errors.InternalError("Failed to enqueue job").Respond(c)
+		return
+	}
+
+	h.logAudit(c, audit.ActionDeliveryCreate, "delivery", &delivery.ID, audit.ResourceDetails{
+		URL: task.URL, Formats: req.Formats,
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"id":         delivery.ID,
+		"task_id":    task.ID,
+		"channel":    delivery.Channel,
+		"status":     delivery.Status,
+		"created_at": delivery.CreatedAt,
+	})
 }
 
 func (h *Handler) ListDeliveries(c *gin.Context) {
@@ -1,7 +1,14 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
 
 	"pagemail/internal/models"
 )
@@ -18,6 +25,10 @@ func TestFormatsToInt(t *testing.T) {
 		{"screenshot only", []string{"screenshot"}, models.FormatPNG},
 		{"all formats", []string{"pdf", "html", "screenshot"}, models.FormatPDF | models.FormatHTML | models.FormatPNG},
 		{"pdf and html", []string{"pdf", "html"}, models.FormatPDF | models.FormatHTML},
+		{"jpeg and webp", []string{"jpeg", "webp"}, models.FormatJPEG | models.FormatWebP},
+		{"reader formats", []string{"markdown", "text", "epub"}, models.FormatMarkdown | models.FormatText | models.FormatEPUB},
+		{"archive formats", []string{"mhtml", "warc"}, models.FormatMHTML | models.FormatWARC},
+		{"diagnostic formats", []string{"har", "console"}, models.FormatHAR | models.FormatConsole},
 		{"unknown format ignored", []string{"pdf", "unknown"}, models.FormatPDF},
 		{"duplicates", []string{"pdf", "pdf"}, models.FormatPDF},
 	}
@@ -44,6 +55,8 @@ func TestIntToFormats(t *testing.T) {
 		{"screenshot only", models.FormatPNG, []string{"screenshot"}},
 		{"all formats", models.FormatPDF | models.FormatHTML | models.FormatPNG, []string{"pdf", "html", "screenshot"}},
 		{"pdf and screenshot", models.FormatPDF | models.FormatPNG, []string{"pdf", "screenshot"}},
+		{"image formats", models.FormatPNG | models.FormatJPEG | models.FormatWebP, []string{"screenshot", "jpeg", "webp"}},
+		{"reader formats", models.FormatPDF | models.FormatMarkdown | models.FormatText, []string{"pdf", "markdown", "text"}},
 	}
 
 	for _, tt := range tests {
@@ -71,6 +84,10 @@ func TestFormatRoundTrip(t *testing.T) {
 		{"pdf", "screenshot"},
 		{"html", "screenshot"},
 		{"pdf", "html", "screenshot"},
+		{"jpeg", "webp"},
+		{"markdown", "text"},
+		{"epub"},
+		{"mhtml", "warc"},
 	}
 
 	for _, formats := range testCases {
@@ -84,3 +101,297 @@ func TestFormatRoundTrip(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateCaptureWaitConfig(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+
+	r.POST("/captures", func(c *gin.Context) {
+		c.Set("user_id", user.ID.String())
+		h.CreateCapture(c)
+	})
+
+	tests := []struct {
+		name       string
+		wait       map[string]interface{}
+		wantStatus int
+	}{
+		{"network idle", map[string]interface{}{"until": "networkidle", "idle_ms": 800, "delay_ms": 250}, http.StatusCreated},
+		{"selector", map[string]interface{}{"until": "selector", "selector": "#app .ready"}, http.StatusCreated},
+		{"function", map[string]interface{}{"until": "function", "expression": "window.appReady === true"}, http.StatusCreated},
+		{"selector missing", map[string]interface{}{"until": "selector"}, http.StatusBadRequest},
+		{"expression missing", map[string]interface{}{"until": "function"}, http.StatusBadRequest},
+		{"idle without networkidle", map[string]interface{}{"until": "load", "idle_ms": 500}, http.StatusBadRequest},
+		{"unknown strategy", map[string]interface{}{"until": "forever"}, http.StatusBadRequest},
+		{"timeout too large", map[string]interface{}{"timeout_ms": 600000}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]interface{}{
+				"url":     "https://example.com",
+				"formats": []string{"pdf"},
+				"wait":    tt.wait,
+			})
+			req := httptest.NewRequest(http.MethodPost, "/captures", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("CreateCapture() status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+
+	var task models.CaptureTask
+	if err := h.db.Where("wait_until = ?", "networkidle").First(&task).Error; err != nil {
+		t.Fatalf("Failed to load task: %v", err)
+	}
+	if task.WaitIdleMs != 800 || task.WaitDelayMs != 250 {
+		t.Errorf("Task wait = %d/%d ms, want 800/250", task.WaitIdleMs, task.WaitDelayMs)
+	}
+}
+
+func TestCreateCaptureCookies(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+
+	r.POST("/captures", func(c *gin.Context) {
+		c.Set("user_id", user.ID.String())
+		h.CreateCapture(c)
+	})
+
+	tests := []struct {
+		name       string
+		cookies    string
+		format     string
+		wantStatus int
+	}{
+		{"netscape", ".example.com\tTRUE\t/\tTRUE\t0\tsession\tsecret-value", "netscape", http.StatusCreated},
+		{"json", `[{"name":"sid","value":"1","domain":".example.com"}]`, "", http.StatusCreated},
+		{"malformed netscape", "example.com\tTRUE", "netscape", http.StatusBadRequest},
+		{"unknown format", "a=1", "yaml", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]interface{}{
+				"url":            "https://example.com",
+				"formats":        []string{"pdf"},
+				"cookies":        tt.cookies,
+				"cookies_format": tt.format,
+			})
+			req := httptest.NewRequest(http.MethodPost, "/captures", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("CreateCapture() status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+
+	var tasks []models.CaptureTask
+	h.db.Where("user_id = ?", user.ID).Find(&tasks)
+	if len(tasks) != 2 {
+		t.Fatalf("Created %d tasks, want 2", len(tasks))
+	}
+	for _, task := range tasks {
+		if len(task.CookiesEnc) == 0 || bytes.Contains(task.CookiesEnc, []byte("secret-value")) ||
+			bytes.Contains(task.CookiesEnc, []byte("sid")) {
+			t.Errorf("Task %s cookies should be stored encrypted", task.ID)
+		}
+	}
+
+	var jobs []models.Job
+	h.db.Find(&jobs)
+	for _, job := range jobs {
+		if bytes.Contains([]byte(job.Payload), []byte("cookies")) {
+			t.Errorf("Job payload should not carry cookies: %s", job.Payload)
+		}
+	}
+}
+
+func TestGetCaptureMetadata(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+	task := models.CaptureTask{
+		UserID:       user.ID,
+		URL:          "http://example.com/old",
+		Status:       models.TaskStatusCompleted,
+		Title:        "Example",
+		FinalURL:     "https://example.com/new",
+		HTTPStatus:   200,
+		PageMetadata: `{"canonical_url":"https://example.com/new","open_graph":{"title":"Example"}}`,
+	}
+	h.db.Create(&task)
+
+	r.GET("/captures", func(c *gin.Context) {
+		c.Set("user_id", user.ID.String())
+		h.ListCaptures(c)
+	})
+	r.GET("/captures/:id", func(c *gin.Context) {
+		c.Set("user_id", user.ID.String())
+		h.GetCapture(c)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/captures/"+task.ID.String(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GetCapture() status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var got struct {
+		Title      string `json:"title"`
+		FinalURL   string `json:"final_url"`
+		HTTPStatus int    `json:"http_status"`
+		Metadata   struct {
+			Canonical string            `json:"canonical_url"`
+			OpenGraph map[string]string `json:"open_graph"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if got.Title != "Example" || got.FinalURL != "https://example.com/new" || got.HTTPStatus != 200 {
+		t.Errorf("GetCapture() = %+v", got)
+	}
+	if got.Metadata.Canonical != "https://example.com/new" || got.Metadata.OpenGraph["title"] != "Example" {
+		t.Errorf("GetCapture() metadata = %+v", got.Metadata)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/captures", nil))
+	var list struct {
+		Data []struct {
+			Title      string `json:"title"`
+			FinalURL   string `json:"final_url"`
+			HTTPStatus int    `json:"http_status"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(list.Data) != 1 || list.Data[0].Title != "Example" || list.Data[0].HTTPStatus != 200 {
+		t.Errorf("ListCaptures() = %s", w.Body.String())
+	}
+}
+
+func TestCaptureMonitoring(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+
+	r.POST("/captures", func(c *gin.Context) {
+		c.Set("user_id", user.ID.String())
+		h.CreateCapture(c)
+	})
+	r.GET("/captures", func(c *gin.Context) {
+		c.Set("user_id", user.ID.String())
+		h.ListCaptures(c)
+	})
+
+	tests := []struct {
+		name       string
+		monitor    map[string]interface{}
+		wantStatus int
+	}{
+		{"threshold", map[string]interface{}{"threshold": 0.02, "ignore_selectors": []string{"#clock"}}, http.StatusCreated},
+		{"threshold too large", map[string]interface{}{"threshold": 2}, http.StatusBadRequest},
+		{"empty selector", map[string]interface{}{"ignore_selectors": []string{""}}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]interface{}{
+				"url":     "https://example.com/pricing",
+				"formats": []string{"pdf"},
+				"monitor": tt.monitor,
+			})
+			req := httptest.NewRequest(http.MethodPost, "/captures", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("CreateCapture() status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+
+	var task models.CaptureTask
+	if err := h.db.Where("user_id = ?", user.ID).First(&task).Error; err != nil {
+		t.Fatalf("Failed to load task: %v", err)
+	}
+	if task.MonitorOptions != `{"threshold":0.02,"ignore_selectors":["#clock"]}` {
+		t.Errorf("Task monitor options = %s", task.MonitorOptions)
+	}
+
+	unchanged := false
+	h.db.Model(&task).Updates(map[string]interface{}{"changed": &unchanged, "change_summary": `{"changed":false}`})
+	changed := true
+	h.db.Create(&models.CaptureTask{UserID: user.ID, URL: "https://example.com/pricing", Changed: &changed})
+
+	for filter, want := range map[string]bool{"true": true, "false": false} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/captures?changed="+filter, nil))
+		var list struct {
+			Data []struct {
+				Changed *bool `json:"changed"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		if len(list.Data) != 1 || list.Data[0].Changed == nil || *list.Data[0].Changed != want {
+			t.Errorf("ListCaptures(changed=%s) = %s", filter, w.Body.String())
+		}
+	}
+}
+
+func TestRetryCapture(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+	now := time.Now()
+	batch := models.CaptureBatch{UserID: user.ID, Total: 1, DeliveryChannel: models.ChannelEmail, DeliveryTarget: "{}",
+		DeliveryStatus: models.DeliveryStatusSent, DeliveryAttempts: 1, DeliveredAt: &now, CompletedAt: &now}
+	h.db.Create(&batch)
+	task := models.CaptureTask{UserID: user.ID, URL: "https://example.com", Status: models.TaskStatusFailed, ErrorMessage: "navigation timeout", Attempts: 3, BatchID: &batch.ID}
+	h.db.Create(&task)
+	delivery := models.Delivery{TaskID: task.ID, Channel: models.ChannelEmail, TargetConfig: "{}", Status: models.DeliveryStatusFailed, Attempts: 1, LastError: "capture failed: navigation timeout"}
+	h.db.Create(&delivery)
+
+	r.POST("/captures/:id/retry", func(c *gin.Context) {
+		c.Set("user_id", user.ID.String())
+		h.RetryCapture(c)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/captures/"+task.ID.String()+"/retry", http.NoBody)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("RetryCapture() status = %d, want %d, body = %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var got models.Delivery
+	h.db.First(&got, "id = ?", delivery.ID)
+	if got.Status != models.DeliveryStatusPending || got.Attempts != 0 || got.LastError != "" || got.CompletedAt != nil {
+		t.Errorf("Delivery after retry = %+v, want pending and cleared", got)
+	}
+
+	var gotBatch models.CaptureBatch
+	h.db.First(&gotBatch, "id = ?", batch.ID)
+	if gotBatch.CompletedAt != nil || gotBatch.DeliveryStatus != models.DeliveryStatusPending || gotBatch.DeliveryAttempts != 0 || gotBatch.DeliveredAt != nil {
+		t.Errorf("Batch after retry = %+v, want it reopened with its delivery pending", gotBatch)
+	}
+}
@@ -0,0 +1,435 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register JPEG decoder
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	_ "golang.org/x/image/webp" // register WebP decoder
+	"gorm.io/gorm"
+
+	"pagemail/internal/capture"
+	"pagemail/internal/models"
+	"pagemail/internal/pkg/diff"
+	"pagemail/internal/pkg/errors"
+)
+
+// maxDiffInputSize bounds each output read to compute a diff.
+const maxDiffInputSize = 64 << 20
+
+const (
+	// maxDiffPixels bounds each screenshot compared. Both are decoded, and
+	// a diff image as large as the larger one drawn, at four bytes a pixel.
+	maxDiffPixels = 16 << 20
+	// maxConcurrentImageDiffs bounds the screenshot comparisons running at
+	// once across requests.
+	maxConcurrentImageDiffs = 2
+)
+
+// imageDiffSlots holds one token per screenshot comparison in progress.
+var imageDiffSlots = make(chan struct{}, maxConcurrentImageDiffs)
+
+// diffContextLines is how many unchanged lines the unified diff shows
+// around each change.
+const diffContextLines = 3
+
+// screenshotFormats are the outputs compared pixel by pixel, in order of
+// preference.
+var screenshotFormats = []string{formatScreenshot, formatJPEG, formatWebP}
+
+type diffFile struct {
+	format string
+	data   []byte
+}
+
+// DiffCaptures compares two completed captures: their screenshots pixel by
+// pixel and their text line by line. The results are stored as outputs of
+// the first capture, so asking again returns the same diff.
+func (h *Handler) DiffCaptures(c *gin.Context) {
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	var task, other models.CaptureTask
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), uid).First(&task).Error; err != nil {
+		errors.NotFound("Capture task not found").Respond(c)
+		return
+	}
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("otherId"), uid).First(&other).Error; err != nil {
+		errors.NotFound("Capture task to compare with not found").Respond(c)
+		return
+	}
+	if task.ID == other.ID {
+		errors.BadRequest("A capture cannot be compared with itself").Respond(c)
+		return
+	}
+	if task.Status != models.TaskStatusCompleted || other.Status != models.TaskStatusCompleted {
+		errors.Conflict("Only completed captures can be compared").Respond(c)
+		return
+	}
+
+	var existing models.CaptureDiff
+	if err := h.db.Where("task_id = ? AND other_task_id = ?", task.ID, other.ID).First(&existing).Error; err == nil {
+		c.JSON(http.StatusOK, h.diffResponse(&existing))
+		return
+	}
+
+	record, files, problem := h.compareCaptures(c.Request.Context(), &task, &other)
+	if problem != nil {
+		problem.Respond(c)
+		return
+	}
+
+	outputs := make([]models.CaptureOutput, 0, len(files))
+	for _, f := range files {
+		output, err := h.saveDiffOutput(c.Request.Context(), &task, &other, f)
+		if err != nil {
+			log.Error().Err(err).Str("format", f.format).Msg("Failed to save diff output")
+			h.deleteObjects(c.Request.Context(), outputs)
+			errors.InternalError("Failed to save diff").Respond(c)
+			return
+		}
+		output.DiffID = &record.ID
+		outputs = append(outputs, *output)
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		return tx.Create(&outputs).Error
+	})
+	if err != nil {
+		h.deleteObjects(c.Request.Context(), outputs)
+		// A concurrent request for the same pair may have won the race.
+		if h.db.Where("task_id = ? AND other_task_id = ?", task.ID, other.ID).First(&existing).Error == nil {
+			c.JSON(http.StatusOK, h.diffResponse(&existing))
+			return
+		}
+		errors.InternalError("Failed to save diff").Respond(c)
+		return
+	}
+
+	c.JSON(http.StatusCreated, h.diffResponse(record))
+}
+
+// compareCaptures computes whichever of the image and text diffs the two
+// captures have outputs for. It fails only when neither can be computed.
+func (h *Handler) compareCaptures(ctx context.Context, task, other *models.CaptureTask) (*models.CaptureDiff, []diffFile, *errors.ProblemDetail) {
+	record := &models.CaptureDiff{TaskID: task.ID, OtherTaskID: other.ID}
+	problems := map[string]string{}
+	var files []diffFile
+
+	if file, ratio, err := h.diffScreenshots(ctx, task.ID, other.ID); err != nil {
+		problems["image"] = err.Error()
+	} else {
+		record.ImageRatio = &ratio
+		files = append(files, *file)
+	}
+
+	if a, b, err := h.loadTexts(ctx, task, other); err != nil {
+		problems["text"] = err.Error()
+	} else {
+		edits := diff.Lines(a, b)
+		stats := diff.Summarize(edits)
+		ratio := roundRatio(stats.Ratio)
+		record.TextRatio = &ratio
+		record.TextAdded, record.TextRemoved = stats.Inserted, stats.Deleted
+		files = append(files,
+			diffFile{formatDiffHTML, diff.HTML(fmt.Sprintf("%s compared with %s", task.URL, other.URL), edits)},
+			diffFile{formatDiffText, diff.Unified(diffLabel(task), diffLabel(other), edits, diffContextLines)},
+		)
+	}
+
+	if len(files) == 0 {
+		reasons := make([]string, 0, len(problems))
+		for _, kind := range []string{"image", "text"} {
+			reasons = append(reasons, kind+": "+problems[kind])
+		}
+		return nil, nil, errors.NewProblemDetail(http.StatusUnprocessableEntity, "Unprocessable Entity",
+			"The captures have nothing to compare ("+strings.Join(reasons, "; ")+")")
+	}
+	if len(problems) > 0 {
+		raw, _ := json.Marshal(problems)
+		record.Errors = string(raw)
+	}
+	return record, files, nil
+}
+
+// diffLabel names a capture in unified diff headers: its URL and when it
+// was taken, as diff(1) labels files.
+func diffLabel(task *models.CaptureTask) string {
+	if task.CompletedAt == nil {
+		return task.URL
+	}
+	return task.URL + "\t" + task.CompletedAt.UTC().Format(time.RFC3339)
+}
+
+// diffScreenshots draws the pixel diff of the captures' screenshots and
+// returns it with the share of pixels that differ. Comparisons wait for one
+// of imageDiffSlots, which bounds the memory they use together.
+func (h *Handler) diffScreenshots(ctx context.Context, taskID, otherID uuid.UUID) (*diffFile, float64, error) {
+	select {
+	case imageDiffSlots <- struct{}{}:
+		defer func() { <-imageDiffSlots }()
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+
+	a, b, err := h.loadScreenshots(ctx, taskID, otherID)
+	if err != nil {
+		return nil, 0, err
+	}
+	img, ratio := diff.Pixels(a, b)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, 0, fmt.Errorf("failed to encode diff: %w", err)
+	}
+	return &diffFile{formatDiffImage, buf.Bytes()}, roundRatio(ratio), nil
+}
+
+// loadScreenshots decodes a screenshot of each capture, preferring a format
+// both have.
+func (h *Handler) loadScreenshots(ctx context.Context, taskID, otherID uuid.UUID) (image.Image, image.Image, error) {
+	a, b := h.diffInputs(taskID, screenshotFormats...), h.diffInputs(otherID, screenshotFormats...)
+	if len(a) == 0 || len(b) == 0 {
+		return nil, nil, fmt.Errorf("both captures need a screenshot")
+	}
+
+	oa, ob := a[screenshotFormats[0]], b[screenshotFormats[0]]
+	for _, format := range screenshotFormats {
+		if a[format] != nil && b[format] != nil {
+			oa, ob = a[format], b[format]
+			break
+		}
+	}
+	for _, format := range screenshotFormats {
+		if oa == nil {
+			oa = a[format]
+		}
+		if ob == nil {
+			ob = b[format]
+		}
+	}
+
+	imgA, err := h.decodeScreenshot(ctx, oa)
+	if err != nil {
+		return nil, nil, err
+	}
+	imgB, err := h.decodeScreenshot(ctx, ob)
+	if err != nil {
+		return nil, nil, err
+	}
+	return imgA, imgB, nil
+}
+
+func (h *Handler) decodeScreenshot(ctx context.Context, output *models.CaptureOutput) (image.Image, error) {
+	data, err := h.readOutput(ctx, output)
+	if err != nil {
+		return nil, err
+	}
+	// The header tells the size before the pixels are decoded.
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", output.Format, err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxDiffPixels {
+		return nil, fmt.Errorf("%s of %dx%d pixels is too large to compare, max %d pixels",
+			output.Format, config.Width, config.Height, maxDiffPixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", output.Format, err)
+	}
+	return img, nil
+}
+
+// loadTexts returns the normalized text of each capture, from the first
+// source both have: text outputs, change detection snapshots, or the
+// article extracted from HTML outputs.
+func (h *Handler) loadTexts(ctx context.Context, task, other *models.CaptureTask) ([]string, []string, error) {
+	a, b := h.diffInputs(task.ID, formatText), h.diffInputs(other.ID, formatText)
+	if a[formatText] != nil && b[formatText] != nil {
+		textA, err := h.readOutput(ctx, a[formatText])
+		if err != nil {
+			return nil, nil, err
+		}
+		textB, err := h.readOutput(ctx, b[formatText])
+		if err != nil {
+			return nil, nil, err
+		}
+		return diff.NormalizeText(string(textA)), diff.NormalizeText(string(textB)), nil
+	}
+
+	var snapshotA, snapshotB models.CaptureSnapshot
+	if h.db.Where("task_id = ?", task.ID).First(&snapshotA).Error == nil &&
+		h.db.Where("task_id = ?", other.ID).First(&snapshotB).Error == nil {
+		return splitSnapshot(snapshotA.Text), splitSnapshot(snapshotB.Text), nil
+	}
+
+	a, b = h.diffInputs(task.ID, formatHTML), h.diffInputs(other.ID, formatHTML)
+	if a[formatHTML] != nil && b[formatHTML] != nil {
+		textA, err := h.articleText(ctx, task, a[formatHTML])
+		if err != nil {
+			return nil, nil, err
+		}
+		textB, err := h.articleText(ctx, other, b[formatHTML])
+		if err != nil {
+			return nil, nil, err
+		}
+		return diff.NormalizeText(textA), diff.NormalizeText(textB), nil
+	}
+
+	return nil, nil, fmt.Errorf("both captures need a text or HTML output")
+}
+
+// splitSnapshot splits snapshot text, which is stored normalized.
+func splitSnapshot(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+func (h *Handler) articleText(ctx context.Context, task *models.CaptureTask, output *models.CaptureOutput) (string, error) {
+	data, err := h.readOutput(ctx, output)
+	if err != nil {
+		return "", err
+	}
+	pageURL := task.FinalURL
+	if pageURL == "" {
+		pageURL = task.URL
+	}
+	article, err := capture.ExtractArticle(data, pageURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract text from html: %w", err)
+	}
+	return article.Text(), nil
+}
+
+// diffInputs returns a task's own outputs in the given formats, by format.
+func (h *Handler) diffInputs(taskID uuid.UUID, formats ...string) map[string]*models.CaptureOutput {
+	var outputs []models.CaptureOutput
+	h.db.Where("task_id = ? AND diff_id IS NULL AND format IN ?", taskID, formats).Find(&outputs)
+
+	byFormat := make(map[string]*models.CaptureOutput, len(outputs))
+	for i := range outputs {
+		byFormat[outputs[i].Format] = &outputs[i]
+	}
+	return byFormat
+}
+
+func (h *Handler) readOutput(ctx context.Context, output *models.CaptureOutput) ([]byte, error) {
+	reader, _, err := h.storage.Download(ctx, output.ObjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", output.Format, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxDiffInputSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", output.Format, err)
+	}
+	if len(data) > maxDiffInputSize {
+		return nil, fmt.Errorf("%s is too large to compare", output.Format)
+	}
+	return data, nil
+}
+
+// saveDiffOutput uploads a diff file next to the first capture's outputs.
+func (h *Handler) saveDiffOutput(ctx context.Context, task, other *models.CaptureTask, f diffFile) (*models.CaptureOutput, error) {
+	now := time.Now().UTC()
+	// Path: captures/2025/12/01/20251201123022123456_uuid_diff_image_otheruuid.png (UTC)
+	timestamp := now.Format("20060102150405") + fmt.Sprintf("%06d", now.Nanosecond()/1000)
+	objectKey := fmt.Sprintf("captures/%s/%s_%s_%s_%s%s",
+		now.Format("2006/01/02"),
+		timestamp,
+		task.ID.String(),
+		f.format,
+		other.ID.String(),
+		getExtension(f.format))
+
+	contentType := getContentType(f.format)
+	info, err := h.storage.Upload(ctx, objectKey, bytes.NewReader(f.data), contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(f.data)
+	return &models.CaptureOutput{
+		TaskID:         task.ID,
+		Format:         f.format,
+		StorageBackend: h.cfg.Storage.Backend,
+		ObjectKey:      info.Key,
+		ContentType:    contentType,
+		SizeBytes:      info.Size,
+		SHA256:         hex.EncodeToString(hash[:]),
+	}, nil
+}
+
+func (h *Handler) deleteObjects(ctx context.Context, outputs []models.CaptureOutput) {
+	for i := range outputs {
+		if err := h.storage.Delete(ctx, outputs[i].ObjectKey); err != nil {
+			log.Warn().Err(err).Str("key", outputs[i].ObjectKey).Msg("Failed to delete diff output")
+		}
+	}
+}
+
+func (h *Handler) diffResponse(record *models.CaptureDiff) gin.H {
+	var outputs []models.CaptureOutput
+	h.db.Where("diff_id = ?", record.ID).Find(&outputs)
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].Format < outputs[j].Format })
+
+	result := make([]gin.H, len(outputs))
+	for i := range outputs {
+		result[i] = gin.H{
+			"id":         outputs[i].ID,
+			"format":     outputs[i].Format,
+			"size":       outputs[i].SizeBytes,
+			"created_at": outputs[i].CreatedAt,
+		}
+	}
+
+	var imageDiff, textDiff gin.H
+	if record.ImageRatio != nil {
+		imageDiff = gin.H{"ratio": *record.ImageRatio}
+	}
+	if record.TextRatio != nil {
+		textDiff = gin.H{
+			"ratio":   *record.TextRatio,
+			"added":   record.TextAdded,
+			"removed": record.TextRemoved,
+		}
+	}
+
+	return gin.H{
+		"id":            record.ID,
+		"task_id":       record.TaskID,
+		"other_task_id": record.OtherTaskID,
+		"image":         imageDiff,
+		"text":          textDiff,
+		"errors":        storedJSON(record.Errors),
+		"outputs":       result,
+		"created_at":    record.CreatedAt,
+	}
+}
+
+// roundRatio keeps four decimal places, enough to tell a one-line change
+// in a long page from none.
+func roundRatio(r float64) float64 {
+	return math.Round(r*10000) / 10000
+}
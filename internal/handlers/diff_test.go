@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"pagemail/internal/models"
+)
+
+func TestDiffCaptures(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+	stranger := models.User{Email: "other@example.com", PasswordHash: "hash"}
+	h.db.Create(&stranger)
+
+	// newCapture stores a completed capture with the given outputs.
+	newCapture := func(t *testing.T, owner models.User, status string, files map[string][]byte) models.CaptureTask {
+		t.Helper()
+		task := models.CaptureTask{UserID: owner.ID, URL: "https://example.com/pricing", Status: status}
+		h.db.Create(&task)
+		for format, data := range files {
+			key := task.ID.String() + "/" + format
+			if _, err := h.storage.Upload(context.Background(), key, bytes.NewReader(data), getContentType(format)); err != nil {
+				t.Fatalf("Failed to upload %s: %v", format, err)
+			}
+			h.db.Create(&models.CaptureOutput{TaskID: task.ID, Format: format, StorageBackend: "local", ObjectKey: key, ContentType: getContentType(format)})
+		}
+		return task
+	}
+	screenshot := func(t *testing.T, banner int) []byte {
+		t.Helper()
+		img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(img, image.Rect(0, 0, 100, banner), image.NewUniform(color.Black), image.Point{}, draw.Src)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatalf("Failed to encode screenshot: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	before := newCapture(t, user, models.TaskStatusCompleted, map[string][]byte{
+		"screenshot": screenshot(t, 10),
+		"text":       []byte("Pricing\n\nStarter $9\nPro $49\n"),
+	})
+	after := newCapture(t, user, models.TaskStatusCompleted, map[string][]byte{
+		"screenshot": screenshot(t, 20),
+		"text":       []byte("Pricing\nStarter $9\nPro $59\n"),
+	})
+	textOnly := newCapture(t, user, models.TaskStatusCompleted, map[string][]byte{
+		"text": []byte("Pricing\n"),
+	})
+	// Only the header is read from a screenshot too large to compare.
+	huge := newCapture(t, user, models.TaskStatusCompleted, map[string][]byte{
+		"screenshot": pngHeader(1920, 20000),
+		"text":       []byte("Pricing\n"),
+	})
+	pdfOnly := newCapture(t, user, models.TaskStatusCompleted, map[string][]byte{"pdf": []byte("%PDF")})
+	pending := newCapture(t, user, models.TaskStatusPending, nil)
+	foreign := newCapture(t, stranger, models.TaskStatusCompleted, map[string][]byte{"text": []byte("Pricing\n")})
+
+	r.GET("/captures/:id/diff/:otherId", func(c *gin.Context) {
+		c.Set("user_id", user.ID.String())
+		h.DiffCaptures(c)
+	})
+
+	type diffResponse struct {
+		ID    string `json:"id"`
+		Image *struct {
+			Ratio float64 `json:"ratio"`
+		} `json:"image"`
+		Text *struct {
+			Ratio   float64 `json:"ratio"`
+			Added   int     `json:"added"`
+			Removed int     `json:"removed"`
+		} `json:"text"`
+		Errors  map[string]string `json:"errors"`
+		Outputs []struct {
+			ID     string `json:"id"`
+			Format string `json:"format"`
+		} `json:"outputs"`
+	}
+	get := func(t *testing.T, id, otherID string, wantStatus int) diffResponse {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/captures/"+id+"/diff/"+otherID, http.NoBody)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != wantStatus {
+			t.Fatalf("DiffCaptures() status = %d, want %d, body = %s", w.Code, wantStatus, w.Body.String())
+		}
+		var resp diffResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	t.Run("image and text", func(t *testing.T) {
+		resp := get(t, before.ID.String(), after.ID.String(), http.StatusCreated)
+		if resp.Image == nil || resp.Image.Ratio != 0.1 {
+			t.Errorf("DiffCaptures() image = %+v, want ratio 0.1", resp.Image)
+		}
+		if resp.Text == nil || resp.Text.Added != 1 || resp.Text.Removed != 1 {
+			t.Errorf("DiffCaptures() text = %+v, want one line added and removed", resp.Text)
+		}
+		var formats []string
+		for _, o := range resp.Outputs {
+			formats = append(formats, o.Format)
+		}
+		if strings.Join(formats, ",") != "diff_html,diff_image,diff_text" {
+			t.Errorf("DiffCaptures() outputs = %v", formats)
+		}
+
+		var output models.CaptureOutput
+		h.db.Where("diff_id = ? AND format = ?", resp.ID, "diff_text").First(&output)
+		reader, _, err := h.storage.Download(context.Background(), output.ObjectKey)
+		if err != nil {
+			t.Fatalf("Failed to download unified diff: %v", err)
+		}
+		defer reader.Close()
+		var unified bytes.Buffer
+		_, _ = unified.ReadFrom(reader)
+		if !strings.Contains(unified.String(), "-Pro $49\n+Pro $59\n") {
+			t.Errorf("Unified diff = %q, want the price change", unified.String())
+		}
+
+		// Asking again returns the stored diff.
+		again := get(t, before.ID.String(), after.ID.String(), http.StatusOK)
+		if again.ID != resp.ID {
+			t.Errorf("DiffCaptures() again id = %s, want %s", again.ID, resp.ID)
+		}
+	})
+
+	t.Run("text only", func(t *testing.T) {
+		resp := get(t, before.ID.String(), textOnly.ID.String(), http.StatusCreated)
+		if resp.Image != nil || resp.Text == nil || resp.Errors["image"] == "" {
+			t.Errorf("DiffCaptures() = %+v, want a text diff and an image error", resp)
+		}
+	})
+
+	t.Run("screenshot too large", func(t *testing.T) {
+		resp := get(t, before.ID.String(), huge.ID.String(), http.StatusCreated)
+		if resp.Image != nil || resp.Text == nil || !strings.Contains(resp.Errors["image"], "too large") {
+			t.Errorf("DiffCaptures() = %+v, want a text diff and a size error", resp)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		id         string
+		otherID    string
+		wantStatus int
+	}{
+		{"same capture", before.ID.String(), before.ID.String(), http.StatusBadRequest},
+		{"not completed", before.ID.String(), pending.ID.String(), http.StatusConflict},
+		{"another user's capture", before.ID.String(), foreign.ID.String(), http.StatusNotFound},
+		{"unknown capture", "00000000-0000-0000-0000-000000000000", before.ID.String(), http.StatusNotFound},
+		{"nothing to compare", before.ID.String(), pdfOnly.ID.String(), http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			get(t, tt.id, tt.otherID, tt.wantStatus)
+		})
+	}
+
+	// Derived outputs are not the capture's own and are never delivered.
+	var own int64
+	h.db.Model(&models.CaptureOutput{}).Where("task_id = ? AND diff_id IS NULL", before.ID).Count(&own)
+	if own != 2 {
+		t.Errorf("Capture has %d own outputs, want 2", own)
+	}
+}
+
+// pngHeader returns the start of a PNG of the given size: enough for
+// image.DecodeConfig, which never reads the pixels.
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8], ihdr[9] = 8, 2 // 8-bit RGB
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	_ = binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
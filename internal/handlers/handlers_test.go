@@ -27,8 +27,14 @@ func setupTestDB(t *testing.T) *gorm.DB {
 		&models.User{},
 		&models.SMTPProfile{},
 		&models.WebhookEndpoint{},
+		&models.Snippet{},
+		&models.LoginRecipe{},
 		&models.CaptureTask{},
 		&models.CaptureOutput{},
+		&models.CaptureSchedule{},
+		&models.CaptureBatch{},
+		&models.CaptureSnapshot{},
+		&models.CaptureDiff{},
 		&models.Delivery{},
 		&models.Job{},
 		&models.AuditLog{},
@@ -308,3 +314,59 @@ func TestParsePagination(t *testing.T) {
 		})
 	}
 }
+
+func TestDeliverCapture(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+	webhook := models.WebhookEndpoint{UserID: user.ID, Name: "hook", URL: "https://hooks.example.com", IsActive: true}
+	h.db.Create(&webhook)
+
+	completed := models.CaptureTask{UserID: user.ID, URL: "https://example.com", Status: models.TaskStatusCompleted}
+	h.db.Create(&completed)
+	h.db.Create(&models.CaptureOutput{TaskID: completed.ID, Format: "pdf", StorageBackend: "local", ObjectKey: "a.pdf", ContentType: "application/pdf"})
+	pending := models.CaptureTask{UserID: user.ID, URL: "https://example.com", Status: models.TaskStatusPending}
+	h.db.Create(&pending)
+
+	r.POST("/captures/:id/deliver", func(c *gin.Context) {
+		c.Set("user_id", user.ID.String())
+		h.DeliverCapture(c)
+	})
+
+	tests := []struct {
+		name       string
+		taskID     string
+		body       map[string]interface{}
+		wantStatus int
+	}{
+		{"redeliver via webhook", completed.ID.String(), map[string]interface{}{"type": "webhook", "id": webhook.ID.String()}, http.StatusAccepted},
+		{"format subset", completed.ID.String(), map[string]interface{}{"type": "webhook", "id": webhook.ID.String(), "formats": []string{"pdf"}}, http.StatusAccepted},
+		{"missing format output", completed.ID.String(), map[string]interface{}{"type": "webhook", "id": webhook.ID.String(), "formats": []string{"html"}}, http.StatusBadRequest},
+		{"unknown webhook", completed.ID.String(), map[string]interface{}{"type": "webhook", "id": "00000000-0000-0000-0000-000000000000"}, http.StatusBadRequest},
+		{"task not completed", pending.ID.String(), map[string]interface{}{"type": "webhook", "id": webhook.ID.String()}, http.StatusConflict},
+		{"unknown task", "00000000-0000-0000-0000-000000000000", map[string]interface{}{"type": "webhook", "id": webhook.ID.String()}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.body)
+			req := httptest.NewRequest(http.MethodPost, "/captures/"+tt.taskID+"/deliver", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("DeliverCapture() status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+
+	var deliveries, jobs, audits int64
+	h.db.Model(&models.Delivery{}).Where("task_id = ?", completed.ID).Count(&deliveries)
+	h.db.Model(&models.Job{}).Where("type = ?", models.JobTypeDeliver).Count(&jobs)
+	h.db.Model(&models.AuditLog{}).Where("action = ?", audit.ActionDeliveryCreate).Count(&audits)
+	if deliveries != 2 || jobs != 2 || audits != 2 {
+		t.Errorf("Got %d deliveries, %d jobs, %d audit entries, want 2 each", deliveries, jobs, audits)
+	}
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"pagemail/internal/audit"
+	"pagemail/internal/capture"
+	"pagemail/internal/models"
+	"pagemail/internal/pkg/crypto"
+	"pagemail/internal/pkg/errors"
+)
+
+type CreateLoginRecipeRequest struct {
+	Name     string              `json:"name" binding:"required,max=100"`
+	LoginURL string              `json:"login_url" binding:"required,url"`
+	Steps    []capture.LoginStep `json:"steps" binding:"required"`
+	// Credentials maps names used by fill steps to their secret values.
+	// On update, omitting it keeps the stored credentials.
+	Credentials map[string]string `json:"credentials"`
+}
+
+func loginRecipeResponse(recipe *models.LoginRecipe, credentialNames []string) gin.H {
+	return gin.H{
+		"id":          recipe.ID,
+		"name":        recipe.Name,
+		"login_url":   recipe.LoginURL,
+		"steps":       storedJSON(recipe.Steps),
+		"credentials": credentialNames,
+		"created_at":  recipe.CreatedAt,
+		"updated_at":  recipe.UpdatedAt,
+	}
+}
+
+// credentialNames lists the stored credential names without their values.
+func (h *Handler) credentialNames(recipe *models.LoginRecipe) []string {
+	credentials, err := h.decryptCredentials(recipe)
+	if err != nil {
+		return []string{}
+	}
+	names := make([]string, 0, len(credentials))
+	for name := range credentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (h *Handler) decryptCredentials(recipe *models.LoginRecipe) (map[string]string, error) {
+	credentials := map[string]string{}
+	if len(recipe.CredentialsEnc) == 0 {
+		return credentials, nil
+	}
+	encryptor, err := crypto.NewEncryptor(h.cfg.Encryption.Key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := encryptor.Decrypt(recipe.CredentialsEnc)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(plaintext, &credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// applyLoginRecipe validates req against the credentials it will use and
+// copies it onto recipe.
+func (h *Handler) applyLoginRecipe(recipe *models.LoginRecipe, req *CreateLoginRecipeRequest) *errors.ProblemDetail {
+	credentials := req.Credentials
+	if credentials == nil {
+		var err error
+		if credentials, err = h.decryptCredentials(recipe); err != nil {
+			return errors.InternalError("Failed to decrypt credentials")
+		}
+	}
+
+	if err := capture.ValidateLoginSteps(req.Steps, credentials); err != nil {
+		return errors.BadRequest("Invalid login steps: " + err.Error())
+	}
+
+	steps, _ := json.Marshal(req.Steps)
+	recipe.Name = req.Name
+	recipe.LoginURL = req.LoginURL
+	recipe.Steps = string(steps)
+
+	if req.Credentials != nil {
+		encrypted, err := h.encryptJSON(req.Credentials)
+		if err != nil {
+			return errors.InternalError("Failed to encrypt credentials")
+		}
+		recipe.CredentialsEnc = encrypted
+	}
+	return nil
+}
+
+func (h *Handler) ListLoginRecipes(c *gin.Context) {
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	var recipes []models.LoginRecipe
+	if err := h.db.Where("user_id = ?", uid).Order("name").Find(&recipes).Error; err != nil {
+		errors.InternalError("Failed to fetch login recipes").Respond(c)
+		return
+	}
+
+	result := make([]gin.H, len(recipes))
+	for i := range recipes {
+		result[i] = loginRecipeResponse(&recipes[i], h.credentialNames(&recipes[i]))
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *Handler) CreateLoginRecipe(c *gin.Context) {
+	var req CreateLoginRecipeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errors.BadRequest(err.Error()).Respond(c)
+		return
+	}
+
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	if h.loginRecipeNameTaken(uid, req.Name, uuid.Nil) {
+		errors.Conflict("A login recipe with this name already exists").Respond(c)
+		return
+	}
+
+	recipe := models.LoginRecipe{UserID: uid}
+	if problem := h.applyLoginRecipe(&recipe, &req); problem != nil {
+		problem.Respond(c)
+		return
+	}
+
+	if err := h.db.Create(&recipe).Error; err != nil {
+		errors.InternalError("Failed to create login recipe").Respond(c)
+		return
+	}
+
+	h.logAudit(c, audit.ActionLoginCreate, "login_recipe", &recipe.ID, audit.ResourceDetails{
+		Name: recipe.Name, URL: recipe.LoginURL,
+	})
+
+	c.JSON(http.StatusCreated, loginRecipeResponse(&recipe, h.credentialNames(&recipe)))
+}
+
+func (h *Handler) UpdateLoginRecipe(c *gin.Context) {
+	recipeID := c.Param("id")
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	var recipe models.LoginRecipe
+	if err := h.db.Where("id = ? AND user_id = ?", recipeID, uid).First(&recipe).Error; err != nil {
+		errors.NotFound("Login recipe not found").Respond(c)
+		return
+	}
+
+	var req CreateLoginRecipeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errors.BadRequest(err.Error()).Respond(c)
+		return
+	}
+
+	if h.loginRecipeNameTaken(uid, req.Name, recipe.ID) {
+		errors.Conflict("A login recipe with this name already exists").Respond(c)
+		return
+	}
+
+	if problem := h.applyLoginRecipe(&recipe, &req); problem != nil {
+		problem.Respond(c)
+		return
+	}
+
+	if err := h.db.Save(&recipe).Error; err != nil {
+		errors.InternalError("Failed to update login recipe").Respond(c)
+		return
+	}
+
+	h.logAudit(c, audit.ActionLoginUpdate, "login_recipe", &recipe.ID, audit.ResourceDetails{
+		Name: recipe.Name, URL: recipe.LoginURL,
+	})
+
+	c.JSON(http.StatusOK, loginRecipeResponse(&recipe, h.credentialNames(&recipe)))
+}
+
+func (h *Handler) DeleteLoginRecipe(c *gin.Context) {
+	recipeID := c.Param("id")
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	var recipe models.LoginRecipe
+	if err := h.db.Where("id = ? AND user_id = ?", recipeID, uid).First(&recipe).Error; err != nil {
+		errors.NotFound("Login recipe not found").Respond(c)
+		return
+	}
+
+	if err := h.db.Delete(&recipe).Error; err != nil {
+		errors.InternalError("Failed to delete login recipe").Respond(c)
+		return
+	}
+
+	h.logAudit(c, audit.ActionLoginDelete, "login_recipe", &recipe.ID, audit.ResourceDetails{Name: recipe.Name})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Login recipe deleted"})
+}
+
+func (h *Handler) loginRecipeNameTaken(uid uuid.UUID, name string, except uuid.UUID) bool {
+	var count int64
+	h.db.Model(&models.LoginRecipe{}).
+		Where("user_id = ? AND name = ? AND id <> ?", uid, name, except).
+		Count(&count)
+	return count > 0
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"pagemail/internal/models"
+)
+
+func TestLoginRecipeCRUD(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+
+	withUser := func(handler gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set("user_id", user.ID.String())
+			handler(c)
+		}
+	}
+	r.POST("/login-recipes", withUser(h.CreateLoginRecipe))
+	r.PUT("/login-recipes/:id", withUser(h.UpdateLoginRecipe))
+	r.GET("/login-recipes", withUser(h.ListLoginRecipes))
+
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			_ = json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	steps := []map[string]string{
+		{"action": "fill", "selector": "#user", "credential": "username"},
+		{"action": "fill", "selector": "#pass", "credential": "password"},
+		{"action": "click", "selector": "button[type=submit]"},
+		{"action": "wait", "selector": "#dashboard"},
+	}
+
+	tests := []struct {
+		name       string
+		body       map[string]interface{}
+		wantStatus int
+	}{
+		{"valid", map[string]interface{}{
+			"name": "dashboard", "login_url": "https://example.com/login", "steps": steps,
+			"credentials": map[string]string{"username": "alice", "password": "hunter2"},
+		}, http.StatusCreated},
+		{"missing credential", map[string]interface{}{
+			"name": "other", "login_url": "https://example.com/login", "steps": steps,
+			"credentials": map[string]string{"username": "alice"},
+		}, http.StatusBadRequest},
+		{"duplicate name", map[string]interface{}{
+			"name": "dashboard", "login_url": "https://example.com/login", "steps": steps,
+		}, http.StatusConflict},
+	}
+
+	var id string
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := send(http.MethodPost, "/login-recipes", tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("CreateLoginRecipe() status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code == http.StatusCreated {
+				if strings.Contains(w.Body.String(), "hunter2") {
+					t.Error("response leaks a credential value")
+				}
+				var resp map[string]interface{}
+				_ = json.Unmarshal(w.Body.Bytes(), &resp)
+				id, _ = resp["id"].(string)
+			}
+		})
+	}
+
+	var recipe models.LoginRecipe
+	h.db.First(&recipe, "id = ?", id)
+	if len(recipe.CredentialsEnc) == 0 || bytes.Contains(recipe.CredentialsEnc, []byte("hunter2")) {
+		t.Error("credentials should be stored encrypted")
+	}
+
+	// Updating without credentials keeps the stored ones.
+	w := send(http.MethodPut, "/login-recipes/"+id, map[string]interface{}{
+		"name": "dashboard", "login_url": "https://example.com/signin", "steps": steps,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("UpdateLoginRecipe() status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var resp map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if names, _ := resp["credentials"].([]interface{}); len(names) != 2 {
+		t.Errorf("credentials after update = %v, want both names", resp["credentials"])
+	}
+}
+
+func TestCreateCaptureAuth(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+	other := models.User{Email: "other@example.com", PasswordHash: "hash"}
+	h.db.Create(&other)
+
+	recipe := models.LoginRecipe{UserID: user.ID, Name: "mine", LoginURL: "https://example.com/login", Steps: "[]"}
+	h.db.Create(&recipe)
+	foreign := models.LoginRecipe{UserID: other.ID, Name: "theirs", LoginURL: "https://example.com/login", Steps: "[]"}
+	h.db.Create(&foreign)
+
+	r.POST("/captures", func(c *gin.Context) {
+		c.Set("user_id", user.ID.String())
+		h.CreateCapture(c)
+	})
+
+	tests := []struct {
+		name       string
+		extra      map[string]interface{}
+		wantStatus int
+	}{
+		{"recipe and headers", map[string]interface{}{
+			"login_recipe_id": recipe.ID,
+			"basic_auth":      map[string]string{"username": "alice", "password": "secret"},
+			"headers":         map[string]string{"X-Team": "archive"},
+		}, http.StatusCreated},
+		{"other user's recipe", map[string]interface{}{"login_recipe_id": foreign.ID}, http.StatusBadRequest},
+		{"basic auth without user", map[string]interface{}{"basic_auth": map[string]string{"password": "x"}}, http.StatusBadRequest},
+		{"forbidden header", map[string]interface{}{"headers": map[string]string{"Host": "internal"}}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]interface{}{"url": "https://example.com", "formats": []string{"pdf"}}
+			for k, v := range tt.extra {
+				body[k] = v
+			}
+			data, _ := json.Marshal(body)
+			req := httptest.NewRequest(http.MethodPost, "/captures", bytes.NewReader(data))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("CreateCapture() status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+
+	var task models.CaptureTask
+	if err := h.db.Where("user_id = ?", user.ID).First(&task).Error; err != nil {
+		t.Fatalf("Failed to load task: %v", err)
+	}
+	if task.LoginRecipeID == nil || *task.LoginRecipeID != recipe.ID {
+		t.Errorf("Task login recipe = %v, want %s", task.LoginRecipeID, recipe.ID)
+	}
+	if len(task.BasicAuthEnc) == 0 || bytes.Contains(task.BasicAuthEnc, []byte("secret")) {
+		t.Error("basic auth should be stored encrypted")
+	}
+	if len(task.HeadersEnc) == 0 || bytes.Contains(task.HeadersEnc, []byte("archive")) {
+		t.Error("headers should be stored encrypted")
+	}
+}
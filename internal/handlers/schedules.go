@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"pagemail/internal/audit"
+	"pagemail/internal/models"
+	"pagemail/internal/pkg/cron"
+	"pagemail/internal/pkg/errors"
+	"pagemail/internal/queue"
+)
+
+// ScheduleRequest describes a recurring capture: the capture itself, as
+// accepted by CreateCapture, and when to run it.
+type ScheduleRequest struct {
+	Name string `json:"name" binding:"max=100"`
+	// Cron is a five-field cron expression or a shorthand such as @daily.
+	Cron string `json:"cron" binding:"required,max=100"`
+	// Timezone is an IANA name the expression is read in; empty means UTC.
+	Timezone string `json:"timezone" binding:"max=64"`
+	IsActive *bool  `json:"is_active"`
+	CreateCaptureRequest
+}
+
+func (h *Handler) scheduleResponse(schedule *models.CaptureSchedule) gin.H {
+	var template models.CaptureTask
+	_ = json.Unmarshal([]byte(schedule.TaskTemplate), &template)
+
+	var delivery gin.H
+	if schedule.DeliveryChannel != "" {
+		var target queue.DeliveryTarget
+		_ = json.Unmarshal([]byte(schedule.DeliveryTarget), &target)
+		delivery = gin.H{
+			"type":       schedule.DeliveryChannel,
+			"id":         target.ID,
+			"recipients": target.Recipients,
+			"formats":    target.Formats,
+		}
+	}
+
+	return gin.H{
+		"id":           schedule.ID,
+		"name":         schedule.Name,
+		"url":          schedule.URL,
+		"formats":      intToFormats(schedule.Formats),
+		"cron":         schedule.CronExpr,
+		"timezone":     schedule.Timezone,
+		"is_active":    schedule.IsActive,
+		"next_run_at":  schedule.NextRunAt,
+		"last_run_at":  schedule.LastRunAt,
+		"last_task_id": schedule.LastTaskID,
+		"runs":         schedule.Runs,
+		"wait": gin.H{
+			"until":      template.WaitUntil,
+			"selector":   template.WaitSelector,
+			"expression": template.WaitExpression,
+			"idle_ms":    template.WaitIdleMs,
+			"delay_ms":   template.WaitDelayMs,
+			"timeout_ms": template.WaitTimeoutMs,
+		},
+		"pdf":               storedJSON(template.PDFOptions),
+		"screenshot":        storedJSON(template.ScreenshotOptions),
+		"emulation":         storedJSON(template.EmulationOptions),
+		"cookies":           len(schedule.CookiesEnc) > 0,
+		"login_recipe_id":   schedule.LoginRecipeID,
+		"basic_auth":        len(schedule.BasicAuthEnc) > 0,
+		"custom_headers":    len(schedule.HeadersEnc) > 0,
+		"block":             storedJSON(template.BlockingOptions),
+		"scroll":            storedJSON(template.ScrollOptions),
+		"monitor":           storedJSON(template.MonitorOptions),
+		"injections":        storedJSON(template.Injections),
+		"script_timeout_ms": template.ScriptTimeoutMs,
+		"delivery_config":   delivery,
+		"created_at":        schedule.CreatedAt,
+		"updated_at":        schedule.UpdatedAt,
+	}
+}
+
+// applySchedule validates req and copies it onto schedule, computing the
+// next run. On update, omitted cookies, basic_auth and headers keep the
+// stored values, since they are never returned.
+func (h *Handler) applySchedule(uid uuid.UUID, schedule *models.CaptureSchedule, req *ScheduleRequest) *errors.ProblemDetail {
+	if _, err := cron.Parse(req.Cron); err != nil {
+		return errors.BadRequest("Invalid cron expression: " + err.Error())
+	}
+	timezone := req.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+		return errors.BadRequest("Unknown timezone: " + timezone)
+	}
+	next, err := queue.NextScheduleRun(req.Cron, timezone, time.Now())
+	if err != nil {
+		return errors.BadRequest("Invalid schedule: " + err.Error())
+	}
+	if next == nil {
+		return errors.BadRequest("Cron expression never fires")
+	}
+
+	task, delivery, problem := h.newCaptureTask(uid, &req.CreateCaptureRequest)
+	if problem != nil {
+		return problem
+	}
+	template, err := json.Marshal(task)
+	if err != nil {
+		return errors.InternalError("Failed to encode capture options")
+	}
+
+	schedule.Name = req.Name
+	schedule.URL = task.URL
+	schedule.Formats = task.Formats
+	schedule.CronExpr = req.Cron
+	schedule.Timezone = timezone
+	schedule.TaskTemplate = string(template)
+	schedule.LoginRecipeID = task.LoginRecipeID
+	if req.Cookies != "" || schedule.ID == uuid.Nil {
+		schedule.CookiesEnc = task.CookiesEnc
+	}
+	if req.BasicAuth != nil || schedule.ID == uuid.Nil {
+		schedule.BasicAuthEnc = task.BasicAuthEnc
+	}
+	if req.Headers != nil || schedule.ID == uuid.Nil {
+		schedule.HeadersEnc = task.HeadersEnc
+	}
+
+	schedule.DeliveryChannel, schedule.DeliveryTarget = "", ""
+	if delivery != nil {
+		schedule.DeliveryChannel = delivery.Channel
+		schedule.DeliveryTarget = delivery.TargetConfig
+	}
+
+	if req.IsActive != nil {
+		schedule.IsActive = *req.IsActive
+	}
+	schedule.NextRunAt = nil
+	if schedule.IsActive {
+		schedule.NextRunAt = next
+	}
+	return nil
+}
+
+func (h *Handler) ListSchedules(c *gin.Context) {
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+	page, limit := parsePagination(c)
+
+	var schedules []models.CaptureSchedule
+	var total int64
+
+	query := h.db.Model(&models.CaptureSchedule{}).Where("user_id = ?", uid)
+	query.Count(&total)
+	query.Order("created_at DESC").Offset((page - 1) * limit).Limit(limit).Find(&schedules)
+
+	result := make([]gin.H, len(schedules))
+	for i := range schedules {
+		result[i] = h.scheduleResponse(&schedules[i])
+	}
+
+	paginatedResponse(c, result, total, page, limit)
+}
+
+func (h *Handler) GetSchedule(c *gin.Context) {
+	scheduleID := c.Param("id")
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	var schedule models.CaptureSchedule
+	if err := h.db.Where("id = ? AND user_id = ?", scheduleID, uid).First(&schedule).Error; err != nil {
+		errors.NotFound("Schedule not found").Respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, h.scheduleResponse(&schedule))
+}
+
+func (h *Handler) CreateSchedule(c *gin.Context) {
+	var req ScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errors.BadRequest(err.Error()).Respond(c)
+		return
+	}
+
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	schedule := models.CaptureSchedule{UserID: uid, IsActive: true}
+	if problem := h.applySchedule(uid, &schedule, &req); problem != nil {
+		problem.Respond(c)
+		return
+	}
+
+	// GORM inserts the column default in place of a false value.
+	active := schedule.IsActive
+	if err := h.db.Create(&schedule).Error; err != nil {
+		errors.InternalError("Failed to create schedule").Respond(c)
+		return
+	}
+	if !active {
+		h.db.Model(&schedule).Update("is_active", false)
+	}
+
+	h.logAudit(c, audit.ActionScheduleCreate, "schedule", &schedule.ID, audit.ResourceDetails{
+		Name: schedule.Name, URL: schedule.URL, Formats: req.Formats, IsActive: audit.BoolPtr(schedule.IsActive),
+	})
+
+	c.JSON(http.StatusCreated, h.scheduleResponse(&schedule))
+}
+
+func (h *Handler) UpdateSchedule(c *gin.Context) {
+	scheduleID := c.Param("id")
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	var schedule models.CaptureSchedule
+	if err := h.db.Where("id = ? AND user_id = ?", scheduleID, uid).First(&schedule).Error; err != nil {
+		errors.NotFound("Schedule not found").Respond(c)
+		return
+	}
+
+	var req ScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errors.BadRequest(err.Error()).Respond(c)
+		return
+	}
+
+	if problem := h.applySchedule(uid, &schedule, &req); problem != nil {
+		problem.Respond(c)
+		return
+	}
+
+	// The run columns belong to the dispatcher, which may claim a run
+	// while the request is in flight.
+	if err := h.db.Omit("runs", "last_run_at", "last_task_id").Save(&schedule).Error; err != nil {
+		errors.InternalError("Failed to update schedule").Respond(c)
+		return
+	}
+	h.db.First(&schedule, "id = ?", schedule.ID)
+
+	h.logAudit(c, audit.ActionScheduleUpdate, "schedule", &schedule.ID, audit.ResourceDetails{
+		Name: schedule.Name, URL: schedule.URL, Formats: req.Formats, IsActive: audit.BoolPtr(schedule.IsActive),
+	})
+
+	c.JSON(http.StatusOK, h.scheduleResponse(&schedule))
+}
+
+func (h *Handler) DeleteSchedule(c *gin.Context) {
+	scheduleID := c.Param("id")
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	var schedule models.CaptureSchedule
+	if err := h.db.Where("id = ? AND user_id = ?", scheduleID, uid).First(&schedule).Error; err != nil {
+		errors.NotFound("Schedule not found").Respond(c)
+		return
+	}
+
+	// Captures already created by the schedule are kept.
+	if err := h.db.Delete(&schedule).Error; err != nil {
+		errors.InternalError("Failed to delete schedule").Respond(c)
+		return
+	}
+
+	h.logAudit(c, audit.ActionScheduleDelete, "schedule", &schedule.ID, audit.ResourceDetails{
+		Name: schedule.Name, URL: schedule.URL,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted"})
+}
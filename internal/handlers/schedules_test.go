@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pagemail/internal/models"
+)
+
+func TestScheduleCRUD(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+	other := models.User{Email: "other@example.com", PasswordHash: "hash"}
+	h.db.Create(&other)
+	webhook := models.WebhookEndpoint{UserID: user.ID, Name: "hook", URL: "https://hooks.example.com", IsActive: true}
+	h.db.Create(&webhook)
+
+	withUser := func(handler gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set("user_id", user.ID.String())
+			handler(c)
+		}
+	}
+	r.POST("/schedules", withUser(h.CreateSchedule))
+	r.GET("/schedules", withUser(h.ListSchedules))
+	r.GET("/schedules/:id", withUser(h.GetSchedule))
+	r.PUT("/schedules/:id", withUser(h.UpdateSchedule))
+	r.DELETE("/schedules/:id", withUser(h.DeleteSchedule))
+
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			_ = json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name       string
+		body       map[string]interface{}
+		wantStatus int
+	}{
+		{"valid", map[string]interface{}{
+			"name": "Monday dashboards", "url": "https://example.com/dashboard", "formats": []string{"pdf", "screenshot"},
+			"cron": "0 9 * * MON", "timezone": "Europe/Berlin",
+			"headers":         map[string]string{"X-Token": "secret-token"},
+			"wait":            map[string]interface{}{"until": "networkidle", "idle_ms": 800},
+			"delivery_config": map[string]interface{}{"type": "webhook", "id": webhook.ID.String()},
+		}, http.StatusCreated},
+		{"invalid cron", map[string]interface{}{
+			"url": "https://example.com", "formats": []string{"pdf"}, "cron": "0 25 * * *",
+		}, http.StatusBadRequest},
+		{"unknown timezone", map[string]interface{}{
+			"url": "https://example.com", "formats": []string{"pdf"}, "cron": "@daily", "timezone": "Mars/Olympus",
+		}, http.StatusBadRequest},
+		{"never fires", map[string]interface{}{
+			"url": "https://example.com", "formats": []string{"pdf"}, "cron": "0 0 30 2 *",
+		}, http.StatusBadRequest},
+		{"invalid capture options", map[string]interface{}{
+			"url": "https://example.com", "formats": []string{"gif"}, "cron": "@daily",
+		}, http.StatusBadRequest},
+		{"missing cron", map[string]interface{}{
+			"url": "https://example.com", "formats": []string{"pdf"},
+		}, http.StatusBadRequest},
+	}
+
+	var id string
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := send(http.MethodPost, "/schedules", tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("CreateSchedule() status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code == http.StatusCreated {
+				if strings.Contains(w.Body.String(), "secret-token") {
+					t.Error("response leaks a header value")
+				}
+				var resp map[string]interface{}
+				_ = json.Unmarshal(w.Body.Bytes(), &resp)
+				id, _ = resp["id"].(string)
+			}
+		})
+	}
+
+	var schedule models.CaptureSchedule
+	if err := h.db.First(&schedule, "id = ?", id).Error; err != nil {
+		t.Fatalf("schedule not saved: %v", err)
+	}
+	if schedule.NextRunAt == nil || !schedule.NextRunAt.After(time.Now()) {
+		t.Errorf("NextRunAt = %v, want a future time", schedule.NextRunAt)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	if next := schedule.NextRunAt.In(berlin); next.Weekday() != time.Monday || next.Hour() != 9 {
+		t.Errorf("NextRunAt = %v, want Monday 09:00 Berlin time", next)
+	}
+	if len(schedule.HeadersEnc) == 0 || bytes.Contains(schedule.HeadersEnc, []byte("secret-token")) ||
+		strings.Contains(schedule.TaskTemplate, "secret-token") {
+		t.Error("headers should be stored encrypted")
+	}
+	if schedule.DeliveryChannel != models.ChannelWebhook {
+		t.Errorf("DeliveryChannel = %q, want webhook", schedule.DeliveryChannel)
+	}
+
+	w := send(http.MethodGet, "/schedules/"+id, nil)
+	var got struct {
+		Formats       []string `json:"formats"`
+		CustomHeaders bool     `json:"custom_headers"`
+		Wait          struct {
+			Until  string `json:"until"`
+			IdleMs int    `json:"idle_ms"`
+		} `json:"wait"`
+		Delivery struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+		} `json:"delivery_config"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GetSchedule() status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if len(got.Formats) != 2 || !got.CustomHeaders || got.Wait.Until != "networkidle" || got.Wait.IdleMs != 800 ||
+		got.Delivery.Type != "webhook" || got.Delivery.ID != webhook.ID.String() {
+		t.Errorf("GetSchedule() = %+v", got)
+	}
+
+	// Pausing without resending headers keeps them and clears the next run.
+	w = send(http.MethodPut, "/schedules/"+id, map[string]interface{}{
+		"url": "https://example.com/dashboard", "formats": []string{"pdf"}, "cron": "0 9 * * MON", "is_active": false,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("UpdateSchedule() status = %d, body = %s", w.Code, w.Body.String())
+	}
+	schedule = models.CaptureSchedule{}
+	h.db.First(&schedule, "id = ?", id)
+	if schedule.IsActive || schedule.NextRunAt != nil || len(schedule.HeadersEnc) == 0 ||
+		schedule.DeliveryChannel != "" || schedule.Timezone != "UTC" {
+		t.Errorf("schedule after update = %+v", schedule)
+	}
+
+	w = send(http.MethodGet, "/schedules", nil)
+	var list struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 1 {
+		t.Errorf("ListSchedules() returned %d schedules, want 1", len(list.Data))
+	}
+
+	foreign := models.CaptureSchedule{UserID: other.ID, URL: "https://example.com", CronExpr: "@daily", TaskTemplate: "{}"}
+	h.db.Create(&foreign)
+	if w := send(http.MethodGet, "/schedules/"+foreign.ID.String(), nil); w.Code != http.StatusNotFound {
+		t.Errorf("GetSchedule() for another user's schedule status = %d, want 404", w.Code)
+	}
+
+	if w := send(http.MethodDelete, "/schedules/"+id, nil); w.Code != http.StatusOK {
+		t.Errorf("DeleteSchedule() status = %d", w.Code)
+	}
+	var count int64
+	h.db.Model(&models.CaptureSchedule{}).Where("id = ?", id).Count(&count)
+	if count != 0 {
+		t.Error("schedule not deleted")
+	}
+}
+
+func TestCreateScheduleInactive(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+	r.POST("/schedules", func(c *gin.Context) {
+		c.Set("user_id", user.ID.String())
+		h.CreateSchedule(c)
+	})
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"url": "https://example.com", "formats": []string{"pdf"}, "cron": "@hourly", "is_active": false,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/schedules", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("CreateSchedule() status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	var schedule models.CaptureSchedule
+	h.db.First(&schedule, "user_id = ?", user.ID)
+	if schedule.IsActive || schedule.NextRunAt != nil {
+		t.Errorf("schedule = %+v, want inactive without a next run", schedule)
+	}
+}
+
+func TestUpdateScheduleKeepsClaimedRun(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+	schedule := models.CaptureSchedule{UserID: user.ID, URL: "https://example.com", CronExpr: "@hourly", TaskTemplate: "{}", Runs: 4}
+	h.db.Create(&schedule)
+	r.PUT("/schedules/:id", func(c *gin.Context) {
+		c.Set("user_id", user.ID.String())
+		h.UpdateSchedule(c)
+	})
+
+	// A dispatcher claims a run after the handler has read the schedule.
+	taskID := uuid.New()
+	claimed := false
+	err := h.db.Callback().Update().Before("gorm:update").Register("test:claim", func(tx *gorm.DB) {
+		if claimed {
+			return
+		}
+		claimed = true
+		tx.Session(&gorm.Session{NewDB: true}).Model(&models.CaptureSchedule{}).
+			Where("id = ? AND runs = ?", schedule.ID, 4).
+			Updates(map[string]interface{}{"runs": 5, "last_run_at": time.Now(), "last_task_id": taskID})
+	})
+	if err != nil {
+		t.Fatalf("Failed to register callback: %v", err)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"url": "https://example.com/new", "formats": []string{"pdf"}, "cron": "@daily"})
+	req := httptest.NewRequest(http.MethodPut, "/schedules/"+schedule.ID.String(), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("UpdateSchedule() status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	var got models.CaptureSchedule
+	h.db.First(&got, "id = ?", schedule.ID)
+	if got.URL != "https://example.com/new" || got.CronExpr != "@daily" {
+		t.Errorf("schedule after update = %+v, want the new URL and cron", got)
+	}
+	if got.Runs != 5 || got.LastRunAt == nil || got.LastTaskID == nil || *got.LastTaskID != taskID {
+		t.Errorf("schedule after update = runs %d, last task %v, want the claimed run kept", got.Runs, got.LastTaskID)
+	}
+	if !strings.Contains(w.Body.String(), `"runs":5`) {
+		t.Errorf("UpdateSchedule() = %s, want the claimed run", w.Body.String())
+	}
+}
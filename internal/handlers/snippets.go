@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"pagemail/internal/audit"
+	"pagemail/internal/capture"
+	"pagemail/internal/models"
+	"pagemail/internal/pkg/errors"
+)
+
+var snippetNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+type CreateSnippetRequest struct {
+	Name    string `json:"name" binding:"required"`
+	Type    string `json:"type" binding:"required,oneof=js css"`
+	Content string `json:"content" binding:"required"`
+}
+
+func (r *CreateSnippetRequest) validate() error {
+	if !snippetNamePattern.MatchString(r.Name) {
+		return fmt.Errorf("name may only contain letters, digits, '-' and '_' (max 64)")
+	}
+	if len(r.Content) > capture.MaxInjectionSize {
+		return fmt.Errorf("content exceeds %d bytes", capture.MaxInjectionSize)
+	}
+	return nil
+}
+
+func snippetResponse(s *models.Snippet) gin.H {
+	return gin.H{
+		"id":         s.ID,
+		"name":       s.Name,
+		"type":       s.Type,
+		"content":    s.Content,
+		"created_at": s.CreatedAt,
+		"updated_at": s.UpdatedAt,
+	}
+}
+
+func (h *Handler) ListSnippets(c *gin.Context) {
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	var snippets []models.Snippet
+	if err := h.db.Where("user_id = ?", uid).Order("name").Find(&snippets).Error; err != nil {
+		errors.InternalError("Failed to fetch snippets").Respond(c)
+		return
+	}
+
+	result := make([]gin.H, len(snippets))
+	for i := range snippets {
+		result[i] = snippetResponse(&snippets[i])
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *Handler) CreateSnippet(c *gin.Context) {
+	var req CreateSnippetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errors.BadRequest(err.Error()).Respond(c)
+		return
+	}
+	if err := req.validate(); err != nil {
+		errors.BadRequest(err.Error()).Respond(c)
+		return
+	}
+
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	if h.snippetNameTaken(uid, req.Name, uuid.Nil) {
+		errors.Conflict("A snippet with this name already exists").Respond(c)
+		return
+	}
+
+	snippet := models.Snippet{
+		UserID:  uid,
+		Name:    req.Name,
+		Type:    req.Type,
+		Content: req.Content,
+	}
+
+	if err := h.db.Create(&snippet).Error; err != nil {
+		errors.InternalError("Failed to create snippet").Respond(c)
+		return
+	}
+
+	h.logAudit(c, audit.ActionSnippetCreate, "snippet", &snippet.ID, audit.ResourceDetails{Name: snippet.Name})
+
+	c.JSON(http.StatusCreated, snippetResponse(&snippet))
+}
+
+func (h *Handler) UpdateSnippet(c *gin.Context) {
+	snippetID := c.Param("id")
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	var snippet models.Snippet
+	if err := h.db.Where("id = ? AND user_id = ?", snippetID, uid).First(&snippet).Error; err != nil {
+		errors.NotFound("Snippet not found").Respond(c)
+		return
+	}
+
+	var req CreateSnippetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errors.BadRequest(err.Error()).Respond(c)
+		return
+	}
+	if err := req.validate(); err != nil {
+		errors.BadRequest(err.Error()).Respond(c)
+		return
+	}
+
+	if h.snippetNameTaken(uid, req.Name, snippet.ID) {
+		errors.Conflict("A snippet with this name already exists").Respond(c)
+		return
+	}
+
+	snippet.Name = req.Name
+	snippet.Type = req.Type
+	snippet.Content = req.Content
+
+	if err := h.db.Save(&snippet).Error; err != nil {
+		errors.InternalError("Failed to update snippet").Respond(c)
+		return
+	}
+
+	h.logAudit(c, audit.ActionSnippetUpdate, "snippet", &snippet.ID, audit.ResourceDetails{Name: snippet.Name})
+
+	c.JSON(http.StatusOK, snippetResponse(&snippet))
+}
+
+func (h *Handler) DeleteSnippet(c *gin.Context) {
+	snippetID := c.Param("id")
+	userID := c.GetString("user_id")
+	uid, _ := uuid.Parse(userID)
+
+	var snippet models.Snippet
+	if err := h.db.Where("id = ? AND user_id = ?", snippetID, uid).First(&snippet).Error; err != nil {
+		errors.NotFound("Snippet not found").Respond(c)
+		return
+	}
+
+	if err := h.db.Delete(&snippet).Error; err != nil {
+		errors.InternalError("Failed to delete snippet").Respond(c)
+		return
+	}
+
+	h.logAudit(c, audit.ActionSnippetDelete, "snippet", &snippet.ID, audit.ResourceDetails{Name: snippet.Name})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Snippet deleted"})
+}
+
+// snippetNameTaken reports whether another snippet of the user already uses
+// name. except is the snippet being renamed, if any.
+func (h *Handler) snippetNameTaken(uid uuid.UUID, name string, except uuid.UUID) bool {
+	var count int64
+	h.db.Model(&models.Snippet{}).
+		Where("user_id = ? AND name = ? AND id <> ?", uid, name, except).
+		Count(&count)
+	return count > 0
+}
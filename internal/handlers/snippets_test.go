@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"pagemail/internal/capture"
+	"pagemail/internal/models"
+)
+
+func TestSnippetCRUD(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+
+	withUser := func(handler gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set("user_id", user.ID.String())
+			handler(c)
+		}
+	}
+	r.GET("/snippets", withUser(h.ListSnippets))
+	r.POST("/snippets", withUser(h.CreateSnippet))
+	r.PUT("/snippets/:id", withUser(h.UpdateSnippet))
+	r.DELETE("/snippets/:id", withUser(h.DeleteSnippet))
+
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			_ = json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name       string
+		body       map[string]string
+		wantStatus int
+	}{
+		{"css", map[string]string{"name": "hide-header", "type": "css", "content": "header { display: none }"}, http.StatusCreated},
+		{"js", map[string]string{"name": "expand", "type": "js", "content": "document.querySelectorAll('details').forEach(d => d.open = true)"}, http.StatusCreated},
+		{"duplicate name", map[string]string{"name": "expand", "type": "js", "content": "1"}, http.StatusConflict},
+		{"invalid name", map[string]string{"name": "has space", "type": "js", "content": "1"}, http.StatusBadRequest},
+		{"invalid type", map[string]string{"name": "other", "type": "html", "content": "1"}, http.StatusBadRequest},
+		{"too large", map[string]string{"name": "big", "type": "css", "content": strings.Repeat("x", capture.MaxInjectionSize+1)}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := send(http.MethodPost, "/snippets", tt.body); w.Code != tt.wantStatus {
+				t.Errorf("CreateSnippet() status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+
+	w := send(http.MethodGet, "/snippets", nil)
+	var list []map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(list) != 2 || list[0]["name"] != "expand" {
+		t.Fatalf("ListSnippets() = %v, want expand and hide-header", list)
+	}
+
+	id := list[0]["id"].(string)
+	w = send(http.MethodPut, "/snippets/"+id, map[string]string{"name": "hide-header", "type": "js", "content": "1"})
+	if w.Code != http.StatusConflict {
+		t.Errorf("UpdateSnippet() rename to taken name status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	w = send(http.MethodPut, "/snippets/"+id, map[string]string{"name": "expand-all", "type": "js", "content": "2"})
+	if w.Code != http.StatusOK {
+		t.Errorf("UpdateSnippet() status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if w = send(http.MethodDelete, "/snippets/"+id, nil); w.Code != http.StatusOK {
+		t.Errorf("DeleteSnippet() status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w = send(http.MethodDelete, "/snippets/"+id, nil); w.Code != http.StatusNotFound {
+		t.Errorf("DeleteSnippet() again status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestCreateCaptureInject(t *testing.T) {
+	h, r := setupTestHandler(t)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+	other := models.User{Email: "other@example.com", PasswordHash: "hash"}
+	h.db.Create(&other)
+
+	h.db.Create(&models.Snippet{UserID: user.ID, Name: "no-chat", Type: models.SnippetTypeCSS, Content: "#chat { display: none }"})
+	h.db.Create(&models.Snippet{UserID: other.ID, Name: "private", Type: models.SnippetTypeJS, Content: "1"})
+
+	r.POST("/captures", func(c *gin.Context) {
+		c.Set("user_id", user.ID.String())
+		h.CreateCapture(c)
+	})
+
+	tests := []struct {
+		name       string
+		inject     map[string]interface{}
+		wantStatus int
+	}{
+		{"snippet and script", map[string]interface{}{"snippets": []string{"no-chat"}, "scripts": []string{"window.scrollTo(0, 0)"}, "timeout_ms": 2000}, http.StatusCreated},
+		{"unknown snippet", map[string]interface{}{"snippets": []string{"missing"}}, http.StatusBadRequest},
+		{"other user's snippet", map[string]interface{}{"snippets": []string{"private"}}, http.StatusBadRequest},
+		{"empty script", map[string]interface{}{"scripts": []string{""}}, http.StatusBadRequest},
+		{"timeout too large", map[string]interface{}{"timeout_ms": 60000}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]interface{}{
+				"url":     "https://example.com",
+				"formats": []string{"pdf"},
+				"inject":  tt.inject,
+			})
+			req := httptest.NewRequest(http.MethodPost, "/captures", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("CreateCapture() status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+
+	var task models.CaptureTask
+	if err := h.db.Where("user_id = ?", user.ID).First(&task).Error; err != nil {
+		t.Fatalf("Failed to load task: %v", err)
+	}
+	var injections []capture.Injection
+	if err := json.Unmarshal([]byte(task.Injections), &injections); err != nil {
+		t.Fatalf("Failed to parse injections: %v", err)
+	}
+	if len(injections) != 2 || injections[0].Name != "no-chat" || injections[0].Type != capture.InjectCSS || injections[1].Type != capture.InjectJS {
+		t.Errorf("Task injections = %+v, want snippet then script", injections)
+	}
+	if task.ScriptTimeoutMs != 2000 {
+		t.Errorf("Task script timeout = %d, want 2000", task.ScriptTimeoutMs)
+	}
+}
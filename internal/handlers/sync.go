@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"pagemail/internal/models"
+	"pagemail/internal/pkg/errors"
+)
+
+const (
+	// defaultSyncWait is how long CreateCaptureSync waits without ?wait.
+	defaultSyncWait = 30 * time.Second
+	// maxSyncWait bounds ?wait, to stay under common proxy timeouts.
+	maxSyncWait = 2 * time.Minute
+	// syncPollInterval is how often a waiting request checks its task.
+	syncPollInterval = 250 * time.Millisecond
+	// maxInlineBytes bounds the output content returned inline by one
+	// response; outputs past it are linked instead.
+	maxInlineBytes = 10 << 20
+	// syncWriteMargin is the time allowed to send the response once the
+	// wait is over.
+	syncWriteMargin = 30 * time.Second
+	// linkExpirySeconds is how long presigned output links stay valid.
+	linkExpirySeconds = 3600
+)
+
+const (
+	syncOutputsLinks  = "links"
+	syncOutputsInline = "inline"
+)
+
+// syncOptions says how long a capture request waits for its capture and
+// how the outputs are returned when it finishes in time.
+type syncOptions struct {
+	wait    time.Duration
+	outputs string
+}
+
+// parseSyncOptions reads ?wait, a duration such as "30s" or a number of
+// seconds, and ?outputs, "links" or "inline".
+func parseSyncOptions(c *gin.Context, defaultWait time.Duration) (syncOptions, *errors.ProblemDetail) {
+	opts := syncOptions{wait: defaultWait, outputs: syncOutputsLinks}
+
+	if raw := c.Query("wait"); raw != "" {
+		wait, err := time.ParseDuration(raw)
+		if err != nil {
+			seconds, convErr := strconv.Atoi(raw)
+			if convErr != nil {
+				return opts, errors.BadRequest("Invalid wait: " + raw)
+			}
+			wait = time.Duration(seconds) * time.Second
+		}
+		if wait < 0 || wait > maxSyncWait {
+			return opts, errors.BadRequest(fmt.Sprintf("wait must be between 0s and %s", maxSyncWait))
+		}
+		opts.wait = wait
+	}
+
+	switch raw := c.DefaultQuery("outputs", syncOutputsLinks); raw {
+	case syncOutputsLinks, syncOutputsInline:
+		opts.outputs = raw
+	default:
+		return opts, errors.BadRequest("outputs must be links or inline")
+	}
+	return opts, nil
+}
+
+// CreateCaptureSync enqueues a capture and waits for it to finish, for
+// thirty seconds unless ?wait says otherwise.
+func (h *Handler) CreateCaptureSync(c *gin.Context) {
+	h.createCapture(c, defaultSyncWait)
+}
+
+// respondWhenDone waits for a new task and responds with its result, or
+// with 202 and where to poll if it is still running when the wait is over.
+func (h *Handler) respondWhenDone(c *gin.Context, task *models.CaptureTask, opts syncOptions) {
+	// The server's write timeout is shorter than the longest wait.
+	deadline := time.Now().Add(opts.wait + syncWriteMargin)
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(deadline); err != nil && !stderrors.Is(err, http.ErrNotSupported) {
+		log.Warn().Err(err).Msg("Failed to extend write deadline")
+	}
+
+	done, err := h.waitForTask(c.Request.Context(), task.ID, opts.wait)
+	if err != nil {
+		errors.InternalError("Failed to load capture task").Respond(c)
+		return
+	}
+
+	if done.Status != models.TaskStatusCompleted && done.Status != models.TaskStatusFailed {
+		c.Header("Location", fmt.Sprintf("/v1/captures/%s", done.ID))
+		c.JSON(http.StatusAccepted, gin.H{
+			"id":         done.ID,
+			"url":        done.URL,
+			"formats":    intToFormats(done.Formats),
+			"status":     done.Status,
+			"message":    "Capture still running; poll the capture for its result",
+			"created_at": done.CreatedAt,
+		})
+		return
+	}
+
+	var outputs []models.CaptureOutput
+	h.db.Where("task_id = ? AND diff_id IS NULL", done.ID).Order("created_at ASC").Find(&outputs)
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":            done.ID,
+		"url":           done.URL,
+		"title":         done.Title,
+		"final_url":     done.FinalURL,
+		"http_status":   done.HTTPStatus,
+		"changed":       done.Changed,
+		"formats":       intToFormats(done.Formats),
+		"status":        done.Status,
+		"error_message": done.ErrorMessage,
+		"output_errors": storedJSON(done.OutputErrors),
+		"outputs":       h.syncOutputs(c.Request.Context(), outputs, opts.outputs),
+		"created_at":    done.CreatedAt,
+		"completed_at":  done.CompletedAt,
+	})
+}
+
+// waitForTask polls a task until it has completed or failed for good, the
+// timeout elapses or the client goes away, and returns it as last seen.
+func (h *Handler) waitForTask(ctx context.Context, taskID uuid.UUID, timeout time.Duration) (*models.CaptureTask, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(syncPollInterval)
+	defer ticker.Stop()
+
+	for {
+		var task models.CaptureTask
+		if err := h.db.First(&task, "id = ?", taskID).Error; err != nil {
+			return nil, err
+		}
+		if task.Status == models.TaskStatusCompleted || task.Status == models.TaskStatusFailed || ctx.Err() != nil {
+			return &task, nil
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncOutputs describes a finished task's outputs, with their content
+// inline while it fits in maxInlineBytes, and links to the rest.
+func (h *Handler) syncOutputs(ctx context.Context, outputs []models.CaptureOutput, mode string) []gin.H {
+	result := make([]gin.H, len(outputs))
+	var inlined int64
+	for i := range outputs {
+		entry := gin.H{
+			"id":           outputs[i].ID,
+			"format":       outputs[i].Format,
+			"content_type": outputs[i].ContentType,
+			"size":         outputs[i].SizeBytes,
+			"sha256":       outputs[i].SHA256,
+		}
+		result[i] = entry
+
+		if mode == syncOutputsInline && inlined+outputs[i].SizeBytes <= maxInlineBytes {
+			data, err := h.readOutput(ctx, &outputs[i])
+			if err == nil {
+				entry["content"] = base64.StdEncoding.EncodeToString(data)
+				inlined += int64(len(data))
+				continue
+			}
+			log.Warn().Err(err).Str("output_id", outputs[i].ID.String()).Msg("Failed to inline output")
+		}
+		entry["url"] = h.outputURL(ctx, &outputs[i])
+	}
+	return result
+}
+
+// outputURL links to an output: a presigned storage URL where the backend
+// has them, or else the download endpoint.
+func (h *Handler) outputURL(ctx context.Context, output *models.CaptureOutput) string {
+	if url, err := h.storage.GetPresignedURL(ctx, output.ObjectKey, linkExpirySeconds); err == nil {
+		return url
+	}
+	return fmt.Sprintf("/v1/captures/%s/outputs/%s/download", output.TaskID, output.ID)
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"pagemail/internal/models"
+)
+
+func TestCreateCaptureSync(t *testing.T) {
+	h, r := setupTestHandler(t)
+	// The fake worker below shares the in-memory database, which only
+	// exists on one connection.
+	sqlDB, _ := h.db.DB()
+	sqlDB.SetMaxOpenConns(1)
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	h.db.Create(&user)
+
+	withUser := func(handler gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set("user_id", user.ID.String())
+			handler(c)
+		}
+	}
+	r.POST("/captures", withUser(h.CreateCapture))
+	r.POST("/captures/sync", withUser(h.CreateCaptureSync))
+
+	// Finish pending captures as a worker would: URLs mentioning "fail"
+	// fail, and those mentioning "slow" never finish.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			time.Sleep(20 * time.Millisecond)
+			var tasks []models.CaptureTask
+			h.db.Where("status = ?", models.TaskStatusPending).Find(&tasks)
+			for i := range tasks {
+				task := &tasks[i]
+				switch {
+				case strings.Contains(task.URL, "slow"):
+					continue
+				case strings.Contains(task.URL, "fail"):
+					h.db.Model(task).Updates(map[string]interface{}{"status": models.TaskStatusFailed, "error_message": "navigation timeout"})
+					continue
+				}
+				key := "captures/" + task.ID.String() + "_pdf.pdf"
+				_, _ = h.storage.Upload(ctx, key, strings.NewReader("%PDF-1.7"), "application/pdf")
+				h.db.Create(&models.CaptureOutput{TaskID: task.ID, Format: "pdf", StorageBackend: "local", ObjectKey: key, ContentType: "application/pdf", SizeBytes: 8})
+				h.db.Model(task).Updates(map[string]interface{}{"status": models.TaskStatusCompleted, "completed_at": time.Now()})
+			}
+		}
+	}()
+
+	type outputResponse struct {
+		ID      string
+		Format  string
+		Content string
+		URL     string
+	}
+	tests := []struct {
+		name        string
+		path        string
+		url         string
+		wantStatus  int
+		wantTask    string
+		wantContent bool
+	}{
+		{"links by default", "/captures/sync", "https://example.com/", http.StatusOK, models.TaskStatusCompleted, false},
+		{"inline outputs", "/captures?wait=5s&outputs=inline", "https://example.com/", http.StatusOK, models.TaskStatusCompleted, true},
+		{"failed capture", "/captures/sync?wait=5", "https://example.com/fail", http.StatusOK, models.TaskStatusFailed, false},
+		{"timeout", "/captures/sync?wait=300ms", "https://example.com/slow", http.StatusAccepted, models.TaskStatusPending, false},
+		{"no wait", "/captures", "https://example.com/slow", http.StatusCreated, models.TaskStatusPending, false},
+		{"invalid wait", "/captures?wait=soon", "https://example.com/", http.StatusBadRequest, "", false},
+		{"wait too long", "/captures/sync?wait=1h", "https://example.com/", http.StatusBadRequest, "", false},
+		{"invalid outputs", "/captures/sync?outputs=zip", "https://example.com/", http.StatusBadRequest, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]interface{}{"url": tt.url, "formats": []string{"pdf"}})
+			req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("CreateCapture() status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantTask == "" {
+				return
+			}
+
+			var resp struct {
+				ID      string
+				Status  string
+				Outputs []outputResponse
+			}
+			_ = json.Unmarshal(w.Body.Bytes(), &resp)
+			if resp.Status != tt.wantTask {
+				t.Errorf("CreateCapture() task status = %q, want %q", resp.Status, tt.wantTask)
+			}
+			if w.Code == http.StatusAccepted && w.Header().Get("Location") != "/v1/captures/"+resp.ID {
+				t.Errorf("CreateCapture() Location = %q", w.Header().Get("Location"))
+			}
+			if tt.wantTask != models.TaskStatusCompleted {
+				return
+			}
+
+			if len(resp.Outputs) != 1 {
+				t.Fatalf("CreateCapture() outputs = %+v, want one", resp.Outputs)
+			}
+			output := resp.Outputs[0]
+			if tt.wantContent {
+				content, _ := base64.StdEncoding.DecodeString(output.Content)
+				if string(content) != "%PDF-1.7" || output.URL != "" {
+					t.Errorf("CreateCapture() inline output = %+v", output)
+				}
+			} else if output.Content != "" || output.URL != "/v1/captures/"+resp.ID+"/outputs/"+output.ID+"/download" {
+				t.Errorf("CreateCapture() linked output = %+v", output)
+			}
+		})
+	}
+}
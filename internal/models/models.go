@@ -101,10 +101,68 @@ func (w *WebhookEndpoint) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+const (
+	SnippetTypeJS  = "js"
+	SnippetTypeCSS = "css"
+)
+
+// Snippet is a reusable script or stylesheet that capture requests can
+// reference by name.
+type Snippet struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_snippets_user_name" json:"user_id"`
+	User      User      `gorm:"foreignKey:UserID" json:"-"`
+	Name      string    `gorm:"not null;uniqueIndex:idx_snippets_user_name" json:"name"`
+	Type      string    `gorm:"not null" json:"type"`
+	Content   string    `gorm:"type:text;not null" json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (s *Snippet) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// LoginRecipe is a reusable sign-in flow run before captures of pages that
+// need authentication. Steps is a JSON list of capture.LoginStep; the
+// credentials they type are stored encrypted.
+type LoginRecipe struct {
+	ID             uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	UserID         uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_login_recipes_user_name" json:"user_id"`
+	User           User      `gorm:"foreignKey:UserID" json:"-"`
+	Name           string    `gorm:"not null;uniqueIndex:idx_login_recipes_user_name" json:"name"`
+	LoginURL       string    `gorm:"not null" json:"login_url"`
+	Steps          string    `gorm:"type:text;not null" json:"steps"`
+	CredentialsEnc []byte    `json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (l *LoginRecipe) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
+
 const (
 	FormatPDF  = 1
 	FormatHTML = 2
 	FormatPNG  = 4
+	FormatJPEG = 8
+	FormatWebP = 16
+	// FormatMarkdown and FormatText hold the reader-mode article.
+	FormatMarkdown = 32
+	FormatText     = 64
+	FormatEPUB     = 128
+	FormatMHTML    = 256
+	FormatWARC     = 512
+	// FormatHAR and FormatConsole are diagnostic logs of the capture.
+	FormatHAR     = 1024
+	FormatConsole = 2048
 )
 
 const (
@@ -115,25 +173,52 @@ const (
 )
 
 type CaptureTask struct {
-	ID             uuid.UUID       `gorm:"type:uuid;primary_key" json:"id"`
-	UserID         uuid.UUID       `gorm:"type:uuid;not null;index" json:"user_id"`
-	User           User            `gorm:"foreignKey:UserID" json:"-"`
-	URL            string          `gorm:"not null" json:"url"`
-	Status         string          `gorm:"not null;default:pending;index" json:"status"`
-	Formats        int             `gorm:"not null;default:1" json:"formats"`
-	CookiesEnc     []byte          `json:"-"`
-	UserAgent      string          `json:"user_agent,omitempty"`
-	ViewportWidth  int             `gorm:"default:1920" json:"viewport_width"`
-	ViewportHeight int             `gorm:"default:1080" json:"viewport_height"`
-	WaitTimeoutMs  int             `gorm:"default:30000" json:"wait_timeout_ms"`
-	Attempts       int             `gorm:"not null;default:0" json:"attempts"`
-	MaxAttempts    int             `gorm:"not null;default:3" json:"max_attempts"`
-	ErrorMessage   string          `json:"error_message,omitempty"`
-	CreatedAt      time.Time       `gorm:"index" json:"created_at"`
-	UpdatedAt      time.Time       `json:"updated_at"`
-	CompletedAt    *time.Time      `json:"completed_at,omitempty"`
-	Outputs        []CaptureOutput `gorm:"foreignKey:TaskID" json:"outputs,omitempty"`
-	Deliveries     []Delivery      `gorm:"foreignKey:TaskID" json:"deliveries,omitempty"`
+	ID                uuid.UUID       `gorm:"type:uuid;primary_key" json:"id"`
+	UserID            uuid.UUID       `gorm:"type:uuid;not null;index" json:"user_id"`
+	User              User            `gorm:"foreignKey:UserID" json:"-"`
+	URL               string          `gorm:"not null" json:"url"`
+	Status            string          `gorm:"not null;default:pending;index" json:"status"`
+	Formats           int             `gorm:"not null;default:1" json:"formats"`
+	CookiesEnc        []byte          `json:"-"`
+	LoginRecipeID     *uuid.UUID      `gorm:"type:uuid" json:"login_recipe_id,omitempty"`
+	HeadersEnc        []byte          `json:"-"`
+	BasicAuthEnc      []byte          `json:"-"`
+	UserAgent         string          `json:"user_agent,omitempty"`
+	ViewportWidth     int             `gorm:"default:1920" json:"viewport_width"`
+	ViewportHeight    int             `gorm:"default:1080" json:"viewport_height"`
+	WaitTimeoutMs     int             `gorm:"default:30000" json:"wait_timeout_ms"`
+	WaitUntil         string          `gorm:"default:load" json:"wait_until"`
+	WaitSelector      string          `json:"wait_selector,omitempty"`
+	WaitExpression    string          `gorm:"type:text" json:"wait_expression,omitempty"`
+	WaitIdleMs        int             `json:"wait_idle_ms,omitempty"`
+	WaitDelayMs       int             `json:"wait_delay_ms,omitempty"`
+	PDFOptions        string          `gorm:"type:text" json:"pdf_options,omitempty"`
+	ScreenshotOptions string          `gorm:"type:text" json:"screenshot_options,omitempty"`
+	EmulationOptions  string          `gorm:"type:text" json:"emulation_options,omitempty"`
+	BlockingOptions   string          `gorm:"type:text" json:"blocking_options,omitempty"`
+	ScrollOptions     string          `gorm:"type:text" json:"scroll_options,omitempty"`
+	Injections        string          `gorm:"type:text" json:"injections,omitempty"`
+	ScriptTimeoutMs   int             `json:"script_timeout_ms,omitempty"`
+	Attempts          int             `gorm:"not null;default:0" json:"attempts"`
+	MaxAttempts       int             `gorm:"not null;default:3" json:"max_attempts"`
+	ErrorMessage      string          `json:"error_message,omitempty"`
+	OutputErrors      string          `gorm:"type:text" json:"output_errors,omitempty"`
+	InjectionErrors   string          `gorm:"type:text" json:"injection_errors,omitempty"`
+	Title             string          `json:"title,omitempty"`
+	FinalURL          string          `json:"final_url,omitempty"`
+	HTTPStatus        int             `json:"http_status,omitempty"`
+	PageMetadata      string          `gorm:"type:text" json:"page_metadata,omitempty"`
+	ScheduleID        *uuid.UUID      `gorm:"type:uuid;index" json:"schedule_id,omitempty"`
+	MonitorOptions    string          `gorm:"type:text" json:"monitor_options,omitempty"`
+	Changed           *bool           `json:"changed,omitempty"`
+	ChangeSummary     string          `gorm:"type:text" json:"change_summary,omitempty"`
+	CreatedAt         time.Time       `gorm:"index" json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
+	CompletedAt       *time.Time      `json:"completed_at,omitempty"`
+	Outputs           []CaptureOutput `gorm:"foreignKey:TaskID" json:"outputs,omitempty"`
+	Deliveries        []Delivery      `gorm:"foreignKey:TaskID" json:"deliveries,omitempty"`
+	BatchID           *uuid.UUID      `gorm:"type:uuid;index" json:"batch_id,omitempty"`
+	BatchIndex        int             `gorm:"not null;default:0" json:"batch_index,omitempty"`
 }
 
 func (c *CaptureTask) BeforeCreate(tx *gorm.DB) error {
@@ -153,6 +238,9 @@ type CaptureOutput struct {
 	SizeBytes      int64     `gorm:"not null" json:"size_bytes"`
 	SHA256         string    `json:"sha256,omitempty"`
 	CreatedAt      time.Time `json:"created_at"`
+	// DiffID is set on outputs derived by comparing the task with another
+	// capture. They are never delivered.
+	DiffID *uuid.UUID `gorm:"type:uuid;index" json:"diff_id,omitempty"`
 }
 
 func (c *CaptureOutput) BeforeCreate(tx *gorm.DB) error {
@@ -162,6 +250,41 @@ func (c *CaptureOutput) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// CaptureDiff compares a capture with another one. The rendered diffs are
+// stored as outputs of the first capture.
+type CaptureDiff struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	TaskID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_capture_diffs_pair" json:"task_id"`
+	OtherTaskID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_capture_diffs_pair;index" json:"other_task_id"`
+	// ImageRatio and TextRatio are the share of pixels and lines that
+	// differ, when the captures had screenshots or text to compare.
+	ImageRatio  *float64 `json:"image_ratio,omitempty"`
+	TextRatio   *float64 `json:"text_ratio,omitempty"`
+	TextAdded   int      `json:"text_added"`
+	TextRemoved int      `json:"text_removed"`
+	// Errors explains, by "image" and "text", why a diff is missing.
+	Errors    string    `gorm:"type:text" json:"errors,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (d *CaptureDiff) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// CaptureSnapshot keeps what change detection compares a monitored capture
+// by: its normalized page text and a grayscale thumbnail of the page.
+type CaptureSnapshot struct {
+	TaskID    uuid.UUID `gorm:"type:uuid;primary_key" json:"task_id"`
+	Text      string    `gorm:"type:text" json:"-"`
+	TextHash  string    `gorm:"not null" json:"text_hash"`
+	Thumbnail []byte    `json:"-"`
+	ImageHash string    `json:"image_hash,omitempty"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
 const (
 	ChannelEmail   = "email"
 	ChannelWebhook = "webhook"
@@ -171,8 +294,89 @@ const (
 	DeliveryStatusPending = "pending"
 	DeliveryStatusSent    = "sent"
 	DeliveryStatusFailed  = "failed"
+	// DeliveryStatusSkipped marks deliveries of monitored captures that
+	// did not change enough to be sent.
+	DeliveryStatusSkipped = "skipped"
+)
+
+// CaptureSchedule creates a capture task, and its delivery, each time its
+// cron expression fires.
+type CaptureSchedule struct {
+	ID       uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	UserID   uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	User     User      `gorm:"foreignKey:UserID" json:"-"`
+	Name     string    `json:"name,omitempty"`
+	URL      string    `gorm:"not null" json:"url"`
+	Formats  int       `gorm:"not null;default:1" json:"formats"`
+	CronExpr string    `gorm:"not null" json:"cron"`
+	Timezone string    `gorm:"not null;default:UTC" json:"timezone"`
+	IsActive bool      `gorm:"not null;default:true" json:"is_active"`
+	// TaskTemplate is the JSON of the task each run creates. Identity,
+	// status and secrets are not part of it.
+	TaskTemplate  string     `gorm:"type:text;not null" json:"-"`
+	CookiesEnc    []byte     `json:"-"`
+	LoginRecipeID *uuid.UUID `gorm:"type:uuid" json:"login_recipe_id,omitempty"`
+	HeadersEnc    []byte     `json:"-"`
+	BasicAuthEnc  []byte     `json:"-"`
+	// DeliveryChannel and DeliveryTarget describe the delivery created
+	// with each task; an empty channel means none.
+	DeliveryChannel string `json:"delivery_channel,omitempty"`
+	DeliveryTarget  string `gorm:"type:text" json:"-"`
+	// Runs counts the tasks created so far. Instances claim a run by
+	// incrementing it, so each tick is materialized once.
+	Runs       int        `gorm:"not null;default:0" json:"runs"`
+	NextRunAt  *time.Time `gorm:"index" json:"next_run_at,omitempty"`
+	LastRunAt  *time.Time `json:"last_run_at,omitempty"`
+	LastTaskID *uuid.UUID `gorm:"type:uuid" json:"last_task_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+func (s *CaptureSchedule) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+const (
+	// BatchCombineAttachments sends every output of a batch in one
+	// message; BatchCombineZip sends them as a single zip archive.
+	BatchCombineAttachments = "attachments"
+	BatchCombineZip         = "zip"
 )
 
+// CaptureBatch groups the tasks created together for a list of URLs. The
+// tasks share their options, and may share one combined delivery sent once
+// all of them have finished.
+type CaptureBatch struct {
+	ID     uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	UserID uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	User   User      `gorm:"foreignKey:UserID" json:"-"`
+	Name   string    `json:"name,omitempty"`
+	Total  int       `gorm:"not null" json:"total"`
+	// DeliveryChannel and DeliveryTarget describe the combined delivery;
+	// an empty channel means none. Combine is how its files are packed.
+	DeliveryChannel  string     `json:"delivery_channel,omitempty"`
+	DeliveryTarget   string     `gorm:"type:text" json:"-"`
+	Combine          string     `json:"combine,omitempty"`
+	DeliveryStatus   string     `json:"delivery_status,omitempty"`
+	DeliveryAttempts int        `gorm:"not null;default:0" json:"delivery_attempts"`
+	DeliveryError    string     `json:"delivery_error,omitempty"`
+	DeliveredAt      *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt        time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	// CompletedAt is set once no task of the batch is left to run.
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+func (b *CaptureBatch) BeforeCreate(tx *gorm.DB) error {
+	if b.ID == uuid.Nil {
+		b.ID = uuid.New()
+	}
+	return nil
+}
+
 type Delivery struct {
 	ID           uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
 	TaskID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"task_id"`
@@ -198,6 +402,8 @@ func (d *Delivery) BeforeCreate(tx *gorm.DB) error {
 const (
 	JobTypeCapture = "capture"
 	JobTypeDeliver = "deliver"
+	// JobTypeDeliverBatch sends the combined delivery of a CaptureBatch.
+	JobTypeDeliverBatch = "deliver_batch"
 )
 
 const (
@@ -0,0 +1,142 @@
+package cron
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"* * * * *", false},
+		{"0 9 * * MON", false},
+		{"*/15 8-18 * * mon-fri", false},
+		{"0 0 1,15 jan,jul *", false},
+		{"5/20 * * * *", false},
+		{"0 0 * * 7", false},
+		{"@weekly", false},
+		{"@Daily", false},
+		{"* * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"*/0 * * * *", true},
+		{"10-5 * * * *", true},
+		{"* * * * funday", true},
+		{"@every 5m", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "every minute",
+			expr: "* * * * *",
+			from: time.Date(2024, 5, 1, 12, 0, 30, 0, time.UTC),
+			want: time.Date(2024, 5, 1, 12, 1, 0, 0, time.UTC),
+		},
+		{
+			name: "monday morning",
+			expr: "0 9 * * MON",
+			from: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), // Wednesday
+			want: time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "exactly on a tick moves to the next",
+			expr: "0 9 * * *",
+			from: time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 5, 7, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "steps",
+			expr: "*/15 * * * *",
+			from: time.Date(2024, 5, 1, 12, 16, 0, 0, time.UTC),
+			want: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "month rollover",
+			expr: "0 0 1 * *",
+			from: time.Date(2024, 12, 15, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or day of week",
+			expr: "0 0 13 * FRI",
+			from: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			from: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "sunday as 7",
+			expr: "0 0 * * 7",
+			from: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "timezone",
+			expr: "0 9 * * *",
+			from: time.Date(2024, 5, 1, 12, 0, 0, 0, berlin),
+			want: time.Date(2024, 5, 2, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "skipped by spring forward",
+			expr: "30 2 * * *",
+			from: time.Date(2024, 3, 30, 12, 0, 0, 0, berlin),
+			want: time.Date(2024, 4, 1, 2, 30, 0, 0, berlin),
+		},
+		{
+			name: "repeated by fall back fires once",
+			expr: "30 2 * * *",
+			from: time.Date(2024, 10, 27, 2, 30, 0, 0, berlin),
+			want: time.Date(2024, 10, 28, 2, 30, 0, 0, berlin),
+		},
+		{
+			name: "never",
+			expr: "0 0 30 2 *",
+			from: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.expr, err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
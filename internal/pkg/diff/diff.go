@@ -0,0 +1,175 @@
+// Package diff compares two captures of a page: their text line by line
+// and their screenshots pixel by pixel.
+package diff
+
+import "strings"
+
+// Op says what happened to a line going from the old text to the new.
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// Edit is one line of a line diff.
+type Edit struct {
+	Op   Op
+	Text string
+}
+
+// maxEditDistance bounds the work done by Lines; the search keeps
+// O(distance²) state. Texts further apart are reported as replaced
+// wholesale.
+const maxEditDistance = 2000
+
+// NormalizeText splits rendered page text into lines with runs of
+// whitespace collapsed and blank lines dropped, so reflowed markup does not
+// count as a change.
+func NormalizeText(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			lines = append(lines, strings.Join(fields, " "))
+		}
+	}
+	return lines
+}
+
+// Lines returns a shortest edit script turning a into b, using Myers'
+// algorithm.
+func Lines(a, b []string) []Edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]Edit, 0, len(a)+len(b)-prefix-suffix)
+	for _, line := range a[:prefix] {
+		edits = append(edits, Edit{Equal, line})
+	}
+	edits = append(edits, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, Edit{Equal, line})
+	}
+	return edits
+}
+
+func myers(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return replace(a, b)
+	}
+
+	limit := min(n+m, maxEditDistance)
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	// trace[d] holds v[-d-1..d+1] as it was before step d.
+	var trace [][]int
+
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace)
+			}
+		}
+	}
+	return replace(a, b)
+}
+
+func backtrack(a, b []string, trace [][]int) []Edit {
+	var edits []Edit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			edits = append(edits, Edit{Equal, a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, Edit{Insert, b[y-1]})
+			} else {
+				edits = append(edits, Edit{Delete, a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+func replace(a, b []string) []Edit {
+	edits := make([]Edit, 0, len(a)+len(b))
+	for _, line := range a {
+		edits = append(edits, Edit{Delete, line})
+	}
+	for _, line := range b {
+		edits = append(edits, Edit{Insert, line})
+	}
+	return edits
+}
+
+// Stats summarizes an edit script.
+type Stats struct {
+	Inserted int
+	Deleted  int
+	// Ratio is the share of lines, old and new, that were inserted or
+	// deleted: 0 for identical texts and 1 for texts with nothing in
+	// common.
+	Ratio float64
+}
+
+// Summarize counts the changed lines of an edit script.
+func Summarize(edits []Edit) Stats {
+	var s Stats
+	equal := 0
+	for _, e := range edits {
+		switch e.Op {
+		case Insert:
+			s.Inserted++
+		case Delete:
+			s.Deleted++
+		default:
+			equal++
+		}
+	}
+	if total := s.Inserted + s.Deleted + 2*equal; total > 0 {
+		s.Ratio = float64(s.Inserted+s.Deleted) / float64(total)
+	}
+	return s
+}
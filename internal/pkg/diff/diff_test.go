@@ -0,0 +1,132 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeText(t *testing.T) {
+	got := NormalizeText("  Pricing \n\n\tPro   plan\t$49 / month\n   \nContact us\r\n")
+	want := []string{"Pricing", "Pro plan $49 / month", "Contact us"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NormalizeText() = %q, want %q", got, want)
+	}
+}
+
+// render writes an edit script as " line", "+line" and "-line".
+func render(edits []Edit) []string {
+	lines := make([]string, len(edits))
+	for i, e := range edits {
+		prefix := " "
+		switch e.Op {
+		case Insert:
+			prefix = "+"
+		case Delete:
+			prefix = "-"
+		}
+		lines[i] = prefix + e.Text
+	}
+	return lines
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []string
+	}{
+		{
+			name: "identical",
+			a:    "a b c",
+			b:    "a b c",
+			want: []string{" a", " b", " c"},
+		},
+		{
+			name: "changed line",
+			a:    "title price:49 footer",
+			b:    "title price:59 footer",
+			want: []string{" title", "-price:49", "+price:59", " footer"},
+		},
+		{
+			name: "insert and delete",
+			a:    "a b c d",
+			b:    "a c d e",
+			want: []string{" a", "-b", " c", " d", "+e"},
+		},
+		{
+			name: "from empty",
+			a:    "",
+			b:    "a b",
+			want: []string{"+a", "+b"},
+		},
+		{
+			name: "to empty",
+			a:    "a b",
+			b:    "",
+			want: []string{"-a", "-b"},
+		},
+		{
+			name: "nothing in common",
+			a:    "a b",
+			b:    "c d",
+			want: []string{"-a", "-b", "+c", "+d"},
+		},
+		{
+			name: "shortest script",
+			a:    "a b c a b b a",
+			b:    "c b a b a c",
+			want: []string{"-a", "-b", " c", "+b", " a", " b", "-b", " a", "+c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := render(Lines(strings.Fields(tt.a), strings.Fields(tt.b)))
+			if len(got) == 0 {
+				got = nil
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinesTooFarApart(t *testing.T) {
+	var a, b []string
+	for i := 0; i < maxEditDistance; i++ {
+		a = append(a, "old")
+		b = append(b, "new")
+	}
+	a = append(a, "shared")
+	b = append(b, "shared")
+
+	stats := Summarize(Lines(a, b))
+	if stats.Deleted != maxEditDistance || stats.Inserted != maxEditDistance {
+		t.Errorf("Summarize() = %+v, want %d deleted and inserted", stats, maxEditDistance)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want Stats
+	}{
+		{"identical", "a b c", "a b c", Stats{}},
+		{"both empty", "", "", Stats{}},
+		{"one of four changed", "a b c d", "a b x d", Stats{Inserted: 1, Deleted: 1, Ratio: 0.25}},
+		{"replaced", "a b", "c d", Stats{Inserted: 2, Deleted: 2, Ratio: 1}},
+		{"appended", "a", "a b", Stats{Inserted: 1, Ratio: 1.0 / 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Summarize(Lines(strings.Fields(tt.a), strings.Fields(tt.b)))
+			if got != tt.want {
+				t.Errorf("Summarize() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package diff
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// ThumbnailWidth is the width screenshots are reduced to before they
+	// are compared. At this size anti-aliasing and sub-pixel shifts
+	// average out, while moved or restyled blocks still show.
+	ThumbnailWidth = 128
+	// maxThumbnailHeight crops very long pages.
+	maxThumbnailHeight = 4096
+	// pixelTolerance is the luminance difference, out of 255, below which
+	// two thumbnail pixels count as the same.
+	pixelTolerance = 24
+)
+
+// Thumbnail reduces a screenshot to a small grayscale image, keeping its
+// aspect ratio, for CompareThumbnails.
+func Thumbnail(img image.Image) *image.Gray {
+	resized := imaging.Resize(img, ThumbnailWidth, 0, imaging.Box)
+	bounds := resized.Bounds()
+	if bounds.Dy() > maxThumbnailHeight {
+		bounds.Max.Y = bounds.Min.Y + maxThumbnailHeight
+	}
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(gray, gray.Bounds(), resized, bounds.Min, draw.Src)
+	return gray
+}
+
+// CompareThumbnails returns the share of thumbnail pixels that differ,
+// from 0 to 1. When one page is taller, the rows only it has count as
+// different.
+func CompareThumbnails(a, b *image.Gray) float64 {
+	if a.Bounds().Dx() != b.Bounds().Dx() {
+		return 1
+	}
+	width := a.Bounds().Dx()
+	heightA, heightB := a.Bounds().Dy(), b.Bounds().Dy()
+	total := width * max(heightA, heightB)
+	if total == 0 {
+		return 0
+	}
+
+	changed := width * (max(heightA, heightB) - min(heightA, heightB))
+	for y := 0; y < min(heightA, heightB); y++ {
+		for x := 0; x < width; x++ {
+			pa := int(a.GrayAt(a.Bounds().Min.X+x, a.Bounds().Min.Y+y).Y)
+			pb := int(b.GrayAt(b.Bounds().Min.X+x, b.Bounds().Min.Y+y).Y)
+			if pa-pb > pixelTolerance || pb-pa > pixelTolerance {
+				changed++
+			}
+		}
+	}
+	return float64(changed) / float64(total)
+}
+
+// colorTolerance is the difference, out of 255 in any channel, below which
+// two screenshot pixels count as the same.
+const colorTolerance = 16
+
+var (
+	// changedColor highlights pixels that differ.
+	changedColor = color.NRGBA{R: 255, G: 0, B: 64, A: 255}
+	// extraColor highlights the area only the larger screenshot covers.
+	extraColor = color.NRGBA{R: 255, G: 170, B: 0, A: 255}
+)
+
+// Pixels draws a diff of two screenshots the size of the larger one:
+// differing pixels are highlighted and the rest is a faded copy of a. It
+// also returns the share of pixels highlighted, from 0 to 1.
+func Pixels(a, b image.Image) (*image.NRGBA, float64) {
+	pa, pb := toNRGBA(a), toNRGBA(b)
+	wa, ha := pa.Bounds().Dx(), pa.Bounds().Dy()
+	wb, hb := pb.Bounds().Dx(), pb.Bounds().Dy()
+	width, height := max(wa, wb), max(ha, hb)
+
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	changed := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			o := out.PixOffset(x, y)
+			if x >= wa || y >= ha || x >= wb || y >= hb {
+				setPixel(out.Pix[o:o+4], extraColor)
+				changed++
+				continue
+			}
+			ca := pa.Pix[pa.PixOffset(x, y):][:4]
+			cb := pb.Pix[pb.PixOffset(x, y):][:4]
+			if pixelsDiffer(ca, cb) {
+				setPixel(out.Pix[o:o+4], changedColor)
+				changed++
+				continue
+			}
+			// Fade to a light gray so the highlights stand out.
+			lum := (299*int(ca[0]) + 587*int(ca[1]) + 114*int(ca[2])) / 1000
+			faded := uint8(255 - (255-lum)/3)
+			setPixel(out.Pix[o:o+4], color.NRGBA{R: faded, G: faded, B: faded, A: 255})
+		}
+	}
+
+	if width*height == 0 {
+		return out, 0
+	}
+	return out, float64(changed) / float64(width*height)
+}
+
+// toNRGBA returns img as NRGBA with its origin at (0, 0). Decoded
+// screenshots are used in place; anything else is copied.
+func toNRGBA(img image.Image) *image.NRGBA {
+	switch img := img.(type) {
+	case *image.NRGBA:
+		if img.Bounds().Min == (image.Point{}) {
+			return img
+		}
+	case *image.RGBA:
+		// Screenshots are opaque, where premultiplied and straight alpha
+		// agree.
+		if img.Bounds().Min == (image.Point{}) && img.Opaque() {
+			return &image.NRGBA{Pix: img.Pix, Stride: img.Stride, Rect: img.Rect}
+		}
+	}
+	return imaging.Clone(img)
+}
+
+func pixelsDiffer(a, b []uint8) bool {
+	for i := 0; i < 4; i++ {
+		d := int(a[i]) - int(b[i])
+		if d > colorTolerance || d < -colorTolerance {
+			return true
+		}
+	}
+	return false
+}
+
+func setPixel(pix []uint8, c color.NRGBA) {
+	pix[0], pix[1], pix[2], pix[3] = c.R, c.G, c.B, c.A
+}
@@ -0,0 +1,91 @@
+package diff
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+// page draws a white page with a dark block covering rows [top, bottom).
+func page(width, height, top, bottom int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	block := image.Rect(0, top, width, bottom)
+	draw.Draw(img, block, image.NewUniform(color.RGBA{R: 20, G: 40, B: 60, A: 255}), image.Point{}, draw.Src)
+	return img
+}
+
+func TestThumbnail(t *testing.T) {
+	thumb := Thumbnail(page(1280, 2000, 0, 100))
+	if got := thumb.Bounds(); got.Dx() != ThumbnailWidth || got.Dy() != 200 {
+		t.Errorf("Thumbnail() bounds = %v, want %dx200", got, ThumbnailWidth)
+	}
+	if thumb.GrayAt(0, 0).Y > 100 || thumb.GrayAt(0, 199).Y < 200 {
+		t.Errorf("Thumbnail() lost the block: top %d, bottom %d", thumb.GrayAt(0, 0).Y, thumb.GrayAt(0, 199).Y)
+	}
+
+	tall := Thumbnail(page(256, 12000, 0, 0))
+	if got := tall.Bounds().Dy(); got != maxThumbnailHeight {
+		t.Errorf("Thumbnail() of a long page has height %d, want %d", got, maxThumbnailHeight)
+	}
+}
+
+func TestCompareThumbnails(t *testing.T) {
+	base := Thumbnail(page(1280, 1000, 0, 100))
+
+	tests := []struct {
+		name  string
+		other image.Image
+		want  float64
+	}{
+		{"identical", page(1280, 1000, 0, 100), 0},
+		{"block moved", page(1280, 1000, 500, 600), 0.2},
+		{"page grew", page(1280, 2000, 0, 100), 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CompareThumbnails(base, Thumbnail(tt.other))
+			if math.Abs(got-tt.want) > 0.01 {
+				t.Errorf("CompareThumbnails() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	narrow := image.NewGray(image.Rect(0, 0, 64, 100))
+	if got := CompareThumbnails(base, narrow); got != 1 {
+		t.Errorf("CompareThumbnails() of different widths = %v, want 1", got)
+	}
+}
+
+func TestPixels(t *testing.T) {
+	a := page(100, 100, 0, 10)
+	b := page(100, 120, 0, 20)
+
+	out, ratio := Pixels(a, b)
+	if got := out.Bounds(); got.Dx() != 100 || got.Dy() != 120 {
+		t.Fatalf("Pixels() bounds = %v, want 100x120", got)
+	}
+	// Rows 10-19 differ and rows 100-119 only exist in b.
+	if want := 30.0 / 120; math.Abs(ratio-want) > 1e-9 {
+		t.Errorf("Pixels() ratio = %v, want %v", ratio, want)
+	}
+	if got := out.NRGBAAt(50, 15); got != changedColor {
+		t.Errorf("changed pixel = %v, want %v", got, changedColor)
+	}
+	if got := out.NRGBAAt(50, 110); got != extraColor {
+		t.Errorf("extra pixel = %v, want %v", got, extraColor)
+	}
+	if got := out.NRGBAAt(50, 50); got.R != 255 || got.G != 255 || got.B != 255 {
+		t.Errorf("unchanged white pixel = %v, want white", got)
+	}
+	if got := out.NRGBAAt(50, 5); got.R != got.G || got.R < 150 || got.R == 255 {
+		t.Errorf("unchanged dark pixel = %v, want a faded gray", got)
+	}
+
+	if _, ratio := Pixels(a, page(100, 100, 0, 10)); ratio != 0 {
+		t.Errorf("Pixels() of identical screenshots ratio = %v, want 0", ratio)
+	}
+}
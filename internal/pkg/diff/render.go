@@ -0,0 +1,145 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+)
+
+// Unified formats an edit script as a unified diff, with context lines of
+// unchanged text around each change.
+func Unified(fromName, toName string, edits []Edit, context int) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Lines within context of a change are shown; each run of them is a
+	// hunk.
+	shown := make([]bool, len(edits))
+	for i, e := range edits {
+		if e.Op == Equal {
+			continue
+		}
+		for j := max(i-context, 0); j <= min(i+context, len(edits)-1); j++ {
+			shown[j] = true
+		}
+	}
+
+	// a and b are the 1-based line numbers of the next old and new line.
+	a, b := 1, 1
+	for i := 0; i < len(edits); {
+		if !shown[i] {
+			a, b = advance(edits[i], a, b)
+			i++
+			continue
+		}
+
+		end := i
+		aLen, bLen := 0, 0
+		for ; end < len(edits) && shown[end]; end++ {
+			if edits[end].Op != Insert {
+				aLen++
+			}
+			if edits[end].Op != Delete {
+				bLen++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(a, aLen), hunkRange(b, bLen))
+		for ; i < end; i++ {
+			buf.WriteString(prefix(edits[i].Op) + edits[i].Text + "\n")
+			a, b = advance(edits[i], a, b)
+		}
+	}
+	return buf.Bytes()
+}
+
+func advance(e Edit, a, b int) (int, int) {
+	switch e.Op {
+	case Insert:
+		return a, b + 1
+	case Delete:
+		return a + 1, b
+	default:
+		return a + 1, b + 1
+	}
+}
+
+// hunkRange writes a hunk's start and length; an empty range starts at the
+// line before it, as diff(1) does.
+func hunkRange(start, length int) string {
+	if length == 0 {
+		start--
+	}
+	if length == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, length)
+}
+
+func prefix(op Op) string {
+	switch op {
+	case Insert:
+		return "+"
+	case Delete:
+		return "-"
+	default:
+		return " "
+	}
+}
+
+var htmlTemplate = template.Must(template.New("diff").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem; color: #1f2328; }
+h1 { font-size: 1.25rem; }
+.stats { color: #59636e; }
+.diff { font-family: ui-monospace, monospace; font-size: 0.875rem; border: 1px solid #d1d9e0; border-radius: 6px; }
+.line { white-space: pre-wrap; padding: 0 0.75rem; }
+.line::before { display: inline-block; width: 1.25rem; color: #59636e; }
+.insert { background: #dafbe1; }
+.insert::before { content: "+"; }
+.delete { background: #ffebe9; }
+.delete::before { content: "-"; }
+.equal::before { content: " "; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="stats">{{.Stats.Inserted}} lines added, {{.Stats.Deleted}} lines removed</p>
+<div class="diff">
+{{range .Lines}}<div class="line {{.Class}}">{{.Text}}</div>
+{{end}}</div>
+</body>
+</html>
+`))
+
+// HTML renders an edit script as a standalone page showing the whole text
+// with added lines in green and removed lines in red.
+func HTML(title string, edits []Edit) []byte {
+	type line struct {
+		Class string
+		Text  string
+	}
+	lines := make([]line, len(edits))
+	for i, e := range edits {
+		class := "equal"
+		switch e.Op {
+		case Insert:
+			class = "insert"
+		case Delete:
+			class = "delete"
+		}
+		lines[i] = line{Class: class, Text: e.Text}
+	}
+
+	var buf bytes.Buffer
+	// The template is fixed and the data well-formed, so it cannot fail.
+	_ = htmlTemplate.Execute(&buf, map[string]interface{}{
+		"Title": title,
+		"Stats": Summarize(edits),
+		"Lines": lines,
+	})
+	return buf.Bytes()
+}
@@ -0,0 +1,80 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		context int
+		want    string
+	}{
+		{
+			name:    "identical",
+			a:       "a b c",
+			b:       "a b c",
+			context: 3,
+			want:    "--- old\n+++ new\n",
+		},
+		{
+			name:    "one change with context",
+			a:       "1 2 3 4 5 6 7 8 9",
+			b:       "1 2 3 4 X 6 7 8 9",
+			context: 2,
+			want:    "--- old\n+++ new\n@@ -3,5 +3,5 @@\n 3\n 4\n-5\n+X\n 6\n 7\n",
+		},
+		{
+			name:    "separate hunks",
+			a:       "1 2 3 4 5 6 7 8 9",
+			b:       "X 2 3 4 5 6 7 8 Y",
+			context: 1,
+			want:    "--- old\n+++ new\n@@ -1,2 +1,2 @@\n-1\n+X\n 2\n@@ -8,2 +8,2 @@\n 8\n-9\n+Y\n",
+		},
+		{
+			name:    "insert only",
+			a:       "1 2",
+			b:       "1 2 3",
+			context: 0,
+			want:    "--- old\n+++ new\n@@ -2,0 +3 @@\n+3\n",
+		},
+		{
+			name:    "delete everything",
+			a:       "1 2",
+			b:       "",
+			context: 3,
+			want:    "--- old\n+++ new\n@@ -1,2 +0,0 @@\n-1\n-2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(Unified("old", "new", Lines(strings.Fields(tt.a), strings.Fields(tt.b)), tt.context))
+			if got != tt.want {
+				t.Errorf("Unified() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTML(t *testing.T) {
+	page := string(HTML("Staging vs production", Lines(
+		[]string{"Pricing", "Pro $49", "<Contact>"},
+		[]string{"Pricing", "Pro $59", "<Contact>"},
+	)))
+
+	for _, want := range []string{
+		"<title>Staging vs production</title>",
+		"1 lines added, 1 lines removed",
+		`<div class="line equal">Pricing</div>`,
+		`<div class="line delete">Pro $49</div>`,
+		`<div class="line insert">Pro $59</div>`,
+		`<div class="line equal">&lt;Contact&gt;</div>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("HTML() missing %q in\n%s", want, page)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package queue
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+
+	"pagemail/internal/models"
+)
+
+// BatchDeliveryPayload is the job payload for JobTypeDeliverBatch.
+type BatchDeliveryPayload struct {
+	BatchID string `json:"batch_id"`
+}
+
+// maxBatchDeliveryAttempts matches the attempts of the job sending it.
+const maxBatchDeliveryAttempts = 3
+
+// maxBatchDeliveryBytes bounds the files of a combined delivery, which are
+// held in memory and must fit the size limits of mail servers and webhook
+// receivers. Tests lower it.
+var maxBatchDeliveryBytes int64 = 20 << 20
+
+// errOverBudget is returned when a page's outputs do not fit what is left
+// of maxBatchDeliveryBytes.
+var errOverBudget = errors.New("outputs exceed the delivery size limit")
+
+// maxSlugLength bounds the part of a batch filename taken from its URL.
+const maxSlugLength = 60
+
+// finishBatchTask is called once a task of a batch has completed or failed
+// for good. When no task of the batch is left to run, the batch completes
+// and its combined delivery is enqueued.
+func (w *Worker) finishBatchTask(batchID uuid.UUID) {
+	var remaining int64
+	if err := w.db.Model(&models.CaptureTask{}).
+		Where("batch_id = ? AND status IN ?", batchID, []string{models.TaskStatusPending, models.TaskStatusRunning}).
+		Count(&remaining).Error; err != nil {
+		log.Error().Err(err).Str("batch_id", batchID.String()).Msg("Failed to count batch tasks")
+		return
+	}
+	if remaining > 0 {
+		return
+	}
+
+	// Workers finishing the last tasks at once may both get here; only
+	// the one that completes the batch enqueues its delivery.
+	result := w.db.Model(&models.CaptureBatch{}).
+		Where("id = ? AND completed_at IS NULL", batchID).
+		Update("completed_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+	log.Info().Str("batch_id", batchID.String()).Msg("Capture batch completed")
+
+	var batch models.CaptureBatch
+	if err := w.db.First(&batch, "id = ?", batchID).Error; err != nil {
+		log.Error().Err(err).Str("batch_id", batchID.String()).Msg("Failed to load batch")
+		return
+	}
+	if batch.DeliveryStatus != models.DeliveryStatusPending {
+		return
+	}
+	if err := EnqueueJob(w.db, models.JobTypeDeliverBatch, BatchDeliveryPayload{BatchID: batchID.String()}); err != nil {
+		log.Error().Err(err).Str("batch_id", batchID.String()).Msg("Failed to enqueue batch delivery job")
+	}
+}
+
+// ReopenBatch undoes the completion of a batch when one of its tasks is
+// retried, so the batch completes again once the retry finishes and its
+// combined delivery, re-armed here, includes the retried page.
+func ReopenBatch(db *gorm.DB, batchID uuid.UUID) error {
+	var batch models.CaptureBatch
+	if err := db.Select("id", "delivery_channel").First(&batch, "id = ?", batchID).Error; err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{"completed_at": nil}
+	if batch.DeliveryChannel != "" {
+		updates["delivery_status"] = models.DeliveryStatusPending
+		updates["delivery_attempts"] = 0
+		updates["delivery_error"] = ""
+		updates["delivered_at"] = nil
+	}
+	return db.Model(&batch).Updates(updates).Error
+}
+
+//nolint:gocritic // hugeParam: job from channel uses value type
+func (w *Worker) processBatchDelivery(ctx context.Context, job models.Job) error {
+	log.Info().Str("job_id", job.ID.String()).Msg("Processing batch delivery job")
+
+	var payload BatchDeliveryPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("failed to parse payload: %w", err)
+	}
+
+	batchID, err := uuid.Parse(payload.BatchID)
+	if err != nil {
+		return fmt.Errorf("invalid batch_id: %w", err)
+	}
+
+	var batch models.CaptureBatch
+	if err := w.db.First(&batch, "id = ?", batchID).Error; err != nil {
+		return fmt.Errorf("batch not found: %w", err)
+	}
+
+	if batch.DeliveryStatus != models.DeliveryStatusPending {
+		log.Warn().Str("batch_id", batchID.String()).Str("status", batch.DeliveryStatus).Msg("Batch delivery not pending, skipping")
+		return nil
+	}
+	// A batch reopened by a retry is delivered when it completes again.
+	if batch.CompletedAt == nil {
+		log.Info().Str("batch_id", batchID.String()).Msg("Batch reopened, delivering once it completes")
+		return nil
+	}
+
+	var target DeliveryTarget
+	if err := json.Unmarshal([]byte(batch.DeliveryTarget), &target); err != nil {
+		w.updateBatchDeliveryFailed(&batch, "invalid target config", true)
+		return nil
+	}
+
+	var tasks []models.CaptureTask
+	if err := w.db.Where("batch_id = ?", batch.ID).Order("batch_index ASC").Find(&tasks).Error; err != nil {
+		w.updateBatchDeliveryFailed(&batch, "failed to load tasks", false)
+		return fmt.Errorf("failed to load tasks: %w", err)
+	}
+
+	files, omitted, err := w.loadBatchFiles(ctx, &batch, tasks, target.Formats)
+	if err != nil {
+		w.updateBatchDeliveryFailed(&batch, err.Error(), false)
+		return err
+	}
+	if len(files) == 0 {
+		if len(omitted) > 0 {
+			w.updateBatchDeliveryFailed(&batch, errOverBudget.Error(), true)
+			return nil
+		}
+		if batchUnchanged(tasks) {
+			w.db.Model(&batch).Updates(map[string]interface{}{
+				"delivery_status": models.DeliveryStatusSkipped,
+				"delivery_error":  "no page changed",
+			})
+			return nil
+		}
+		w.updateBatchDeliveryFailed(&batch, "no outputs to deliver", true)
+		return nil
+	}
+
+	if batch.Combine == models.BatchCombineZip {
+		archive, err := zipFiles(files)
+		if err != nil {
+			w.updateBatchDeliveryFailed(&batch, err.Error(), true)
+			return nil
+		}
+		files = []deliveryFile{archive}
+	}
+
+	switch batch.DeliveryChannel {
+	case models.ChannelEmail:
+		err = w.sendEmailMessage(batch.UserID, &target, batchSubject(&batch), batchBody(&batch, tasks, omitted, len(files)), files)
+	case models.ChannelWebhook:
+		err = w.sendWebhookPayload(ctx, batch.UserID, &target, "batch.delivered", batchWebhookData(&batch, tasks, omitted), files)
+	default:
+		w.updateBatchDeliveryFailed(&batch, "unknown channel: "+batch.DeliveryChannel, true)
+		return nil
+	}
+
+	if err != nil {
+		w.updateBatchDeliveryFailed(&batch, err.Error(), false)
+		return err
+	}
+
+	w.db.Model(&batch).Updates(map[string]interface{}{
+		"delivery_status":   models.DeliveryStatusSent,
+		"delivery_attempts": batch.DeliveryAttempts + 1,
+		"delivery_error":    "",
+		"delivered_at":      time.Now(),
+	})
+
+	log.Info().
+		Str("batch_id", batchID.String()).
+		Str("channel", batch.DeliveryChannel).
+		Int("files", len(files)).
+		Int("omitted", len(omitted)).
+		Msg("Batch delivery sent successfully")
+
+	return nil
+}
+
+// loadBatchFiles reads the outputs of every deliverable task of a batch,
+// named after the task's position and page. Pages are taken in order until
+// maxBatchDeliveryBytes is reached; the outputs of a page that does not fit
+// are left out whole and its task is reported in omitted.
+func (w *Worker) loadBatchFiles(ctx context.Context, batch *models.CaptureBatch, tasks []models.CaptureTask, formats []string) ([]deliveryFile, map[uuid.UUID]bool, error) {
+	width := len(strconv.Itoa(batch.Total))
+	var files []deliveryFile
+	omitted := make(map[uuid.UUID]bool)
+	remaining := maxBatchDeliveryBytes
+	for i := range tasks {
+		if !batchDeliverable(&tasks[i]) {
+			continue
+		}
+
+		query := w.db.Where("task_id = ? AND diff_id IS NULL", tasks[i].ID)
+		if len(formats) > 0 {
+			query = query.Where("format IN ?", formats)
+		}
+		var outputs []models.CaptureOutput
+		if err := query.Order("created_at ASC").Find(&outputs).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed to load outputs: %w", err)
+		}
+
+		page, size, err := w.loadPageFiles(ctx, &tasks[i], width, outputs, remaining)
+		if errors.Is(err, errOverBudget) {
+			log.Warn().Str("batch_id", batch.ID.String()).Str("task_id", tasks[i].ID.String()).Msg("Batch page too large to deliver, leaving it out")
+			omitted[tasks[i].ID] = true
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, page...)
+		remaining -= size
+	}
+	return files, omitted, nil
+}
+
+// loadPageFiles reads the outputs of one task of a batch, failing with
+// errOverBudget as soon as they are known to exceed budget bytes. The
+// recorded sizes are checked first so oversized pages are not downloaded.
+func (w *Worker) loadPageFiles(ctx context.Context, task *models.CaptureTask, width int, outputs []models.CaptureOutput, budget int64) ([]deliveryFile, int64, error) {
+	var declared int64
+	for i := range outputs {
+		declared += outputs[i].SizeBytes
+	}
+	if declared > budget {
+		return nil, 0, errOverBudget
+	}
+
+	files := make([]deliveryFile, 0, len(outputs))
+	var size int64
+	for i := range outputs {
+		data, err := w.readOutputLimited(ctx, &outputs[i], budget-size)
+		if err != nil {
+			return nil, 0, err
+		}
+		size += int64(len(data))
+		files = append(files, deliveryFile{
+			Filename:    batchFilename(task, width, &outputs[i]),
+			ContentType: outputs[i].ContentType,
+			Data:        data,
+		})
+	}
+	return files, size, nil
+}
+
+// readOutputLimited reads an output, failing with errOverBudget if it is
+// larger than limit bytes.
+func (w *Worker) readOutputLimited(ctx context.Context, output *models.CaptureOutput, limit int64) ([]byte, error) {
+	reader, _, err := w.storage.Download(ctx, output.ObjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", output.Format, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", output.Format, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, errOverBudget
+	}
+	return data, nil
+}
+
+// batchDeliverable reports whether a task's outputs go in the combined
+// delivery: it completed and, when monitored, the page changed.
+func batchDeliverable(task *models.CaptureTask) bool {
+	return task.Status == models.TaskStatusCompleted && (task.Changed == nil || *task.Changed)
+}
+
+// batchUnchanged reports whether some task completed but none is
+// deliverable, because no monitored page changed.
+func batchUnchanged(tasks []models.CaptureTask) bool {
+	completed := false
+	for i := range tasks {
+		if tasks[i].Status != models.TaskStatusCompleted {
+			continue
+		}
+		completed = true
+		if batchDeliverable(&tasks[i]) {
+			return false
+		}
+	}
+	return completed
+}
+
+// batchFilename names an output after its task's position in the batch
+// and its page, such as "07-example.com-pricing-pdf.pdf".
+func batchFilename(task *models.CaptureTask, width int, output *models.CaptureOutput) string {
+	name := fmt.Sprintf("%0*d", width, task.BatchIndex+1)
+	if slug := urlSlug(task.URL); slug != "" {
+		name += "-" + slug
+	}
+	return name + "-" + output.Format + path.Ext(output.ObjectKey)
+}
+
+// urlSlug reduces a URL's host and path to lowercase letters, digits,
+// dots and dashes.
+func urlSlug(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(u.Host + u.Path) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' {
+			sb.WriteRune(r)
+			dash = false
+		} else if !dash {
+			sb.WriteByte('-')
+			dash = true
+		}
+	}
+
+	slug := strings.Trim(sb.String(), "-.")
+	if len(slug) > maxSlugLength {
+		slug = strings.TrimRight(slug[:maxSlugLength], "-.")
+	}
+	return slug
+}
+
+// zipFiles packs files into a single archive. Its input is bounded by
+// maxBatchDeliveryBytes, and so is the archive.
+func zipFiles(files []deliveryFile) (deliveryFile, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	now := time.Now()
+	for i := range files {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: files[i].Filename, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return deliveryFile{}, fmt.Errorf("failed to create zip entry: %w", err)
+		}
+		if _, err := f.Write(files[i].Data); err != nil {
+			return deliveryFile{}, fmt.Errorf("failed to write zip entry: %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return deliveryFile{}, fmt.Errorf("failed to finish zip: %w", err)
+	}
+
+	return deliveryFile{
+		Filename:    "capture-batch.zip",
+		ContentType: "application/zip",
+		Data:        buf.Bytes(),
+	}, nil
+}
+
+func batchSubject(batch *models.CaptureBatch) string {
+	if batch.Name != "" {
+		return "Pagemail batch: " + batch.Name
+	}
+	return fmt.Sprintf("Pagemail batch: %d pages", batch.Total)
+}
+
+// batchBody lists every page of the batch by position, noting those that
+// are not attached and why.
+func batchBody(batch *models.CaptureBatch, tasks []models.CaptureTask, omitted map[uuid.UUID]bool, attached int) string {
+	width := len(strconv.Itoa(batch.Total))
+	completed := 0
+	var lines strings.Builder
+	for i := range tasks {
+		fmt.Fprintf(&lines, "%0*d %s", width, tasks[i].BatchIndex+1, tasks[i].URL)
+		switch {
+		case tasks[i].Status == models.TaskStatusCompleted && !batchDeliverable(&tasks[i]):
+			completed++
+			lines.WriteString(" (unchanged)")
+		case tasks[i].Status == models.TaskStatusCompleted && omitted[tasks[i].ID]:
+			completed++
+			lines.WriteString(" (left out: too large to attach)")
+		case tasks[i].Status == models.TaskStatusCompleted:
+			completed++
+		default:
+			fmt.Fprintf(&lines, " (failed: %s)", tasks[i].ErrorMessage)
+		}
+		lines.WriteString("\n")
+	}
+
+	finishedAt := batch.UpdatedAt
+	if batch.CompletedAt != nil {
+		finishedAt = *batch.CompletedAt
+	}
+	return fmt.Sprintf("%d of %d pages were captured by %s.\n\n%s\nAttached files: %d\n",
+		completed, batch.Total, finishedAt.UTC().Format(time.RFC1123), lines.String(), attached)
+}
+
+func batchWebhookData(batch *models.CaptureBatch, tasks []models.CaptureTask, omitted map[uuid.UUID]bool) map[string]interface{} {
+	list := make([]map[string]interface{}, len(tasks))
+	for i := range tasks {
+		list[i] = map[string]interface{}{
+			"index":   tasks[i].BatchIndex + 1,
+			"task_id": tasks[i].ID.String(),
+			"url":     tasks[i].URL,
+			"status":  tasks[i].Status,
+			"changed": tasks[i].Changed,
+			"omitted": omitted[tasks[i].ID],
+		}
+	}
+
+	return map[string]interface{}{
+		"batch_id":     batch.ID.String(),
+		"name":         batch.Name,
+		"completed_at": batch.CompletedAt,
+		"tasks":        list,
+	}
+}
+
+// updateBatchDeliveryFailed records a failed attempt at a batch's combined
+// delivery, as updateDeliveryFailed does for a task's.
+func (w *Worker) updateBatchDeliveryFailed(batch *models.CaptureBatch, errMsg string, permanent bool) {
+	batch.DeliveryAttempts++
+	updates := map[string]interface{}{
+		"delivery_attempts": batch.DeliveryAttempts,
+		"delivery_error":    errMsg,
+	}
+	if permanent || batch.DeliveryAttempts >= maxBatchDeliveryAttempts {
+		updates["delivery_status"] = models.DeliveryStatusFailed
+	}
+
+	w.db.Model(batch).Updates(updates)
+	log.Warn().
+		Str("batch_id", batch.ID.String()).
+		Int("attempt", batch.DeliveryAttempts).
+		Str("error", errMsg).
+		Msg("Batch delivery failed")
+}
@@ -0,0 +1,353 @@
+package queue
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"pagemail/internal/config"
+	"pagemail/internal/models"
+	"pagemail/internal/storage"
+)
+
+func TestBatchFilename(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		index  int
+		width  int
+		format string
+		key    string
+		want   string
+	}{
+		{"page", "https://Example.com/pricing/", 6, 2, "pdf", "captures/x_pdf.pdf", "07-example.com-pricing-pdf.pdf"},
+		{"query and port", "http://localhost:8080/a?b=c", 0, 1, "screenshot", "captures/x_screenshot.png", "1-localhost-8080-a-screenshot.png"},
+		{"long path", "https://example.com/" + strings.Repeat("a", 100), 0, 3, "markdown", "k.md", "001-example.com-" + strings.Repeat("a", 48) + "-markdown.md"},
+		{"unparsable", "://", 0, 1, "pdf", "k.pdf", "1-pdf.pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &models.CaptureTask{URL: tt.url, BatchIndex: tt.index}
+			got := batchFilename(task, tt.width, &models.CaptureOutput{Format: tt.format, ObjectKey: tt.key})
+			if got != tt.want {
+				t.Errorf("batchFilename() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchDelivery(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.User{}, &models.WebhookEndpoint{}, &models.CaptureBatch{}, &models.CaptureTask{}, &models.CaptureOutput{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("Failed to parse multipart form: %v", err)
+		}
+		for _, fh := range r.MultipartForm.File["files"] {
+			received = append(received, fh.Filename)
+			f, _ := fh.Open()
+			data, _ := io.ReadAll(f)
+			f.Close()
+			zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Errorf("Webhook file %s is not a zip: %v", fh.Filename, err)
+				continue
+			}
+			for _, entry := range zr.File {
+				received = append(received, entry.Name)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	store, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	db.Create(&user)
+	webhook := models.WebhookEndpoint{UserID: user.ID, Name: "hook", URL: server.URL, IsActive: true}
+	db.Create(&webhook)
+	batch := models.CaptureBatch{
+		UserID:          user.ID,
+		Total:           3,
+		DeliveryChannel: models.ChannelWebhook,
+		DeliveryTarget:  `{"id":"` + webhook.ID.String() + `","formats":["pdf"]}`,
+		Combine:         models.BatchCombineZip,
+		DeliveryStatus:  models.DeliveryStatusPending,
+	}
+	db.Create(&batch)
+
+	var tasks []models.CaptureTask
+	for i, u := range []string{"https://a.example.com/", "https://b.example.com/", "https://c.example.com/"} {
+		task := models.CaptureTask{UserID: user.ID, URL: u, Status: models.TaskStatusRunning, BatchID: &batch.ID, BatchIndex: i}
+		db.Create(&task)
+		tasks = append(tasks, task)
+		for _, format := range []string{"pdf", "html"} {
+			key := "captures/" + task.ID.String() + "_" + format + "." + format
+			if _, err := store.Upload(context.Background(), key, strings.NewReader(format+" data"), "text/plain"); err != nil {
+				t.Fatalf("Failed to upload output: %v", err)
+			}
+			db.Create(&models.CaptureOutput{TaskID: task.ID, Format: format, StorageBackend: "local", ObjectKey: key, ContentType: "text/plain"})
+		}
+	}
+
+	cfg := &config.Config{Encryption: config.EncryptionConfig{Key: "test-encryption-key-32-bytes!!!!"}}
+	w := NewWorker(0, cfg, db, store, nil, nil)
+	countJobs := func() int64 {
+		var n int64
+		db.Model(&models.Job{}).Where("type = ?", models.JobTypeDeliverBatch).Count(&n)
+		return n
+	}
+
+	// The batch waits for its last task.
+	db.Model(&tasks[0]).Update("status", models.TaskStatusCompleted)
+	w.finishBatchTask(batch.ID)
+	db.Model(&tasks[1]).Update("status", models.TaskStatusFailed)
+	w.finishBatchTask(batch.ID)
+	if n := countJobs(); n != 0 {
+		t.Fatalf("Enqueued %d batch deliveries before the batch finished, want 0", n)
+	}
+
+	db.Model(&tasks[2]).Update("status", models.TaskStatusCompleted)
+	w.finishBatchTask(batch.ID)
+	w.finishBatchTask(batch.ID)
+	if n := countJobs(); n != 1 {
+		t.Fatalf("Enqueued %d batch deliveries, want 1", n)
+	}
+
+	var job models.Job
+	db.Where("type = ?", models.JobTypeDeliverBatch).First(&job)
+	if err := w.processBatchDelivery(context.Background(), job); err != nil {
+		t.Fatalf("processBatchDelivery() error = %v", err)
+	}
+
+	want := []string{"capture-batch.zip", "1-a.example.com-pdf.pdf", "3-c.example.com-pdf.pdf"}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("Webhook received %v, want %v", received, want)
+	}
+
+	db.First(&batch, "id = ?", batch.ID)
+	if batch.CompletedAt == nil || batch.DeliveryStatus != models.DeliveryStatusSent || batch.DeliveryAttempts != 1 || batch.DeliveredAt == nil {
+		t.Errorf("Batch after delivery = %+v", batch)
+	}
+
+	// A second run finds the delivery already sent.
+	if err := w.processBatchDelivery(context.Background(), job); err != nil {
+		t.Fatalf("processBatchDelivery() again error = %v", err)
+	}
+	if len(received) != len(want) {
+		t.Errorf("Webhook received %d files after the batch was delivered, want %d", len(received), len(want))
+	}
+
+	// Retrying the failed task reopens the batch. A delivery job still
+	// queued waits, and the batch is delivered again with the retried page
+	// once it completes.
+	if err := ReopenBatch(db, batch.ID); err != nil {
+		t.Fatalf("ReopenBatch() error = %v", err)
+	}
+	db.Model(&tasks[1]).Update("status", models.TaskStatusPending)
+	var reopened models.CaptureBatch
+	db.First(&reopened, "id = ?", batch.ID)
+	if reopened.CompletedAt != nil || reopened.DeliveryStatus != models.DeliveryStatusPending || reopened.DeliveredAt != nil {
+		t.Errorf("Batch after reopening = %+v, want it running with its delivery pending", reopened)
+	}
+
+	received = nil
+	if err := w.processBatchDelivery(context.Background(), job); err != nil {
+		t.Fatalf("processBatchDelivery() while reopened error = %v", err)
+	}
+	if len(received) != 0 {
+		t.Errorf("Webhook received %v before the retried task finished, want nothing", received)
+	}
+
+	db.Model(&tasks[1]).Update("status", models.TaskStatusCompleted)
+	w.finishBatchTask(batch.ID)
+	if n := countJobs(); n != 2 {
+		t.Fatalf("Enqueued %d batch deliveries after the retry, want 2", n)
+	}
+	var again models.Job
+	db.Where("type = ? AND id <> ?", models.JobTypeDeliverBatch, job.ID).First(&again)
+	if err := w.processBatchDelivery(context.Background(), again); err != nil {
+		t.Fatalf("processBatchDelivery() after the retry error = %v", err)
+	}
+	want = []string{"capture-batch.zip", "1-a.example.com-pdf.pdf", "2-b.example.com-pdf.pdf", "3-c.example.com-pdf.pdf"}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("Webhook received %v after the retry, want %v", received, want)
+	}
+}
+
+func TestBatchDeliverySizeLimit(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.User{}, &models.WebhookEndpoint{}, &models.CaptureBatch{}, &models.CaptureTask{}, &models.CaptureOutput{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	var received []string
+	var omitted []bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("Failed to parse multipart form: %v", err)
+		}
+		for _, fh := range r.MultipartForm.File["files"] {
+			received = append(received, fh.Filename)
+		}
+		var payload struct {
+			Data struct {
+				Tasks []struct {
+					Omitted bool `json:"omitted"`
+				} `json:"tasks"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(r.FormValue("payload")), &payload); err != nil {
+			t.Errorf("Failed to parse payload: %v", err)
+		}
+		for _, task := range payload.Data.Tasks {
+			omitted = append(omitted, task.Omitted)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	store, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	db.Create(&user)
+	webhook := models.WebhookEndpoint{UserID: user.ID, Name: "hook", URL: server.URL, IsActive: true}
+	db.Create(&webhook)
+
+	orig := maxBatchDeliveryBytes
+	maxBatchDeliveryBytes = 50
+	t.Cleanup(func() { maxBatchDeliveryBytes = orig })
+
+	// Each page has one output: its data and the size recorded for it.
+	type page struct {
+		data     string
+		declared int64
+	}
+	tests := []struct {
+		name        string
+		pages       []page
+		wantFiles   []string
+		wantOmitted []bool
+		wantStatus  string
+	}{
+		{
+			name:        "pages over the limit are left out",
+			pages:       []page{{strings.Repeat("a", 30), 30}, {strings.Repeat("b", 30), 30}, {strings.Repeat("c", 10), 10}},
+			wantFiles:   []string{"1-a.example.com-pdf.pdf", "3-c.example.com-pdf.pdf"},
+			wantOmitted: []bool{false, true, false},
+			wantStatus:  models.DeliveryStatusSent,
+		},
+		{
+			name:        "recorded size understated",
+			pages:       []page{{strings.Repeat("a", 60), 1}, {strings.Repeat("b", 10), 10}},
+			wantFiles:   []string{"2-b.example.com-pdf.pdf"},
+			wantOmitted: []bool{true, false},
+			wantStatus:  models.DeliveryStatusSent,
+		},
+		{
+			name:       "every page too large",
+			pages:      []page{{strings.Repeat("a", 60), 60}},
+			wantStatus: models.DeliveryStatusFailed,
+		},
+	}
+
+	cfg := &config.Config{Encryption: config.EncryptionConfig{Key: "test-encryption-key-32-bytes!!!!"}}
+	w := NewWorker(0, cfg, db, store, nil, nil)
+	completedAt := time.Now()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received, omitted = nil, nil
+			batch := models.CaptureBatch{
+				UserID:          user.ID,
+				Total:           len(tt.pages),
+				DeliveryChannel: models.ChannelWebhook,
+				DeliveryTarget:  `{"id":"` + webhook.ID.String() + `"}`,
+				Combine:         models.BatchCombineAttachments,
+				DeliveryStatus:  models.DeliveryStatusPending,
+				CompletedAt:     &completedAt,
+			}
+			db.Create(&batch)
+
+			for i, p := range tt.pages {
+				task := models.CaptureTask{UserID: user.ID, URL: "https://" + string(rune('a'+i)) + ".example.com/", Status: models.TaskStatusCompleted, BatchID: &batch.ID, BatchIndex: i}
+				db.Create(&task)
+				key := "captures/" + uuid.NewString() + ".pdf"
+				if _, err := store.Upload(context.Background(), key, strings.NewReader(p.data), "application/pdf"); err != nil {
+					t.Fatalf("Failed to upload output: %v", err)
+				}
+				db.Create(&models.CaptureOutput{TaskID: task.ID, Format: "pdf", StorageBackend: "local", ObjectKey: key, ContentType: "application/pdf", SizeBytes: p.declared})
+			}
+
+			job := models.Job{Type: models.JobTypeDeliverBatch, Payload: `{"batch_id":"` + batch.ID.String() + `"}`}
+			if err := w.processBatchDelivery(context.Background(), job); err != nil {
+				t.Fatalf("processBatchDelivery() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(received, tt.wantFiles) {
+				t.Errorf("Webhook received %v, want %v", received, tt.wantFiles)
+			}
+			if !reflect.DeepEqual(omitted, tt.wantOmitted) {
+				t.Errorf("Webhook reported omitted %v, want %v", omitted, tt.wantOmitted)
+			}
+			db.First(&batch, "id = ?", batch.ID)
+			if batch.DeliveryStatus != tt.wantStatus {
+				t.Errorf("Batch delivery status = %q (%s), want %q", batch.DeliveryStatus, batch.DeliveryError, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestBatchBody(t *testing.T) {
+	changed, unchanged := true, false
+	batch := &models.CaptureBatch{Total: 4}
+	tasks := []models.CaptureTask{
+		{URL: "https://a.example.com/", Status: models.TaskStatusCompleted, Changed: &changed},
+		{URL: "https://b.example.com/", Status: models.TaskStatusFailed, ErrorMessage: "timeout", BatchIndex: 1},
+		{URL: "https://c.example.com/", Status: models.TaskStatusCompleted, Changed: &unchanged, BatchIndex: 2},
+		{ID: uuid.New(), URL: "https://d.example.com/", Status: models.TaskStatusCompleted, BatchIndex: 3},
+	}
+
+	body := batchBody(batch, tasks, map[uuid.UUID]bool{tasks[3].ID: true}, 1)
+	for _, want := range []string{
+		"3 of 4 pages were captured",
+		"1 https://a.example.com/\n",
+		"2 https://b.example.com/ (failed: timeout)\n",
+		"3 https://c.example.com/ (unchanged)\n",
+		"4 https://d.example.com/ (left out: too large to attach)\n",
+		"Attached files: 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("batchBody() missing %q in\n%s", want, body)
+		}
+	}
+
+	if batchUnchanged(tasks) {
+		t.Error("batchUnchanged() = true with a changed page")
+	}
+	if !batchUnchanged(tasks[1:3]) {
+		t.Error("batchUnchanged() = false with only an unchanged page completed")
+	}
+}
@@ -0,0 +1,372 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+
+	"pagemail/internal/models"
+	"pagemail/internal/notify"
+	"pagemail/internal/pkg/crypto"
+)
+
+// DeliveryPayload is the job payload for JobTypeDeliver.
+type DeliveryPayload struct {
+	DeliveryID string `json:"delivery_id"`
+}
+
+// DeliveryTarget is stored as JSON in Delivery.TargetConfig.
+// ID refers to an SMTP profile for email deliveries and to a webhook
+// endpoint for webhook deliveries. An empty Formats list means every
+// output of the task is delivered.
+type DeliveryTarget struct {
+	ID         string   `json:"id"`
+	Recipients []string `json:"recipients,omitempty"`
+	Formats    []string `json:"formats,omitempty"`
+}
+
+type deliveryFile struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+func (w *Worker) enqueuePendingDeliveries(taskID uuid.UUID) {
+	var deliveries []models.Delivery
+	if err := w.db.Where("task_id = ? AND status = ?", taskID, models.DeliveryStatusPending).Find(&deliveries).Error; err != nil {
+		log.Error().Err(err).Str("task_id", taskID.String()).Msg("Failed to load deliveries")
+		return
+	}
+
+	for i := range deliveries {
+		payload := DeliveryPayload{DeliveryID: deliveries[i].ID.String()}
+		if err := EnqueueJob(w.db, models.JobTypeDeliver, payload); err != nil {
+			log.Error().Err(err).Str("delivery_id", deliveries[i].ID.String()).Msg("Failed to enqueue delivery job")
+		}
+	}
+}
+
+// captureFailedPrefix starts the last error of deliveries failed along with
+// their capture.
+const captureFailedPrefix = "capture failed: "
+
+// failPendingDeliveries marks the pending deliveries of a task that failed
+// for good as failed, since there is nothing to send.
+func (w *Worker) failPendingDeliveries(taskID uuid.UUID, errMsg string) {
+	if err := w.db.Model(&models.Delivery{}).
+		Where("task_id = ? AND status = ?", taskID, models.DeliveryStatusPending).
+		Updates(map[string]interface{}{
+			"status":       models.DeliveryStatusFailed,
+			"last_error":   captureFailedPrefix + errMsg,
+			"completed_at": time.Now(),
+		}).Error; err != nil {
+		log.Error().Err(err).Str("task_id", taskID.String()).Msg("Failed to fail deliveries")
+	}
+}
+
+// ResetFailedDeliveries moves the deliveries that failed along with a
+// capture back to pending, so they are sent if a retry of the capture
+// succeeds.
+func ResetFailedDeliveries(db *gorm.DB, taskID uuid.UUID) error {
+	return db.Model(&models.Delivery{}).
+		Where("task_id = ? AND status = ? AND last_error LIKE ?", taskID, models.DeliveryStatusFailed, captureFailedPrefix+"%").
+		Updates(map[string]interface{}{
+			"status":        models.DeliveryStatusPending,
+			"attempts":      0,
+			"last_error":    "",
+			"next_retry_at": nil,
+			"completed_at":  nil,
+		}).Error
+}
+
+//nolint:gocritic // hugeParam: job from channel uses value type
+func (w *Worker) processDelivery(ctx context.Context, job models.Job) error {
+	log.Info().Str("job_id", job.ID.String()).Msg("Processing delivery job")
+
+	var payload DeliveryPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("failed to parse payload: %w", err)
+	}
+
+	deliveryID, err := uuid.Parse(payload.DeliveryID)
+	if err != nil {
+		return fmt.Errorf("invalid delivery_id: %w", err)
+	}
+
+	var delivery models.Delivery
+	if err := w.db.First(&delivery, "id = ?", deliveryID).Error; err != nil {
+		return fmt.Errorf("delivery not found: %w", err)
+	}
+
+	if delivery.Status != models.DeliveryStatusPending {
+		log.Warn().Str("delivery_id", deliveryID.String()).Str("status", delivery.Status).Msg("Delivery not pending, skipping")
+		return nil
+	}
+
+	var task models.CaptureTask
+	if err := w.db.First(&task, "id = ?", delivery.TaskID).Error; err != nil {
+		w.updateDeliveryFailed(&delivery, "capture task not found", true)
+		return nil
+	}
+
+	var target DeliveryTarget
+	if err := json.Unmarshal([]byte(delivery.TargetConfig), &target); err != nil {
+		w.updateDeliveryFailed(&delivery, "invalid target config", true)
+		return nil
+	}
+
+	files, err := w.loadDeliveryFiles(ctx, task.ID, target.Formats)
+	if err != nil {
+		w.updateDeliveryFailed(&delivery, err.Error(), false)
+		return err
+	}
+
+	switch delivery.Channel {
+	case models.ChannelEmail:
+		err = w.sendEmail(&task, &target, files)
+	case models.ChannelWebhook:
+		err = w.sendWebhook(ctx, &task, &delivery, &target, files)
+	default:
+		w.updateDeliveryFailed(&delivery, "unknown channel: "+delivery.Channel, true)
+		return nil
+	}
+
+	if err != nil {
+		w.updateDeliveryFailed(&delivery, err.Error(), false)
+		return err
+	}
+
+	now := time.Now()
+	w.db.Model(&delivery).Updates(map[string]interface{}{
+		"status":        models.DeliveryStatusSent,
+		"attempts":      delivery.Attempts + 1,
+		"last_error":    "",
+		"next_retry_at": nil,
+		"completed_at":  now,
+	})
+
+	log.Info().
+		Str("delivery_id", deliveryID.String()).
+		Str("channel", delivery.Channel).
+		Int("files", len(files)).
+		Msg("Delivery sent successfully")
+
+	return nil
+}
+
+func (w *Worker) loadDeliveryFiles(ctx context.Context, taskID uuid.UUID, formats []string) ([]deliveryFile, error) {
+	query := w.db.Where("task_id = ? AND diff_id IS NULL", taskID)
+	if len(formats) > 0 {
+		query = query.Where("format IN ?", formats)
+	}
+
+	var outputs []models.CaptureOutput
+	if err := query.Order("created_at ASC").Find(&outputs).Error; err != nil {
+		return nil, fmt.Errorf("failed to load outputs: %w", err)
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("no outputs to deliver")
+	}
+
+	files := make([]deliveryFile, 0, len(outputs))
+	for i := range outputs {
+		data, err := w.readOutput(ctx, &outputs[i])
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, deliveryFile{
+			Filename:    "capture-" + outputs[i].Format + path.Ext(outputs[i].ObjectKey),
+			ContentType: outputs[i].ContentType,
+			Data:        data,
+		})
+	}
+
+	return files, nil
+}
+
+func (w *Worker) readOutput(ctx context.Context, output *models.CaptureOutput) ([]byte, error) {
+	reader, _, err := w.storage.Download(ctx, output.ObjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", output.Format, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", output.Format, err)
+	}
+	return data, nil
+}
+
+func (w *Worker) sendEmail(task *models.CaptureTask, target *DeliveryTarget, files []deliveryFile) error {
+	capturedAt := task.CreatedAt
+	if task.CompletedAt != nil {
+		capturedAt = *task.CompletedAt
+	}
+
+	return w.sendEmailMessage(task.UserID, target, "Pagemail capture: "+task.URL,
+		fmt.Sprintf("The page %s was captured at %s.\n\nAttached files: %d\n",
+			task.URL, capturedAt.UTC().Format(time.RFC1123), len(files)),
+		files)
+}
+
+// sendEmailMessage sends files through the user's SMTP profile named by
+// target, to its recipients or else to the user.
+func (w *Worker) sendEmailMessage(userID uuid.UUID, target *DeliveryTarget, subject, body string, files []deliveryFile) error {
+	var profile models.SMTPProfile
+	if err := w.db.Where("id = ? AND user_id = ?", target.ID, userID).First(&profile).Error; err != nil {
+		return fmt.Errorf("SMTP profile not found")
+	}
+
+	recipients := target.Recipients
+	if len(recipients) == 0 {
+		var user models.User
+		if err := w.db.First(&user, "id = ?", userID).Error; err != nil {
+			return fmt.Errorf("no recipients and task owner not found")
+		}
+		recipients = []string{user.Email}
+	}
+
+	password := ""
+	if len(profile.PasswordEnc) > 0 {
+		encryptor, err := crypto.NewEncryptor(w.cfg.Encryption.Key)
+		if err != nil {
+			return fmt.Errorf("encryption error: %w", err)
+		}
+		password, err = encryptor.DecryptToString(profile.PasswordEnc)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt SMTP password: %w", err)
+		}
+	}
+
+	sender := notify.NewSMTPSender(&notify.SMTPConfig{
+		Host:      profile.Host,
+		Port:      profile.Port,
+		Username:  profile.Username,
+		Password:  password,
+		FromName:  profile.FromName,
+		FromEmail: profile.FromEmail,
+		UseTLS:    profile.UseTLS,
+	})
+
+	attachments := make([]notify.Attachment, len(files))
+	for i := range files {
+		attachments[i] = notify.Attachment{
+			Filename:    files[i].Filename,
+			ContentType: files[i].ContentType,
+			Reader:      bytes.NewReader(files[i].Data),
+		}
+	}
+
+	return sender.Send(&notify.EmailMessage{
+		To:          recipients,
+		Subject:     subject,
+		Body:        body,
+		Attachments: attachments,
+	})
+}
+
+func (w *Worker) sendWebhook(ctx context.Context, task *models.CaptureTask, delivery *models.Delivery, target *DeliveryTarget, files []deliveryFile) error {
+	return w.sendWebhookPayload(ctx, task.UserID, target, "capture.delivered", map[string]interface{}{
+		"delivery_id":  delivery.ID.String(),
+		"task_id":      task.ID.String(),
+		"url":          task.URL,
+		"completed_at": task.CompletedAt,
+	}, files)
+}
+
+// sendWebhookPayload posts event to the user's webhook endpoint named by
+// target, with files attached and listed under "outputs" in data.
+func (w *Worker) sendWebhookPayload(ctx context.Context, userID uuid.UUID, target *DeliveryTarget, event string, data map[string]interface{}, files []deliveryFile) error {
+	var endpoint models.WebhookEndpoint
+	if err := w.db.Where("id = ? AND user_id = ?", target.ID, userID).First(&endpoint).Error; err != nil {
+		return fmt.Errorf("webhook endpoint not found")
+	}
+	if !endpoint.IsActive {
+		return fmt.Errorf("webhook endpoint is inactive")
+	}
+
+	secret := ""
+	if endpoint.Secret != "" {
+		encryptor, err := crypto.NewEncryptor(w.cfg.Encryption.Key)
+		if err != nil {
+			return fmt.Errorf("encryption error: %w", err)
+		}
+		secret, err = encryptor.DecryptToString([]byte(endpoint.Secret))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt webhook secret: %w", err)
+		}
+	}
+
+	var headers map[string]string
+	if endpoint.Headers != "" {
+		if err := json.Unmarshal([]byte(endpoint.Headers), &headers); err != nil {
+			return fmt.Errorf("invalid webhook headers: %w", err)
+		}
+	}
+
+	sender := notify.NewWebhookSender(&notify.WebhookConfig{
+		URL:     endpoint.URL,
+		Secret:  secret,
+		Headers: headers,
+	})
+
+	outputs := make([]map[string]interface{}, len(files))
+	attachments := make([]notify.WebhookAttachment, len(files))
+	for i := range files {
+		outputs[i] = map[string]interface{}{
+			"filename":     files[i].Filename,
+			"content_type": files[i].ContentType,
+			"size":         len(files[i].Data),
+		}
+		attachments[i] = notify.WebhookAttachment{
+			Filename:    files[i].Filename,
+			ContentType: files[i].ContentType,
+			Reader:      bytes.NewReader(files[i].Data),
+		}
+	}
+
+	data["outputs"] = outputs
+	payload := &notify.WebhookPayload{
+		Event:     event,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Data:      data,
+	}
+
+	return sender.Send(ctx, payload, attachments)
+}
+
+// updateDeliveryFailed records a failed attempt. Permanent failures, or
+// failures on the final attempt, mark the delivery as failed; otherwise
+// the delivery stays pending until the job is retried.
+func (w *Worker) updateDeliveryFailed(delivery *models.Delivery, errMsg string, permanent bool) {
+	delivery.Attempts++
+	updates := map[string]interface{}{
+		"attempts":   delivery.Attempts,
+		"last_error": errMsg,
+	}
+
+	if permanent || delivery.Attempts >= delivery.MaxAttempts {
+		updates["status"] = models.DeliveryStatusFailed
+		updates["next_retry_at"] = nil
+		updates["completed_at"] = time.Now()
+	} else {
+		updates["next_retry_at"] = time.Now().Add(retryDelay(delivery.Attempts))
+	}
+
+	w.db.Model(delivery).Updates(updates)
+	log.Warn().
+		Str("delivery_id", delivery.ID.String()).
+		Int("attempt", delivery.Attempts).
+		Str("error", errMsg).
+		Msg("Delivery failed")
+}
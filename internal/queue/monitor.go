@@ -0,0 +1,260 @@
+package queue
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+
+	"pagemail/internal/capture"
+	"pagemail/internal/models"
+	"pagemail/internal/pkg/diff"
+)
+
+const (
+	// maxSummaryLines bounds the added and removed lines quoted in a
+	// change summary.
+	maxSummaryLines = 20
+	// maxSummaryLineLength truncates each quoted line, in characters.
+	maxSummaryLineLength = 300
+	// maxConcurrentSnapshotDecodes bounds the snapshot screenshots decoded
+	// at once across workers.
+	maxConcurrentSnapshotDecodes = 2
+)
+
+// snapshotDecodeSlots holds one token per snapshot screenshot being decoded.
+var snapshotDecodeSlots = make(chan struct{}, maxConcurrentSnapshotDecodes)
+
+// changeSummary is stored on monitored tasks as their ChangeSummary.
+type changeSummary struct {
+	Changed bool `json:"changed"`
+	// Baseline marks the first capture of a page, which has nothing to be
+	// compared with and is always delivered.
+	Baseline       bool          `json:"baseline,omitempty"`
+	PreviousTaskID *uuid.UUID    `json:"previous_task_id,omitempty"`
+	Threshold      float64       `json:"threshold"`
+	Text           *textChange   `json:"text,omitempty"`
+	Visual         *visualChange `json:"visual,omitempty"`
+	// ChangedOutputs lists the formats whose file differs from the
+	// previous capture's, by SHA-256.
+	ChangedOutputs []string `json:"changed_outputs,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+type textChange struct {
+	Ratio        float64  `json:"ratio"`
+	Added        int      `json:"added"`
+	Removed      int      `json:"removed"`
+	AddedLines   []string `json:"added_lines,omitempty"`
+	RemovedLines []string `json:"removed_lines,omitempty"`
+}
+
+type visualChange struct {
+	Ratio float64 `json:"ratio"`
+}
+
+// detectChange compares a monitored capture with the previous capture of
+// the same page, stores its snapshot for the next one and returns the
+// change summary. Captures that cannot be compared count as changed, so
+// they are still delivered.
+func detectChange(db *gorm.DB, task *models.CaptureTask, opts *capture.MonitorOptions, snapshot *capture.Snapshot, outputs []models.CaptureOutput) *changeSummary {
+	summary := &changeSummary{Changed: true, Threshold: opts.Threshold}
+	if snapshot == nil {
+		summary.Error = "page snapshot failed"
+		return summary
+	}
+
+	current := newCaptureSnapshot(task.ID, snapshot)
+	if err := db.Save(current).Error; err != nil {
+		log.Error().Err(err).Str("task_id", task.ID.String()).Msg("Failed to save page snapshot")
+	}
+	previous, err := previousSnapshot(db, task)
+	if err != nil {
+		summary.Baseline = true
+		return summary
+	}
+
+	summary.PreviousTaskID = &previous.TaskID
+	summary.Text = compareText(previous, current)
+	summary.Visual = compareThumbnails(previous, current)
+	summary.ChangedOutputs = changedOutputs(db, previous.TaskID, outputs)
+
+	summary.Changed = summary.Text.Ratio > opts.Threshold ||
+		(summary.Visual != nil && summary.Visual.Ratio > opts.Threshold)
+	return summary
+}
+
+func newCaptureSnapshot(taskID uuid.UUID, snapshot *capture.Snapshot) *models.CaptureSnapshot {
+	text := strings.Join(diff.NormalizeText(snapshot.Text), "\n")
+	textHash := sha256.Sum256([]byte(text))
+	current := &models.CaptureSnapshot{
+		TaskID:   taskID,
+		Text:     text,
+		TextHash: hex.EncodeToString(textHash[:]),
+	}
+
+	thumbnail, err := snapshotThumbnail(snapshot.Screenshot)
+	if err != nil {
+		log.Warn().Err(err).Str("task_id", taskID.String()).Msg("Failed to decode snapshot screenshot")
+		return current
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, thumbnail); err != nil {
+		log.Warn().Err(err).Str("task_id", taskID.String()).Msg("Failed to encode snapshot thumbnail")
+		return current
+	}
+	imageHash := sha256.Sum256(buf.Bytes())
+	current.Thumbnail = buf.Bytes()
+	current.ImageHash = hex.EncodeToString(imageHash[:])
+	return current
+}
+
+// snapshotThumbnail reduces a snapshot screenshot to its thumbnail. Its
+// size is checked from the header before the pixels are decoded, since
+// screenshots are not trusted to have been cut off when taken.
+func snapshotThumbnail(data []byte) (*image.Gray, error) {
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if int64(config.Width)*int64(config.Height) > capture.MaxSnapshotPixels {
+		return nil, fmt.Errorf("screenshot of %dx%d pixels is too large to compare, max %d pixels",
+			config.Width, config.Height, capture.MaxSnapshotPixels)
+	}
+
+	snapshotDecodeSlots <- struct{}{}
+	defer func() { <-snapshotDecodeSlots }()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return diff.Thumbnail(img), nil
+}
+
+// previousSnapshot finds the latest snapshot of the page the task
+// captured. Scheduled captures are compared within their schedule, others
+// with the user's earlier captures of the same URL.
+func previousSnapshot(db *gorm.DB, task *models.CaptureTask) (*models.CaptureSnapshot, error) {
+	query := db.Model(&models.CaptureSnapshot{}).
+		Joins("JOIN capture_tasks ON capture_tasks.id = capture_snapshots.task_id").
+		Where("capture_snapshots.task_id <> ?", task.ID)
+	if task.ScheduleID != nil {
+		query = query.Where("capture_tasks.schedule_id = ?", *task.ScheduleID)
+	} else {
+		query = query.Where("capture_tasks.user_id = ? AND capture_tasks.url = ? AND capture_tasks.schedule_id IS NULL", task.UserID, task.URL)
+	}
+
+	var previous models.CaptureSnapshot
+	if err := query.Order("capture_snapshots.created_at DESC").First(&previous).Error; err != nil {
+		return nil, err
+	}
+	return &previous, nil
+}
+
+func compareText(previous, current *models.CaptureSnapshot) *textChange {
+	change := &textChange{}
+	if previous.TextHash == current.TextHash {
+		return change
+	}
+
+	edits := diff.Lines(splitLines(previous.Text), splitLines(current.Text))
+	stats := diff.Summarize(edits)
+	change.Ratio = roundRatio(stats.Ratio)
+	change.Added = stats.Inserted
+	change.Removed = stats.Deleted
+	for _, e := range edits {
+		switch {
+		case e.Op == diff.Insert && len(change.AddedLines) < maxSummaryLines:
+			change.AddedLines = append(change.AddedLines, truncateLine(e.Text))
+		case e.Op == diff.Delete && len(change.RemovedLines) < maxSummaryLines:
+			change.RemovedLines = append(change.RemovedLines, truncateLine(e.Text))
+		}
+	}
+	return change
+}
+
+// compareThumbnails returns nil when either screenshot is missing.
+func compareThumbnails(previous, current *models.CaptureSnapshot) *visualChange {
+	if previous.ImageHash == "" || current.ImageHash == "" {
+		return nil
+	}
+	if previous.ImageHash == current.ImageHash {
+		return &visualChange{}
+	}
+	a, errA := decodeThumbnail(previous.Thumbnail)
+	b, errB := decodeThumbnail(current.Thumbnail)
+	if errA != nil || errB != nil {
+		return nil
+	}
+	return &visualChange{Ratio: roundRatio(diff.CompareThumbnails(a, b))}
+}
+
+func decodeThumbnail(data []byte) (*image.Gray, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if gray, ok := img.(*image.Gray); ok {
+		return gray, nil
+	}
+	return diff.Thumbnail(img), nil
+}
+
+// changedOutputs lists the formats both captures produced whose files
+// differ.
+func changedOutputs(db *gorm.DB, previousTaskID uuid.UUID, outputs []models.CaptureOutput) []string {
+	var previous []models.CaptureOutput
+	db.Where("task_id = ? AND diff_id IS NULL", previousTaskID).Find(&previous)
+	hashes := make(map[string]string, len(previous))
+	for _, output := range previous {
+		hashes[output.Format] = output.SHA256
+	}
+
+	var changed []string
+	for _, output := range outputs {
+		if hash, ok := hashes[output.Format]; ok && hash != output.SHA256 {
+			changed = append(changed, output.Format)
+		}
+	}
+	return changed
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+func truncateLine(line string) string {
+	if utf8.RuneCountInString(line) <= maxSummaryLineLength {
+		return line
+	}
+	return string([]rune(line)[:maxSummaryLineLength]) + "…"
+}
+
+func roundRatio(ratio float64) float64 {
+	return math.Round(ratio*10000) / 10000
+}
+
+// skipPendingDeliveries marks the task's pending deliveries as skipped
+// instead of sending them.
+func (w *Worker) skipPendingDeliveries(taskID uuid.UUID, reason string) {
+	if err := w.db.Model(&models.Delivery{}).
+		Where("task_id = ? AND status = ?", taskID, models.DeliveryStatusPending).
+		Updates(map[string]interface{}{
+			"status":     models.DeliveryStatusSkipped,
+			"last_error": reason,
+		}).Error; err != nil {
+		log.Error().Err(err).Str("task_id", taskID.String()).Msg("Failed to skip deliveries")
+	}
+}
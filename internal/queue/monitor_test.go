@@ -0,0 +1,206 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"pagemail/internal/capture"
+	"pagemail/internal/models"
+)
+
+// screenshot encodes a white page with a dark banner of the given height.
+func screenshot(t *testing.T, banner int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 640, 800))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 640, banner), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode screenshot: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// pngHeader returns the start of a PNG of the given size, enough for its
+// size to be read but not its pixels.
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8], ihdr[9] = 8, 2 // 8-bit RGB
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	_ = binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func pricingText(price string) string {
+	lines := []string{"Acme", "Pricing", "Starter  $9", "Pro   " + price, "Team $99",
+		"", "Features", "Support", "FAQ", "Contact", "Terms"}
+	return strings.Join(lines, "\n")
+}
+
+func TestDetectChange(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.CaptureTask{}, &models.CaptureOutput{}, &models.CaptureSnapshot{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	userID := uuid.New()
+	started := time.Now()
+	run := 0
+	capturePage := func(t *testing.T, scheduleID *uuid.UUID, threshold float64, snapshot *capture.Snapshot, pdfHash string) *changeSummary {
+		t.Helper()
+		task := models.CaptureTask{UserID: userID, URL: "https://example.com/pricing", ScheduleID: scheduleID}
+		db.Create(&task)
+		outputs := []models.CaptureOutput{{TaskID: task.ID, Format: "pdf", StorageBackend: "local", ObjectKey: "k", ContentType: "application/pdf", SHA256: pdfHash}}
+		db.Create(&outputs)
+		summary := detectChange(db, &task, &capture.MonitorOptions{Threshold: threshold}, snapshot, outputs)
+		// Snapshots are ordered by creation time, which may not tick
+		// between runs.
+		run++
+		db.Model(&models.CaptureSnapshot{}).Where("task_id = ?", task.ID).
+			Update("created_at", started.Add(time.Duration(run)*time.Minute))
+		return summary
+	}
+
+	first := capturePage(t, nil, 0.05, &capture.Snapshot{Text: pricingText("$49"), Screenshot: screenshot(t, 80)}, "a")
+	if !first.Baseline || !first.Changed || first.PreviousTaskID != nil {
+		t.Errorf("first capture summary = %+v, want a changed baseline", first)
+	}
+
+	// Whitespace differences do not count.
+	same := capturePage(t, nil, 0.05, &capture.Snapshot{Text: "  " + pricingText("$49") + "\n\n", Screenshot: screenshot(t, 80)}, "a")
+	if same.Changed || same.Baseline || same.PreviousTaskID == nil {
+		t.Errorf("unchanged capture summary = %+v, want unchanged", same)
+	}
+	if same.Text == nil || same.Text.Ratio != 0 || same.Visual == nil || same.Visual.Ratio != 0 || len(same.ChangedOutputs) != 0 {
+		t.Errorf("unchanged capture summary = %+v, text %+v, visual %+v", same, same.Text, same.Visual)
+	}
+
+	// One of ten lines changed: a ratio of 0.1.
+	price := capturePage(t, nil, 0.05, &capture.Snapshot{Text: pricingText("$59"), Screenshot: screenshot(t, 80)}, "b")
+	if !price.Changed {
+		t.Errorf("price change summary = %+v, want changed", price)
+	}
+	if price.Text.Ratio != 0.1 || price.Text.Added != 1 || price.Text.Removed != 1 {
+		t.Errorf("price change text = %+v, want ratio 0.1 with one line added and removed", price.Text)
+	}
+	if !reflect.DeepEqual(price.Text.AddedLines, []string{"Pro $59"}) || !reflect.DeepEqual(price.Text.RemovedLines, []string{"Pro $49"}) {
+		t.Errorf("price change lines = +%q -%q", price.Text.AddedLines, price.Text.RemovedLines)
+	}
+	if !reflect.DeepEqual(price.ChangedOutputs, []string{"pdf"}) {
+		t.Errorf("price change outputs = %v, want [pdf]", price.ChangedOutputs)
+	}
+
+	// A taller banner covers another 10% of the page, under a 0.2 threshold.
+	banner := capturePage(t, nil, 0.2, &capture.Snapshot{Text: pricingText("$59"), Screenshot: screenshot(t, 160)}, "b")
+	if banner.Changed {
+		t.Errorf("small visual change summary = %+v, want unchanged under the threshold", banner)
+	}
+	if banner.Visual == nil || banner.Visual.Ratio < 0.05 || banner.Visual.Ratio > 0.15 {
+		t.Errorf("small visual change = %+v, want a ratio near 0.1", banner.Visual)
+	}
+
+	// Scheduled captures are only compared within their schedule.
+	scheduleID := uuid.New()
+	scheduled := capturePage(t, &scheduleID, 0.05, &capture.Snapshot{Text: pricingText("$59"), Screenshot: screenshot(t, 160)}, "b")
+	if !scheduled.Baseline {
+		t.Errorf("first scheduled capture summary = %+v, want a baseline", scheduled)
+	}
+
+	failed := capturePage(t, nil, 0.05, nil, "b")
+	if !failed.Changed || failed.Error == "" {
+		t.Errorf("capture without snapshot summary = %+v, want changed with an error", failed)
+	}
+
+	var count int64
+	db.Model(&models.CaptureSnapshot{}).Count(&count)
+	if count != 5 {
+		t.Errorf("Snapshot count = %d, want 5", count)
+	}
+}
+
+func TestSnapshotThumbnail(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"screenshot", screenshot(t, 80), ""},
+		{"too large", pngHeader(1920, 100000), "too large to compare"},
+		{"not a png", []byte("not a png"), "png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thumbnail, err := snapshotThumbnail(tt.data)
+			if tt.wantErr == "" {
+				if err != nil || thumbnail.Bounds().Dx() != 128 {
+					t.Errorf("snapshotThumbnail() = %v, %v, want a 128px thumbnail", thumbnail, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("snapshotThumbnail() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Without a thumbnail the capture is compared by its text only.
+	current := newCaptureSnapshot(uuid.New(), &capture.Snapshot{Text: "page", Screenshot: pngHeader(1920, 100000)})
+	if current.ImageHash != "" || current.Thumbnail != nil || current.TextHash == "" {
+		t.Errorf("newCaptureSnapshot() with an oversized screenshot = %+v, want text only", current)
+	}
+}
+
+func TestSkipPendingDeliveries(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.Delivery{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	taskID := uuid.New()
+	pending := models.Delivery{TaskID: taskID, Channel: models.ChannelEmail, TargetConfig: "{}", Status: models.DeliveryStatusPending}
+	sent := models.Delivery{TaskID: taskID, Channel: models.ChannelEmail, TargetConfig: "{}", Status: models.DeliveryStatusSent}
+	db.Create(&pending)
+	db.Create(&sent)
+
+	w := NewWorker(0, nil, db, nil, nil, nil)
+	w.skipPendingDeliveries(taskID, "page unchanged")
+
+	for _, tt := range []struct {
+		delivery *models.Delivery
+		want     string
+	}{
+		{&pending, models.DeliveryStatusSkipped},
+		{&sent, models.DeliveryStatusSent},
+	} {
+		var got models.Delivery
+		db.First(&got, "id = ?", tt.delivery.ID)
+		if got.Status != tt.want {
+			t.Errorf("Delivery status = %q, want %q", got.Status, tt.want)
+		}
+	}
+
+	var jobs int64
+	db.Model(&models.Job{}).Count(&jobs)
+	if jobs != 0 {
+		t.Errorf("Enqueued %d jobs for skipped deliveries, want 0", jobs)
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/go-rod/rod/lib/proto"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
@@ -19,6 +18,7 @@ import (
 	"pagemail/internal/capture"
 	"pagemail/internal/config"
 	"pagemail/internal/models"
+	"pagemail/internal/pkg/crypto"
 	"pagemail/internal/storage"
 )
 
@@ -27,6 +27,7 @@ type Dispatcher struct {
 	db       *gorm.DB
 	storage  storage.Storage
 	jobChan  chan models.Job
+	pool     *capture.Pool
 	workers  []*Worker
 	ctx      context.Context
 	cancel   context.CancelFunc
@@ -41,6 +42,7 @@ func NewDispatcher(cfg *config.Config, db *gorm.DB, store storage.Storage) *Disp
 		db:       db,
 		storage:  store,
 		jobChan:  make(chan models.Job, 100),
+		pool:     newBrowserPool(cfg),
 		ctx:      ctx,
 		cancel:   cancel,
 		workerID: uuid.New().String()[:8],
@@ -50,8 +52,10 @@ func NewDispatcher(cfg *config.Config, db *gorm.DB, store storage.Storage) *Disp
 func (d *Dispatcher) Start() {
 	log.Info().Int("workers", d.cfg.Capture.Workers).Msg("Starting job dispatcher")
 
+	d.pool.Start()
+
 	for i := 0; i < d.cfg.Capture.Workers; i++ {
-		worker := NewWorker(i, d.cfg, d.db, d.storage, d.jobChan)
+		worker := NewWorker(i, d.cfg, d.db, d.storage, d.pool, d.jobChan)
 		d.workers = append(d.workers, worker)
 		d.wg.Add(1)
 		go func(w *Worker) {
@@ -71,6 +75,12 @@ func (d *Dispatcher) Start() {
 		defer d.wg.Done()
 		d.recoverStuckJobs()
 	}()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.runSchedules()
+	}()
 }
 
 func (d *Dispatcher) Stop() {
@@ -78,14 +88,47 @@ func (d *Dispatcher) Stop() {
 	d.cancel()
 	close(d.jobChan)
 
-	for _, w := range d.workers {
-		w.Close()
-	}
+	// Closing the pool fails any capture still in flight, so workers
+	// return promptly.
+	d.pool.Close()
 
 	d.wg.Wait()
 	log.Info().Msg("Job dispatcher stopped")
 }
 
+func newBrowserPool(cfg *config.Config) *capture.Pool {
+	var filters *capture.FilterList
+	if cfg.Capture.FilterListFile != "" {
+		var err error
+		filters, err = capture.LoadFilterList(cfg.Capture.FilterListFile)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to load filter list, using bundled list")
+		}
+	}
+
+	return capture.NewPool(&capture.PoolConfig{
+		Browser: capture.BrowserConfig{
+			Headless:       true,
+			ViewportWidth:  cfg.Capture.ViewportWidth,
+			ViewportHeight: cfg.Capture.ViewportHeight,
+			Timeout:        time.Duration(cfg.Capture.WaitTimeout) * time.Millisecond,
+			MaxPageSize:    cfg.Capture.MaxPageSize,
+			Filters:        filters,
+			TunnelPorts:    cfg.Capture.TunnelPorts,
+			Inline: capture.InlinerConfig{
+				MaxResourceSize: cfg.Capture.InlineMaxResourceSize,
+				MaxTotalSize:    cfg.Capture.InlineMaxTotalSize,
+				Concurrency:     cfg.Capture.InlineConcurrency,
+			},
+		},
+		Size:                cfg.Capture.Browsers,
+		PagesPerBrowser:     cfg.Capture.PagesPerBrowser,
+		RecycleAfterPages:   cfg.Capture.BrowserRecycleAfter,
+		MaxMemory:           int64(cfg.Capture.BrowserMaxMemoryMB) << 20,
+		HealthCheckInterval: time.Duration(cfg.Capture.BrowserHealthInterval) * time.Second,
+	})
+}
+
 func (d *Dispatcher) poll() {
 	ticker := time.NewTicker(time.Duration(d.cfg.Queue.PollInterval) * time.Second)
 	defer ticker.Stop()
@@ -177,52 +220,21 @@ type Worker struct {
 	cfg     *config.Config
 	db      *gorm.DB
 	storage storage.Storage
+	pool    *capture.Pool
 	jobChan <-chan models.Job
-	browser *capture.Browser
-	mu      sync.Mutex
 }
 
-func NewWorker(id int, cfg *config.Config, db *gorm.DB, store storage.Storage, jobChan <-chan models.Job) *Worker {
+func NewWorker(id int, cfg *config.Config, db *gorm.DB, store storage.Storage, pool *capture.Pool, jobChan <-chan models.Job) *Worker {
 	return &Worker{
 		id:      id,
 		cfg:     cfg,
 		db:      db,
 		storage: store,
+		pool:    pool,
 		jobChan: jobChan,
 	}
 }
 
-func (w *Worker) Close() {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-	if w.browser != nil {
-		w.browser.Close()
-		w.browser = nil
-	}
-}
-
-func (w *Worker) getBrowser() (*capture.Browser, error) {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-
-	if w.browser != nil {
-		return w.browser, nil
-	}
-
-	browser, err := capture.NewBrowser(&capture.BrowserConfig{
-		Headless:       true,
-		ViewportWidth:  w.cfg.Capture.ViewportWidth,
-		ViewportHeight: w.cfg.Capture.ViewportHeight,
-		Timeout:        time.Duration(w.cfg.Capture.WaitTimeout) * time.Millisecond,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	w.browser = browser
-	return w.browser, nil
-}
-
 func (w *Worker) Start(ctx context.Context) {
 	log.Info().Int("worker_id", w.id).Msg("Worker started")
 
@@ -254,6 +266,8 @@ func (w *Worker) process(ctx context.Context, job models.Job) {
 		err = w.processCapture(ctx, job)
 	case models.JobTypeDeliver:
 		err = w.processDelivery(ctx, job)
+	case models.JobTypeDeliverBatch:
+		err = w.processBatchDelivery(ctx, job)
 	default:
 		log.Error().Str("type", job.Type).Msg("Unknown job type")
 		err = nil
@@ -269,7 +283,6 @@ func (w *Worker) process(ctx context.Context, job models.Job) {
 type CapturePayload struct {
 	TaskID  string   `json:"task_id"`
 	URL     string   `json:"url"`
-	Cookies string   `json:"cookies"`
 	Formats []string `json:"formats"`
 }
 
@@ -313,24 +326,97 @@ func (w *Worker) processCapture(ctx context.Context, job models.Job) error {
 				"status":        models.TaskStatusFailed,
 				"error_message": "internal error: failed to reload task",
 			})
+		w.failPendingDeliveries(taskID, "internal error: failed to reload task")
 		return fmt.Errorf("failed to reload task: %w", err)
 	}
 
-	browser, err := w.getBrowser()
-	if err != nil {
-		w.updateTaskFailed(&task, fmt.Sprintf("browser init failed: %v", err))
-		return fmt.Errorf("failed to get browser: %w", err)
-	}
-
-	cookies := parseCookies(payload.Cookies, payload.URL)
-
 	opts := &capture.CaptureOptions{
 		URL:            payload.URL,
-		Cookies:        cookies,
 		ViewportWidth:  task.ViewportWidth,
 		ViewportHeight: task.ViewportHeight,
 		UserAgent:      task.UserAgent,
 		Timeout:        time.Duration(task.WaitTimeoutMs) * time.Millisecond,
+		WaitUntil:      task.WaitUntil,
+		WaitSelector:   task.WaitSelector,
+		WaitExpression: task.WaitExpression,
+		NetworkIdle:    time.Duration(task.WaitIdleMs) * time.Millisecond,
+		Delay:          time.Duration(task.WaitDelayMs) * time.Millisecond,
+	}
+
+	if task.ScreenshotOptions != "" {
+		opts.Screenshot = &capture.ScreenshotOptions{}
+		if err := json.Unmarshal([]byte(task.ScreenshotOptions), opts.Screenshot); err != nil {
+			w.updateTaskFailed(&task, fmt.Sprintf("invalid screenshot options: %v", err))
+			return fmt.Errorf("invalid screenshot options: %w", err)
+		}
+		opts.DeviceScaleFactor = opts.Screenshot.DeviceScaleFactor
+	}
+
+	formatSet := make(map[string]bool)
+	for _, f := range payload.Formats {
+		formatSet[strings.ToLower(f)] = true
+	}
+	for _, co := range captureOutputs {
+		if formatSet[co.format] {
+			opts.Outputs = append(opts.Outputs, co.output)
+		}
+	}
+	if len(opts.Outputs) == 0 {
+		w.updateTaskFailed(&task, "no supported formats requested")
+		return fmt.Errorf("no supported formats requested")
+	}
+
+	if task.EmulationOptions != "" {
+		opts.Emulation = &capture.EmulationOptions{}
+		if err := json.Unmarshal([]byte(task.EmulationOptions), opts.Emulation); err != nil {
+			w.updateTaskFailed(&task, fmt.Sprintf("invalid emulation options: %v", err))
+			return fmt.Errorf("invalid emulation options: %w", err)
+		}
+	}
+
+	if task.BlockingOptions != "" {
+		opts.Blocking = &capture.BlockingOptions{}
+		if err := json.Unmarshal([]byte(task.BlockingOptions), opts.Blocking); err != nil {
+			w.updateTaskFailed(&task, fmt.Sprintf("invalid blocking options: %v", err))
+			return fmt.Errorf("invalid blocking options: %w", err)
+		}
+	}
+
+	if task.ScrollOptions != "" {
+		opts.Scroll = &capture.ScrollOptions{}
+		if err := json.Unmarshal([]byte(task.ScrollOptions), opts.Scroll); err != nil {
+			w.updateTaskFailed(&task, fmt.Sprintf("invalid scroll options: %v", err))
+			return fmt.Errorf("invalid scroll options: %w", err)
+		}
+	}
+
+	if task.Injections != "" {
+		if err := json.Unmarshal([]byte(task.Injections), &opts.Injections); err != nil {
+			w.updateTaskFailed(&task, fmt.Sprintf("invalid injections: %v", err))
+			return fmt.Errorf("invalid injections: %w", err)
+		}
+		opts.ScriptTimeout = time.Duration(task.ScriptTimeoutMs) * time.Millisecond
+	}
+
+	if err := w.loadAuth(&task, opts); err != nil {
+		w.updateTaskFailed(&task, err.Error())
+		return err
+	}
+
+	if task.MonitorOptions != "" {
+		opts.Monitor = &capture.MonitorOptions{}
+		if err := json.Unmarshal([]byte(task.MonitorOptions), opts.Monitor); err != nil {
+			w.updateTaskFailed(&task, fmt.Sprintf("invalid monitor options: %v", err))
+			return fmt.Errorf("invalid monitor options: %w", err)
+		}
+	}
+
+	if task.PDFOptions != "" {
+		opts.PDF = &capture.PDFOptions{}
+		if err := json.Unmarshal([]byte(task.PDFOptions), opts.PDF); err != nil {
+			w.updateTaskFailed(&task, fmt.Sprintf("invalid PDF options: %v", err))
+			return fmt.Errorf("invalid PDF options: %w", err)
+		}
 	}
 
 	log.Info().
@@ -339,7 +425,7 @@ func (w *Worker) processCapture(ctx context.Context, job models.Job) error {
 		Strs("formats", payload.Formats).
 		Msg("Starting browser capture")
 
-	result, err := browser.Capture(ctx, opts)
+	result, err := w.pool.Capture(ctx, opts)
 	if err != nil {
 		w.updateTaskFailed(&task, fmt.Sprintf("capture failed: %v", err))
 		return fmt.Errorf("capture failed: %w", err)
@@ -349,46 +435,41 @@ func (w *Worker) processCapture(ctx context.Context, job models.Job) error {
 		Str("task_id", taskID.String()).
 		Int("html_size", len(result.HTML)).
 		Int("pdf_size", len(result.PDF)).
-		Int("screenshot_size", len(result.Screenshot)).
+		Int("screenshot_count", len(result.Screenshots)).
 		Msg("Capture completed, saving outputs")
 
-	formatSet := make(map[string]bool)
-	for _, f := range payload.Formats {
-		formatSet[strings.ToLower(f)] = true
-	}
-
 	var outputs []models.CaptureOutput
+	outputErrors := make(map[string]string)
 
-	if formatSet["pdf"] && len(result.PDF) > 0 {
-		output, err := w.saveOutput(ctx, taskID, "pdf", result.PDF, "application/pdf")
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to save PDF")
-		} else {
-			outputs = append(outputs, *output)
+	for _, co := range captureOutputs {
+		if !formatSet[co.format] {
+			continue
 		}
-	}
-
-	if formatSet["html"] && len(result.HTML) > 0 {
-		output, err := w.saveOutput(ctx, taskID, "html", result.HTML, "text/html")
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to save HTML")
-		} else {
-			outputs = append(outputs, *output)
+		if err := result.Errors[co.output]; err != nil {
+			outputErrors[co.format] = err.Error()
+			continue
 		}
-	}
-
-	if formatSet["screenshot"] && len(result.Screenshot) > 0 {
-		output, err := w.saveOutput(ctx, taskID, "screenshot", result.Screenshot, "image/png")
+		data := result.Output(co.output)
+		if len(data) == 0 {
+			outputErrors[co.format] = "empty output"
+			continue
+		}
+		output, err := w.saveOutput(ctx, taskID, co.format, data)
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to save screenshot")
-		} else {
-			outputs = append(outputs, *output)
+			log.Error().Err(err).Str("format", co.format).Msg("Failed to save output")
+			outputErrors[co.format] = err.Error()
+			continue
 		}
+		outputs = append(outputs, *output)
 	}
 
 	if len(outputs) == 0 {
-		w.updateTaskFailed(&task, "no outputs generated")
-		return fmt.Errorf("no outputs generated")
+		msg := "no outputs generated"
+		if len(outputErrors) > 0 {
+			msg += ": " + formatOutputErrors(outputErrors)
+		}
+		w.updateTaskFailed(&task, msg)
+		return fmt.Errorf("%s", msg)
 	}
 
 	if err := w.db.Create(&outputs).Error; err != nil {
@@ -396,24 +477,181 @@ func (w *Worker) processCapture(ctx context.Context, job models.Job) error {
 		return fmt.Errorf("failed to save outputs: %w", err)
 	}
 
-	now := time.Now()
-	w.db.Model(&task).Updates(map[string]interface{}{
-		"status":       models.TaskStatusCompleted,
-		"completed_at": now,
-	})
+	// Partial captures still complete; the failed formats are recorded so
+	// callers can tell why an output is missing.
+	var outputErrorsJSON []byte
+	if len(outputErrors) > 0 {
+		outputErrorsJSON, _ = json.Marshal(outputErrors)
+	}
+	// Injection failures do not fail the capture either; the page is
+	// rendered as it was left.
+	var injectionErrorsJSON []byte
+	if len(result.InjectionErrors) > 0 {
+		injectionErrorsJSON, _ = json.Marshal(result.InjectionErrors)
+	}
+
+	updates := map[string]interface{}{
+		"status":           models.TaskStatusCompleted,
+		"completed_at":     time.Now(),
+		"output_errors":    string(outputErrorsJSON),
+		"injection_errors": string(injectionErrorsJSON),
+		"title":            result.Title,
+		"final_url":        result.FinalURL,
+	}
+	if result.Metadata != nil {
+		metadataJSON, _ := json.Marshal(result.Metadata)
+		updates["http_status"] = result.Metadata.StatusCode
+		updates["page_metadata"] = string(metadataJSON)
+	}
+	changed := true
+	if opts.Monitor != nil {
+		summary := detectChange(w.db, &task, opts.Monitor, result.Snapshot, outputs)
+		summaryJSON, _ := json.Marshal(summary)
+		changed = summary.Changed
+		updates["changed"] = changed
+		updates["change_summary"] = string(summaryJSON)
+	}
+	w.db.Model(&task).Updates(updates)
 
 	log.Info().
 		Str("task_id", taskID.String()).
 		Int("output_count", len(outputs)).
+		Bool("changed", changed).
 		Msg("Capture task completed successfully")
 
+	// Monitored captures are only delivered when the page changed.
+	if changed {
+		w.enqueuePendingDeliveries(taskID)
+	} else {
+		w.skipPendingDeliveries(taskID, "page unchanged")
+	}
+	if task.BatchID != nil {
+		w.finishBatchTask(*task.BatchID)
+	}
+
+	return nil
+}
+
+// captureOutputs maps task formats to their flag on CaptureTask.Formats
+// and the outputs rendered by the browser.
+var captureOutputs = []struct {
+	format string
+	flag   int
+	output string
+}{
+	{"pdf", models.FormatPDF, capture.OutputPDF},
+	{"html", models.FormatHTML, capture.OutputHTML},
+	{"screenshot", models.FormatPNG, capture.ImagePNG},
+	{"jpeg", models.FormatJPEG, capture.ImageJPEG},
+	{"webp", models.FormatWebP, capture.ImageWebP},
+	{"markdown", models.FormatMarkdown, capture.OutputMarkdown},
+	{"text", models.FormatText, capture.OutputText},
+	{"epub", models.FormatEPUB, capture.OutputEPUB},
+	{"mhtml", models.FormatMHTML, capture.OutputMHTML},
+	{"warc", models.FormatWARC, capture.OutputWARC},
+	{"har", models.FormatHAR, capture.OutputHAR},
+	{"console", models.FormatConsole, capture.OutputConsole},
+}
+
+// loadAuth decrypts the task's cookies, login recipe, Basic credentials and
+// headers into opts.
+func (w *Worker) loadAuth(task *models.CaptureTask, opts *capture.CaptureOptions) error {
+	if task.LoginRecipeID == nil && len(task.CookiesEnc) == 0 &&
+		len(task.BasicAuthEnc) == 0 && len(task.HeadersEnc) == 0 {
+		return nil
+	}
+
+	encryptor, err := crypto.NewEncryptor(w.cfg.Encryption.Key)
+	if err != nil {
+		return fmt.Errorf("encryption error: %w", err)
+	}
+
+	if len(task.CookiesEnc) > 0 {
+		plaintext, err := encryptor.Decrypt(task.CookiesEnc)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt cookies: %w", err)
+		}
+		if err := json.Unmarshal(plaintext, &opts.Cookies); err != nil {
+			return fmt.Errorf("invalid cookies: %w", err)
+		}
+	}
+
+	if task.LoginRecipeID != nil {
+		var recipe models.LoginRecipe
+		if err := w.db.Where("id = ? AND user_id = ?", *task.LoginRecipeID, task.UserID).First(&recipe).Error; err != nil {
+			return fmt.Errorf("login recipe not found: %w", err)
+		}
+		flow := &capture.LoginFlow{URL: recipe.LoginURL, Credentials: map[string]string{}}
+		if err := json.Unmarshal([]byte(recipe.Steps), &flow.Steps); err != nil {
+			return fmt.Errorf("invalid login steps: %w", err)
+		}
+		if len(recipe.CredentialsEnc) > 0 {
+			plaintext, err := encryptor.Decrypt(recipe.CredentialsEnc)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt login credentials: %w", err)
+			}
+			if err := json.Unmarshal(plaintext, &flow.Credentials); err != nil {
+				return fmt.Errorf("invalid login credentials: %w", err)
+			}
+		}
+		opts.Login = flow
+	}
+
+	if len(task.BasicAuthEnc) > 0 {
+		plaintext, err := encryptor.Decrypt(task.BasicAuthEnc)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt basic auth: %w", err)
+		}
+		opts.BasicAuth = &capture.BasicAuth{}
+		if err := json.Unmarshal(plaintext, opts.BasicAuth); err != nil {
+			return fmt.Errorf("invalid basic auth: %w", err)
+		}
+	}
+
+	if len(task.HeadersEnc) > 0 {
+		plaintext, err := encryptor.Decrypt(task.HeadersEnc)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt headers: %w", err)
+		}
+		if err := json.Unmarshal(plaintext, &opts.Headers); err != nil {
+			return fmt.Errorf("invalid headers: %w", err)
+		}
+	}
+
 	return nil
 }
 
-func (w *Worker) saveOutput(ctx context.Context, taskID uuid.UUID, format string, data []byte, contentType string) (*models.CaptureOutput, error) {
-	ext := format
-	if format == "screenshot" {
-		ext = "png"
+// formatOutputErrors renders per-format errors in a stable order.
+func formatOutputErrors(outputErrors map[string]string) string {
+	parts := make([]string, 0, len(outputErrors))
+	for _, co := range captureOutputs {
+		if msg, ok := outputErrors[co.format]; ok {
+			parts = append(parts, co.format+": "+msg)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// outputTypes maps output formats to file extensions and content types.
+var outputTypes = map[string]struct{ ext, contentType string }{
+	"pdf":        {"pdf", "application/pdf"},
+	"html":       {"html", "text/html"},
+	"screenshot": {"png", "image/png"},
+	"jpeg":       {"jpg", "image/jpeg"},
+	"webp":       {"webp", "image/webp"},
+	"markdown":   {"md", "text/markdown; charset=utf-8"},
+	"text":       {"txt", "text/plain; charset=utf-8"},
+	"epub":       {"epub", "application/epub+zip"},
+	"mhtml":      {"mhtml", "multipart/related"},
+	"warc":       {"warc", "application/warc"},
+	"har":        {"har", "application/json"},
+	"console":    {"json", "application/json"},
+}
+
+func (w *Worker) saveOutput(ctx context.Context, taskID uuid.UUID, format string, data []byte) (*models.CaptureOutput, error) {
+	ext, contentType := format, "application/octet-stream"
+	if t, ok := outputTypes[format]; ok {
+		ext, contentType = t.ext, t.contentType
 	}
 
 	now := time.Now().UTC()
@@ -460,49 +698,15 @@ func (w *Worker) updateTaskFailed(task *models.CaptureTask, errMsg string) {
 	}
 
 	// Conditional update: only if task is not completed
-	w.db.Model(task).Where("completed_at IS NULL").Updates(updates)
-}
-
-func parseCookies(cookieStr, targetURL string) []*proto.NetworkCookieParam {
-	if cookieStr == "" {
-		return nil
+	result := w.db.Model(task).Where("completed_at IS NULL").Updates(updates)
+	if result.Error != nil || result.RowsAffected == 0 || updates["status"] != models.TaskStatusFailed {
+		return
 	}
 
-	pairs := strings.Split(cookieStr, ";")
-	cookies := make([]*proto.NetworkCookieParam, 0, len(pairs))
-
-	for _, pair := range pairs {
-		pair = strings.TrimSpace(pair)
-		if pair == "" {
-			continue
-		}
-
-		parts := strings.SplitN(pair, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-
-		name := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-
-		if name == "" {
-			continue
-		}
-
-		cookies = append(cookies, &proto.NetworkCookieParam{
-			Name:  name,
-			Value: value,
-			URL:   targetURL,
-		})
+	w.failPendingDeliveries(task.ID, errMsg)
+	if task.BatchID != nil {
+		w.finishBatchTask(*task.BatchID)
 	}
-
-	return cookies
-}
-
-//nolint:gocritic,unparam // hugeParam: job from channel uses value type; ctx reserved for future use
-func (w *Worker) processDelivery(ctx context.Context, job models.Job) error {
-	log.Info().Str("job_id", job.ID.String()).Msg("Processing delivery job")
-	return nil
 }
 
 func (w *Worker) handleSuccess(job *models.Job) {
@@ -530,8 +734,7 @@ func (w *Worker) handleFailure(job *models.Job, err error) {
 		})
 		log.Error().Str("job_id", job.ID.String()).Err(err).Msg("Job failed permanently")
 	} else {
-		retryDelay := time.Duration(10*(1<<job.Attempts)) * time.Second
-		runAt := time.Now().Add(retryDelay)
+		runAt := time.Now().Add(retryDelay(job.Attempts))
 		w.db.Model(job).Updates(map[string]interface{}{
 			"status":      models.JobStatusPending,
 			"attempts":    job.Attempts,
@@ -545,6 +748,12 @@ func (w *Worker) handleFailure(job *models.Job, err error) {
 	}
 }
 
+// retryDelay returns the exponential backoff applied after the given
+// number of failed attempts.
+func retryDelay(attempts int) time.Duration {
+	return time.Duration(10*(1<<attempts)) * time.Second
+}
+
 func EnqueueJob(db *gorm.DB, jobType string, payload interface{}) error {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
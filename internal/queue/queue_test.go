@@ -1,12 +1,21 @@
 package queue
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
+	"pagemail/internal/capture"
+	"pagemail/internal/config"
 	"pagemail/internal/models"
+	"pagemail/internal/pkg/crypto"
+	"pagemail/internal/storage"
 )
 
 func setupTestDB(t *testing.T) *gorm.DB {
@@ -114,3 +123,265 @@ func TestEnqueueJobDefaults(t *testing.T) {
 		t.Errorf("Job Type = %q, want %q", job.Type, models.JobTypeCapture)
 	}
 }
+
+func TestProcessDeliveryWebhook(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.User{}, &models.WebhookEndpoint{}, &models.CaptureTask{}, &models.CaptureOutput{}, &models.Delivery{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("Failed to parse multipart form: %v", err)
+		}
+		for _, fh := range r.MultipartForm.File["files"] {
+			received = append(received, fh.Filename)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	store, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	db.Create(&user)
+	webhook := models.WebhookEndpoint{UserID: user.ID, Name: "hook", URL: server.URL, IsActive: true}
+	db.Create(&webhook)
+	task := models.CaptureTask{UserID: user.ID, URL: "https://example.com", Status: models.TaskStatusCompleted}
+	db.Create(&task)
+
+	for _, format := range []string{"pdf", "html"} {
+		key := "captures/" + task.ID.String() + "_" + format + "." + format
+		if _, err := store.Upload(context.Background(), key, strings.NewReader(format+" data"), "text/plain"); err != nil {
+			t.Fatalf("Failed to upload output: %v", err)
+		}
+		db.Create(&models.CaptureOutput{TaskID: task.ID, Format: format, StorageBackend: "local", ObjectKey: key, ContentType: "text/plain"})
+	}
+
+	delivery := models.Delivery{
+		TaskID:       task.ID,
+		Channel:      models.ChannelWebhook,
+		TargetConfig: `{"id":"` + webhook.ID.String() + `","formats":["pdf"]}`,
+		Status:       models.DeliveryStatusPending,
+		MaxAttempts:  3,
+	}
+	db.Create(&delivery)
+
+	cfg := &config.Config{Encryption: config.EncryptionConfig{Key: "test-encryption-key-32-bytes!!!!"}}
+	w := NewWorker(0, cfg, db, store, nil, nil)
+	job := models.Job{Type: models.JobTypeDeliver, Payload: `{"delivery_id":"` + delivery.ID.String() + `"}`}
+
+	if err := w.processDelivery(context.Background(), job); err != nil {
+		t.Fatalf("processDelivery() error = %v", err)
+	}
+
+	if len(received) != 1 || received[0] != "capture-pdf.pdf" {
+		t.Errorf("Webhook received files %v, want [capture-pdf.pdf]", received)
+	}
+
+	db.First(&delivery, "id = ?", delivery.ID)
+	if delivery.Status != models.DeliveryStatusSent {
+		t.Errorf("Delivery status = %q, want %q", delivery.Status, models.DeliveryStatusSent)
+	}
+	if delivery.Attempts != 1 {
+		t.Errorf("Delivery attempts = %d, want 1", delivery.Attempts)
+	}
+	if delivery.CompletedAt == nil {
+		t.Error("Delivery CompletedAt should be set")
+	}
+}
+
+func TestUpdateTaskFailed(t *testing.T) {
+	tests := []struct {
+		name          string
+		attempts      int
+		completed     bool
+		wantTask      string
+		wantDelivery  string
+		wantLastError string
+	}{
+		{"retried", 1, false, models.TaskStatusPending, models.DeliveryStatusPending, ""},
+		{"final attempt", 3, false, models.TaskStatusFailed, models.DeliveryStatusFailed, "capture failed: navigation timeout"},
+		{"already completed", 3, true, models.TaskStatusCompleted, models.DeliveryStatusPending, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			if err := db.AutoMigrate(&models.CaptureTask{}, &models.Delivery{}); err != nil {
+				t.Fatalf("Failed to migrate test database: %v", err)
+			}
+
+			task := models.CaptureTask{URL: "https://example.com", Status: models.TaskStatusRunning, Attempts: tt.attempts, MaxAttempts: 3}
+			if tt.completed {
+				now := time.Now()
+				task.Status, task.CompletedAt = models.TaskStatusCompleted, &now
+			}
+			db.Create(&task)
+			delivery := models.Delivery{TaskID: task.ID, Channel: models.ChannelEmail, TargetConfig: "{}", Status: models.DeliveryStatusPending}
+			db.Create(&delivery)
+
+			w := NewWorker(0, nil, db, nil, nil, nil)
+			w.updateTaskFailed(&task, "navigation timeout")
+
+			var gotTask models.CaptureTask
+			db.First(&gotTask, "id = ?", task.ID)
+			var gotDelivery models.Delivery
+			db.First(&gotDelivery, "id = ?", delivery.ID)
+			if gotTask.Status != tt.wantTask {
+				t.Errorf("Task status = %q, want %q", gotTask.Status, tt.wantTask)
+			}
+			if gotDelivery.Status != tt.wantDelivery || gotDelivery.LastError != tt.wantLastError {
+				t.Errorf("Delivery = %q %q, want %q %q", gotDelivery.Status, gotDelivery.LastError, tt.wantDelivery, tt.wantLastError)
+			}
+			if (gotDelivery.CompletedAt != nil) != (tt.wantDelivery == models.DeliveryStatusFailed) {
+				t.Errorf("Delivery CompletedAt = %v", gotDelivery.CompletedAt)
+			}
+		})
+	}
+}
+
+func TestResetFailedDeliveries(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.CaptureTask{}, &models.Delivery{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	task := models.CaptureTask{URL: "https://example.com", Status: models.TaskStatusRunning, Attempts: 3, MaxAttempts: 3}
+	db.Create(&task)
+	pending := models.Delivery{TaskID: task.ID, Channel: models.ChannelEmail, TargetConfig: "{}", Status: models.DeliveryStatusPending}
+	db.Create(&pending)
+	sendFailed := models.Delivery{TaskID: task.ID, Channel: models.ChannelEmail, TargetConfig: "{}", Status: models.DeliveryStatusFailed, LastError: "smtp: connection refused"}
+	db.Create(&sendFailed)
+
+	w := NewWorker(0, nil, db, nil, nil, nil)
+	w.updateTaskFailed(&task, "navigation timeout")
+
+	// The capture is retried and succeeds.
+	if err := ResetFailedDeliveries(db, task.ID); err != nil {
+		t.Fatalf("ResetFailedDeliveries() error = %v", err)
+	}
+	w.enqueuePendingDeliveries(task.ID)
+
+	var got models.Delivery
+	db.First(&got, "id = ?", pending.ID)
+	if got.Status != models.DeliveryStatusPending || got.LastError != "" || got.Attempts != 0 || got.CompletedAt != nil {
+		t.Errorf("Delivery after retry = %q %q attempts=%d completed_at=%v, want pending and cleared", got.Status, got.LastError, got.Attempts, got.CompletedAt)
+	}
+	var other models.Delivery
+	db.First(&other, "id = ?", sendFailed.ID)
+	if other.Status != models.DeliveryStatusFailed {
+		t.Errorf("Delivery failed on send has status %q after retry, want %q", other.Status, models.DeliveryStatusFailed)
+	}
+
+	var jobs []models.Job
+	db.Where("type = ?", models.JobTypeDeliver).Find(&jobs)
+	if len(jobs) != 1 || !strings.Contains(jobs[0].Payload, pending.ID.String()) {
+		t.Errorf("Enqueued delivery jobs = %+v, want one for %s", jobs, pending.ID)
+	}
+}
+
+func TestFormatOutputErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		errors map[string]string
+		want   string
+	}{
+		{"empty", map[string]string{}, ""},
+		{"single", map[string]string{"pdf": "timeout"}, "pdf: timeout"},
+		{"stable order", map[string]string{"webp": "b", "pdf": "a", "screenshot": "c"}, "pdf: a; screenshot: c; webp: b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatOutputErrors(tt.errors); got != tt.want {
+				t.Errorf("formatOutputErrors() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadAuth(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.User{}, &models.LoginRecipe{}, &models.CaptureTask{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	cfg := &config.Config{Encryption: config.EncryptionConfig{Key: "test-encryption-key-32-bytes!!!!"}}
+	encryptor, _ := crypto.NewEncryptor(cfg.Encryption.Key)
+	encrypt := func(s string) []byte {
+		data, err := encryptor.Encrypt([]byte(s))
+		if err != nil {
+			t.Fatalf("Encrypt() error = %v", err)
+		}
+		return data
+	}
+
+	user := models.User{Email: "test@example.com", PasswordHash: "hash"}
+	db.Create(&user)
+	recipe := models.LoginRecipe{
+		UserID:         user.ID,
+		Name:           "dashboard",
+		LoginURL:       "https://example.com/login",
+		Steps:          `[{"action":"fill","selector":"#password","credential":"password"},{"action":"click","selector":"button"}]`,
+		CredentialsEnc: encrypt(`{"password":"hunter2"}`),
+	}
+	db.Create(&recipe)
+
+	task := models.CaptureTask{
+		UserID:        user.ID,
+		URL:           "https://example.com/dashboard",
+		LoginRecipeID: &recipe.ID,
+		CookiesEnc:    encrypt(`[{"name":"session","value":"abc","domain":".example.com","path":"/"}]`),
+		BasicAuthEnc:  encrypt(`{"username":"alice","password":"secret"}`),
+		HeadersEnc:    encrypt(`{"X-Team":"archive"}`),
+	}
+
+	w := NewWorker(0, cfg, db, nil, nil, nil)
+	opts := &capture.CaptureOptions{}
+	if err := w.loadAuth(&task, opts); err != nil {
+		t.Fatalf("loadAuth() error = %v", err)
+	}
+
+	if opts.Login == nil || opts.Login.URL != recipe.LoginURL || len(opts.Login.Steps) != 2 {
+		t.Fatalf("Login = %+v, want recipe flow", opts.Login)
+	}
+	if opts.Login.Credentials["password"] != "hunter2" {
+		t.Errorf("Login credentials not decrypted: %v", opts.Login.Credentials)
+	}
+	if opts.BasicAuth == nil || opts.BasicAuth.Username != "alice" {
+		t.Errorf("BasicAuth = %+v, want alice", opts.BasicAuth)
+	}
+	if opts.Headers["X-Team"] != "archive" {
+		t.Errorf("Headers = %v, want X-Team", opts.Headers)
+	}
+	if len(opts.Cookies) != 1 || opts.Cookies[0].Domain != ".example.com" {
+		t.Errorf("Cookies = %+v, want session cookie", opts.Cookies)
+	}
+
+	// Cookies that do not decrypt, under another key or as plaintext, fail
+	// the task rather than being parsed as a header.
+	otherKey, _ := crypto.NewEncryptor("another-encryption-key-32-bytes!")
+	rotated, _ := otherKey.Encrypt([]byte(`[{"name":"session","value":"abc"}]`))
+	for _, cookies := range [][]byte{rotated, []byte("a=1; b=2")} {
+		undecryptable := models.CaptureTask{UserID: user.ID, URL: task.URL, CookiesEnc: cookies}
+		opts = &capture.CaptureOptions{}
+		if err := w.loadAuth(&undecryptable, opts); err == nil || !strings.Contains(err.Error(), "failed to decrypt cookies") {
+			t.Errorf("loadAuth(%q) error = %v, want failed to decrypt cookies", cookies, err)
+		}
+		if len(opts.Cookies) != 0 {
+			t.Errorf("loadAuth(%q) Cookies = %+v, want none", cookies, opts.Cookies)
+		}
+	}
+
+	other := models.User{Email: "other@example.com", PasswordHash: "hash"}
+	db.Create(&other)
+	task.UserID = other.ID
+	if err := w.loadAuth(&task, &capture.CaptureOptions{}); err == nil {
+		t.Error("loadAuth() should not use another user's recipe")
+	}
+}
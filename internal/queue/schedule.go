@@ -0,0 +1,189 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+
+	"pagemail/internal/models"
+	"pagemail/internal/pkg/cron"
+)
+
+// maxSchedulesPerTick bounds the schedules materialized in one pass; the
+// rest are picked up on the next tick.
+const maxSchedulesPerTick = 100
+
+// NextScheduleRun returns the first time after t that a cron expression
+// fires in the given timezone, or nil if it never does.
+func NextScheduleRun(expr, timezone string, t time.Time) (*time.Time, error) {
+	schedule, err := cron.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", timezone)
+	}
+	next := schedule.Next(t.In(loc))
+	if next.IsZero() {
+		return nil, nil
+	}
+	next = next.UTC()
+	return &next, nil
+}
+
+func (d *Dispatcher) runSchedules() {
+	ticker := time.NewTicker(time.Duration(d.cfg.Queue.PollInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			materializeSchedules(d.db, time.Now())
+		}
+	}
+}
+
+// materializeSchedules creates the task of every schedule due at now and
+// returns how many were created. Runs missed while no instance was up are
+// not made up for; a late schedule runs once and moves on.
+func materializeSchedules(db *gorm.DB, now time.Time) int {
+	var schedules []models.CaptureSchedule
+	if err := db.Where("is_active = ? AND next_run_at <= ?", true, now).
+		Order("next_run_at").
+		Limit(maxSchedulesPerTick).
+		Find(&schedules).Error; err != nil {
+		log.Error().Err(err).Msg("Failed to fetch due schedules")
+		return 0
+	}
+
+	created := 0
+	for i := range schedules {
+		task, err := runSchedule(db, &schedules[i], now)
+		if err != nil {
+			log.Error().Err(err).Str("schedule_id", schedules[i].ID.String()).Msg("Failed to run schedule")
+			continue
+		}
+		if task != nil {
+			created++
+			log.Info().
+				Str("schedule_id", schedules[i].ID.String()).
+				Str("task_id", task.ID.String()).
+				Msg("Scheduled capture created")
+		}
+	}
+	return created
+}
+
+// runSchedule creates the task, delivery and job for one run of a due
+// schedule and moves it to its next run. Instances claim the run by
+// incrementing Runs from the value they read, so only one of them creates
+// the task; the others get nil.
+func runSchedule(db *gorm.DB, schedule *models.CaptureSchedule, now time.Time) (*models.CaptureTask, error) {
+	next, err := NextScheduleRun(schedule.CronExpr, schedule.Timezone, now)
+	if err != nil {
+		// Expressions are validated when saved, so this only happens if
+		// the timezone database changed. Stop retrying every tick.
+		db.Model(&models.CaptureSchedule{}).
+			Where("id = ? AND runs = ?", schedule.ID, schedule.Runs).
+			Updates(map[string]interface{}{"is_active": false, "next_run_at": nil})
+		return nil, fmt.Errorf("invalid schedule, deactivated: %w", err)
+	}
+
+	task, err := newScheduledTask(schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{
+			"runs":         schedule.Runs + 1,
+			"next_run_at":  next,
+			"last_run_at":  now,
+			"last_task_id": task.ID,
+		}
+		if next == nil {
+			updates["is_active"] = false
+		}
+		claim := tx.Model(&models.CaptureSchedule{}).
+			Where("id = ? AND runs = ?", schedule.ID, schedule.Runs).
+			Updates(updates)
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			return nil
+		}
+
+		if err := tx.Create(task).Error; err != nil {
+			return fmt.Errorf("failed to create task: %w", err)
+		}
+		if schedule.DeliveryChannel != "" {
+			delivery := models.Delivery{
+				TaskID:       task.ID,
+				Channel:      schedule.DeliveryChannel,
+				TargetConfig: schedule.DeliveryTarget,
+				Status:       models.DeliveryStatusPending,
+				MaxAttempts:  3,
+			}
+			if err := tx.Create(&delivery).Error; err != nil {
+				return fmt.Errorf("failed to create delivery: %w", err)
+			}
+		}
+		if err := EnqueueJob(tx, models.JobTypeCapture, CapturePayload{
+			TaskID:  task.ID.String(),
+			URL:     task.URL,
+			Formats: formatNames(task.Formats),
+		}); err != nil {
+			return fmt.Errorf("failed to enqueue job: %w", err)
+		}
+		claimed = true
+		return nil
+	})
+	if err != nil || !claimed {
+		return nil, err
+	}
+	return task, nil
+}
+
+// newScheduledTask builds the pending task for one run of a schedule from
+// its template and secrets.
+func newScheduledTask(schedule *models.CaptureSchedule) (*models.CaptureTask, error) {
+	var task models.CaptureTask
+	if err := json.Unmarshal([]byte(schedule.TaskTemplate), &task); err != nil {
+		return nil, fmt.Errorf("invalid task template: %w", err)
+	}
+
+	scheduleID := schedule.ID
+	task.ID = uuid.New()
+	task.UserID = schedule.UserID
+	task.ScheduleID = &scheduleID
+	task.URL = schedule.URL
+	task.Formats = schedule.Formats
+	task.Status = models.TaskStatusPending
+	task.Attempts = 0
+	task.MaxAttempts = 3
+	task.CookiesEnc = schedule.CookiesEnc
+	task.LoginRecipeID = schedule.LoginRecipeID
+	task.HeadersEnc = schedule.HeadersEnc
+	task.BasicAuthEnc = schedule.BasicAuthEnc
+	return &task, nil
+}
+
+// formatNames lists the format names set in a CaptureTask.Formats value.
+func formatNames(flags int) []string {
+	var formats []string
+	for _, co := range captureOutputs {
+		if flags&co.flag != 0 {
+			formats = append(formats, co.format)
+		}
+	}
+	return formats
+}
@@ -0,0 +1,220 @@
+package queue
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"pagemail/internal/models"
+)
+
+func TestNextScheduleRun(t *testing.T) {
+	from := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		expr     string
+		timezone string
+		want     *time.Time
+		wantErr  bool
+	}{
+		{
+			name:     "utc",
+			expr:     "0 9 * * *",
+			timezone: "UTC",
+			want:     ptrTime(time.Date(2024, 5, 2, 9, 0, 0, 0, time.UTC)),
+		},
+		{
+			name:     "timezone",
+			expr:     "0 9 * * *",
+			timezone: "America/New_York",
+			want:     ptrTime(time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)),
+		},
+		{
+			name:     "never fires",
+			expr:     "0 0 31 2 *",
+			timezone: "UTC",
+		},
+		{name: "invalid expression", expr: "0 9 * *", timezone: "UTC", wantErr: true},
+		{name: "invalid timezone", expr: "0 9 * * *", timezone: "Mars/Olympus", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NextScheduleRun(tt.expr, tt.timezone, from)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NextScheduleRun() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && !got.Equal(*tt.want)) {
+				t.Errorf("NextScheduleRun() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunSchedule(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.CaptureSchedule{}, &models.CaptureTask{}, &models.Delivery{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	template, _ := json.Marshal(models.CaptureTask{
+		WaitUntil:  "networkidle",
+		PDFOptions: `{"format":"A4"}`,
+	})
+	now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	schedule := models.CaptureSchedule{
+		UserID:          uuid.New(),
+		URL:             "https://example.com",
+		Formats:         1 | 4,
+		CronExpr:        "0 9 * * *",
+		Timezone:        "UTC",
+		IsActive:        true,
+		TaskTemplate:    string(template),
+		CookiesEnc:      []byte("cookies"),
+		DeliveryChannel: models.ChannelWebhook,
+		DeliveryTarget:  `{"id":"hook"}`,
+		NextRunAt:       &now,
+	}
+	db.Create(&schedule)
+
+	task, err := runSchedule(db, &schedule, now)
+	if err != nil {
+		t.Fatalf("runSchedule() error = %v", err)
+	}
+	if task == nil {
+		t.Fatal("runSchedule() created no task")
+	}
+
+	var stored models.CaptureTask
+	if err := db.First(&stored, "id = ?", task.ID).Error; err != nil {
+		t.Fatalf("Task not stored: %v", err)
+	}
+	if stored.ScheduleID == nil || *stored.ScheduleID != schedule.ID {
+		t.Errorf("Task ScheduleID = %v, want %v", stored.ScheduleID, schedule.ID)
+	}
+	if stored.UserID != schedule.UserID || stored.URL != schedule.URL || stored.Formats != schedule.Formats {
+		t.Errorf("Task = %+v, want the schedule's user, URL and formats", stored)
+	}
+	if stored.Status != models.TaskStatusPending || stored.WaitUntil != "networkidle" || stored.PDFOptions != `{"format":"A4"}` {
+		t.Errorf("Task = %+v, want a pending task with the template options", stored)
+	}
+	if string(stored.CookiesEnc) != "cookies" {
+		t.Errorf("Task CookiesEnc = %q, want the schedule's", stored.CookiesEnc)
+	}
+
+	var delivery models.Delivery
+	if err := db.First(&delivery, "task_id = ?", task.ID).Error; err != nil {
+		t.Errorf("Delivery not created: %v", err)
+	} else if delivery.Channel != models.ChannelWebhook || delivery.TargetConfig != `{"id":"hook"}` {
+		t.Errorf("Delivery = %+v, want the schedule's target", delivery)
+	}
+
+	var job models.Job
+	if err := db.First(&job).Error; err != nil {
+		t.Fatalf("Job not enqueued: %v", err)
+	}
+	var payload CapturePayload
+	_ = json.Unmarshal([]byte(job.Payload), &payload)
+	if payload.TaskID != task.ID.String() || !reflect.DeepEqual(payload.Formats, []string{"pdf", "screenshot"}) {
+		t.Errorf("Job payload = %+v, want task %s with [pdf screenshot]", payload, task.ID)
+	}
+
+	var updated models.CaptureSchedule
+	db.First(&updated, "id = ?", schedule.ID)
+	if updated.Runs != 1 {
+		t.Errorf("Schedule runs = %d, want 1", updated.Runs)
+	}
+	if want := now.AddDate(0, 0, 1); updated.NextRunAt == nil || !updated.NextRunAt.Equal(want) {
+		t.Errorf("Schedule next_run_at = %v, want %v", updated.NextRunAt, want)
+	}
+	if updated.LastTaskID == nil || *updated.LastTaskID != task.ID {
+		t.Errorf("Schedule last_task_id = %v, want %v", updated.LastTaskID, task.ID)
+	}
+
+	// Another instance that read the schedule before the run was claimed
+	// must not create a second task.
+	again, err := runSchedule(db, &schedule, now)
+	if err != nil {
+		t.Fatalf("runSchedule() second call error = %v", err)
+	}
+	if again != nil {
+		t.Error("runSchedule() with a stale run count created a task")
+	}
+	var count int64
+	db.Model(&models.CaptureTask{}).Count(&count)
+	if count != 1 {
+		t.Errorf("Task count = %d, want 1", count)
+	}
+}
+
+func TestMaterializeSchedules(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.CaptureSchedule{}, &models.CaptureTask{}, &models.Delivery{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+	newSchedule := func(name string, next *time.Time) *models.CaptureSchedule {
+		s := &models.CaptureSchedule{
+			UserID:       uuid.New(),
+			Name:         name,
+			URL:          "https://example.com",
+			Formats:      1,
+			CronExpr:     "0 * * * *",
+			Timezone:     "UTC",
+			IsActive:     true,
+			TaskTemplate: "{}",
+			NextRunAt:    next,
+		}
+		db.Create(s)
+		return s
+	}
+
+	due := newSchedule("due", &past)
+	newSchedule("later", &future)
+	inactive := newSchedule("inactive", &past)
+	db.Model(inactive).Update("is_active", false)
+
+	if got := materializeSchedules(db, now); got != 1 {
+		t.Fatalf("materializeSchedules() = %d, want 1", got)
+	}
+
+	var tasks []models.CaptureTask
+	db.Find(&tasks)
+	if len(tasks) != 1 || tasks[0].ScheduleID == nil || *tasks[0].ScheduleID != due.ID {
+		t.Errorf("Tasks = %+v, want one task for the due schedule", tasks)
+	}
+
+	// The due schedule moved to the next hour, so nothing runs twice.
+	if got := materializeSchedules(db, now); got != 0 {
+		t.Errorf("materializeSchedules() second pass = %d, want 0", got)
+	}
+}
+
+func TestFormatNames(t *testing.T) {
+	tests := []struct {
+		flags int
+		want  []string
+	}{
+		{0, nil},
+		{1, []string{"pdf"}},
+		{1 | 2 | 4, []string{"pdf", "html", "screenshot"}},
+		{1024 | 2048, []string{"har", "console"}},
+	}
+
+	for _, tt := range tests {
+		if got := formatNames(tt.flags); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("formatNames(%d) = %v, want %v", tt.flags, got, tt.want)
+		}
+	}
+}
+
+func ptrTime(t time.Time) *time.Time {
+	return &t
+}
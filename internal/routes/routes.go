@@ -45,7 +45,11 @@ func Setup(cfg *config.Config, db *gorm.DB, store storage.Storage) *gin.Engine {
 	captures := v1.Group("/captures")
 	captures.Use(middleware.Auth(cfg))
 	captures.POST("", h.CreateCapture)
+	captures.POST("/sync", h.CreateCaptureSync)
 	captures.GET("", h.ListCaptures)
+	captures.POST("/batch", h.CreateCaptureBatch)
+	captures.GET("/batch", h.ListCaptureBatches)
+	captures.GET("/batch/:id", h.GetCaptureBatch)
 	captures.GET("/:id", h.GetCapture)
 	captures.POST("/:id/retry", h.RetryCapture)
 	captures.DELETE("/:id", h.DeleteCapture)
@@ -53,6 +57,7 @@ func Setup(cfg *config.Config, db *gorm.DB, store storage.Storage) *gin.Engine {
 	captures.GET("/:id/outputs/:oid/download", h.DownloadOutput)
 	captures.GET("/:id/outputs/:oid/preview", h.PreviewOutput)
 	captures.POST("/:id/deliver", h.DeliverCapture)
+	captures.GET("/:id/diff/:otherId", h.DiffCaptures)
 
 	deliveries := v1.Group("/deliveries")
 	deliveries.Use(middleware.Auth(cfg))
@@ -74,6 +79,28 @@ func Setup(cfg *config.Config, db *gorm.DB, store storage.Storage) *gin.Engine {
 	webhooks.DELETE("/:id", h.DeleteWebhook)
 	webhooks.POST("/:id/test", h.TestWebhook)
 
+	snippets := v1.Group("/snippets")
+	snippets.Use(middleware.Auth(cfg))
+	snippets.GET("", h.ListSnippets)
+	snippets.POST("", h.CreateSnippet)
+	snippets.PUT("/:id", h.UpdateSnippet)
+	snippets.DELETE("/:id", h.DeleteSnippet)
+
+	logins := v1.Group("/login-recipes")
+	logins.Use(middleware.Auth(cfg))
+	logins.GET("", h.ListLoginRecipes)
+	logins.POST("", h.CreateLoginRecipe)
+	logins.PUT("/:id", h.UpdateLoginRecipe)
+	logins.DELETE("/:id", h.DeleteLoginRecipe)
+
+	schedules := v1.Group("/schedules")
+	schedules.Use(middleware.Auth(cfg))
+	schedules.GET("", h.ListSchedules)
+	schedules.POST("", h.CreateSchedule)
+	schedules.GET("/:id", h.GetSchedule)
+	schedules.PUT("/:id", h.UpdateSchedule)
+	schedules.DELETE("/:id", h.DeleteSchedule)
+
 	admin := v1.Group("/admin")
 	admin.Use(middleware.Auth(cfg), middleware.RequireAdmin())
 	admin.GET("/users", h.AdminListUsers)
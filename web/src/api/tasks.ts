@@ -1,6 +1,6 @@
 import apiClient from './client'
 import type { PaginatedResponse } from '@/types/api'
-import type { Task, TaskCreatePayload } from '@/types/task'
+import type { DeliveryConfig, Task, TaskCreatePayload } from '@/types/task'
 
 export const tasksApi = {
   listTasks(params: { page?: number; limit?: number; status?: string }) {
@@ -19,6 +19,10 @@ export const tasksApi = {
     return apiClient.post(`/captures/${id}/retry`)
   },
 
+  deliverTask(id: string, data: DeliveryConfig) {
+    return apiClient.post(`/captures/${id}/deliver`, data)
+  },
+
   deleteTask(id: string) {
     return apiClient.delete(`/captures/${id}`)
   },
@@ -35,7 +39,8 @@ export const tasksApi = {
     const url = window.URL.createObjectURL(blob)
     const link = document.createElement('a')
     link.href = url
-    link.download = `${format}.${format === 'screenshot' ? 'png' : format}`
+    const extensions: Record<string, string> = { screenshot: 'png', jpeg: 'jpg' }
+    link.download = `${format}.${extensions[format] ?? format}`
     document.body.appendChild(link)
     link.click()
     document.body.removeChild(link)
@@ -8,6 +8,8 @@ export interface Task {
   attempts: number
   max_attempts: number
   error_message?: string
+  output_errors?: Record<string, string>
+  injection_errors?: string[]
   outputs?: TaskOutput[]
   delivery_history?: DeliveryAttempt[]
 }
@@ -23,7 +25,34 @@ export interface TaskCreatePayload {
   url: string
   formats: string[]
   cookies?: string
-  delivery_config?: { type: 'email' | 'webhook'; id: string }
+  cookies_format?: 'header' | 'netscape' | 'json'
+  login_recipe_id?: string
+  basic_auth?: { username: string; password?: string }
+  headers?: Record<string, string>
+  wait?: WaitConfig
+  pdf?: PdfOptions
+  screenshot?: ScreenshotOptions
+  emulation?: EmulationOptions
+  block?: BlockingOptions
+  scroll?: ScrollOptions
+  inject?: InjectConfig
+  delivery_config?: DeliveryConfig
+}
+
+export interface WaitConfig {
+  until?: 'load' | 'domcontentloaded' | 'networkidle' | 'selector' | 'function'
+  selector?: string
+  expression?: string
+  idle_ms?: number
+  delay_ms?: number
+  timeout_ms?: number
+}
+
+export interface DeliveryConfig {
+  type: 'email' | 'webhook'
+  id: string
+  recipients?: string[]
+  formats?: string[]
 }
 
 export interface DeliveryAttempt {
@@ -32,3 +61,90 @@ export interface DeliveryAttempt {
   attempt_time: string
   error?: string
 }
+
+export interface PdfOptions {
+  paper_size?: 'A3' | 'A4' | 'A5' | 'Letter' | 'Legal' | 'Tabloid'
+  paper_width?: number
+  paper_height?: number
+  landscape?: boolean
+  margin_top?: number
+  margin_bottom?: number
+  margin_left?: number
+  margin_right?: number
+  scale?: number
+  page_ranges?: string
+  prefer_css_page_size?: boolean
+  omit_background?: boolean
+  show_header_footer?: boolean
+  header_template?: string
+  footer_template?: string
+}
+
+export interface ScreenshotOptions {
+  viewport_only?: boolean
+  selector?: string
+  device_scale_factor?: number
+  quality?: number
+}
+
+export interface EmulationOptions {
+  device?: 'iphone-se' | 'iphone-15' | 'iphone-15-pro-max' | 'pixel-8' | 'ipad' | 'ipad-pro'
+  landscape?: boolean
+  locale?: string
+  timezone?: string
+  geolocation?: { latitude: number; longitude: number; accuracy?: number }
+  color_scheme?: 'light' | 'dark' | 'no-preference'
+  media?: 'screen' | 'print'
+}
+
+export interface BlockingOptions {
+  ads?: boolean
+  cookie_banners?: boolean
+}
+
+export interface ScrollOptions {
+  step_px?: number
+  delay_ms?: number
+  max_height?: number
+  idle_ms?: number
+}
+
+export interface InjectConfig {
+  snippets?: string[]
+  styles?: string[]
+  scripts?: string[]
+  timeout_ms?: number
+}
+
+export interface Snippet {
+  id: string
+  name: string
+  type: 'js' | 'css'
+  content: string
+  created_at: string
+  updated_at: string
+}
+
+export interface LoginStep {
+  action: 'fill' | 'click' | 'wait'
+  selector: string
+  credential?: string
+  value?: string
+}
+
+export interface LoginRecipe {
+  id: string
+  name: string
+  login_url: string
+  steps: LoginStep[]
+  credentials: string[]
+  created_at: string
+  updated_at: string
+}
+
+export interface LoginRecipePayload {
+  name: string
+  login_url: string
+  steps: LoginStep[]
+  credentials?: Record<string, string>
+}